@@ -20,6 +20,13 @@ const (
 	ActivityEventIdentityValidated  ActivityEventType = "identity.validated"
 	ActivityEventAgentDeployed      ActivityEventType = "agent.deployed"
 	ActivityEventRegionConfigured   ActivityEventType = "region.configured"
+	ActivityEventRoutingFallback    ActivityEventType = "residency.fallback"
+	ActivityEventResidencyRefused   ActivityEventType = "residency.unavailable"
+	ActivityEventMigrationStarted   ActivityEventType = "migration.started"
+	ActivityEventMigrationProgress  ActivityEventType = "migration.progress"
+	ActivityEventMigrationCompleted ActivityEventType = "migration.completed"
+	ActivityEventMigrationFailed    ActivityEventType = "migration.failed"
+	ActivityEventMigrationRolledBack ActivityEventType = "migration.rolled_back"
 )
 
 // ActivitySeverity represents the severity level of an activity event
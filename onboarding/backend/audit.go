@@ -3,8 +3,12 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -132,3 +136,79 @@ func GetClientIP(r *http.Request) string {
 	return ip
 }
 
+// Proxies whose forwarding headers are believed, from FEDERATION_TRUSTED_PROXIES (comma-separated IPs or CIDRs)
+var (
+	trustedProxiesOnce sync.Once
+	trustedProxies     []netip.Prefix
+)
+
+// parseTrustedProxies parses a comma-separated list of IPs and CIDRs, skipping invalid entries
+func parseTrustedProxies(list string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		log.Printf("Warning: ignoring invalid FEDERATION_TRUSTED_PROXIES entry %q", entry)
+	}
+	return prefixes
+}
+
+// GetTrustedClientIP returns the client address for limits that must not be spoofable:
+// the connection's peer address, unless the peer is a trusted proxy, in which case the
+// forwarding headers are followed back to the first address that is not a trusted proxy
+func GetTrustedClientIP(r *http.Request) string {
+	trustedProxiesOnce.Do(func() {
+		trustedProxies = parseTrustedProxies(os.Getenv("FEDERATION_TRUSTED_PROXIES"))
+	})
+	return trustedClientIP(r, trustedProxies)
+}
+
+func trustedClientIP(r *http.Request, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host, proxies) {
+		return host
+	}
+
+	// Walk X-Forwarded-For from the nearest hop; entries left of an untrusted hop are client-controlled
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if !isTrustedProxy(hop, proxies) {
+				return hop
+			}
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return host
+}
+
+// isTrustedProxy reports whether addr falls within one of the trusted proxy prefixes
+func isTrustedProxy(addr string, proxies []netip.Prefix) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedClientIP(t *testing.T) {
+	proxies := parseTrustedProxies("10.0.0.1, 192.168.0.0/16, bogus")
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.5:5000", "", "", "203.0.113.5"},
+		{"direct client spoofing headers", "203.0.113.5:5000", "198.51.100.1", "198.51.100.2", "203.0.113.5"},
+		{"through a trusted proxy", "10.0.0.1:443", "198.51.100.1", "", "198.51.100.1"},
+		{"client-prepended hops are skipped", "10.0.0.1:443", "1.1.1.1, 198.51.100.1, 192.168.1.1", "", "198.51.100.1"},
+		{"trusted proxy with X-Real-IP", "10.0.0.1:443", "", "198.51.100.3", "198.51.100.3"},
+		{"trusted proxy without headers", "10.0.0.1:443", "", "", "10.0.0.1"},
+		{"IPv6 peer", "[2001:db8::1]:443", "198.51.100.1", "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/federation/handshake", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := trustedClientIP(r, proxies); got != tt.want {
+				t.Errorf("trustedClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package bootstrap
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -16,6 +17,7 @@ import (
 	"time"
 
 	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/metrics"
 )
 
 // TenantInfo represents tenant data needed for kit generation
@@ -48,10 +50,25 @@ type BootstrapKit struct {
 	ZIPData     []byte
 	Fingerprint string
 	Size        int64
+	// NodeID and NodeKeys identify the node for the asymmetric federation handshake;
+	// the caller registers the public keys
+	NodeID   string
+	NodeKeys []federation.NodeKey
 }
 
 // GenerateBootstrapKit generates a complete bootstrap kit ZIP file
 func GenerateBootstrapKit(tenant TenantInfo) (*BootstrapKit, error) {
+	start := time.Now()
+	kit, err := generateBootstrapKit(tenant)
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	metrics.ObserveKitGeneration(outcome, time.Since(start))
+	return kit, err
+}
+
+func generateBootstrapKit(tenant TenantInfo) (*BootstrapKit, error) {
 	buf := new(bytes.Buffer)
 	zipWriter := zip.NewWriter(buf)
 
@@ -67,6 +84,29 @@ func GenerateBootstrapKit(tenant TenantInfo) (*BootstrapKit, error) {
 		return nil, fmt.Errorf("failed to generate certificate: %w", err)
 	}
 
+	// Generate node identity keypair for the federation handshake
+	nodeID := kitNodeID(tenant)
+	var nodeKeys []federation.NodeKey
+	var nodeKeyPEM []byte
+	if nodeID != "" {
+		nodePub, nodePriv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate node key: %w", err)
+		}
+		nodeKeyPEM, err = federation.EncodeNodePrivateKey(nodePriv)
+		if err != nil {
+			return nil, err
+		}
+		tenantPubDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal tenant public key: %w", err)
+		}
+		nodeKeys = []federation.NodeKey{
+			{NodeID: nodeID, TenantID: tenant.ID, Algorithm: federation.NodeKeyEd25519, PublicKey: nodePub},
+			{NodeID: nodeID, TenantID: tenant.ID, Algorithm: federation.NodeKeyRSASHA256, PublicKey: tenantPubDER},
+		}
+	}
+
 	// Write README.md
 	if err := writeFile(zipWriter, "README.md", generateREADME(tenant)); err != nil {
 		return nil, err
@@ -123,6 +163,13 @@ func GenerateBootstrapKit(tenant TenantInfo) (*BootstrapKit, error) {
 		return nil, err
 	}
 
+	// Write secrets/node-key.pem (Ed25519 handshake key)
+	if nodeKeyPEM != nil {
+		if err := writeFile(zipWriter, "secrets/node-key.pem", nodeKeyPEM); err != nil {
+			return nil, err
+		}
+	}
+
 	// Write metadata.json
 	metadata := map[string]interface{}{
 		"tenantId":    tenant.ID,
@@ -142,26 +189,15 @@ func GenerateBootstrapKit(tenant TenantInfo) (*BootstrapKit, error) {
 	
 	// Phase 13.10: Generate federation token envelope for Pi bootstrap
 	// Create a pre-signed federation token for this node/tenant
-	if tenant.ID != "" && tenant.Region != "" {
-		// Generate a node ID from tenant ID (or use provided nodeId if available)
-		nodeID := "node-" + tenant.ID[:8]
-		if tenant.Config != nil {
-			if nodeIDFromConfig, ok := tenant.Config["nodeId"].(string); ok && nodeIDFromConfig != "" {
-				nodeID = nodeIDFromConfig
-			}
-		}
+	if nodeID != "" {
 		
 		// Generate bootstrap fingerprint (simplified for now)
 		fingerprint := "bootstrap-" + tenant.ID
 		
 		// Create and sign federation token using Ed25519
-		// Import federation package to sign token
-		payload := federation.FederationTokenPayload{
-			NodeID:      nodeID,
-			TenantID:    tenant.ID,
-			Fingerprint: fingerprint,
-			IssuedAt:    time.Now().UnixMilli(),
-		}
+		// The kit token is only good for /node/join, which exchanges it for a session token
+		payload := federation.NewTokenPayload(nodeID, tenant.ID, fingerprint,
+			federation.BootstrapTokenTTL, []string{federation.AudienceNodeJoin}, []string{federation.ScopeNode})
 		
 		signedToken, err := federation.SignFederationToken(payload)
 		if err == nil {
@@ -173,6 +209,12 @@ func GenerateBootstrapKit(tenant TenantInfo) (*BootstrapKit, error) {
 				"region":      tenant.Region,
 				"source":      "bootstrap",
 				"ts":          time.Now().UnixMilli(),
+				"expiresAt":   payload.ExpiresAt,
+				"handshake": map[string]interface{}{
+					"algo":          federation.NodeKeyEd25519,
+					"privateKey":    "secrets/node-key.pem",
+					"signedMessage": "challenge:nonce:nodeId",
+				},
 			}
 		} else {
 			// Fallback: include envelope structure without token
@@ -206,9 +248,26 @@ func GenerateBootstrapKit(tenant TenantInfo) (*BootstrapKit, error) {
 		ZIPData:     zipData,
 		Fingerprint: fingerprint,
 		Size:        int64(len(zipData)),
+		NodeID:      nodeID,
+		NodeKeys:    nodeKeys,
 	}, nil
 }
 
+// kitNodeID returns the federation node ID for the kit
+// Generated from the tenant ID unless a nodeId is provided in the config; empty when the
+// tenant has no ID or region yet (no federation envelope is generated then)
+func kitNodeID(tenant TenantInfo) string {
+	if tenant.ID == "" || tenant.Region == "" {
+		return ""
+	}
+	if tenant.Config != nil {
+		if nodeIDFromConfig, ok := tenant.Config["nodeId"].(string); ok && nodeIDFromConfig != "" {
+			return nodeIDFromConfig
+		}
+	}
+	return "node-" + tenant.ID[:8]
+}
+
 // writeFile writes a file to the zip archive
 func writeFile(zipWriter *zip.Writer, filename string, content []byte) error {
 	writer, err := zipWriter.Create(filename)
@@ -269,6 +328,7 @@ This bootstrap kit contains:
 - Data region configuration
 - Access configuration (%s)
 - Tenant-specific certificates and keys
+- Node handshake key (secrets/node-key.pem)
 - Metadata and instructions
 
 ## Installation
@@ -0,0 +1,551 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/metrics"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/tracing"
+)
+
+// Federation bus handler registry
+// Subsystems register a Handler per message type and version. Every dispatch runs through the
+// registered middleware (validation, authorization, metrics, ...) before reaching the handler.
+// Messages with no registered handler are rejected or dead-lettered according to the UnknownTypePolicy.
+
+// Message is an authenticated bus message
+type Message struct {
+	ID            string // envelope messageId; deduplicated per node
+	Type          string
+	Version       int    // 0 = latest registered version
+	NodeID        string // node the message acts for
+	RelayedBy     string // gateway node that forwarded it (set by AuthorizeNode)
+	CorrelationID string
+	TraceParent   string // W3C trace context from the envelope; parents the dispatch span when set
+	TraceState    string
+	SentAt        time.Time
+	Data          map[string]interface{}
+	Origin        Origin
+	ReceivedAt    time.Time
+}
+
+// Response is a handler's reply; Status 0 means 200 OK
+// Body is encoded as JSON; handlers return their own response type (see router.go), and replayed
+// responses carry the stored JSON as a json.RawMessage.
+type Response struct {
+	Status   int
+	Body     interface{}
+	Replayed bool // stored response of an earlier delivery of the same message ID
+}
+
+// Handler processes one message type
+type Handler interface {
+	Handle(ctx context.Context, msg *Message) (*Response, error)
+}
+
+// HandlerFunc adapts a function to the Handler interface
+type HandlerFunc func(ctx context.Context, msg *Message) (*Response, error)
+
+// Handle calls f(ctx, msg)
+func (f HandlerFunc) Handle(ctx context.Context, msg *Message) (*Response, error) {
+	return f(ctx, msg)
+}
+
+// Middleware wraps every handler the registry dispatches to
+type Middleware func(next Handler) Handler
+
+// UnknownTypePolicy decides what happens to messages without a registered handler
+type UnknownTypePolicy string
+
+const (
+	UnknownReject     UnknownTypePolicy = "reject"
+	UnknownDeadLetter UnknownTypePolicy = "dead_letter"
+)
+
+// EventDeadLetter is the FederationEvent type emitted when a message is dead-lettered
+const EventDeadLetter = "dead_letter"
+
+// Dead-letter limits
+// A node cannot hold more than maxDeadLettersPerNode within the retention window, so one
+// misbehaving node cannot fill the table; older entries are purged with the message IDs.
+const (
+	DeadLetterRetention   = 7 * 24 * time.Hour
+	maxDeadLettersPerNode = 1000
+)
+
+// Bus errors
+var (
+	ErrUnknownMessageType = errors.New("unknown bus message type")
+	ErrUnsupportedVersion = errors.New("unsupported bus message version")
+	ErrHandlerRegistered  = errors.New("bus handler already registered")
+	ErrInvalidMessage     = errors.New("invalid bus message")
+	ErrDeadLetterNotUp    = errors.New("dead letter store not initialized")
+	ErrDeadLetterQuota    = errors.New("dead letter quota exceeded")
+	ErrInvalidBusPolicy   = errors.New("invalid unknown-type policy")
+)
+
+// ParseUnknownTypePolicy validates a policy name
+func ParseUnknownTypePolicy(s string) (UnknownTypePolicy, error) {
+	switch p := UnknownTypePolicy(s); p {
+	case UnknownReject, UnknownDeadLetter:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w %q", ErrInvalidBusPolicy, s)
+}
+
+type handlerKey struct {
+	msgType string
+	version int
+}
+
+// handlerStats counts dispatches for one message type
+type handlerStats struct {
+	handled  atomic.Uint64
+	failed   atomic.Uint64
+	totalNs  atomic.Uint64
+	lastNano atomic.Int64
+}
+
+// Registry maps message types and versions to handlers
+type Registry struct {
+	mu         sync.RWMutex
+	handlers   map[handlerKey]Handler
+	latest     map[string]int
+	middleware []Middleware
+	policy     UnknownTypePolicy
+	stats      map[string]*handlerStats
+	schemas    map[handlerKey]*jsonschema.Schema
+	db         *pgxpool.Pool // dead letters and message IDs
+}
+
+// NewRegistry returns an empty registry that dead-letters unknown types
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[handlerKey]Handler),
+		latest:   make(map[string]int),
+		policy:   UnknownDeadLetter,
+		stats:    make(map[string]*handlerStats),
+		schemas:  make(map[handlerKey]*jsonschema.Schema),
+	}
+}
+
+// Bus is the registry used by /api/federation/bus
+var Bus = NewRegistry()
+
+// Register adds a handler for a message type and version (versions start at 1)
+func (r *Registry) Register(msgType string, version int, h Handler) error {
+	if msgType == "" || version < 1 || h == nil {
+		return fmt.Errorf("%w: type, version >= 1 and handler are required", ErrInvalidMessage)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := handlerKey{msgType, version}
+	if _, ok := r.handlers[key]; ok {
+		return fmt.Errorf("%w: %s v%d", ErrHandlerRegistered, msgType, version)
+	}
+	r.handlers[key] = h
+	if version > r.latest[msgType] {
+		r.latest[msgType] = version
+	}
+	if _, ok := r.stats[msgType]; !ok {
+		r.stats[msgType] = &handlerStats{}
+	}
+	return nil
+}
+
+// Use appends middleware; the first added is the outermost
+func (r *Registry) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, mw...)
+}
+
+// SetUnknownTypePolicy sets how messages without a handler are treated
+func (r *Registry) SetUnknownTypePolicy(p UnknownTypePolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = p
+}
+
+// Dispatch runs a message through the middleware chain and its handler
+func (r *Registry) Dispatch(ctx context.Context, msg *Message) (*Response, error) {
+	if msg.Data == nil {
+		msg.Data = map[string]interface{}{}
+	}
+	if msg.ReceivedAt.IsZero() {
+		msg.ReceivedAt = time.Now()
+	}
+
+	// Unregistered types are reported as "unknown" so arbitrary client input cannot add metric labels
+	// or span names
+	msgType := "unknown"
+	if r.Known(msg.Type) {
+		msgType = msg.Type
+	}
+
+	r.mu.RLock()
+	h := traceHandler(msgType, r.resolveLocked(msg))
+	chain := r.middleware
+	r.mu.RUnlock()
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i](h)
+	}
+
+	ctx, span := startDispatchSpan(ctx, msgType, msg)
+	start := time.Now()
+	resp, err := h.Handle(ctx, msg)
+
+	outcome := "ok"
+	switch {
+	case err != nil:
+		outcome = "error"
+	case resp != nil && resp.Replayed:
+		outcome = "replayed"
+	}
+	metrics.ObserveBusMessage(msgType, outcome, time.Since(start))
+	span.SetAttributes(attribute.String("sage.outcome", outcome))
+	tracing.End(span, err)
+	return resp, err
+}
+
+// startDispatchSpan starts the span of a bus message
+// A traceparent in the envelope (e.g. a message relayed for another node) becomes the parent, linked to
+// the HTTP request span it arrived in; otherwise the message continues the request's trace.
+func startDispatchSpan(ctx context.Context, msgType string, msg *Message) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("sage.bus.message_id", msg.ID),
+			attribute.String("sage.bus.type", msg.Type),
+			attribute.Int("sage.bus.version", msg.Version),
+			attribute.String("sage.bus.correlation_id", msg.CorrelationID),
+			attribute.String("sage.bus.origin_node_id", msg.Origin.NodeID),
+			tracing.NodeID(msg.NodeID),
+			tracing.TenantID(msg.Origin.TenantID),
+		),
+	}
+	if parent, ok := tracing.ContextWithTraceParent(ctx, msg.TraceParent, msg.TraceState); ok {
+		opts = append(opts, trace.WithLinks(trace.LinkFromContext(ctx)))
+		ctx = parent
+	}
+	return tracing.StartSpan(ctx, "federation.bus "+msgType, opts...)
+}
+
+// traceHandler wraps the message handler in its own span, separating handler time from middleware
+// (authorization, schema validation, deduplication, event recording)
+func traceHandler(msgType string, h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+		ctx, span := tracing.Start(ctx, "federation.bus.handle "+msgType)
+		resp, err := h.Handle(ctx, msg)
+		tracing.End(span, err)
+		return resp, err
+	})
+}
+
+// resolveLocked picks the handler for a message, falling back to the unknown-type policy
+func (r *Registry) resolveLocked(msg *Message) Handler {
+	latest, known := r.latest[msg.Type]
+	if !known {
+		if r.policy == UnknownReject {
+			return HandlerFunc(func(context.Context, *Message) (*Response, error) {
+				return nil, fmt.Errorf("%w %q", ErrUnknownMessageType, msg.Type)
+			})
+		}
+		return HandlerFunc(r.deadLetter)
+	}
+	if msg.Version == 0 {
+		msg.Version = latest
+	}
+	h, ok := r.handlers[handlerKey{msg.Type, msg.Version}]
+	if !ok {
+		return HandlerFunc(func(context.Context, *Message) (*Response, error) {
+			return nil, fmt.Errorf("%w: %s v%d", ErrUnsupportedVersion, msg.Type, msg.Version)
+		})
+	}
+	return h
+}
+
+// Known reports whether a handler is registered for the message type
+func (r *Registry) Known(msgType string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.latest[msgType]
+	return ok
+}
+
+// HandlerInfo describes a registered message type
+type HandlerInfo struct {
+	Type         string     `json:"type"`
+	Versions     []int      `json:"versions"`
+	Handled      uint64     `json:"handled"`
+	Failed       uint64     `json:"failed"`
+	AvgLatencyMs float64    `json:"avgLatencyMs"`
+	LastHandled  *time.Time `json:"lastHandled,omitempty"`
+}
+
+// Handlers lists registered message types with their versions and dispatch counters
+func (r *Registry) Handlers() []HandlerInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := make(map[string][]int)
+	for k := range r.handlers {
+		versions[k.msgType] = append(versions[k.msgType], k.version)
+	}
+	infos := make([]HandlerInfo, 0, len(versions))
+	for msgType, vs := range versions {
+		sort.Ints(vs)
+		info := HandlerInfo{Type: msgType, Versions: vs}
+		if s := r.stats[msgType]; s != nil {
+			info.Handled = s.handled.Load()
+			info.Failed = s.failed.Load()
+			if total := info.Handled + info.Failed; total > 0 {
+				info.AvgLatencyMs = float64(s.totalNs.Load()) / float64(total) / 1e6
+			}
+			if last := s.lastNano.Load(); last > 0 {
+				t := time.Unix(0, last)
+				info.LastHandled = &t
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Type < infos[j].Type })
+	return infos
+}
+
+// Instrument is middleware counting dispatches and latency per registered message type
+func (r *Registry) Instrument() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+			start := time.Now()
+			resp, err := next.Handle(ctx, msg)
+
+			r.mu.RLock()
+			s := r.stats[msg.Type] // nil for unknown types, which would otherwise grow the map unbounded
+			r.mu.RUnlock()
+			if s != nil {
+				if err != nil {
+					s.failed.Add(1)
+				} else {
+					s.handled.Add(1)
+				}
+				s.totalNs.Add(uint64(time.Since(start)))
+				s.lastNano.Store(time.Now().UnixNano())
+			}
+			return resp, err
+		})
+	}
+}
+
+// ValidateMessage is middleware rejecting malformed messages before any handler runs
+func ValidateMessage() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+			if msg.Type == "" || len(msg.Type) > 100 {
+				return nil, fmt.Errorf("%w: type is required (max 100 characters)", ErrInvalidMessage)
+			}
+			if msg.NodeID == "" {
+				return nil, fmt.Errorf("%w: node is required", ErrInvalidMessage)
+			}
+			if msg.Version < 0 {
+				return nil, fmt.Errorf("%w: version must be positive", ErrInvalidMessage)
+			}
+			// Messages older than the dedup window could be replays that would no longer be detected
+			if !msg.SentAt.IsZero() {
+				if msg.SentAt.After(msg.ReceivedAt.Add(maxEnvelopeClockSkew)) {
+					return nil, fmt.Errorf("%w: sentAt is in the future", ErrInvalidMessage)
+				}
+				if msg.SentAt.Before(msg.ReceivedAt.Add(-DedupWindow)) {
+					return nil, fmt.Errorf("%w: sentAt is outside the deduplication window", ErrInvalidMessage)
+				}
+			}
+			return next.Handle(ctx, msg)
+		})
+	}
+}
+
+// RequireActiveNode is middleware refusing messages from decommissioned nodes
+func RequireActiveNode() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+			if IsNodeDecommissioned(msg.NodeID) {
+				return nil, ErrNodeDecommissioned
+			}
+			return next.Handle(ctx, msg)
+		})
+	}
+}
+
+// RecordEvents is middleware adding every routable message to the federation event stream
+func (r *Registry) RecordEvents() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+			if r.Known(msg.Type) {
+				AddEvent(msg.Type, msg.NodeID, msg.Origin.TenantID, msg.Data)
+			}
+			return next.Handle(ctx, msg)
+		})
+	}
+}
+
+// AttachStore enables dead-letter persistence and message ID deduplication
+func (r *Registry) AttachStore(db *pgxpool.Pool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.db = db
+}
+
+// DeadLetterResponse acknowledges a dead-lettered message
+type DeadLetterResponse struct {
+	OK           bool   `json:"ok"`
+	DeadLettered bool   `json:"deadLettered"`
+	DeadLetterID string `json:"deadLetterId"`
+	Type         string `json:"type"`
+}
+
+// deadLetter stores a message no handler accepted and acknowledges it with 202
+func (r *Registry) deadLetter(ctx context.Context, msg *Message) (*Response, error) {
+	id, err := r.storeDeadLetter(ctx, msg, "no handler registered")
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		Status: 202,
+		Body: DeadLetterResponse{
+			OK:           true,
+			DeadLettered: true,
+			DeadLetterID: id,
+			Type:         msg.Type,
+		},
+	}, nil
+}
+
+// storeDeadLetter persists a message with the reason it could not be handled
+func (r *Registry) storeDeadLetter(ctx context.Context, msg *Message, reason string) (string, error) {
+	r.mu.RLock()
+	db := r.db
+	r.mu.RUnlock()
+	if db == nil {
+		return "", ErrDeadLetterNotUp
+	}
+
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	var id string
+	err = db.QueryRow(ctx,
+		`INSERT INTO public.federation_dead_letters
+		 (message_type, version, node_id, tenant_id, remote_ip, data, reason, received_at, message_id, correlation_id)
+		 SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+		 WHERE (SELECT count(*) FROM (
+		        SELECT 1 FROM public.federation_dead_letters
+		        WHERE node_id = $3 AND received_at > $11
+		        LIMIT $12) recent) < $12
+		 RETURNING id`,
+		msg.Type, msg.Version, msg.NodeID, nullIfEmpty(msg.Origin.TenantID), nullIfEmpty(msg.Origin.RemoteIP),
+		data, reason, msg.ReceivedAt, nullIfEmpty(msg.ID), nullIfEmpty(msg.CorrelationID),
+		msg.ReceivedAt.Add(-DeadLetterRetention), maxDeadLettersPerNode,
+	).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("%w: node %s has %d dead letters", ErrDeadLetterQuota, msg.NodeID, maxDeadLettersPerNode)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to store dead letter: %w", err)
+	}
+
+	log.Printf("Dead-lettered bus message type=%q from node=%s: %s", msg.Type, msg.NodeID, reason)
+	AddEvent(EventDeadLetter, msg.NodeID, msg.Origin.TenantID, map[string]interface{}{
+		"deadLetterId": id,
+		"messageType":  msg.Type,
+		"reason":       reason,
+	})
+	return id, nil
+}
+
+// DeadLetter is a bus message that no handler accepted
+type DeadLetter struct {
+	ID            string                 `json:"id"`
+	MessageID     string                 `json:"messageId,omitempty"`
+	CorrelationID string                 `json:"correlationId,omitempty"`
+	Type          string                 `json:"type"`
+	Version       int                    `json:"version"`
+	NodeID        string                 `json:"nodeId"`
+	TenantID      string                 `json:"tenantId,omitempty"`
+	RemoteIP      string                 `json:"remoteIp,omitempty"`
+	Data          map[string]interface{} `json:"data,omitempty"`
+	Reason        string                 `json:"reason"`
+	ReceivedAt    time.Time              `json:"receivedAt"`
+}
+
+// ListDeadLetters returns dead-lettered messages newest first, optionally filtered by node and type
+func (r *Registry) ListDeadLetters(ctx context.Context, nodeID, msgType string, limit int) ([]*DeadLetter, error) {
+	r.mu.RLock()
+	db := r.db
+	r.mu.RUnlock()
+	if db == nil {
+		return nil, ErrDeadLetterNotUp
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT id, COALESCE(message_id, ''), COALESCE(correlation_id, ''), message_type, version, node_id,
+		        COALESCE(tenant_id, ''), COALESCE(remote_ip, ''), data, reason, received_at
+		 FROM public.federation_dead_letters
+		 WHERE ($1 = '' OR node_id = $1) AND ($2 = '' OR message_type = $2)
+		 ORDER BY received_at DESC
+		 LIMIT $3`,
+		nodeID, msgType, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	letters := []*DeadLetter{}
+	for rows.Next() {
+		var d DeadLetter
+		var data []byte
+		if err := rows.Scan(&d.ID, &d.MessageID, &d.CorrelationID, &d.Type, &d.Version, &d.NodeID, &d.TenantID, &d.RemoteIP,
+			&data, &d.Reason, &d.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter: %w", err)
+		}
+		if len(data) > 0 {
+			_ = json.Unmarshal(data, &d.Data)
+		}
+		letters = append(letters, &d)
+	}
+	return letters, rows.Err()
+}
+
+// purgeDeadLetters deletes dead letters older than the retention window
+func (r *Registry) purgeDeadLetters(ctx context.Context, now time.Time) error {
+	r.mu.RLock()
+	db := r.db
+	r.mu.RUnlock()
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec(ctx,
+		`DELETE FROM public.federation_dead_letters WHERE received_at < $1`,
+		now.Add(-DeadLetterRetention),
+	)
+	return err
+}
@@ -0,0 +1,134 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Bus authorization
+// A bus message acts for the node named in its envelope (the token's node when omitted). Tokens may
+// only act for their own node, inside their own tenant. Gateways that legitimately forward for other
+// nodes need both the relay scope in their token and the relay capability granted by an operator,
+// and may still only relay for registered nodes of their own tenant. Every refusal is reported to
+// the RejectionAuditor and emitted as a "bus_rejected" event.
+
+// EventBusRejected is the FederationEvent type emitted when a bus message is refused by authorization
+const EventBusRejected = "bus_rejected"
+
+// ErrNodeNotAuthorized is returned when a token acts for a node it is not bound to
+var ErrNodeNotAuthorized = errors.New("federation token not authorized for this node")
+
+// RejectionAuditor records a refused bus message
+type RejectionAuditor func(ctx context.Context, msg *Message, reason string)
+
+// AuthorizeNode is middleware binding messages to the token's node and tenant
+func AuthorizeNode(audit RejectionAuditor) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+			if reason := authorizeNode(msg); reason != "" {
+				log.Printf("Rejected bus message type=%q for node=%s from token node=%s tenant=%s: %s",
+					msg.Type, msg.NodeID, msg.Origin.NodeID, msg.Origin.TenantID, reason)
+				AddEvent(EventBusRejected, msg.Origin.NodeID, msg.Origin.TenantID, map[string]interface{}{
+					"messageId":    msg.ID,
+					"messageType":  msg.Type,
+					"targetNodeId": msg.NodeID,
+					"reason":       reason,
+					"remoteIp":     msg.Origin.RemoteIP,
+				})
+				if audit != nil {
+					audit(ctx, msg, reason)
+				}
+				return nil, fmt.Errorf("%w: %s", ErrNodeNotAuthorized, reason)
+			}
+			if msg.NodeID != msg.Origin.NodeID {
+				msg.RelayedBy = msg.Origin.NodeID
+			}
+			return next.Handle(ctx, msg)
+		})
+	}
+}
+
+// authorizeNode returns why the message's origin may not act for its node ("" when allowed)
+func authorizeNode(msg *Message) string {
+	origin := msg.Origin
+	if origin.NodeID == "" || origin.TenantID == "" {
+		return "token has no node or tenant binding"
+	}
+
+	target := GetNode(msg.NodeID)
+	if target != nil && target.TenantID != "" && target.TenantID != origin.TenantID {
+		return "node belongs to another tenant"
+	}
+	if msg.NodeID == origin.NodeID {
+		return ""
+	}
+
+	// Acting for another node requires the relay scope and a current relay grant
+	if !hasString(origin.Scopes, ScopeRelay) {
+		return "token lacks the relay scope"
+	}
+	relay := GetNode(origin.NodeID)
+	if relay == nil || !relay.Relay {
+		return "relay capability not granted to this node"
+	}
+	if target == nil {
+		return "relayed node is not registered"
+	}
+	return ""
+}
+
+func hasString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+// SetNodeRelay grants or withdraws a node's relay capability; it is persisted synchronously
+func SetNodeRelay(ctx context.Context, nodeID string, enabled bool) (*NodeStatus, error) {
+	nodesMutex.Lock()
+	node, ok := nodes[nodeID]
+	if !ok {
+		nodesMutex.Unlock()
+		return nil, ErrNodeNotFound
+	}
+	if node.State == NodeDecommissioned {
+		nodesMutex.Unlock()
+		return nil, ErrNodeDecommissioned
+	}
+	previous := node.Relay
+	node.Relay = enabled
+	snapshot := *node
+	db := nodesDB
+	nodesMutex.Unlock()
+
+	if db != nil {
+		err := writeNode(ctx, db, &snapshot)
+		if err == nil {
+			_, err = db.Exec(ctx,
+				`UPDATE public.federation_nodes SET relay_enabled = $2 WHERE node_id = $1`,
+				nodeID, enabled,
+			)
+		}
+		if err != nil {
+			nodesMutex.Lock()
+			node.Relay = previous
+			nodesMutex.Unlock()
+			return nil, fmt.Errorf("failed to persist relay capability: %w", err)
+		}
+	}
+	return nodeSnapshot(&snapshot), nil
+}
+
+// SessionScopes returns the scopes to put in a new session token for a node
+func SessionScopes(nodeID string) []string {
+	scopes := []string{ScopeNode}
+	if node := GetNode(nodeID); node != nil && node.Relay {
+		scopes = append(scopes, ScopeRelay)
+	}
+	return scopes
+}
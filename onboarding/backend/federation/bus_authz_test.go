@@ -0,0 +1,92 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// withNodes replaces the node registry cache for the duration of a test
+func withNodes(t *testing.T, registered map[string]*NodeStatus) {
+	t.Helper()
+	nodesMutex.Lock()
+	saved := nodes
+	nodes = registered
+	nodesMutex.Unlock()
+	t.Cleanup(func() {
+		nodesMutex.Lock()
+		nodes = saved
+		nodesMutex.Unlock()
+	})
+}
+
+func TestAuthorizeNode(t *testing.T) {
+	withNodes(t, map[string]*NodeStatus{
+		"gateway":  {NodeID: "gateway", TenantID: "tenant-a", State: NodeOnline, Relay: true},
+		"plain":    {NodeID: "plain", TenantID: "tenant-a", State: NodeOnline},
+		"sensor":   {NodeID: "sensor", TenantID: "tenant-a", State: NodeOnline},
+		"neighbor": {NodeID: "neighbor", TenantID: "tenant-b", State: NodeOnline},
+	})
+
+	relayScopes := []string{ScopeNode, ScopeRelay}
+	tests := []struct {
+		name   string
+		target string
+		origin Origin
+		want   string
+	}{
+		{"own node", "plain", Origin{NodeID: "plain", TenantID: "tenant-a"}, ""},
+		{"own unregistered node", "fresh", Origin{NodeID: "fresh", TenantID: "tenant-a"}, ""},
+		{"unbound token", "plain", Origin{NodeID: "plain"}, "token has no node or tenant binding"},
+		{"own node ID registered to another tenant", "neighbor", Origin{NodeID: "neighbor", TenantID: "tenant-a"}, "node belongs to another tenant"},
+		{"other node without relay scope", "sensor", Origin{NodeID: "gateway", TenantID: "tenant-a"}, "token lacks the relay scope"},
+		{"relay scope without a grant", "sensor", Origin{NodeID: "plain", TenantID: "tenant-a", Scopes: relayScopes}, "relay capability not granted to this node"},
+		{"granted relay", "sensor", Origin{NodeID: "gateway", TenantID: "tenant-a", Scopes: relayScopes}, ""},
+		{"relay into another tenant", "neighbor", Origin{NodeID: "gateway", TenantID: "tenant-a", Scopes: relayScopes}, "node belongs to another tenant"},
+		{"relay for an unregistered node", "ghost", Origin{NodeID: "gateway", TenantID: "tenant-a", Scopes: relayScopes}, "relayed node is not registered"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := authorizeNode(&Message{NodeID: tt.target, Origin: tt.origin}); got != tt.want {
+				t.Fatalf("authorizeNode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeNodeMiddleware(t *testing.T) {
+	withNodes(t, map[string]*NodeStatus{
+		"gateway": {NodeID: "gateway", TenantID: "tenant-a", State: NodeOnline, Relay: true},
+		"sensor":  {NodeID: "sensor", TenantID: "tenant-a", State: NodeOnline},
+	})
+
+	var audited []string
+	var handled *Message
+	h := AuthorizeNode(func(ctx context.Context, msg *Message, reason string) {
+		audited = append(audited, reason)
+	})(HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+		handled = msg
+		return &Response{}, nil
+	}))
+
+	relayed := &Message{Type: "heartbeat", NodeID: "sensor",
+		Origin: Origin{NodeID: "gateway", TenantID: "tenant-a", Scopes: []string{ScopeNode, ScopeRelay}}}
+	if _, err := h.Handle(context.Background(), relayed); err != nil {
+		t.Fatalf("relayed message: %v", err)
+	}
+	if handled != relayed || relayed.RelayedBy != "gateway" {
+		t.Fatalf("relayed message not handled as relayed: %+v", handled)
+	}
+
+	handled = nil
+	refused := &Message{Type: "heartbeat", NodeID: "sensor", Origin: Origin{NodeID: "gateway", TenantID: "tenant-a"}}
+	if _, err := h.Handle(context.Background(), refused); !errors.Is(err, ErrNodeNotAuthorized) {
+		t.Fatalf("refused message: got %v, want ErrNodeNotAuthorized", err)
+	}
+	if handled != nil {
+		t.Fatal("refused message reached the handler")
+	}
+	if len(audited) != 1 || audited[0] != "token lacks the relay scope" {
+		t.Fatalf("audited = %v", audited)
+	}
+}
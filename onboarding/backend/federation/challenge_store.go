@@ -0,0 +1,327 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Handshake challenge store
+// Challenges are keyed by their own value (not by node), carry the nonce they were issued with,
+// and are consumed exactly once. The store is an interface so multiple backend replicas can
+// share challenges through Postgres. Outstanding challenges are capped per node, and challenge
+// requests are rate-limited per source address.
+
+// ChallengeTTL is how long a handshake challenge may be asserted
+const ChallengeTTL = 30 * time.Second
+
+// DefaultMaxChallengesPerNode caps outstanding challenges per node
+const DefaultMaxChallengesPerNode = 3
+
+// MaxMemoryChallenges caps the challenges held by a MemoryChallengeStore
+const MaxMemoryChallenges = 10000
+
+// MaxTrackedSources caps the source addresses a SourceRateLimiter keeps counts for
+const MaxTrackedSources = 10000
+
+// DefaultChallengesPerSource caps challenges issued to one source address per ChallengeRateWindow
+const DefaultChallengesPerSource = 10
+
+// ChallengeRateWindow is the window of the per-source challenge rate limit
+const ChallengeRateWindow = time.Minute
+
+// Challenge store errors
+var (
+	ErrChallengeNotFound  = errors.New("handshake challenge not found or already used")
+	ErrTooManyChallenges  = errors.New("too many outstanding handshake challenges for node")
+	ErrChallengeStoreFull = errors.New("handshake challenge store is full")
+)
+
+// HandshakeChallenge is an outstanding handshake challenge
+type HandshakeChallenge struct {
+	Challenge   string
+	Nonce       string
+	NodeID      string
+	TenantID    string
+	Fingerprint string
+	Algo        string
+	NodeKey     *NodeKey // nil in legacy HMAC mode
+	Source      string   // client address the challenge was issued to
+	IssuedAt    time.Time
+	ExpiresAt   time.Time
+}
+
+// Expired reports whether the challenge can no longer be asserted
+func (c *HandshakeChallenge) Expired(now time.Time) bool {
+	return now.After(c.ExpiresAt)
+}
+
+// ChallengeStore holds outstanding handshake challenges
+type ChallengeStore interface {
+	// Create stores a challenge, failing with ErrTooManyChallenges when the node already
+	// has maxPerNode unexpired challenges outstanding
+	Create(ctx context.Context, c *HandshakeChallenge, maxPerNode int) error
+	// Consume atomically removes and returns a node's challenge (single use).
+	// An empty challenge consumes the newest legacy HMAC challenge issued to the node at
+	// source (legacy clients never echo the challenge); key-based challenges must be named.
+	Consume(ctx context.Context, nodeID, challenge, source string) (*HandshakeChallenge, error)
+	// PurgeExpired deletes expired challenges and returns how many were removed
+	PurgeExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// MemoryChallengeStore keeps challenges in process memory (single replica)
+// At most max challenges are held; when full, expired ones are dropped before refusing new ones.
+type MemoryChallengeStore struct {
+	mu         sync.Mutex
+	max        int
+	challenges map[string]*HandshakeChallenge
+}
+
+// NewMemoryChallengeStore creates an empty in-memory challenge store holding up to MaxMemoryChallenges
+func NewMemoryChallengeStore() *MemoryChallengeStore {
+	return &MemoryChallengeStore{max: MaxMemoryChallenges, challenges: make(map[string]*HandshakeChallenge)}
+}
+
+// Create stores the challenge, enforcing the per-node limit and the store's capacity
+func (s *MemoryChallengeStore) Create(ctx context.Context, c *HandshakeChallenge, maxPerNode int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.challenges[c.Challenge]; exists {
+		return errors.New("duplicate handshake challenge")
+	}
+
+	now := time.Now()
+	if s.max > 0 && len(s.challenges) >= s.max {
+		s.purgeExpiredLocked(now)
+		if len(s.challenges) >= s.max {
+			return ErrChallengeStoreFull
+		}
+	}
+
+	outstanding := 0
+	for _, existing := range s.challenges {
+		if existing.NodeID == c.NodeID && !existing.Expired(now) {
+			outstanding++
+		}
+	}
+	if maxPerNode > 0 && outstanding >= maxPerNode {
+		return ErrTooManyChallenges
+	}
+
+	s.challenges[c.Challenge] = c
+	return nil
+}
+
+// Consume removes and returns the node's challenge
+func (s *MemoryChallengeStore) Consume(ctx context.Context, nodeID, challenge, source string) (*HandshakeChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if challenge == "" {
+		var newest *HandshakeChallenge
+		for _, c := range s.challenges {
+			if c.NodeID == nodeID && c.Algo == NodeKeyHMAC && c.Source == source &&
+				(newest == nil || c.IssuedAt.After(newest.IssuedAt)) {
+				newest = c
+			}
+		}
+		if newest == nil {
+			return nil, ErrChallengeNotFound
+		}
+		challenge = newest.Challenge
+	}
+
+	c, ok := s.challenges[challenge]
+	if !ok || c.NodeID != nodeID {
+		return nil, ErrChallengeNotFound
+	}
+	delete(s.challenges, challenge)
+	return c, nil
+}
+
+// PurgeExpired deletes expired challenges
+func (s *MemoryChallengeStore) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.purgeExpiredLocked(now), nil
+}
+
+func (s *MemoryChallengeStore) purgeExpiredLocked(now time.Time) int {
+	removed := 0
+	for key, c := range s.challenges {
+		if c.Expired(now) {
+			delete(s.challenges, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// PostgresChallengeStore shares challenges across replicas via public.federation_handshake_challenges
+type PostgresChallengeStore struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresChallengeStore creates a challenge store backed by the given pool
+func NewPostgresChallengeStore(db *pgxpool.Pool) *PostgresChallengeStore {
+	return &PostgresChallengeStore{db: db}
+}
+
+// Create stores the challenge; a per-node advisory lock serializes the limit check
+func (s *PostgresChallengeStore) Create(ctx context.Context, c *HandshakeChallenge, maxPerNode int) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "federation-challenge:"+c.NodeID); err != nil {
+		return fmt.Errorf("failed to lock node challenges: %w", err)
+	}
+
+	if maxPerNode > 0 {
+		var outstanding int
+		err := tx.QueryRow(ctx,
+			`SELECT COUNT(*) FROM public.federation_handshake_challenges
+			 WHERE node_id = $1 AND expires_at > $2`,
+			c.NodeID, time.Now(),
+		).Scan(&outstanding)
+		if err != nil {
+			return fmt.Errorf("failed to count challenges: %w", err)
+		}
+		if outstanding >= maxPerNode {
+			return ErrTooManyChallenges
+		}
+	}
+
+	var keyAlgo *string
+	var keyBytes []byte
+	if c.NodeKey != nil {
+		keyAlgo = &c.NodeKey.Algorithm
+		keyBytes = c.NodeKey.PublicKey
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO public.federation_handshake_challenges
+		 (challenge, nonce, node_id, tenant_id, fingerprint, algo, key_algorithm, public_key, source, issued_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		c.Challenge, c.Nonce, c.NodeID, c.TenantID, c.Fingerprint, c.Algo,
+		keyAlgo, keyBytes, c.Source, c.IssuedAt, c.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store challenge: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Consume deletes and returns the node's challenge in a single statement
+func (s *PostgresChallengeStore) Consume(ctx context.Context, nodeID, challenge, source string) (*HandshakeChallenge, error) {
+	c := HandshakeChallenge{NodeID: nodeID}
+	var keyAlgo *string
+	var keyBytes []byte
+
+	err := s.db.QueryRow(ctx,
+		`DELETE FROM public.federation_handshake_challenges
+		 WHERE challenge = (
+		     SELECT challenge FROM public.federation_handshake_challenges
+		     WHERE node_id = $1
+		       AND (challenge = $2 OR ($2 = '' AND algo = $3 AND source = $4))
+		     ORDER BY issued_at DESC
+		     LIMIT 1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING challenge, nonce, tenant_id, fingerprint, algo, key_algorithm, public_key, source, issued_at, expires_at`,
+		nodeID, challenge, NodeKeyHMAC, source,
+	).Scan(&c.Challenge, &c.Nonce, &c.TenantID, &c.Fingerprint, &c.Algo, &keyAlgo, &keyBytes, &c.Source, &c.IssuedAt, &c.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrChallengeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume challenge: %w", err)
+	}
+
+	if keyAlgo != nil {
+		c.NodeKey = &NodeKey{NodeID: nodeID, TenantID: c.TenantID, Algorithm: *keyAlgo, PublicKey: keyBytes}
+	}
+	return &c, nil
+}
+
+// PurgeExpired deletes expired challenges
+func (s *PostgresChallengeStore) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM public.federation_handshake_challenges WHERE expires_at < $1`,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge challenges: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// SourceRateLimiter limits how many challenges one source address may request per window
+// Counts are kept per replica; the per-node cap of the store still applies across replicas.
+// At most MaxTrackedSources sources are tracked; beyond that new sources are refused until windows end.
+type SourceRateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*sourceWindow
+}
+
+type sourceWindow struct {
+	start time.Time
+	count int
+}
+
+// NewSourceRateLimiter allows limit requests per source in each window (limit <= 0 = unlimited)
+func NewSourceRateLimiter(limit int, window time.Duration) *SourceRateLimiter {
+	return &SourceRateLimiter{limit: limit, window: window, windows: make(map[string]*sourceWindow)}
+}
+
+// Allow counts a request from source and reports whether it is within the limit
+func (l *SourceRateLimiter) Allow(source string, now time.Time) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[source]
+	if !ok && len(l.windows) >= MaxTrackedSources {
+		l.purgeLocked(now)
+		if len(l.windows) >= MaxTrackedSources {
+			return false
+		}
+	}
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &sourceWindow{start: now}
+		l.windows[source] = w
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// Purge drops the counts of sources whose window has ended
+func (l *SourceRateLimiter) Purge(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.purgeLocked(now)
+}
+
+func (l *SourceRateLimiter) purgeLocked(now time.Time) {
+	for source, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, source)
+		}
+	}
+}
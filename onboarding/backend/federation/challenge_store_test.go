@@ -0,0 +1,146 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestChallenge(challenge, nodeID, algo, source string, issuedAt time.Time) *HandshakeChallenge {
+	return &HandshakeChallenge{
+		Challenge: challenge,
+		Nonce:     "nonce-" + challenge,
+		NodeID:    nodeID,
+		TenantID:  "tenant-1",
+		Algo:      algo,
+		Source:    source,
+		IssuedAt:  issuedAt,
+		ExpiresAt: issuedAt.Add(ChallengeTTL),
+	}
+}
+
+func TestMemoryChallengeStoreLimitPerNode(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryChallengeStore()
+	now := time.Now()
+
+	for i, c := range []string{"a", "b", "c"} {
+		if err := s.Create(ctx, newTestChallenge(c, "node-1", NodeKeyEd25519, "10.0.0.1", now.Add(time.Duration(i))), 3); err != nil {
+			t.Fatalf("Create(%s): %v", c, err)
+		}
+	}
+	if err := s.Create(ctx, newTestChallenge("d", "node-1", NodeKeyEd25519, "10.0.0.1", now), 3); !errors.Is(err, ErrTooManyChallenges) {
+		t.Fatalf("fourth challenge from the same source: got %v, want ErrTooManyChallenges", err)
+	}
+	if err := s.Create(ctx, newTestChallenge("e", "node-1", NodeKeyEd25519, "10.0.0.2", now), 3); !errors.Is(err, ErrTooManyChallenges) {
+		t.Fatalf("fourth challenge from another source: got %v, want ErrTooManyChallenges", err)
+	}
+	if err := s.Create(ctx, newTestChallenge("f", "node-2", NodeKeyEd25519, "10.0.0.1", now), 3); err != nil {
+		t.Fatalf("challenge for another node: %v", err)
+	}
+	if err := s.Create(ctx, newTestChallenge("a", "node-2", NodeKeyEd25519, "10.0.0.3", now), 3); err == nil {
+		t.Fatal("duplicate challenge value: want error")
+	}
+}
+
+func TestMemoryChallengeStoreCapacity(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryChallengeStore()
+	s.max = 2
+	now := time.Now()
+
+	stale := newTestChallenge("stale", "node-1", NodeKeyEd25519, "10.0.0.1", now.Add(-2*ChallengeTTL))
+	for _, c := range []*HandshakeChallenge{stale, newTestChallenge("a", "node-2", NodeKeyEd25519, "10.0.0.1", now)} {
+		if err := s.Create(ctx, c, 0); err != nil {
+			t.Fatalf("Create(%s): %v", c.Challenge, err)
+		}
+	}
+	// A full store drops expired challenges to make room
+	if err := s.Create(ctx, newTestChallenge("b", "node-3", NodeKeyEd25519, "10.0.0.1", now), 0); err != nil {
+		t.Fatalf("Create with an expired challenge to drop: %v", err)
+	}
+	if err := s.Create(ctx, newTestChallenge("c", "node-4", NodeKeyEd25519, "10.0.0.1", now), 0); !errors.Is(err, ErrChallengeStoreFull) {
+		t.Fatalf("Create on a full store: got %v, want ErrChallengeStoreFull", err)
+	}
+}
+
+func TestMemoryChallengeStoreConsume(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		nodeID    string
+		challenge string
+		source    string
+		want      string // consumed challenge, "" = ErrChallengeNotFound
+	}{
+		{"exact key challenge", "node-1", "key", "10.0.0.9", "key"},
+		{"exact challenge of another node", "node-2", "key", "10.0.0.1", ""},
+		{"unknown challenge", "node-1", "nope", "10.0.0.1", ""},
+		{"legacy: newest HMAC challenge of the source", "node-1", "", "10.0.0.1", "hmac-new"},
+		{"legacy: HMAC challenge of another source", "node-1", "", "10.0.0.2", "hmac-other"},
+		{"legacy: never a key challenge", "node-3", "", "10.0.0.1", ""},
+		{"legacy: nothing issued to the source", "node-1", "", "10.0.0.7", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewMemoryChallengeStore()
+			for _, c := range []*HandshakeChallenge{
+				newTestChallenge("key", "node-1", NodeKeyEd25519, "10.0.0.1", now.Add(3*time.Second)),
+				newTestChallenge("hmac-old", "node-1", NodeKeyHMAC, "10.0.0.1", now),
+				newTestChallenge("hmac-new", "node-1", NodeKeyHMAC, "10.0.0.1", now.Add(time.Second)),
+				newTestChallenge("hmac-other", "node-1", NodeKeyHMAC, "10.0.0.2", now.Add(2*time.Second)),
+				newTestChallenge("key-3", "node-3", NodeKeyEd25519, "10.0.0.1", now),
+			} {
+				if err := s.Create(ctx, c, 0); err != nil {
+					t.Fatalf("Create(%s): %v", c.Challenge, err)
+				}
+			}
+
+			got, err := s.Consume(ctx, tt.nodeID, tt.challenge, tt.source)
+			if tt.want == "" {
+				if !errors.Is(err, ErrChallengeNotFound) {
+					t.Fatalf("Consume() = %v, %v; want ErrChallengeNotFound", got, err)
+				}
+				return
+			}
+			if err != nil || got.Challenge != tt.want {
+				t.Fatalf("Consume() = %v, %v; want %s", got, err, tt.want)
+			}
+			if _, err := s.Consume(ctx, got.NodeID, got.Challenge, tt.source); !errors.Is(err, ErrChallengeNotFound) {
+				t.Fatalf("second Consume(%s) = %v, want ErrChallengeNotFound", got.Challenge, err)
+			}
+		})
+	}
+}
+
+func TestSourceRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewSourceRateLimiter(2, time.Minute)
+
+	steps := []struct {
+		source string
+		at     time.Duration
+		want   bool
+	}{
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", time.Second, true},
+		{"10.0.0.1", 2 * time.Second, false},
+		{"10.0.0.2", 2 * time.Second, true},
+		{"10.0.0.1", time.Minute, true},
+	}
+	for i, step := range steps {
+		if got := l.Allow(step.source, now.Add(step.at)); got != step.want {
+			t.Fatalf("step %d: Allow(%s) = %v, want %v", i, step.source, got, step.want)
+		}
+	}
+
+	l.Purge(now.Add(2 * time.Minute))
+	if len(l.windows) != 0 {
+		t.Fatalf("Purge left %d window(s)", len(l.windows))
+	}
+	if !NewSourceRateLimiter(0, time.Minute).Allow("10.0.0.1", now) {
+		t.Fatal("unlimited limiter refused a request")
+	}
+}
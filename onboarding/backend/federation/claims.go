@@ -0,0 +1,139 @@
+package federation
+
+import (
+	"errors"
+	"time"
+)
+
+// Federation token claims: lifetime, audience and scopes
+// A token is only accepted by the API surface named in its audience, and only inside its validity window
+
+// Token audiences
+const (
+	AudienceBus      = "federation.bus"       // /federation/bus
+	AudienceAgents   = "federation.agents"    // /api/federation/agents/*
+	AudienceNodeJoin = "federation.node.join" // /api/federation/auth/node/join
+	AudienceAPI      = "federation.api"       // protected /api/federation/* APIs
+)
+
+// Token scopes
+const (
+	ScopeNode  = "node"  // Acts as a federation node
+	ScopeRelay = "relay" // May forward bus messages for other nodes of its tenant (gateway)
+)
+
+// Token lifetimes
+const (
+	// SessionTokenTTL is the lifetime of tokens issued by the handshake and node join
+	SessionTokenTTL = time.Hour
+	// BootstrapTokenTTL is the lifetime of join tokens baked into bootstrap kits
+	BootstrapTokenTTL = 7 * 24 * time.Hour
+	// clockSkew tolerates small clock differences between backend replicas and nodes
+	clockSkew = 30 * time.Second
+)
+
+// Claim validation errors
+var (
+	ErrTokenExpired     = errors.New("federation token expired")
+	ErrTokenNotYetValid = errors.New("federation token not yet valid")
+	ErrWrongAudience    = errors.New("federation token not valid for this audience")
+)
+
+// SessionAudiences are the audiences granted to node session tokens
+var SessionAudiences = []string{AudienceBus, AudienceAgents, AudienceAPI}
+
+// NewTokenPayload builds a payload valid from now for the given lifetime
+func NewTokenPayload(nodeID, tenantID, fingerprint string, ttl time.Duration, audience, scopes []string) FederationTokenPayload {
+	now := time.Now()
+	return FederationTokenPayload{
+		NodeID:      nodeID,
+		TenantID:    tenantID,
+		Fingerprint: fingerprint,
+		IssuedAt:    now.UnixMilli(),
+		NotBefore:   now.UnixMilli(),
+		ExpiresAt:   now.Add(ttl).UnixMilli(),
+		Audience:    audience,
+		Scopes:      scopes,
+	}
+}
+
+// HasAudience reports whether the token was issued for the given audience
+func (p *FederationTokenPayload) HasAudience(audience string) bool {
+	for _, a := range p.Audience {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the token carries the given scope
+func (p *FederationTokenPayload) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RemainingLifetime returns how long the token stays valid (zero once expired)
+func (p *FederationTokenPayload) RemainingLifetime(now time.Time) time.Duration {
+	remaining := time.UnixMilli(p.ExpiresAt).Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// ValidateTime checks the not-before and expiry claims
+// Tokens without an expiry (minted before claims existed) are treated as expired
+func (p *FederationTokenPayload) ValidateTime(now time.Time) error {
+	if p.ExpiresAt == 0 || now.After(time.UnixMilli(p.ExpiresAt).Add(clockSkew)) {
+		return ErrTokenExpired
+	}
+	if p.NotBefore != 0 && now.Add(clockSkew).Before(time.UnixMilli(p.NotBefore)) {
+		return ErrTokenNotYetValid
+	}
+	return nil
+}
+
+// ValidateClaims checks the validity window and, when audience is non-empty, the audience
+func (p *FederationTokenPayload) ValidateClaims(audience string, now time.Time) error {
+	if err := p.ValidateTime(now); err != nil {
+		return err
+	}
+	if audience != "" && !p.HasAudience(audience) {
+		return ErrWrongAudience
+	}
+	return nil
+}
+
+// VerifyFederationTokenFor verifies the token and additionally requires the given audience
+func VerifyFederationTokenFor(token, audience string) (*FederationTokenPayload, error) {
+	payload, err := VerifyFederationToken(token)
+	if err != nil {
+		return payload, err
+	}
+	if !payload.HasAudience(audience) {
+		return payload, ErrWrongAudience
+	}
+	return payload, nil
+}
+
+// TokenErrorCode maps a claim validation or revocation error to its API error code
+// Returns "" for errors without a dedicated code (bad format, bad signature)
+func TokenErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		return "TOKEN_EXPIRED"
+	case errors.Is(err, ErrTokenNotYetValid):
+		return "TOKEN_NOT_YET_VALID"
+	case errors.Is(err, ErrWrongAudience):
+		return "WRONG_AUDIENCE"
+	case errors.Is(err, ErrTokenRevoked):
+		return "TOKEN_REVOKED"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,78 @@
+package federation
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateTime(t *testing.T) {
+	now := time.Now()
+	ms := func(d time.Duration) int64 { return now.Add(d).UnixMilli() }
+
+	tests := []struct {
+		name      string
+		notBefore int64
+		expiresAt int64
+		want      error
+	}{
+		{"valid", ms(-time.Minute), ms(time.Hour), nil},
+		{"no not-before", 0, ms(time.Hour), nil},
+		{"no expiry", ms(-time.Minute), 0, ErrTokenExpired},
+		{"expired", ms(-2 * time.Hour), ms(-time.Hour), ErrTokenExpired},
+		{"expired within skew", ms(-time.Hour), ms(-clockSkew / 2), nil},
+		{"expired beyond skew", ms(-time.Hour), ms(-2 * clockSkew), ErrTokenExpired},
+		{"not yet valid", ms(time.Hour), ms(2 * time.Hour), ErrTokenNotYetValid},
+		{"not yet valid within skew", ms(clockSkew / 2), ms(time.Hour), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &FederationTokenPayload{NotBefore: tt.notBefore, ExpiresAt: tt.expiresAt}
+			if err := p.ValidateTime(now); !errors.Is(err, tt.want) {
+				t.Fatalf("ValidateTime() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	now := time.Now()
+	p := NewTokenPayload("node-1", "tenant-1", "fp", time.Hour, []string{AudienceBus}, nil)
+
+	tests := []struct {
+		name     string
+		audience string
+		now      time.Time
+		want     error
+	}{
+		{"matching audience", AudienceBus, now, nil},
+		{"any audience", "", now, nil},
+		{"wrong audience", AudienceAgents, now, ErrWrongAudience},
+		{"expired before audience check", AudienceAgents, now.Add(2 * time.Hour), ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.ValidateClaims(tt.audience, tt.now); !errors.Is(err, tt.want) {
+				t.Fatalf("ValidateClaims() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{ErrTokenExpired, "TOKEN_EXPIRED"},
+		{ErrTokenNotYetValid, "TOKEN_NOT_YET_VALID"},
+		{ErrWrongAudience, "WRONG_AUDIENCE"},
+		{ErrTokenRevoked, "TOKEN_REVOKED"},
+		{errors.New("invalid signature"), ""},
+	}
+	for _, tt := range tests {
+		if got := TokenErrorCode(tt.err); got != tt.want {
+			t.Errorf("TokenErrorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,567 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Agent command queue backed by public.federation_commands
+// Operators enqueue commands for a node (optionally a specific agent); agents pull them,
+// acknowledge delivery and report completion. Every state change emits a "command_state" event.
+// Each command spawns a job (jobs.go) that tracks execution, retries and timeouts.
+
+// CommandStatus is a command lifecycle state
+type CommandStatus string
+
+const (
+	CommandQueued       CommandStatus = "queued"
+	CommandDelivered    CommandStatus = "delivered"
+	CommandAcknowledged CommandStatus = "acknowledged"
+	CommandCompleted    CommandStatus = "completed"
+	CommandFailed       CommandStatus = "failed"
+	CommandExpired      CommandStatus = "expired"
+	CommandCancelled    CommandStatus = "cancelled"
+)
+
+// EventCommandState is the FederationEvent type emitted on every command state change
+const EventCommandState = "command_state"
+
+// Command queue defaults
+const (
+	DefaultCommandTTL      = time.Hour
+	MaxCommandTTL          = 7 * 24 * time.Hour
+	CommandDeliveryLease   = 60 * time.Second // delivered commands must be acknowledged within this
+	MaxCommandPollWait     = 30 * time.Second
+	commandSweepInterval   = 5 * time.Second
+	commandPollRecheck     = 2 * time.Second // long-poll recheck for commands enqueued on other replicas
+	maxCommandsPerPull     = 50
+	defaultCommandsPerPull = 10
+)
+
+// Command queue errors
+var (
+	ErrCommandNotFound   = errors.New("federation command not found")
+	ErrCommandState      = errors.New("federation command is not in a valid state for this operation")
+	ErrCommandQueueNotUp = errors.New("federation command queue not initialized")
+)
+
+// Command is a unit of work for an agent
+type Command struct {
+	ID             string                 `json:"id"`
+	NodeID         string                 `json:"nodeId"`
+	AgentID        string                 `json:"agentId,omitempty"`
+	TenantID       string                 `json:"tenantId,omitempty"`
+	Type           string                 `json:"type"`
+	Payload        map[string]interface{} `json:"payload,omitempty"`
+	Priority       int                    `json:"priority"`
+	IdempotencyKey string                 `json:"idempotencyKey,omitempty"`
+	Status         CommandStatus          `json:"status"`
+	Attempts       int                    `json:"attempts"`
+	Result         map[string]interface{} `json:"result,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+	CreatedBy      string                 `json:"createdBy"`
+	CreatedAt      time.Time              `json:"createdAt"`
+	ExpiresAt      time.Time              `json:"expiresAt"`
+	DeliveredAt    *time.Time             `json:"deliveredAt,omitempty"`
+	AcknowledgedAt *time.Time             `json:"acknowledgedAt,omitempty"`
+	CompletedAt    *time.Time             `json:"completedAt,omitempty"`
+}
+
+// CommandRequest is an operator's request to enqueue a command
+type CommandRequest struct {
+	NodeID         string                 `json:"nodeId"`
+	AgentID        string                 `json:"agentId"`
+	TenantID       string                 `json:"tenantId"`
+	Type           string                 `json:"type"`
+	Payload        map[string]interface{} `json:"payload"`
+	Priority       int                    `json:"priority"`
+	TTLSeconds     int                    `json:"ttlSeconds"`
+	IdempotencyKey string                 `json:"idempotencyKey"`
+	// Job retry policy (see jobs.go)
+	MaxAttempts         int `json:"maxAttempts"`
+	TimeoutSeconds      int `json:"timeoutSeconds"`
+	RetryBackoffSeconds int `json:"retryBackoffSeconds"`
+}
+
+// CommandQueue is the persistent command queue
+type CommandQueue struct {
+	db *pgxpool.Pool
+
+	mu      sync.Mutex
+	waiters map[string][]chan struct{} // node ID -> long-poll waiters on this replica
+}
+
+var commandQueue *CommandQueue
+
+// querier is satisfied by both *pgxpool.Pool and pgx.Tx
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// InitCommandQueue attaches the queue to Postgres and starts the lease/TTL and job timeout sweeper
+func InitCommandQueue(ctx context.Context, db *pgxpool.Pool) error {
+	cq := &CommandQueue{db: db, waiters: make(map[string][]chan struct{})}
+	if err := cq.sweep(ctx, time.Now()); err != nil {
+		return err
+	}
+	commandQueue = cq
+
+	ticker := time.NewTicker(commandSweepInterval)
+	go func() {
+		for range ticker.C {
+			if err := cq.sweep(context.Background(), time.Now()); err != nil {
+				log.Printf("Federation command sweep failed: %v", err)
+			}
+			if err := sweepJobs(context.Background(), time.Now()); err != nil {
+				log.Printf("Federation job sweep failed: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+const commandColumns = `id, node_id, COALESCE(agent_id, ''), COALESCE(tenant_id, ''), command_type, payload, priority,
+	COALESCE(idempotency_key, ''), status, attempts, result, COALESCE(error, ''), created_by, created_at, expires_at,
+	delivered_at, acknowledged_at, completed_at`
+
+// scanCommand scans commandColumns, after any extra leading destinations
+func scanCommand(row pgx.Row, extra ...interface{}) (*Command, error) {
+	var c Command
+	var payload, result []byte
+	err := row.Scan(append(extra, &c.ID, &c.NodeID, &c.AgentID, &c.TenantID, &c.Type, &payload, &c.Priority,
+		&c.IdempotencyKey, &c.Status, &c.Attempts, &result, &c.Error, &c.CreatedBy, &c.CreatedAt, &c.ExpiresAt,
+		&c.DeliveredAt, &c.AcknowledgedAt, &c.CompletedAt)...)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &c.Payload); err != nil {
+			log.Printf("Failed to unmarshal payload for command %s: %v", c.ID, err)
+		}
+	}
+	if len(result) > 0 {
+		if err := json.Unmarshal(result, &c.Result); err != nil {
+			log.Printf("Failed to unmarshal result for command %s: %v", c.ID, err)
+		}
+	}
+	return &c, nil
+}
+
+func scanCommands(rows pgx.Rows) ([]*Command, error) {
+	defer rows.Close()
+	commands := []*Command{}
+	for rows.Next() {
+		c, err := scanCommand(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan command: %w", err)
+		}
+		commands = append(commands, c)
+	}
+	return commands, rows.Err()
+}
+
+// emitCommandState publishes a command state change
+func emitCommandState(c *Command, from CommandStatus) {
+	data := map[string]interface{}{
+		"commandId":   c.ID,
+		"commandType": c.Type,
+		"from":        string(from),
+		"to":          string(c.Status),
+		"attempts":    c.Attempts,
+	}
+	if c.AgentID != "" {
+		data["agentId"] = c.AgentID
+	}
+	if c.Error != "" {
+		data["error"] = c.Error
+	}
+	AddEvent(EventCommandState, c.NodeID, c.TenantID, data)
+}
+
+// EnqueueCommand adds a command to a node's queue. A repeated idempotency key for the same
+// node returns the existing command with created=false.
+func EnqueueCommand(ctx context.Context, req CommandRequest, createdBy string) (*Command, bool, error) {
+	if commandQueue == nil {
+		return nil, false, ErrCommandQueueNotUp
+	}
+	if req.NodeID == "" || req.Type == "" {
+		return nil, false, errors.New("nodeId and type are required")
+	}
+
+	ttl := DefaultCommandTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl > MaxCommandTTL {
+		return nil, false, fmt.Errorf("ttlSeconds exceeds maximum of %d", int(MaxCommandTTL.Seconds()))
+	}
+	policy, err := newJobPolicy(req)
+	if err != nil {
+		return nil, false, err
+	}
+	if req.TenantID == "" {
+		if node := GetNode(req.NodeID); node != nil {
+			req.TenantID = node.TenantID
+		}
+	}
+
+	var payload []byte
+	if req.Payload != nil {
+		var err error
+		if payload, err = json.Marshal(req.Payload); err != nil {
+			return nil, false, fmt.Errorf("invalid payload: %w", err)
+		}
+	}
+
+	tx, err := commandQueue.db.Begin(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	c, err := scanCommand(tx.QueryRow(ctx,
+		`INSERT INTO public.federation_commands
+		 (node_id, agent_id, tenant_id, command_type, payload, priority, idempotency_key, status,
+		  created_by, created_at, expires_at, updated_at)
+		 VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6, NULLIF($7, ''), 'queued', $8, $9, $10, $9)
+		 ON CONFLICT (node_id, idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING
+		 RETURNING `+commandColumns,
+		req.NodeID, req.AgentID, req.TenantID, req.Type, payload, req.Priority, req.IdempotencyKey,
+		createdBy, now, now.Add(ttl),
+	))
+	if errors.Is(err, pgx.ErrNoRows) && req.IdempotencyKey != "" {
+		existing, err := scanCommand(tx.QueryRow(ctx,
+			`SELECT `+commandColumns+` FROM public.federation_commands
+			 WHERE node_id = $1 AND idempotency_key = $2`,
+			req.NodeID, req.IdempotencyKey,
+		))
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to load idempotent command: %w", err)
+		}
+		return existing, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to enqueue command: %w", err)
+	}
+
+	job, err := createJob(ctx, tx, c, policy)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, fmt.Errorf("failed to enqueue command: %w", err)
+	}
+
+	emitCommandState(c, "")
+	emitJobState(job, "")
+	commandQueue.notify(c.NodeID)
+	return c, true, nil
+}
+
+// PullCommands delivers up to max queued commands to an agent, highest priority first.
+// With wait > 0 it long-polls until a command arrives, wait elapses or ctx is done.
+func PullCommands(ctx context.Context, nodeID, agentID string, max int, wait time.Duration) ([]*Command, error) {
+	if commandQueue == nil {
+		return nil, ErrCommandQueueNotUp
+	}
+	if max <= 0 {
+		max = defaultCommandsPerPull
+	}
+	if max > maxCommandsPerPull {
+		max = maxCommandsPerPull
+	}
+	if wait > MaxCommandPollWait {
+		wait = MaxCommandPollWait
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		// Register before claiming so an enqueue between the two is not missed
+		notify := commandQueue.wait(nodeID)
+		commands, err := commandQueue.claim(ctx, nodeID, agentID, max)
+		if err != nil || len(commands) > 0 {
+			commandQueue.unwait(nodeID, notify)
+			return commands, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			commandQueue.unwait(nodeID, notify)
+			return commands, nil
+		}
+		if remaining > commandPollRecheck {
+			remaining = commandPollRecheck
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			commandQueue.unwait(nodeID, notify)
+			return []*Command{}, nil
+		case <-notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+		commandQueue.unwait(nodeID, notify)
+	}
+}
+
+// claim atomically moves queued commands to delivered with a lease
+func (cq *CommandQueue) claim(ctx context.Context, nodeID, agentID string, max int) ([]*Command, error) {
+	now := time.Now()
+	rows, err := cq.db.Query(ctx,
+		`UPDATE public.federation_commands
+		 SET status = 'delivered', attempts = attempts + 1, delivered_at = $4, lease_expires_at = $5, updated_at = $4
+		 WHERE id IN (
+		     SELECT id FROM public.federation_commands
+		     WHERE node_id = $1 AND status = 'queued' AND expires_at > $4
+		       AND (not_before IS NULL OR not_before <= $4)
+		       AND (agent_id IS NULL OR agent_id = NULLIF($2, ''))
+		     ORDER BY priority DESC, created_at
+		     LIMIT $3
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+commandColumns,
+		nodeID, agentID, max, now, now.Add(CommandDeliveryLease),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim commands: %w", err)
+	}
+	commands, err := scanCommands(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		if commands[i].Priority != commands[j].Priority {
+			return commands[i].Priority > commands[j].Priority
+		}
+		return commands[i].CreatedAt.Before(commands[j].CreatedAt)
+	})
+	for _, c := range commands {
+		emitCommandState(c, CommandQueued)
+	}
+	return commands, nil
+}
+
+// AcknowledgeCommand records that the node's agent received the command
+func AcknowledgeCommand(ctx context.Context, nodeID, commandID string) (*Command, error) {
+	return transitionCommand(ctx, nodeID, commandID, CommandAcknowledged,
+		[]CommandStatus{CommandDelivered},
+		`acknowledged_at = $3, lease_expires_at = NULL`, nil, "")
+}
+
+// transitionCommand moves a node's command between states and emits the change
+func transitionCommand(ctx context.Context, nodeID, commandID string, to CommandStatus, from []CommandStatus,
+	set string, result map[string]interface{}, errMsg string) (*Command, error) {
+	if commandQueue == nil {
+		return nil, ErrCommandQueueNotUp
+	}
+	c, prev, err := updateCommand(ctx, commandQueue.db, nodeID, commandID, to, from, set, result, errMsg)
+	if err != nil {
+		return nil, err
+	}
+	emitCommandState(c, prev)
+	return c, nil
+}
+
+// updateCommand moves a node's command from one of the given states to another and returns it
+// with its previous state; it does not emit events so it can run inside a job transaction.
+// set may reference $3 (now) and extra arguments from $8 on.
+func updateCommand(ctx context.Context, q querier, nodeID, commandID string, to CommandStatus, from []CommandStatus,
+	set string, result map[string]interface{}, errMsg string, extra ...interface{}) (*Command, CommandStatus, error) {
+	if _, err := uuid.Parse(commandID); err != nil {
+		return nil, "", ErrCommandNotFound
+	}
+
+	var resultJSON []byte
+	if result != nil {
+		var err error
+		if resultJSON, err = json.Marshal(result); err != nil {
+			return nil, "", fmt.Errorf("invalid result: %w", err)
+		}
+	}
+	fromStates := make([]string, len(from))
+	for i, s := range from {
+		fromStates[i] = string(s)
+	}
+
+	var prev CommandStatus
+	args := append([]interface{}{commandID, nodeID, time.Now(), string(to), resultJSON, errMsg, fromStates}, extra...)
+	c, err := scanCommand(q.QueryRow(ctx,
+		`WITH prev AS (
+		     SELECT id AS prev_id, status AS prev_status FROM public.federation_commands
+		     WHERE id = $1 AND node_id = $2
+		     FOR UPDATE
+		 )
+		 UPDATE public.federation_commands
+		 SET status = $4, updated_at = $3,
+		     result = COALESCE($5::jsonb, result), error = COALESCE(NULLIF($6::text, ''), error), `+set+`
+		 FROM prev
+		 WHERE id = prev.prev_id AND prev.prev_status = ANY($7)
+		 RETURNING prev.prev_status, `+commandColumns,
+		args...,
+	), &prev)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Distinguish unknown commands from commands in the wrong state
+		var status string
+		lookupErr := q.QueryRow(ctx,
+			`SELECT status FROM public.federation_commands WHERE id = $1 AND node_id = $2`,
+			commandID, nodeID,
+		).Scan(&status)
+		if errors.Is(lookupErr, pgx.ErrNoRows) {
+			return nil, "", ErrCommandNotFound
+		}
+		return nil, "", fmt.Errorf("%w (status %s)", ErrCommandState, status)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to update command: %w", err)
+	}
+	return c, prev, nil
+}
+
+// GetCommand returns a command by ID
+func GetCommand(ctx context.Context, commandID string) (*Command, error) {
+	if commandQueue == nil {
+		return nil, ErrCommandQueueNotUp
+	}
+	if _, err := uuid.Parse(commandID); err != nil {
+		return nil, ErrCommandNotFound
+	}
+	c, err := scanCommand(commandQueue.db.QueryRow(ctx,
+		`SELECT `+commandColumns+` FROM public.federation_commands WHERE id = $1`,
+		commandID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCommandNotFound
+	}
+	return c, err
+}
+
+// ListCommands returns commands newest first, optionally filtered by node, tenant and status
+func ListCommands(ctx context.Context, nodeID, tenantID string, status CommandStatus, limit int) ([]*Command, error) {
+	if commandQueue == nil {
+		return nil, ErrCommandQueueNotUp
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := commandQueue.db.Query(ctx,
+		`SELECT `+commandColumns+` FROM public.federation_commands
+		 WHERE ($1 = '' OR node_id = $1) AND ($2 = '' OR tenant_id = $2) AND ($3 = '' OR status = $3)
+		 ORDER BY created_at DESC
+		 LIMIT $4`,
+		nodeID, tenantID, string(status), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commands: %w", err)
+	}
+	return scanCommands(rows)
+}
+
+// sweep expires commands past their TTL and requeues deliveries whose lease lapsed without an ack
+func (cq *CommandQueue) sweep(ctx context.Context, now time.Time) error {
+	rows, err := cq.db.Query(ctx,
+		`WITH prev AS (
+		     SELECT id AS prev_id, status AS prev_status FROM public.federation_commands
+		     WHERE status IN ('queued', 'delivered', 'acknowledged') AND expires_at <= $1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 UPDATE public.federation_commands
+		 SET status = 'expired', lease_expires_at = NULL, updated_at = $1
+		 FROM prev
+		 WHERE id = prev.prev_id
+		 RETURNING prev.prev_status, `+commandColumns,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to expire commands: %w", err)
+	}
+	if err := emitSwept(rows); err != nil {
+		return err
+	}
+
+	rows, err = cq.db.Query(ctx,
+		`WITH prev AS (
+		     SELECT id AS prev_id, status AS prev_status FROM public.federation_commands
+		     WHERE status = 'delivered' AND lease_expires_at <= $1
+		     FOR UPDATE SKIP LOCKED
+		 )
+		 UPDATE public.federation_commands
+		 SET status = 'queued', lease_expires_at = NULL, updated_at = $1
+		 FROM prev
+		 WHERE id = prev.prev_id
+		 RETURNING prev.prev_status, `+commandColumns,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to requeue commands: %w", err)
+	}
+	return emitSwept(rows)
+}
+
+// emitSwept emits state changes for commands updated by the sweeper and wakes pollers
+func emitSwept(rows pgx.Rows) error {
+	defer rows.Close()
+	for rows.Next() {
+		var prev CommandStatus
+		c, err := scanCommand(rows, &prev)
+		if err != nil {
+			return fmt.Errorf("failed to scan command: %w", err)
+		}
+		emitCommandState(c, prev)
+		if c.Status == CommandQueued && commandQueue != nil {
+			commandQueue.notify(c.NodeID)
+		}
+	}
+	return rows.Err()
+}
+
+// wait registers a long-poll waiter for a node
+func (cq *CommandQueue) wait(nodeID string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	cq.mu.Lock()
+	cq.waiters[nodeID] = append(cq.waiters[nodeID], ch)
+	cq.mu.Unlock()
+	return ch
+}
+
+// unwait removes a long-poll waiter
+func (cq *CommandQueue) unwait(nodeID string, ch chan struct{}) {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+	waiters := cq.waiters[nodeID]
+	for i, w := range waiters {
+		if w == ch {
+			cq.waiters[nodeID] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(cq.waiters[nodeID]) == 0 {
+		delete(cq.waiters, nodeID)
+	}
+}
+
+// notify wakes long-poll waiters for a node on this replica
+func (cq *CommandQueue) notify(nodeID string) {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+	for _, ch := range cq.waiters[nodeID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
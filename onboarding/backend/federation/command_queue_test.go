@@ -0,0 +1,74 @@
+package federation
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCommandLease(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	saved := commandQueue
+	commandQueue = &CommandQueue{db: pool, waiters: make(map[string][]chan struct{})}
+	t.Cleanup(func() { commandQueue = saved })
+
+	nodeID := testID("node")
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, "DELETE FROM public.federation_commands WHERE node_id = $1", nodeID)
+	})
+
+	cmd, created, err := EnqueueCommand(ctx, CommandRequest{NodeID: nodeID, Type: "noop", IdempotencyKey: "once"}, "tester")
+	if err != nil || !created {
+		t.Fatalf("EnqueueCommand = %v, %v", created, err)
+	}
+	again, created, err := EnqueueCommand(ctx, CommandRequest{NodeID: nodeID, Type: "noop", IdempotencyKey: "once"}, "tester")
+	if err != nil || created || again.ID != cmd.ID {
+		t.Fatalf("repeated idempotency key = %v (created %v), %v; want the existing command", again, created, err)
+	}
+
+	pull := func() []*Command {
+		t.Helper()
+		commands, err := PullCommands(ctx, nodeID, "", 10, 0)
+		if err != nil {
+			t.Fatalf("PullCommands: %v", err)
+		}
+		return commands
+	}
+
+	first := pull()
+	if len(first) != 1 || first[0].ID != cmd.ID || first[0].Status != CommandDelivered || first[0].Attempts != 1 {
+		t.Fatalf("first pull = %+v, want the command delivered on attempt 1", first)
+	}
+	if leased := pull(); len(leased) != 0 {
+		t.Fatalf("pull during the lease returned %d command(s)", len(leased))
+	}
+
+	// The lease lapses without an ack: the sweeper requeues the command for redelivery
+	if err := commandQueue.sweep(ctx, time.Now().Add(CommandDeliveryLease+time.Second)); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	second := pull()
+	if len(second) != 1 || second[0].Attempts != 2 {
+		t.Fatalf("pull after the lease lapsed = %+v, want redelivery on attempt 2", second)
+	}
+
+	// Acknowledged commands are no longer leased
+	if _, err := AcknowledgeCommand(ctx, nodeID, cmd.ID); err != nil {
+		t.Fatalf("AcknowledgeCommand: %v", err)
+	}
+	if err := commandQueue.sweep(ctx, time.Now().Add(CommandDeliveryLease+time.Second)); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	got, err := GetCommand(ctx, cmd.ID)
+	if err != nil {
+		t.Fatalf("GetCommand: %v", err)
+	}
+	if got.Status != CommandAcknowledged {
+		t.Fatalf("status after sweep = %s, want %s", got.Status, CommandAcknowledged)
+	}
+	if _, err := AcknowledgeCommand(ctx, nodeID, cmd.ID); err == nil {
+		t.Fatal("second AcknowledgeCommand: want error")
+	}
+}
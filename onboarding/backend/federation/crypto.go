@@ -1,46 +1,255 @@
 package federation
 
 import (
+	"context"
 	"crypto/ed25519"
-	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Phase 13.4: Ed25519 signed federation tokens
-// In production this keypair will eventually come from Rho²
-// For now: stored in memory; persistent later
-var (
-	keyPair     ed25519.PrivateKey
-	keyPairOnce sync.Once
-)
+// Signing keys are loaded from a persistent KeyStore (file or Postgres) and held in a keyring.
+// The newest key signs; the current key plus the previous `retain` keys verify, so a key
+// can be rotated out without re-bootstrapping every node.
+// Replicas sharing a keystore pick up each other's rotations by reloading on a timer
+// and whenever a token names a kid they do not know yet.
+type keyring struct {
+	mu         sync.RWMutex
+	store      KeyStore
+	keys       []*SigningKey // newest first
+	retain     int
+	reloadMu   sync.Mutex
+	lastReload time.Time
+}
+
+// ring is the configured keyring; nil until InitKeyring has run
+var ring atomic.Pointer[keyring]
+
+// ErrKeyringNotInitialized is returned when tokens are signed or verified before InitKeyring
+var ErrKeyringNotInitialized = errors.New("federation keyring not initialized")
+
+// DefaultKeyRetention is the number of previous keys accepted for verification
+const DefaultKeyRetention = 2
+
+// DefaultKeyRefresh is how often the keyring reloads from the keystore
+const DefaultKeyRefresh = time.Minute
 
-// Initialize keypair (lazy initialization)
-func initKeyPair() {
-	keyPairOnce.Do(func() {
-		var err error
-		_, keyPair, err = ed25519.GenerateKey(rand.Reader)
+// minUnknownKidReload bounds how often tokens with an unknown kid can force a keystore reload
+const minUnknownKidReload = 5 * time.Second
+
+// InitKeyring loads signing keys from the store, generating the first key if the store is empty,
+// and reloads them every refresh interval so rotations on other replicas are picked up.
+// Must be called before tokens are signed or verified; until then both fail with ErrKeyringNotInitialized.
+func InitKeyring(ctx context.Context, store KeyStore, retain int, refresh time.Duration) error {
+	if retain < 0 {
+		retain = 0
+	}
+
+	keys, err := store.LoadKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		key, err := GenerateSigningKey()
 		if err != nil {
-			panic(fmt.Sprintf("Failed to generate Ed25519 keypair: %v", err))
+			return err
+		}
+		if err := store.SaveKey(ctx, key); err != nil {
+			return err
+		}
+		keys = []*SigningKey{key}
+		log.Printf("Generated initial federation signing key kid=%s", key.KeyID)
+	}
+
+	kr := &keyring{store: store, keys: keys, retain: retain, lastReload: time.Now()}
+	ring.Store(kr)
+	log.Printf("Federation keyring loaded: current kid=%s, %d key(s) accepted", keys[0].KeyID, len(kr.activeKeys()))
+
+	if refresh <= 0 {
+		refresh = DefaultKeyRefresh
+	}
+	ticker := time.NewTicker(refresh)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			if ring.Load() != kr {
+				return // replaced by a later InitKeyring
+			}
+			if err := kr.reload(context.Background()); err != nil {
+				log.Printf("Failed to refresh federation keyring: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// reload replaces the in-memory keys with the keystore's current set
+func (kr *keyring) reload(ctx context.Context) error {
+	kr.reloadMu.Lock()
+	defer kr.reloadMu.Unlock()
+
+	keys, err := kr.store.LoadKeys(ctx)
+	kr.lastReload = time.Now()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil // keep what we have rather than lose every key
+	}
+
+	kr.mu.Lock()
+	previous := kr.keys[0].KeyID
+	kr.keys = keys
+	kr.mu.Unlock()
+
+	if keys[0].KeyID != previous {
+		log.Printf("Federation keyring reloaded: current kid=%s", keys[0].KeyID)
+	}
+	return nil
+}
+
+// reloadForUnknownKid reloads the keyring unless it was reloaded within minUnknownKidReload,
+// so a token signed after a rotation on another replica verifies without a restart
+func (kr *keyring) reloadForUnknownKid() {
+	kr.reloadMu.Lock()
+	recent := time.Since(kr.lastReload) < minUnknownKidReload
+	kr.reloadMu.Unlock()
+	if recent {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := kr.reload(ctx); err != nil {
+		log.Printf("Failed to reload federation keyring for unknown kid: %v", err)
+	}
+}
+
+// activeKeyring returns the configured keyring
+func activeKeyring() (*keyring, error) {
+	kr := ring.Load()
+	if kr == nil {
+		return nil, ErrKeyringNotInitialized
+	}
+	return kr, nil
+}
+
+// current returns the key used for signing new tokens
+func (kr *keyring) current() *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.keys[0]
+}
+
+// activeKeys returns the keys accepted for verification (current + retained previous keys)
+func (kr *keyring) activeKeys() []*SigningKey {
+	n := kr.retain + 1
+	if n > len(kr.keys) {
+		n = len(kr.keys)
+	}
+	return kr.keys[:n]
+}
+
+// lookup finds an accepted verification key by kid
+func (kr *keyring) lookup(kid string) *SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	for _, key := range kr.activeKeys() {
+		if key.KeyID == kid {
+			return key
 		}
-	})
+	}
+	return nil
+}
+
+// RotateSigningKey generates and persists a new signing key and makes it current.
+// The previous key stays valid for verification until it falls outside the retention window.
+func RotateSigningKey(ctx context.Context) (*SigningKey, error) {
+	kr, err := activeKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// Hold off reloads so a concurrent one cannot drop the key between save and swap
+	kr.reloadMu.Lock()
+	defer kr.reloadMu.Unlock()
+	if err := kr.store.SaveKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	kr.mu.Lock()
+	kr.keys = append([]*SigningKey{key}, kr.keys...)
+	kr.mu.Unlock()
+
+	log.Printf("Rotated federation signing key: new kid=%s", key.KeyID)
+	return key, nil
+}
+
+// PublicKeyInfo describes a verification key for distribution to nodes
+type PublicKeyInfo struct {
+	KeyID     string `json:"kid"`
+	PublicKey string `json:"publicKey"`
+	CreatedAt int64  `json:"createdAt"`
+	Current   bool   `json:"current"`
+}
+
+// GetPublicKeys returns all keys currently accepted for verification, newest first
+func GetPublicKeys() []PublicKeyInfo {
+	kr, err := activeKeyring()
+	if err != nil {
+		return []PublicKeyInfo{}
+	}
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	active := kr.activeKeys()
+	result := make([]PublicKeyInfo, 0, len(active))
+	for i, key := range active {
+		result = append(result, PublicKeyInfo{
+			KeyID:     key.KeyID,
+			PublicKey: base64.StdEncoding.EncodeToString(key.PublicKey()),
+			CreatedAt: key.CreatedAt.UnixMilli(),
+			Current:   i == 0,
+		})
+	}
+	return result
 }
 
 // FederationTokenPayload represents the payload in a signed token
 type FederationTokenPayload struct {
-	NodeID      string `json:"nodeId"`
-	TenantID    string `json:"tenantId"`
-	Fingerprint string `json:"fingerprint"`
-	IssuedAt    int64  `json:"issuedAt"`
+	KeyID       string   `json:"kid"`
+	NodeID      string   `json:"nodeId"`
+	TenantID    string   `json:"tenantId"`
+	Fingerprint string   `json:"fingerprint"`
+	IssuedAt    int64    `json:"issuedAt"`
+	NotBefore   int64    `json:"notBefore,omitempty"`
+	ExpiresAt   int64    `json:"expiresAt"`
+	Audience    []string `json:"aud"`
+	Scopes      []string `json:"scopes,omitempty"`
 }
 
 // SignFederationToken converts payload to signed token
 // Format: base64(payload).base64(signature)
+// The current key's kid is embedded in the payload
 func SignFederationToken(payload FederationTokenPayload) (string, error) {
-	initKeyPair()
+	kr, err := activeKeyring()
+	if err != nil {
+		return "", err
+	}
+	key := kr.current()
+	payload.KeyID = key.KeyID
 
 	// Serialize payload to JSON
 	data, err := json.Marshal(payload)
@@ -49,7 +258,7 @@ func SignFederationToken(payload FederationTokenPayload) (string, error) {
 	}
 
 	// Sign the payload
-	signature := ed25519.Sign(keyPair, data)
+	signature := ed25519.Sign(key.PrivateKey, data)
 
 	// Encode payload and signature to base64
 	payloadB64 := base64.RawURLEncoding.EncodeToString(data)
@@ -59,10 +268,15 @@ func SignFederationToken(payload FederationTokenPayload) (string, error) {
 	return payloadB64 + "." + signatureB64, nil
 }
 
-// VerifyFederationToken verifies token signature and returns payload
-// Returns error if signature is invalid
+// VerifyFederationToken verifies token signature and validity window and returns payload
+// Returns error if signature is invalid, the signing key is no longer accepted,
+// the token is expired / not yet valid, or it has been revoked
+// (the decoded payload is returned with claim and revocation errors)
 func VerifyFederationToken(token string) (*FederationTokenPayload, error) {
-	initKeyPair()
+	kr, err := activeKeyring()
+	if err != nil {
+		return nil, err
+	}
 
 	// Split token into payload and signature
 	parts := splitToken(token)
@@ -84,25 +298,46 @@ func VerifyFederationToken(token string) (*FederationTokenPayload, error) {
 		return nil, fmt.Errorf("failed to decode signature: %w", err)
 	}
 
+	// Parse payload (kid selects the verification key)
+	var payload FederationTokenPayload
+	if err := json.Unmarshal(payloadData, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+
+	key := kr.lookup(payload.KeyID)
+	if key == nil {
+		kr.reloadForUnknownKid()
+		key = kr.lookup(payload.KeyID)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown or retired signing key: %q", payload.KeyID)
+	}
+
 	// Verify signature
-	publicKey := keyPair.Public().(ed25519.PublicKey)
-	if !ed25519.Verify(publicKey, payloadData, signature) {
+	if !ed25519.Verify(key.PublicKey(), payloadData, signature) {
 		return nil, errors.New("invalid signature")
 	}
 
-	// Parse payload
-	var payload FederationTokenPayload
-	if err := json.Unmarshal(payloadData, &payload); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	// Enforce not-before / expiry
+	if err := payload.ValidateTime(time.Now()); err != nil {
+		return &payload, err
+	}
+
+	// Reject revoked tokens, nodes and tenants
+	if err := CheckRevocation(token, &payload); err != nil {
+		return &payload, err
 	}
 
 	return &payload, nil
 }
 
-// GetPublicKey returns the public key (for distribution to nodes)
+// GetPublicKey returns the current public key (for distribution to nodes), nil before InitKeyring
 func GetPublicKey() ed25519.PublicKey {
-	initKeyPair()
-	return keyPair.Public().(ed25519.PublicKey)
+	kr, err := activeKeyring()
+	if err != nil {
+		return nil
+	}
+	return kr.current().PublicKey()
 }
 
 // Helper function to split token
@@ -0,0 +1,106 @@
+package federation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKeyringSignVerifyRotate(t *testing.T) {
+	ring.Store(nil)
+	t.Cleanup(func() { ring.Store(nil) })
+
+	payload := NewTokenPayload("node-1", "tenant-1", "fp", time.Hour, SessionAudiences, []string{ScopeNode})
+	if _, err := SignFederationToken(payload); !errors.Is(err, ErrKeyringNotInitialized) {
+		t.Fatalf("sign before InitKeyring: got %v, want ErrKeyringNotInitialized", err)
+	}
+	if _, err := VerifyFederationToken("a.b"); !errors.Is(err, ErrKeyringNotInitialized) {
+		t.Fatalf("verify before InitKeyring: got %v, want ErrKeyringNotInitialized", err)
+	}
+	if keys := GetPublicKeys(); len(keys) != 0 {
+		t.Fatalf("public keys before InitKeyring: got %d, want 0", len(keys))
+	}
+
+	ctx := context.Background()
+	if err := InitKeyring(ctx, NewMemoryKeyStore(), 1, time.Hour); err != nil {
+		t.Fatalf("InitKeyring: %v", err)
+	}
+
+	token, err := SignFederationToken(payload)
+	if err != nil {
+		t.Fatalf("SignFederationToken: %v", err)
+	}
+	got, err := VerifyFederationToken(token)
+	if err != nil {
+		t.Fatalf("VerifyFederationToken: %v", err)
+	}
+	if got.NodeID != "node-1" || got.TenantID != "tenant-1" {
+		t.Fatalf("payload = %+v", got)
+	}
+
+	// Tokens of a rotated-out key stay valid within the retention window
+	for i := 0; i < 2; i++ {
+		if _, err := RotateSigningKey(ctx); err != nil {
+			t.Fatalf("RotateSigningKey: %v", err)
+		}
+		_, err := VerifyFederationToken(token)
+		if i == 0 && err != nil {
+			t.Fatalf("verify after one rotation: %v", err)
+		}
+		if i == 1 && err == nil {
+			t.Fatal("verify after the key left the retention window: want error")
+		}
+	}
+
+	if _, err := VerifyFederationToken(token[:len(token)-2] + "AA"); err == nil {
+		t.Fatal("verify with a tampered signature: want error")
+	}
+}
+
+func TestKeyringReloadsUnknownKid(t *testing.T) {
+	ring.Store(nil)
+	t.Cleanup(func() { ring.Store(nil) })
+
+	ctx := context.Background()
+	store := NewMemoryKeyStore()
+	if err := InitKeyring(ctx, store, 1, time.Hour); err != nil {
+		t.Fatalf("InitKeyring: %v", err)
+	}
+
+	// Another replica rotates through the shared store and signs with the new key
+	rotated, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	if err := store.SaveKey(ctx, rotated); err != nil {
+		t.Fatalf("SaveKey: %v", err)
+	}
+	payload := NewTokenPayload("node-1", "tenant-1", "fp", time.Hour, SessionAudiences, []string{ScopeNode})
+	payload.KeyID = rotated.KeyID
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(data) + "." +
+		base64.RawURLEncoding.EncodeToString(ed25519.Sign(rotated.PrivateKey, data))
+
+	// A reload just happened, so the unknown kid is rejected until the rate limit lapses
+	if _, err := VerifyFederationToken(token); err == nil {
+		t.Fatal("verify right after a reload: want error")
+	}
+
+	kr := ring.Load()
+	kr.reloadMu.Lock()
+	kr.lastReload = time.Now().Add(-minUnknownKidReload)
+	kr.reloadMu.Unlock()
+	if _, err := VerifyFederationToken(token); err != nil {
+		t.Fatalf("verify after reload: %v", err)
+	}
+	if got := kr.current().KeyID; got != rotated.KeyID {
+		t.Fatalf("current kid = %s, want %s", got, rotated.KeyID)
+	}
+}
@@ -0,0 +1,390 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+)
+
+// Bus message envelope
+// Every bus request is an envelope {messageId, type, version, sentAt, correlationId, nodeId, traceparent,
+// tracestate, payload}.
+// The envelope itself and each payload are validated against JSON Schemas (schemas/<type>.v<version>.json
+// for built-in types). Message IDs are remembered per node for DedupWindow so agent retries replay the
+// original response instead of being processed twice.
+
+// Envelope limits
+const (
+	DedupWindow          = 24 * time.Hour
+	maxEnvelopeSize      = 1 << 20
+	maxEnvelopeClockSkew = 5 * time.Minute
+	inProgressTimeout    = 2 * time.Minute // claims left by a crashed dispatch are released after this
+	dedupPurgeInterval   = 5 * time.Minute
+	envelopeSchemaURL    = "envelope.json"
+)
+
+// Envelope errors
+var (
+	ErrInvalidEnvelope   = errors.New("invalid bus envelope")
+	ErrSchemaValidation  = errors.New("bus payload failed schema validation")
+	ErrMessageInProgress = errors.New("bus message is already being processed")
+	ErrMessageIDReused   = errors.New("bus message ID reused for a different message type")
+)
+
+//go:embed schemas/*.json
+var builtinSchemas embed.FS
+
+var schemaFilePattern = regexp.MustCompile(`^([a-z][a-z0-9_.-]*)\.v([0-9]+)\.json$`)
+
+// Envelope is the wire format of a bus message
+type Envelope struct {
+	MessageID     string                 `json:"messageId"`
+	Type          string                 `json:"type"`
+	Version       int                    `json:"version"`
+	SentAt        time.Time              `json:"sentAt"`
+	CorrelationID string                 `json:"correlationId,omitempty"`
+	NodeID        string                 `json:"nodeId,omitempty"`
+	TraceParent   string                 `json:"traceparent,omitempty"` // W3C trace context of the sender
+	TraceState    string                 `json:"tracestate,omitempty"`
+	Payload       map[string]interface{} `json:"payload"`
+}
+
+// SchemaViolation is one schema validation failure
+type SchemaViolation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// SchemaError lists why an envelope or payload failed validation
+type SchemaError struct {
+	Kind       error // ErrInvalidEnvelope or ErrSchemaValidation
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	parts := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		parts = append(parts, v.Path+": "+v.Message)
+	}
+	return fmt.Sprintf("%v: %s", e.Kind, strings.Join(parts, "; "))
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Kind
+}
+
+var envelopeSchema = mustCompileSchema(envelopeSchemaURL)
+
+// DecodeEnvelope reads and validates a bus envelope
+func DecodeEnvelope(r io.Reader) (*Envelope, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, maxEnvelopeSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	if len(raw) > maxEnvelopeSize {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrInvalidEnvelope, maxEnvelopeSize)
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	if err := validateSchema(envelopeSchema, doc, ErrInvalidEnvelope); err != nil {
+		return nil, err
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	return &env, nil
+}
+
+// Message converts the envelope to a bus message from the given node
+func (e *Envelope) Message(nodeID string, origin Origin) *Message {
+	return &Message{
+		ID:            e.MessageID,
+		Type:          e.Type,
+		Version:       e.Version,
+		NodeID:        nodeID,
+		CorrelationID: e.CorrelationID,
+		TraceParent:   e.TraceParent,
+		TraceState:    e.TraceState,
+		SentAt:        e.SentAt,
+		Data:          e.Payload,
+		Origin:        origin,
+	}
+}
+
+// validateSchema validates a decoded JSON document, converting failures to a SchemaError
+func validateSchema(sch *jsonschema.Schema, doc interface{}, failure error) error {
+	err := sch.Validate(doc)
+	if err == nil {
+		return nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return fmt.Errorf("%w: %v", failure, err)
+	}
+
+	schemaErr := &SchemaError{Kind: failure}
+	for _, unit := range verr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		if _, group := unit.Error.Kind.(*kind.Group); group {
+			continue // summary of nested failures, which are listed individually
+		}
+		path := unit.InstanceLocation
+		if path == "" {
+			path = "/"
+		}
+		schemaErr.Violations = append(schemaErr.Violations, SchemaViolation{Path: path, Message: unit.Error.String()})
+	}
+	if len(schemaErr.Violations) == 0 {
+		schemaErr.Violations = []SchemaViolation{{Path: "/", Message: verr.Error()}}
+	}
+	return schemaErr
+}
+
+// newSchemaCompiler returns a compiler that asserts formats (uuid, date-time, ...)
+func newSchemaCompiler() *jsonschema.Compiler {
+	c := jsonschema.NewCompiler()
+	c.AssertFormat()
+	return c
+}
+
+// mustCompileSchema compiles an embedded schema; used for schemas shipped with the binary
+func mustCompileSchema(name string) *jsonschema.Schema {
+	sch, err := compileSchema(name, mustReadSchema(name))
+	if err != nil {
+		panic(err)
+	}
+	return sch
+}
+
+func mustReadSchema(name string) []byte {
+	doc, err := builtinSchemas.ReadFile("schemas/" + name)
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+func compileSchema(name string, doc []byte) (*jsonschema.Schema, error) {
+	parsed, err := jsonschema.UnmarshalJSON(bytes.NewReader(doc))
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", name, err)
+	}
+	c := newSchemaCompiler()
+	if err := c.AddResource(name, parsed); err != nil {
+		return nil, fmt.Errorf("schema %s: %w", name, err)
+	}
+	sch, err := c.Compile(name)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", name, err)
+	}
+	return sch, nil
+}
+
+// RegisterSchema attaches a JSON Schema to a message type and version; payloads that do not
+// match are rejected by ValidateSchema before reaching the handler
+func (r *Registry) RegisterSchema(msgType string, version int, schema []byte) error {
+	sch, err := compileSchema(fmt.Sprintf("%s.v%d.json", msgType, version), schema)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas[handlerKey{msgType, version}] = sch
+	return nil
+}
+
+// registerBuiltinSchemas registers every schemas/<type>.v<version>.json shipped with the binary
+func (r *Registry) registerBuiltinSchemas() error {
+	entries, err := builtinSchemas.ReadDir("schemas")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		m := schemaFilePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue // envelope.json and other shared schemas
+		}
+		version, _ := strconv.Atoi(m[2])
+		if err := r.RegisterSchema(m[1], version, mustReadSchema(entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateSchema is middleware validating payloads against the schema registered for their type and version
+func (r *Registry) ValidateSchema() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+			r.mu.RLock()
+			sch := r.schemas[handlerKey{msg.Type, msg.Version}]
+			r.mu.RUnlock()
+			if sch == nil {
+				return next.Handle(ctx, msg)
+			}
+
+			// Round-trip through JSON so numbers and nested values have the types the validator expects
+			raw, err := json.Marshal(msg.Data)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+			}
+			doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+			}
+			if err := validateSchema(sch, doc, ErrSchemaValidation); err != nil {
+				return nil, err
+			}
+			return next.Handle(ctx, msg)
+		})
+	}
+}
+
+// Deduplicate is middleware making bus messages idempotent per (node, message ID).
+// The first delivery claims the ID and stores the handler's response; retries within DedupWindow
+// get that response back (Replayed) without running the handler. Failed dispatches release the
+// claim so the agent can retry.
+func (r *Registry) Deduplicate() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+			r.mu.RLock()
+			db := r.db
+			r.mu.RUnlock()
+			if db == nil || msg.ID == "" {
+				return next.Handle(ctx, msg)
+			}
+
+			tag, err := db.Exec(ctx,
+				`INSERT INTO public.federation_bus_messages
+				 (node_id, message_id, message_type, correlation_id, sent_at, received_at)
+				 VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+				 ON CONFLICT (node_id, message_id) DO NOTHING`,
+				msg.NodeID, msg.ID, msg.Type, msg.CorrelationID, msg.SentAt, msg.ReceivedAt,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to record bus message: %w", err)
+			}
+			if tag.RowsAffected() == 0 {
+				return r.replay(ctx, msg)
+			}
+
+			resp, err := next.Handle(ctx, msg)
+			if err != nil {
+				releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if _, delErr := db.Exec(releaseCtx,
+					`DELETE FROM public.federation_bus_messages WHERE node_id = $1 AND message_id = $2`,
+					msg.NodeID, msg.ID,
+				); delErr != nil {
+					log.Printf("Failed to release bus message %s from node=%s: %v", msg.ID, msg.NodeID, delErr)
+				}
+				return nil, err
+			}
+
+			status := resp.Status
+			if status == 0 {
+				status = 200
+			}
+			body, err := json.Marshal(resp.Body)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode bus response: %w", err)
+			}
+			if _, err := db.Exec(ctx,
+				`UPDATE public.federation_bus_messages
+				 SET response_status = $3, response_body = $4, completed_at = $5
+				 WHERE node_id = $1 AND message_id = $2`,
+				msg.NodeID, msg.ID, status, body, time.Now(),
+			); err != nil {
+				// The message was handled; a retry will see it as in progress until the claim times out
+				log.Printf("Failed to store response for bus message %s from node=%s: %v", msg.ID, msg.NodeID, err)
+			}
+			return resp, nil
+		})
+	}
+}
+
+// replay returns the stored response of an already handled message
+func (r *Registry) replay(ctx context.Context, msg *Message) (*Response, error) {
+	var msgType string
+	var status *int
+	var body []byte
+	err := r.db.QueryRow(ctx,
+		`SELECT message_type, response_status, response_body
+		 FROM public.federation_bus_messages
+		 WHERE node_id = $1 AND message_id = $2`,
+		msg.NodeID, msg.ID,
+	).Scan(&msgType, &status, &body)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Released by a failed dispatch between our insert and this read
+		return nil, ErrMessageInProgress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bus message: %w", err)
+	}
+	if msgType != msg.Type {
+		return nil, fmt.Errorf("%w (%s)", ErrMessageIDReused, msg.ID)
+	}
+	if status == nil {
+		return nil, ErrMessageInProgress
+	}
+
+	resp := &Response{Status: *status, Replayed: true}
+	if len(body) > 0 {
+		if !json.Valid(body) {
+			return nil, errors.New("failed to decode stored bus response: invalid JSON")
+		}
+		resp.Body = json.RawMessage(body)
+	}
+	return resp, nil
+}
+
+// purgeMessageIDs forgets message IDs outside the dedup window and claims abandoned mid-dispatch
+func (r *Registry) purgeMessageIDs(ctx context.Context, now time.Time) error {
+	r.mu.RLock()
+	db := r.db
+	r.mu.RUnlock()
+	if db == nil {
+		return nil
+	}
+	_, err := db.Exec(ctx,
+		`DELETE FROM public.federation_bus_messages
+		 WHERE received_at < $1 OR (response_status IS NULL AND received_at < $2)`,
+		now.Add(-DedupWindow), now.Add(-inProgressTimeout),
+	)
+	return err
+}
+
+// startDedupPurger periodically purges expired message IDs and dead letters
+func (r *Registry) startDedupPurger() {
+	go func() {
+		ticker := time.NewTicker(dedupPurgeInterval)
+		for range ticker.C {
+			if err := r.purgeMessageIDs(context.Background(), time.Now()); err != nil {
+				log.Printf("Federation bus message ID purge failed: %v", err)
+			}
+			if err := r.purgeDeadLetters(context.Background(), time.Now()); err != nil {
+				log.Printf("Federation dead letter purge failed: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,204 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	sentAt := time.Now().UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name string
+		body string
+		want error
+	}{
+		{"valid", `{"messageId": "m-1", "type": "heartbeat", "version": 1, "sentAt": "` + sentAt + `", "payload": {}}`, nil},
+		{"with optional fields", `{"messageId": "m-1", "type": "heartbeat", "version": 1, "sentAt": "` + sentAt + `",
+			"correlationId": "c-1", "nodeId": "node-1",
+			"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "payload": {"region": "eu"}}`, nil},
+		{"not JSON", `{"messageId"`, ErrInvalidEnvelope},
+		{"missing payload", `{"messageId": "m-1", "type": "heartbeat", "version": 1, "sentAt": "` + sentAt + `"}`, ErrInvalidEnvelope},
+		{"unknown field", `{"messageId": "m-1", "type": "heartbeat", "version": 1, "sentAt": "` + sentAt + `", "payload": {}, "x": 1}`, ErrInvalidEnvelope},
+		{"bad message ID", `{"messageId": "m 1", "type": "heartbeat", "version": 1, "sentAt": "` + sentAt + `", "payload": {}}`, ErrInvalidEnvelope},
+		{"uppercase type", `{"messageId": "m-1", "type": "Heartbeat", "version": 1, "sentAt": "` + sentAt + `", "payload": {}}`, ErrInvalidEnvelope},
+		{"version zero", `{"messageId": "m-1", "type": "heartbeat", "version": 0, "sentAt": "` + sentAt + `", "payload": {}}`, ErrInvalidEnvelope},
+		{"bad sentAt", `{"messageId": "m-1", "type": "heartbeat", "version": 1, "sentAt": "yesterday", "payload": {}}`, ErrInvalidEnvelope},
+		{"bad traceparent", `{"messageId": "m-1", "type": "heartbeat", "version": 1, "sentAt": "` + sentAt + `", "traceparent": "x", "payload": {}}`, ErrInvalidEnvelope},
+		{"payload not an object", `{"messageId": "m-1", "type": "heartbeat", "version": 1, "sentAt": "` + sentAt + `", "payload": []}`, ErrInvalidEnvelope},
+		{"too large", `{"messageId": "m-1", "payload": {"x": "` + strings.Repeat("a", maxEnvelopeSize) + `"}}`, ErrInvalidEnvelope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := DecodeEnvelope(strings.NewReader(tt.body))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("DecodeEnvelope() = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (env.MessageID != "m-1" || env.Type != "heartbeat" || env.Version != 1) {
+				t.Fatalf("envelope = %+v", env)
+			}
+		})
+	}
+}
+
+func TestDecodeEnvelopeViolations(t *testing.T) {
+	_, err := DecodeEnvelope(strings.NewReader(`{"messageId": "m-1", "type": "heartbeat", "version": 0, "payload": {}}`))
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("DecodeEnvelope() = %v, want a SchemaError", err)
+	}
+	if len(schemaErr.Violations) < 2 {
+		t.Fatalf("violations = %+v, want the missing sentAt and the version", schemaErr.Violations)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	r := NewRegistry()
+	if err := r.registerBuiltinSchemas(); err != nil {
+		t.Fatalf("registerBuiltinSchemas: %v", err)
+	}
+	handled := 0
+	h := r.ValidateSchema()(HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+		handled++
+		return &Response{}, nil
+	}))
+
+	tests := []struct {
+		name    string
+		msgType string
+		version int
+		data    map[string]interface{}
+		want    error
+	}{
+		{"valid heartbeat", "heartbeat", 1, map[string]interface{}{"region": "eu-west"}, nil},
+		{"heartbeat with a numeric region", "heartbeat", 1, map[string]interface{}{"region": 42}, ErrSchemaValidation},
+		{"heartbeat with a long region", "heartbeat", 1, map[string]interface{}{"region": strings.Repeat("r", 65)}, ErrSchemaValidation},
+		{"version without a schema", "heartbeat", 2, map[string]interface{}{"region": 42}, nil},
+		{"type without a schema", "custom.thing", 1, map[string]interface{}{"anything": true}, nil},
+	}
+	for _, tt := range tests {
+		before := handled
+		_, err := h.Handle(context.Background(), &Message{Type: tt.msgType, Version: tt.version, Data: tt.data})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: ValidateSchema() = %v, want %v", tt.name, err, tt.want)
+		}
+		if ran := handled > before; ran != (tt.want == nil) {
+			t.Errorf("%s: handler ran = %v", tt.name, ran)
+		}
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	now := time.Now()
+	h := ValidateMessage()(HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+		return &Response{}, nil
+	}))
+
+	tests := []struct {
+		name string
+		msg  Message
+		want error
+	}{
+		{"valid", Message{Type: "heartbeat", NodeID: "node-1", SentAt: now, ReceivedAt: now}, nil},
+		{"no sentAt", Message{Type: "heartbeat", NodeID: "node-1", ReceivedAt: now}, nil},
+		{"no type", Message{NodeID: "node-1", ReceivedAt: now}, ErrInvalidMessage},
+		{"no node", Message{Type: "heartbeat", ReceivedAt: now}, ErrInvalidMessage},
+		{"sent in the future", Message{Type: "heartbeat", NodeID: "node-1", SentAt: now.Add(maxEnvelopeClockSkew + time.Minute), ReceivedAt: now}, ErrInvalidMessage},
+		{"sent before the dedup window", Message{Type: "heartbeat", NodeID: "node-1", SentAt: now.Add(-DedupWindow - time.Minute), ReceivedAt: now}, ErrInvalidMessage},
+	}
+	for _, tt := range tests {
+		msg := tt.msg
+		if _, err := h.Handle(context.Background(), &msg); !errors.Is(err, tt.want) {
+			t.Errorf("%s: ValidateMessage() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestDeduplicateWithoutStore(t *testing.T) {
+	r := NewRegistry()
+	calls := 0
+	h := r.Deduplicate()(HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+		calls++
+		return &Response{Body: map[string]interface{}{"ok": true}}, nil
+	}))
+
+	// Without a store every delivery is handled
+	for i := 0; i < 2; i++ {
+		resp, err := h.Handle(context.Background(), &Message{ID: "m-1", Type: "heartbeat", NodeID: "node-1"})
+		if err != nil || resp.Replayed {
+			t.Fatalf("delivery %d: %+v, %v", i, resp, err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("handler calls = %d, want 2", calls)
+	}
+}
+
+func TestDeduplicateReplay(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	r := NewRegistry()
+	r.AttachStore(pool)
+	nodeID := testID("node")
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, "DELETE FROM public.federation_bus_messages WHERE node_id = $1", nodeID)
+	})
+
+	calls := 0
+	fail := false
+	h := r.Deduplicate()(HandlerFunc(func(ctx context.Context, msg *Message) (*Response, error) {
+		calls++
+		if fail {
+			return nil, errors.New("handler failed")
+		}
+		return &Response{Status: 202, Body: EventResponse{OK: true, NodeID: msg.NodeID, Type: msg.Type}}, nil
+	}))
+	deliver := func(id, msgType string) (*Response, error) {
+		return h.Handle(ctx, &Message{ID: id, Type: msgType, NodeID: nodeID, SentAt: time.Now(), ReceivedAt: time.Now()})
+	}
+
+	first, err := deliver("m-1", "event")
+	if err != nil || first.Replayed {
+		t.Fatalf("first delivery = %+v, %v", first, err)
+	}
+	again, err := deliver("m-1", "event")
+	if err != nil || !again.Replayed || again.Status != 202 {
+		t.Fatalf("redelivery = %+v, %v; want the stored 202 response replayed", again, err)
+	}
+	if body, _ := again.Body.(json.RawMessage); !strings.Contains(string(body), `"nodeId":"`+nodeID+`"`) {
+		t.Fatalf("replayed body = %s", again.Body)
+	}
+	if calls != 1 {
+		t.Fatalf("handler calls = %d, want 1", calls)
+	}
+
+	if _, err := deliver("m-1", "heartbeat"); !errors.Is(err, ErrMessageIDReused) {
+		t.Fatalf("message ID reused for another type = %v, want ErrMessageIDReused", err)
+	}
+
+	// A failed dispatch releases the claim so the retry runs the handler
+	fail = true
+	if _, err := deliver("m-2", "event"); err == nil {
+		t.Fatal("failing handler returned no error")
+	}
+	fail = false
+	if resp, err := deliver("m-2", "event"); err != nil || resp.Replayed {
+		t.Fatalf("retry after a failed dispatch = %+v, %v; want it handled", resp, err)
+	}
+	if calls != 3 {
+		t.Fatalf("handler calls = %d, want 3", calls)
+	}
+
+	// Outside the dedup window the ID is forgotten
+	if err := r.purgeMessageIDs(ctx, time.Now().Add(DedupWindow+time.Minute)); err != nil {
+		t.Fatalf("purgeMessageIDs: %v", err)
+	}
+	if resp, err := deliver("m-1", "event"); err != nil || resp.Replayed {
+		t.Fatalf("delivery after the purge = %+v, %v; want it handled", resp, err)
+	}
+}
@@ -0,0 +1,321 @@
+package federation
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Durable federation event log
+// Every event added to the stream is queued and written to public.federation_events in batches
+// by a background writer. The in-memory ring in event_stream.go stays the hot cache for the
+// dashboard and SSE; this log serves history queries. Daily partitions are created ahead of
+// time and dropped once they fall outside the retention window.
+
+// Event log defaults
+const (
+	DefaultEventRetentionDays = 30
+	eventLogQueueSize         = 4096
+	eventLogBatchSize         = 500
+	eventLogFlushInterval     = time.Second
+	eventLogMaintenance       = time.Hour
+	eventPartitionsAhead      = 2 // days of partitions created beyond today
+	eventPartitionPrefix      = "federation_events_"
+)
+
+// ErrInvalidCursor is returned when a query cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid event cursor")
+
+// EventLog persists federation events to Postgres
+type EventLog struct {
+	db            *pgxpool.Pool
+	queue         chan *FederationEvent
+	retentionDays int
+	dropped       atomic.Uint64
+}
+
+var eventLog *EventLog
+
+// InitEventLog prepares partitions, enforces retention and starts the background writer
+func InitEventLog(ctx context.Context, db *pgxpool.Pool, retentionDays int) error {
+	if retentionDays <= 0 {
+		retentionDays = DefaultEventRetentionDays
+	}
+	el := &EventLog{
+		db:            db,
+		queue:         make(chan *FederationEvent, eventLogQueueSize),
+		retentionDays: retentionDays,
+	}
+	if err := el.maintain(ctx, time.Now()); err != nil {
+		return err
+	}
+
+	eventsMutex.Lock()
+	eventLog = el
+	eventsMutex.Unlock()
+
+	go el.run()
+	go func() {
+		ticker := time.NewTicker(eventLogMaintenance)
+		for range ticker.C {
+			if err := el.maintain(context.Background(), time.Now()); err != nil {
+				log.Printf("Federation event log maintenance failed: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// enqueue hands an event to the writer without blocking; callers hold eventsMutex
+func (el *EventLog) enqueue(e *FederationEvent) {
+	select {
+	case el.queue <- e:
+	default:
+		if n := el.dropped.Add(1); n == 1 || n%1000 == 0 {
+			log.Printf("Warning: federation event log queue full, %d event(s) not persisted", n)
+		}
+	}
+}
+
+// run batches queued events and writes them
+func (el *EventLog) run() {
+	ticker := time.NewTicker(eventLogFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*FederationEvent, 0, eventLogBatchSize)
+	for {
+		select {
+		case e := <-el.queue:
+			batch = append(batch, e)
+			if len(batch) < eventLogBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := el.write(batch); err != nil {
+			log.Printf("Failed to persist %d federation event(s): %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+}
+
+// write copies a batch of events into the log
+func (el *EventLog) write(batch []*FederationEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows := make([][]interface{}, 0, len(batch))
+	for _, e := range batch {
+		var data []byte
+		if e.Data != nil {
+			var err error
+			if data, err = json.Marshal(e.Data); err != nil {
+				log.Printf("Skipping federation event %d: %v", e.ID, err)
+				continue
+			}
+		}
+		rows = append(rows, []interface{}{
+			int64(e.ID), time.UnixMilli(e.TS), e.Type, nullIfEmpty(e.NodeID), nullIfEmpty(e.TenantID), data,
+		})
+	}
+
+	_, err := el.db.CopyFrom(ctx,
+		pgx.Identifier{"public", "federation_events"},
+		[]string{"id", "ts", "type", "node_id", "tenant_id", "data"},
+		pgx.CopyFromRows(rows),
+	)
+	return err
+}
+
+// maintain creates upcoming daily partitions and drops those past retention
+func (el *EventLog) maintain(ctx context.Context, now time.Time) error {
+	today := now.UTC().Truncate(24 * time.Hour)
+	for i := 0; i <= eventPartitionsAhead; i++ {
+		day := today.AddDate(0, 0, i)
+		_, err := el.db.Exec(ctx, fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS public.%s PARTITION OF public.federation_events
+			 FOR VALUES FROM ('%s') TO ('%s')`,
+			eventPartitionPrefix+day.Format("20060102"),
+			day.Format(time.RFC3339), day.AddDate(0, 0, 1).Format(time.RFC3339),
+		))
+		if err != nil {
+			return fmt.Errorf("failed to create event partition for %s: %w", day.Format("2006-01-02"), err)
+		}
+	}
+
+	rows, err := el.db.Query(ctx,
+		`SELECT c.relname FROM pg_inherits i
+		 JOIN pg_class c ON c.oid = i.inhrelid
+		 JOIN pg_class p ON p.oid = i.inhparent
+		 JOIN pg_namespace n ON n.oid = p.relnamespace
+		 WHERE n.nspname = 'public' AND p.relname = 'federation_events'`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to list event partitions: %w", err)
+	}
+	var partitions []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		partitions = append(partitions, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cutoff := today.AddDate(0, 0, -el.retentionDays)
+	for _, name := range partitions {
+		day, err := time.Parse("20060102", strings.TrimPrefix(name, eventPartitionPrefix))
+		if err != nil || !day.Before(cutoff) {
+			continue // default partition or still within retention
+		}
+		if _, err := el.db.Exec(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS public.%s`, pgx.Identifier{name}.Sanitize())); err != nil {
+			return fmt.Errorf("failed to drop event partition %s: %w", name, err)
+		}
+		log.Printf("Dropped federation event partition %s (retention %d days)", name, el.retentionDays)
+	}
+	return nil
+}
+
+// EventQuery selects events from the durable log
+type EventQuery struct {
+	From   time.Time // inclusive; zero = no lower bound
+	To     time.Time // exclusive; zero = no upper bound
+	Filter EventFilter
+	Cursor string // from the previous page; empty for the first page
+	Limit  int
+}
+
+// QueryEvents returns events newest first and the cursor for the next page ("" when done)
+func QueryEvents(ctx context.Context, q EventQuery) ([]*FederationEvent, string, error) {
+	eventsMutex.RLock()
+	el := eventLog
+	eventsMutex.RUnlock()
+	if el == nil {
+		return nil, "", errors.New("federation event log not initialized")
+	}
+	if q.Limit <= 0 || q.Limit > 1000 {
+		q.Limit = 100
+	}
+
+	var cursorTS *time.Time
+	var cursorID int64
+	if q.Cursor != "" {
+		ts, id, err := decodeEventCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		cursorTS, cursorID = &ts, id
+	}
+
+	var types []string
+	if len(q.Filter.Types) > 0 {
+		types = q.Filter.Types
+	}
+
+	rows, err := el.db.Query(ctx,
+		`SELECT id, ts, type, COALESCE(node_id, ''), COALESCE(tenant_id, ''), data
+		 FROM public.federation_events
+		 WHERE ($1::timestamptz IS NULL OR ts >= $1)
+		   AND ($2::timestamptz IS NULL OR ts < $2)
+		   AND ($3::text[] IS NULL OR type = ANY($3))
+		   AND ($4 = '' OR node_id = $4)
+		   AND ($5 = '' OR tenant_id = $5)
+		   AND ($6::timestamptz IS NULL OR (ts, id) < ($6, $7))
+		 ORDER BY ts DESC, id DESC
+		 LIMIT $8`,
+		timeOrNilTime(q.From), timeOrNilTime(q.To), types, q.Filter.NodeID, q.Filter.TenantID,
+		cursorTS, cursorID, q.Limit,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	result := []*FederationEvent{}
+	var lastTS time.Time
+	for rows.Next() {
+		var e FederationEvent
+		var id int64
+		var ts time.Time
+		var data []byte
+		if err := rows.Scan(&id, &ts, &e.Type, &e.NodeID, &e.TenantID, &data); err != nil {
+			return nil, "", fmt.Errorf("failed to scan event: %w", err)
+		}
+		e.ID = uint64(id)
+		e.TS = ts.UnixMilli()
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &e.Data); err != nil {
+				log.Printf("Failed to unmarshal data for federation event %d: %v", id, err)
+			}
+		}
+		lastTS = ts
+		result = append(result, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(result) == q.Limit {
+		next = encodeEventCursor(lastTS, int64(result[len(result)-1].ID))
+	}
+	return result, next, nil
+}
+
+// encodeEventCursor packs the (ts, id) position of the last returned event
+func encodeEventCursor(ts time.Time, id int64) string {
+	raw := strconv.FormatInt(ts.UnixMicro(), 10) + ":" + strconv.FormatInt(id, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeEventCursor(cursor string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	tsPart, idPart, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	micros, err := strconv.ParseInt(tsPart, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	return time.UnixMicro(micros), id, nil
+}
+
+func timeOrNilTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
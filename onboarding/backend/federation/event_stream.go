@@ -8,54 +8,187 @@ import (
 // Phase 14.5: Federation Event Stream
 // In-memory event stream for federation awareness
 // Safe, internal, isolated - no filesystem, no spawning, no network, no commands
+// Events are also fanned out to live subscribers (SSE) and the durable event log (event_log.go);
+// producers never block on a slow subscriber or on Postgres.
 
 // FederationEvent represents a single federation event
 type FederationEvent struct {
-	TS     int64                  `json:"ts"`
-	Type   string                 `json:"type"`
-	NodeID string                 `json:"nodeId"`
-	Data   map[string]interface{} `json:"data"`
+	ID       uint64                 `json:"id"` // Monotonic, used as the SSE event ID
+	TS       int64                  `json:"ts"`
+	Type     string                 `json:"type"`
+	NodeID   string                 `json:"nodeId"`
+	TenantID string                 `json:"tenantId,omitempty"`
+	Data     map[string]interface{} `json:"data"`
 }
 
 var (
-	events     []*FederationEvent
+	events      []*FederationEvent
 	eventsMutex sync.RWMutex
-	maxEvents  = 200 // Keep last 200 events in memory
+	maxEvents   = 200 // Keep last 200 events in memory
+
+	// Seeded from the clock so IDs keep increasing across restarts and Last-Event-ID cursors stay valid
+	lastEventID = uint64(time.Now().UnixMicro())
+	subscribers = make(map[*Subscription]struct{})
 )
 
-// AddEvent adds a new event to the stream
-func AddEvent(eventType string, nodeID string, data map[string]interface{}) {
+// AddEvent adds a new event to the stream and delivers it to matching subscribers
+func AddEvent(eventType string, nodeID string, tenantID string, data map[string]interface{}) {
 	eventsMutex.Lock()
 	defer eventsMutex.Unlock()
-	
+
+	lastEventID++
 	event := &FederationEvent{
-		TS:     time.Now().UnixMilli(),
-		Type:   eventType,
-		NodeID: nodeID,
-		Data:   data,
+		ID:       lastEventID,
+		TS:       time.Now().UnixMilli(),
+		Type:     eventType,
+		NodeID:   nodeID,
+		TenantID: tenantID,
+		Data:     data,
 	}
-	
+
 	events = append(events, event)
-	
+
 	// Prune old events if we exceed maxEvents
 	if len(events) > maxEvents {
 		events = events[len(events)-maxEvents:]
 	}
+
+	if eventLog != nil {
+		eventLog.enqueue(event)
+	}
+
+	for sub := range subscribers {
+		sub.deliver(event)
+	}
 }
 
 // GetEvents returns the last N events (tail of stream)
 func GetEvents() []*FederationEvent {
 	eventsMutex.RLock()
 	defer eventsMutex.RUnlock()
-	
+
 	// Return last 200 events (in-memory tail)
 	start := 0
 	if len(events) > maxEvents {
 		start = len(events) - maxEvents
 	}
-	
+
 	result := make([]*FederationEvent, len(events)-start)
 	copy(result, events[start:])
 	return result
 }
 
+// EventFilter selects events by type, node and tenant (empty fields match everything)
+type EventFilter struct {
+	Types    []string
+	NodeID   string
+	TenantID string
+}
+
+// Matches reports whether the event passes the filter
+func (f EventFilter) Matches(e *FederationEvent) bool {
+	if f.NodeID != "" && e.NodeID != f.NodeID {
+		return false
+	}
+	if f.TenantID != "" && e.TenantID != f.TenantID {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives live events matching its filter
+type Subscription struct {
+	filter EventFilter
+	ch     chan *FederationEvent
+	lagged bool
+	closed bool
+}
+
+// Events returns the channel of live events; it is closed when the subscription
+// ends, either by Close or because the subscriber fell behind (see Lagged)
+func (s *Subscription) Events() <-chan *FederationEvent {
+	return s.ch
+}
+
+// Lagged reports whether the subscription was dropped for not keeping up.
+// The client should reconnect and resume from the last event ID it received.
+func (s *Subscription) Lagged() bool {
+	eventsMutex.RLock()
+	defer eventsMutex.RUnlock()
+	return s.lagged
+}
+
+// deliver hands the event to the subscriber without blocking; callers hold eventsMutex
+func (s *Subscription) deliver(e *FederationEvent) {
+	if s.closed || !s.filter.Matches(e) {
+		return
+	}
+	select {
+	case s.ch <- e:
+	default:
+		// Buffer full: drop the subscriber rather than stall the producer
+		s.lagged = true
+		s.closeLocked()
+	}
+}
+
+func (s *Subscription) closeLocked() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(subscribers, s)
+	close(s.ch)
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	s.closeLocked()
+}
+
+// DefaultSubscriberBuffer is the per-subscriber event buffer
+const DefaultSubscriberBuffer = 64
+
+// Subscribe registers a live subscriber and returns the buffered events after lastID
+// that match the filter. Backlog and subscription are taken atomically, so no event is
+// missed or duplicated between them. lastID 0 returns no backlog.
+// resumed is false when lastID is not covered by this process's buffer (evicted, from before a
+// restart, or issued by another replica); events after it may then be missing from the backlog.
+func Subscribe(filter EventFilter, lastID uint64, buffer int) (backlog []*FederationEvent, sub *Subscription, resumed bool) {
+	if buffer <= 0 {
+		buffer = DefaultSubscriberBuffer
+	}
+
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
+	backlog = []*FederationEvent{}
+	resumed = lastID == 0 || cursorBufferedLocked(lastID)
+	if lastID > 0 {
+		for _, e := range events {
+			if e.ID > lastID && filter.Matches(e) {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+
+	sub = &Subscription{filter: filter, ch: make(chan *FederationEvent, buffer)}
+	subscribers[sub] = struct{}{}
+	return backlog, sub, resumed
+}
+
+// cursorBufferedLocked reports whether every event after lastID is still buffered.
+// IDs are consecutive within a process, so the buffer covers (lastEventID-len(events), lastEventID].
+func cursorBufferedLocked(lastID uint64) bool {
+	return lastID >= lastEventID-uint64(len(events)) && lastID <= lastEventID
+}
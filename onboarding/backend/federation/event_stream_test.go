@@ -0,0 +1,68 @@
+package federation
+
+import "testing"
+
+func TestEventFilterMatches(t *testing.T) {
+	event := &FederationEvent{Type: "job_failed", NodeID: "node-1", TenantID: "tenant-1"}
+
+	tests := []struct {
+		name   string
+		filter EventFilter
+		want   bool
+	}{
+		{"empty filter", EventFilter{}, true},
+		{"node", EventFilter{NodeID: "node-1"}, true},
+		{"other node", EventFilter{NodeID: "node-2"}, false},
+		{"tenant", EventFilter{TenantID: "tenant-1"}, true},
+		{"other tenant", EventFilter{TenantID: "tenant-2"}, false},
+		{"type", EventFilter{Types: []string{"job_failed"}}, true},
+		{"one of several types", EventFilter{Types: []string{"heartbeat", "job_failed"}}, true},
+		{"other types", EventFilter{Types: []string{"heartbeat", "node_state"}}, false},
+		{"all fields", EventFilter{Types: []string{"job_failed"}, NodeID: "node-1", TenantID: "tenant-1"}, true},
+		{"type matches, tenant does not", EventFilter{Types: []string{"job_failed"}, TenantID: "tenant-2"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Matches(event); got != tt.want {
+			t.Errorf("%s: Matches() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSubscribeResume(t *testing.T) {
+	eventsMutex.Lock()
+	savedEvents, savedLastID := events, lastEventID
+	events, lastEventID = nil, 1000
+	eventsMutex.Unlock()
+	t.Cleanup(func() {
+		eventsMutex.Lock()
+		events, lastEventID = savedEvents, savedLastID
+		eventsMutex.Unlock()
+	})
+
+	// Buffer holds 1001..1003
+	for i := 0; i < 3; i++ {
+		AddEvent("heartbeat", "node-1", "tenant-1", nil)
+	}
+
+	tests := []struct {
+		name        string
+		lastID      uint64
+		wantBacklog int
+		wantResumed bool
+	}{
+		{"fresh connect", 0, 0, true},
+		{"cursor before the first buffered event", 1000, 3, true},
+		{"cursor inside the buffer", 1002, 1, true},
+		{"cursor at the newest event", 1003, 0, true},
+		{"evicted cursor", 999, 3, false},
+		{"cursor from another replica", 5000, 0, false},
+	}
+	for _, tt := range tests {
+		backlog, sub, resumed := Subscribe(EventFilter{}, tt.lastID, 0)
+		sub.Close()
+		if len(backlog) != tt.wantBacklog || resumed != tt.wantResumed {
+			t.Errorf("%s: Subscribe(%d) = %d event(s), resumed %v; want %d, %v",
+				tt.name, tt.lastID, len(backlog), resumed, tt.wantBacklog, tt.wantResumed)
+		}
+	}
+}
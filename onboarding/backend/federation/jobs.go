@@ -0,0 +1,605 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Job execution tracking backed by public.federation_jobs
+// Every queued command spawns one job. Agents report progress through /api/federation/agents/jobs;
+// failed or timed-out attempts are retried (the command is requeued after a backoff) until
+// MaxAttempts is reached. A sweeper enforces timeouts. Every state change emits a "job_state" event.
+
+// JobStatus is a job lifecycle state
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobTimedOut  JobStatus = "timed_out"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// EventJobState is the FederationEvent type emitted on every job state change
+const EventJobState = "job_state"
+
+// CommandTypeCancelJob is queued to a node to stop a job that is already running
+const CommandTypeCancelJob = "job.cancel"
+
+// Job defaults
+const (
+	DefaultJobTimeout      = 10 * time.Minute
+	DefaultJobRetryBackoff = 30 * time.Second
+	MaxJobAttempts         = 10
+	maxJobRetryBackoff     = time.Hour
+	jobOutputExcerpt       = 4096 // bytes of stdout/stderr kept (tail)
+	jobSweepInterval       = 5 * time.Second
+)
+
+// Job errors
+var (
+	ErrJobNotFound = errors.New("federation job not found")
+	ErrJobState    = errors.New("federation job is not in a valid state for this operation")
+	// ErrInvalidJobReport is returned for reports whose status is not running, succeeded or failed
+	ErrInvalidJobReport = errors.New("invalid federation job report")
+)
+
+// Job is the execution record of a command
+type Job struct {
+	ID                  string                 `json:"id"`
+	CommandID           string                 `json:"commandId"`
+	NodeID              string                 `json:"nodeId"`
+	AgentID             string                 `json:"agentId,omitempty"`
+	TenantID            string                 `json:"tenantId,omitempty"`
+	Type                string                 `json:"type"`
+	Status              JobStatus              `json:"status"`
+	Attempt             int                    `json:"attempt"`
+	MaxAttempts         int                    `json:"maxAttempts"`
+	TimeoutSeconds      int                    `json:"timeoutSeconds"`
+	RetryBackoffSeconds int                    `json:"retryBackoffSeconds"`
+	ExitCode            *int                   `json:"exitCode,omitempty"`
+	Result              map[string]interface{} `json:"result,omitempty"`
+	Stdout              string                 `json:"stdoutExcerpt,omitempty"`
+	Stderr              string                 `json:"stderrExcerpt,omitempty"`
+	Error               string                 `json:"error,omitempty"`
+	CancelledBy         string                 `json:"cancelledBy,omitempty"`
+	CreatedAt           time.Time              `json:"createdAt"`
+	StartedAt           *time.Time             `json:"startedAt,omitempty"`
+	DeadlineAt          *time.Time             `json:"deadlineAt,omitempty"`
+	NextRetryAt         *time.Time             `json:"nextRetryAt,omitempty"`
+	FinishedAt          *time.Time             `json:"finishedAt,omitempty"`
+}
+
+// Terminal reports whether the job can no longer change state
+func (j *Job) Terminal() bool {
+	switch j.Status {
+	case JobSucceeded, JobFailed, JobTimedOut, JobCancelled:
+		return true
+	}
+	return false
+}
+
+// JobReport is an agent's progress report for a job (identified by job or command ID)
+type JobReport struct {
+	JobID     string                 `json:"jobId"`
+	CommandID string                 `json:"commandId"`
+	Status    string                 `json:"status"` // running | succeeded | failed
+	ExitCode  *int                   `json:"exitCode"`
+	Result    map[string]interface{} `json:"result"`
+	Stdout    string                 `json:"stdout"`
+	Stderr    string                 `json:"stderr"`
+	Error     string                 `json:"error"`
+}
+
+// jobPolicy is the retry/timeout policy taken from a CommandRequest
+type jobPolicy struct {
+	maxAttempts int
+	timeout     time.Duration
+	backoff     time.Duration
+}
+
+func newJobPolicy(req CommandRequest) (jobPolicy, error) {
+	p := jobPolicy{maxAttempts: 1, timeout: DefaultJobTimeout, backoff: DefaultJobRetryBackoff}
+	if req.MaxAttempts > 0 {
+		p.maxAttempts = req.MaxAttempts
+	}
+	if p.maxAttempts > MaxJobAttempts {
+		return p, fmt.Errorf("maxAttempts exceeds maximum of %d", MaxJobAttempts)
+	}
+	if req.TimeoutSeconds > 0 {
+		p.timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+	if req.RetryBackoffSeconds > 0 {
+		p.backoff = time.Duration(req.RetryBackoffSeconds) * time.Second
+	}
+	return p, nil
+}
+
+const jobColumns = `id, command_id, node_id, COALESCE(agent_id, ''), COALESCE(tenant_id, ''), job_type, status,
+	attempt, max_attempts, timeout_seconds, retry_backoff_seconds, exit_code, result,
+	COALESCE(stdout_excerpt, ''), COALESCE(stderr_excerpt, ''), COALESCE(error, ''), COALESCE(cancelled_by, ''),
+	created_at, started_at, deadline_at, next_retry_at, finished_at`
+
+func scanJob(row pgx.Row) (*Job, error) {
+	var j Job
+	var result []byte
+	err := row.Scan(&j.ID, &j.CommandID, &j.NodeID, &j.AgentID, &j.TenantID, &j.Type, &j.Status,
+		&j.Attempt, &j.MaxAttempts, &j.TimeoutSeconds, &j.RetryBackoffSeconds, &j.ExitCode, &result,
+		&j.Stdout, &j.Stderr, &j.Error, &j.CancelledBy,
+		&j.CreatedAt, &j.StartedAt, &j.DeadlineAt, &j.NextRetryAt, &j.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) > 0 {
+		if err := json.Unmarshal(result, &j.Result); err != nil {
+			log.Printf("Failed to unmarshal result for job %s: %v", j.ID, err)
+		}
+	}
+	return &j, nil
+}
+
+// emitJobState publishes a job state change
+func emitJobState(j *Job, from JobStatus) {
+	data := map[string]interface{}{
+		"jobId":     j.ID,
+		"commandId": j.CommandID,
+		"jobType":   j.Type,
+		"from":      string(from),
+		"to":        string(j.Status),
+		"attempt":   j.Attempt,
+	}
+	if j.ExitCode != nil {
+		data["exitCode"] = *j.ExitCode
+	}
+	if j.Error != "" {
+		data["error"] = j.Error
+	}
+	AddEvent(EventJobState, j.NodeID, j.TenantID, data)
+}
+
+// createJob inserts the job for a newly enqueued command
+func createJob(ctx context.Context, q querier, c *Command, p jobPolicy) (*Job, error) {
+	job, err := scanJob(q.QueryRow(ctx,
+		`INSERT INTO public.federation_jobs
+		 (command_id, node_id, agent_id, tenant_id, job_type, status, attempt, max_attempts,
+		  timeout_seconds, retry_backoff_seconds, created_at, updated_at)
+		 VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, 'queued', 1, $6, $7, $8, $9, $9)
+		 RETURNING `+jobColumns,
+		c.ID, c.NodeID, c.AgentID, c.TenantID, c.Type, p.maxAttempts,
+		int(p.timeout.Seconds()), int(p.backoff.Seconds()), c.CreatedAt,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+	return job, nil
+}
+
+// setJobState updates a locked job row; set may reference $3 (now) and extra arguments from $4 on
+func setJobState(ctx context.Context, q querier, jobID string, to JobStatus, set string, extra ...interface{}) (*Job, error) {
+	args := append([]interface{}{jobID, string(to), time.Now()}, extra...)
+	if set != "" {
+		set = ", " + set
+	}
+	job, err := scanJob(q.QueryRow(ctx,
+		`UPDATE public.federation_jobs SET status = $2, updated_at = $3`+set+`
+		 WHERE id = $1
+		 RETURNING `+jobColumns,
+		args...,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update job: %w", err)
+	}
+	return job, nil
+}
+
+// jobChange collects state changes to emit after the transaction commits
+type jobChange struct {
+	job         *Job
+	jobFrom     JobStatus
+	command     *Command
+	commandFrom CommandStatus
+}
+
+func (c *jobChange) emit() {
+	if c.command != nil {
+		emitCommandState(c.command, c.commandFrom)
+		if c.command.Status == CommandQueued && commandQueue != nil {
+			commandQueue.notify(c.command.NodeID)
+		}
+	}
+	if c.job != nil {
+		emitJobState(c.job, c.jobFrom)
+	}
+}
+
+// retryBackoff is the delay before the next attempt (exponential, capped)
+func retryBackoff(j *Job) time.Duration {
+	d := time.Duration(j.RetryBackoffSeconds) * time.Second
+	for i := 1; i < j.Attempt && d < maxJobRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxJobRetryBackoff {
+		d = maxJobRetryBackoff
+	}
+	return d
+}
+
+// failAttempt ends the job's current attempt as failed or timed out, requeueing the command
+// when attempts remain
+func failAttempt(ctx context.Context, tx pgx.Tx, j *Job, final JobStatus, errMsg string, exitCode *int,
+	result map[string]interface{}, stdout, stderr string) (*jobChange, error) {
+	var resultJSON []byte
+	if result != nil {
+		resultJSON, _ = json.Marshal(result)
+	}
+	change := &jobChange{jobFrom: j.Status}
+
+	if j.Attempt < j.MaxAttempts {
+		nextRetry := time.Now().Add(retryBackoff(j))
+		job, err := setJobState(ctx, tx, j.ID, JobQueued,
+			`attempt = attempt + 1, next_retry_at = $4, started_at = NULL, deadline_at = NULL,
+			 exit_code = $5, result = $6, stdout_excerpt = NULLIF($7, ''), stderr_excerpt = NULLIF($8, ''), error = NULLIF($9, '')`,
+			nextRetry, exitCode, resultJSON, stdout, stderr, errMsg)
+		if err != nil {
+			return nil, err
+		}
+		cmd, from, err := updateCommand(ctx, tx, j.NodeID, j.CommandID, CommandQueued,
+			[]CommandStatus{CommandQueued, CommandDelivered, CommandAcknowledged},
+			`not_before = $8, lease_expires_at = NULL, acknowledged_at = NULL`, nil, errMsg, nextRetry)
+		if err != nil {
+			return nil, err
+		}
+		change.job, change.command, change.commandFrom = job, cmd, from
+		return change, nil
+	}
+
+	job, err := setJobState(ctx, tx, j.ID, final,
+		`finished_at = $3, deadline_at = NULL, next_retry_at = NULL,
+		 exit_code = $4, result = $5, stdout_excerpt = NULLIF($6, ''), stderr_excerpt = NULLIF($7, ''), error = NULLIF($8, '')`,
+		exitCode, resultJSON, stdout, stderr, errMsg)
+	if err != nil {
+		return nil, err
+	}
+	change.job = job
+
+	cmd, from, err := updateCommand(ctx, tx, j.NodeID, j.CommandID, CommandFailed,
+		[]CommandStatus{CommandQueued, CommandDelivered, CommandAcknowledged},
+		`completed_at = $3, lease_expires_at = NULL`, result, errMsg)
+	if err != nil && !errors.Is(err, ErrCommandState) {
+		return nil, err
+	}
+	change.command, change.commandFrom = cmd, from
+	return change, nil
+}
+
+// lockJob loads and locks a job by job ID or command ID; nodeID restricts it to one node
+func lockJob(ctx context.Context, tx pgx.Tx, jobID, commandID, nodeID string) (*Job, error) {
+	key, column := jobID, "id"
+	if key == "" {
+		key, column = commandID, "command_id"
+	}
+	if _, err := uuid.Parse(key); err != nil {
+		return nil, ErrJobNotFound
+	}
+	job, err := scanJob(tx.QueryRow(ctx,
+		`SELECT `+jobColumns+` FROM public.federation_jobs
+		 WHERE `+column+` = $1 AND ($2 = '' OR node_id = $2)
+		 FOR UPDATE`,
+		key, nodeID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load job: %w", err)
+	}
+	return job, nil
+}
+
+// ReportJob applies an agent's progress report for a job on its node
+func ReportJob(ctx context.Context, nodeID string, report JobReport) (*Job, error) {
+	switch report.Status {
+	case "running", "succeeded", "completed", "failed":
+	default:
+		return nil, fmt.Errorf("%w (unknown status %q)", ErrInvalidJobReport, report.Status)
+	}
+	if commandQueue == nil {
+		return nil, ErrCommandQueueNotUp
+	}
+
+	tx, err := commandQueue.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	j, err := lockJob(ctx, tx, report.JobID, report.CommandID, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if j.Terminal() {
+		return nil, fmt.Errorf("%w (status %s)", ErrJobState, j.Status)
+	}
+
+	stdout, stderr := excerpt(report.Stdout), excerpt(report.Stderr)
+	var change *jobChange
+	switch report.Status {
+	case "running":
+		if j.Status != JobQueued {
+			return nil, fmt.Errorf("%w (status %s)", ErrJobState, j.Status)
+		}
+		job, err := setJobState(ctx, tx, j.ID, JobRunning,
+			`started_at = $3, deadline_at = $4, next_retry_at = NULL`,
+			time.Now().Add(time.Duration(j.TimeoutSeconds)*time.Second))
+		if err != nil {
+			return nil, err
+		}
+		change = &jobChange{job: job, jobFrom: j.Status}
+
+		// Starting a job implies the command was received
+		cmd, from, err := updateCommand(ctx, tx, nodeID, j.CommandID, CommandAcknowledged,
+			[]CommandStatus{CommandDelivered}, `acknowledged_at = $3, lease_expires_at = NULL`, nil, "")
+		if err != nil && !errors.Is(err, ErrCommandState) {
+			return nil, err
+		}
+		change.command, change.commandFrom = cmd, from
+
+	case "succeeded", "completed":
+		var resultJSON []byte
+		if report.Result != nil {
+			resultJSON, _ = json.Marshal(report.Result)
+		}
+		job, err := setJobState(ctx, tx, j.ID, JobSucceeded,
+			`finished_at = $3, deadline_at = NULL, next_retry_at = NULL, error = NULL,
+			 exit_code = $4, result = $5, stdout_excerpt = NULLIF($6, ''), stderr_excerpt = NULLIF($7, '')`,
+			report.ExitCode, resultJSON, stdout, stderr)
+		if err != nil {
+			return nil, err
+		}
+		change = &jobChange{job: job, jobFrom: j.Status}
+
+		cmd, from, err := updateCommand(ctx, tx, nodeID, j.CommandID, CommandCompleted,
+			[]CommandStatus{CommandDelivered, CommandAcknowledged},
+			`completed_at = $3, lease_expires_at = NULL`, report.Result, "")
+		if err != nil && !errors.Is(err, ErrCommandState) {
+			return nil, err
+		}
+		change.command, change.commandFrom = cmd, from
+
+	case "failed":
+		errMsg := report.Error
+		if errMsg == "" && report.ExitCode != nil {
+			errMsg = fmt.Sprintf("exit code %d", *report.ExitCode)
+		}
+		change, err = failAttempt(ctx, tx, j, JobFailed, errMsg, report.ExitCode, report.Result, stdout, stderr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record job report: %w", err)
+	}
+	change.emit()
+	return change.job, nil
+}
+
+// CancelJob cancels a queued or running job. A running job also gets a job.cancel command
+// queued to its node so the agent can stop it.
+func CancelJob(ctx context.Context, jobID, cancelledBy string) (*Job, error) {
+	if commandQueue == nil {
+		return nil, ErrCommandQueueNotUp
+	}
+
+	tx, err := commandQueue.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	j, err := lockJob(ctx, tx, jobID, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if j.Terminal() {
+		return nil, fmt.Errorf("%w (status %s)", ErrJobState, j.Status)
+	}
+
+	job, err := setJobState(ctx, tx, j.ID, JobCancelled,
+		`finished_at = $3, deadline_at = NULL, next_retry_at = NULL, cancelled_by = $4`, cancelledBy)
+	if err != nil {
+		return nil, err
+	}
+	change := &jobChange{job: job, jobFrom: j.Status}
+
+	cmd, from, err := updateCommand(ctx, tx, j.NodeID, j.CommandID, CommandCancelled,
+		[]CommandStatus{CommandQueued, CommandDelivered, CommandAcknowledged},
+		`completed_at = $3, lease_expires_at = NULL`, nil, "cancelled by "+cancelledBy)
+	if err != nil && !errors.Is(err, ErrCommandState) {
+		return nil, err
+	}
+	change.command, change.commandFrom = cmd, from
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to cancel job: %w", err)
+	}
+	change.emit()
+
+	// The agent may already be working on it
+	if from == CommandDelivered || from == CommandAcknowledged {
+		_, _, err := EnqueueCommand(ctx, CommandRequest{
+			NodeID:         j.NodeID,
+			AgentID:        j.AgentID,
+			TenantID:       j.TenantID,
+			Type:           CommandTypeCancelJob,
+			Payload:        map[string]interface{}{"jobId": j.ID, "commandId": j.CommandID},
+			Priority:       100,
+			IdempotencyKey: "cancel:" + j.ID,
+		}, cancelledBy)
+		if err != nil {
+			log.Printf("Failed to queue cancellation of job %s to node %s: %v", j.ID, j.NodeID, err)
+		}
+	}
+	return job, nil
+}
+
+// GetJob returns a job by ID
+func GetJob(ctx context.Context, jobID string) (*Job, error) {
+	if commandQueue == nil {
+		return nil, ErrCommandQueueNotUp
+	}
+	if _, err := uuid.Parse(jobID); err != nil {
+		return nil, ErrJobNotFound
+	}
+	job, err := scanJob(commandQueue.db.QueryRow(ctx,
+		`SELECT `+jobColumns+` FROM public.federation_jobs WHERE id = $1`,
+		jobID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// GetCommandJob returns the job spawned by a command
+func GetCommandJob(ctx context.Context, commandID string) (*Job, error) {
+	if commandQueue == nil {
+		return nil, ErrCommandQueueNotUp
+	}
+	if _, err := uuid.Parse(commandID); err != nil {
+		return nil, ErrJobNotFound
+	}
+	job, err := scanJob(commandQueue.db.QueryRow(ctx,
+		`SELECT `+jobColumns+` FROM public.federation_jobs WHERE command_id = $1`,
+		commandID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// ListJobs returns jobs newest first, optionally filtered by node, tenant and status
+func ListJobs(ctx context.Context, nodeID, tenantID string, status JobStatus, limit int) ([]*Job, error) {
+	if commandQueue == nil {
+		return nil, ErrCommandQueueNotUp
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := commandQueue.db.Query(ctx,
+		`SELECT `+jobColumns+` FROM public.federation_jobs
+		 WHERE ($1 = '' OR node_id = $1) AND ($2 = '' OR tenant_id = $2) AND ($3 = '' OR status = $3)
+		 ORDER BY created_at DESC
+		 LIMIT $4`,
+		nodeID, tenantID, string(status), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// sweepJobs times out running jobs past their deadline and queued jobs whose command expired
+func sweepJobs(ctx context.Context, now time.Time) error {
+	rows, err := commandQueue.db.Query(ctx,
+		`SELECT j.id, CASE WHEN j.status = 'running' THEN 'job timed out' ELSE 'command expired before execution' END
+		 FROM public.federation_jobs j
+		 JOIN public.federation_commands c ON c.id = j.command_id
+		 WHERE (j.status = 'running' AND j.deadline_at <= $1)
+		    OR (j.status = 'queued' AND c.status = 'expired')`,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to find timed out jobs: %w", err)
+	}
+	type overdue struct{ id, reason string }
+	var jobs []overdue
+	for rows.Next() {
+		var o overdue
+		if err := rows.Scan(&o.id, &o.reason); err != nil {
+			rows.Close()
+			return err
+		}
+		jobs = append(jobs, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, o := range jobs {
+		if err := timeOutJob(ctx, o.id, o.reason, now); err != nil {
+			log.Printf("Failed to time out job %s: %v", o.id, err)
+		}
+	}
+	return nil
+}
+
+// timeOutJob ends a job's attempt as timed out (retrying if attempts remain)
+func timeOutJob(ctx context.Context, jobID, reason string, now time.Time) error {
+	tx, err := commandQueue.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	j, err := lockJob(ctx, tx, jobID, "", "")
+	if err != nil {
+		return err
+	}
+	// Re-check under the lock: the agent may have reported in the meantime
+	if j.Terminal() || (j.Status == JobRunning && j.DeadlineAt != nil && j.DeadlineAt.After(now)) {
+		return nil
+	}
+
+	var change *jobChange
+	if j.Status == JobQueued {
+		// The command expired, so there is nothing left to retry
+		job, err := setJobState(ctx, tx, j.ID, JobTimedOut,
+			`finished_at = $3, next_retry_at = NULL, error = $4`, reason)
+		if err != nil {
+			return err
+		}
+		change = &jobChange{job: job, jobFrom: j.Status}
+	} else {
+		change, err = failAttempt(ctx, tx, j, JobTimedOut, reason, nil, nil, "", "")
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	change.emit()
+	return nil
+}
+
+// excerpt keeps the tail of agent output
+func excerpt(s string) string {
+	if len(s) <= jobOutputExcerpt {
+		return s
+	}
+	return strings.ToValidUTF8("…"+s[len(s)-jobOutputExcerpt:], "")
+}
@@ -0,0 +1,112 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportJobStatus(t *testing.T) {
+	saved := commandQueue
+	commandQueue = nil
+	t.Cleanup(func() { commandQueue = saved })
+
+	tests := []struct {
+		status string
+		want   error
+	}{
+		{"running", ErrCommandQueueNotUp},
+		{"succeeded", ErrCommandQueueNotUp},
+		{"completed", ErrCommandQueueNotUp},
+		{"failed", ErrCommandQueueNotUp},
+		{"", ErrInvalidJobReport},
+		{"done", ErrInvalidJobReport},
+		{"cancelled", ErrInvalidJobReport},
+		{"Succeeded", ErrInvalidJobReport},
+	}
+	for _, tt := range tests {
+		_, err := ReportJob(context.Background(), "node-1", JobReport{CommandID: "cmd-1", Status: tt.status})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("ReportJob(status %q) = %v, want %v", tt.status, err, tt.want)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		backoffSeconds int
+		attempt        int
+		want           time.Duration
+	}{
+		{30, 1, 30 * time.Second},
+		{30, 2, time.Minute},
+		{30, 3, 2 * time.Minute},
+		{30, 5, 8 * time.Minute},
+		{30, 8, time.Hour},
+		{30, 100, maxJobRetryBackoff},
+		{7200, 1, maxJobRetryBackoff},
+		{0, 3, 0},
+	}
+	for _, tt := range tests {
+		j := &Job{RetryBackoffSeconds: tt.backoffSeconds, Attempt: tt.attempt}
+		if got := retryBackoff(j); got != tt.want {
+			t.Errorf("retryBackoff(%ds, attempt %d) = %v, want %v", tt.backoffSeconds, tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestJobTerminal(t *testing.T) {
+	tests := []struct {
+		status JobStatus
+		want   bool
+	}{
+		{JobQueued, false},
+		{JobRunning, false},
+		{JobSucceeded, true},
+		{JobFailed, true},
+		{JobTimedOut, true},
+		{JobCancelled, true},
+	}
+	for _, tt := range tests {
+		if got := (&Job{Status: tt.status}).Terminal(); got != tt.want {
+			t.Errorf("Terminal(%s) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestNewJobPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     CommandRequest
+		want    jobPolicy
+		wantErr bool
+	}{
+		{"defaults", CommandRequest{}, jobPolicy{maxAttempts: 1, timeout: DefaultJobTimeout, backoff: DefaultJobRetryBackoff}, false},
+		{"custom", CommandRequest{MaxAttempts: 3, TimeoutSeconds: 60, RetryBackoffSeconds: 5},
+			jobPolicy{maxAttempts: 3, timeout: time.Minute, backoff: 5 * time.Second}, false},
+		{"too many attempts", CommandRequest{MaxAttempts: MaxJobAttempts + 1}, jobPolicy{}, true},
+	}
+	for _, tt := range tests {
+		got, err := newJobPolicy(tt.req)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: newJobPolicy() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%s: newJobPolicy() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	if got := excerpt("short"); got != "short" {
+		t.Fatalf("excerpt(short) = %q", got)
+	}
+	long := strings.Repeat("a", jobOutputExcerpt) + "tail"
+	got := excerpt(long)
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "tail") || len(got) != len("…")+jobOutputExcerpt {
+		t.Fatalf("excerpt(long) kept %d bytes: %q...", len(got), got[:10])
+	}
+}
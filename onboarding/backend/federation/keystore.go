@@ -0,0 +1,255 @@
+package federation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Persistent keystore for federation signing keys
+// Keys survive restarts so tokens handed to Pi nodes and baked into bootstrap kits stay valid
+
+// SigningKey is an Ed25519 federation signing key identified by its key ID (kid)
+type SigningKey struct {
+	KeyID      string
+	PrivateKey ed25519.PrivateKey
+	CreatedAt  time.Time
+}
+
+// PublicKey returns the public half of the signing key
+func (k *SigningKey) PublicKey() ed25519.PublicKey {
+	return k.PrivateKey.Public().(ed25519.PublicKey)
+}
+
+// KeyStore loads and persists federation signing keys
+type KeyStore interface {
+	// LoadKeys returns all stored keys, newest first
+	LoadKeys(ctx context.Context) ([]*SigningKey, error)
+	// SaveKey persists a newly generated key
+	SaveKey(ctx context.Context, key *SigningKey) error
+}
+
+// GenerateSigningKey creates a new Ed25519 signing key with a derived key ID
+func GenerateSigningKey() (*SigningKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate Ed25519 keypair: %w", err)
+	}
+	return &SigningKey{
+		KeyID:      keyIDFor(priv.Public().(ed25519.PublicKey)),
+		PrivateKey: priv,
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// keyIDFor derives a stable key ID from the public key
+func keyIDFor(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// sortNewestFirst orders keys by creation time, newest first
+func sortNewestFirst(keys []*SigningKey) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+}
+
+// MemoryKeyStore keeps keys in process memory only (development fallback)
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys []*SigningKey
+}
+
+// NewMemoryKeyStore creates an empty in-memory keystore
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{}
+}
+
+// LoadKeys returns all keys held in memory
+func (s *MemoryKeyStore) LoadKeys(ctx context.Context) ([]*SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]*SigningKey, len(s.keys))
+	copy(keys, s.keys)
+	sortNewestFirst(keys)
+	return keys, nil
+}
+
+// SaveKey stores the key in memory
+func (s *MemoryKeyStore) SaveKey(ctx context.Context, key *SigningKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+// FileKeyStore persists keys as JSON in a local file (mode 0600)
+type FileKeyStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileKeyStore creates a keystore backed by the given file path
+func NewFileKeyStore(path string) *FileKeyStore {
+	return &FileKeyStore{path: path}
+}
+
+type fileKeyRecord struct {
+	KeyID     string    `json:"kid"`
+	Seed      string    `json:"seed"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type fileKeyDocument struct {
+	Keys []fileKeyRecord `json:"keys"`
+}
+
+// LoadKeys reads all keys from the keystore file
+func (s *FileKeyStore) LoadKeys(ctx context.Context) ([]*SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readLocked()
+}
+
+// SaveKey appends the key to the keystore file
+func (s *FileKeyStore) SaveKey(ctx context.Context, key *SigningKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.readLocked()
+	if err != nil {
+		return err
+	}
+	keys = append(keys, key)
+
+	doc := fileKeyDocument{Keys: make([]fileKeyRecord, 0, len(keys))}
+	for _, k := range keys {
+		doc.Keys = append(doc.Keys, fileKeyRecord{
+			KeyID:     k.KeyID,
+			Seed:      base64.StdEncoding.EncodeToString(k.PrivateKey.Seed()),
+			CreatedAt: k.CreatedAt,
+		})
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal keystore: %w", err)
+	}
+
+	// Write to a temp file and rename so a crash never leaves a truncated keystore
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create keystore directory: %w", err)
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write keystore: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace keystore: %w", err)
+	}
+	return nil
+}
+
+func (s *FileKeyStore) readLocked() ([]*SigningKey, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %w", err)
+	}
+
+	var doc fileKeyDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore: %w", err)
+	}
+
+	keys := make([]*SigningKey, 0, len(doc.Keys))
+	for _, rec := range doc.Keys {
+		seed, err := base64.StdEncoding.DecodeString(rec.Seed)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid seed for key %s", rec.KeyID)
+		}
+		keys = append(keys, &SigningKey{
+			KeyID:      rec.KeyID,
+			PrivateKey: ed25519.NewKeyFromSeed(seed),
+			CreatedAt:  rec.CreatedAt,
+		})
+	}
+	sortNewestFirst(keys)
+	return keys, nil
+}
+
+// PostgresKeyStore persists keys in public.federation_signing_keys
+type PostgresKeyStore struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresKeyStore creates a keystore backed by the given pool
+func NewPostgresKeyStore(db *pgxpool.Pool) *PostgresKeyStore {
+	return &PostgresKeyStore{db: db}
+}
+
+// LoadKeys reads all keys from the database
+func (s *PostgresKeyStore) LoadKeys(ctx context.Context) ([]*SigningKey, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT kid, private_seed, created_at
+		 FROM public.federation_signing_keys
+		 ORDER BY created_at DESC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*SigningKey
+	for rows.Next() {
+		var kid string
+		var seed []byte
+		var createdAt time.Time
+		if err := rows.Scan(&kid, &seed, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan signing key: %w", err)
+		}
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid seed for key %s", kid)
+		}
+		keys = append(keys, &SigningKey{
+			KeyID:      kid,
+			PrivateKey: ed25519.NewKeyFromSeed(seed),
+			CreatedAt:  createdAt,
+		})
+	}
+	return keys, rows.Err()
+}
+
+// SaveKey inserts the key into the database
+func (s *PostgresKeyStore) SaveKey(ctx context.Context, key *SigningKey) error {
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO public.federation_signing_keys (kid, private_seed, public_key, created_at)
+		 VALUES ($1, $2, $3, $4)`,
+		key.KeyID,
+		key.PrivateKey.Seed(),
+		[]byte(key.PublicKey()),
+		key.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store signing key: %w", err)
+	}
+	return nil
+}
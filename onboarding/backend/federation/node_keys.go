@@ -0,0 +1,89 @@
+package federation
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Node identity keys for the asymmetric federation handshake
+// Each node holds a private key from its bootstrap kit; the public half is registered
+// at kit generation time and used to verify the handshake assert step.
+
+// Node key algorithms
+const (
+	NodeKeyEd25519   = "ed25519"    // secrets/node-key.pem
+	NodeKeyRSASHA256 = "rsa-sha256" // secrets/tenant-key.pem (RSASSA-PKCS1-v1_5 with SHA-256)
+	// NodeKeyHMAC is the legacy HMAC-with-fingerprint mode kept for existing Pi images
+	NodeKeyHMAC = "HMAC-SHA256"
+)
+
+// ErrInvalidNodeSignature is returned when a handshake signature does not verify
+var ErrInvalidNodeSignature = errors.New("invalid node signature")
+
+// NodeKey is a registered node public key
+type NodeKey struct {
+	NodeID    string `json:"nodeId"`
+	TenantID  string `json:"tenantId"`
+	Algorithm string `json:"algorithm"`
+	// PublicKey is the raw Ed25519 key or the PKIX DER encoding of an RSA key
+	PublicKey []byte `json:"publicKey"`
+}
+
+// HandshakeMessage is the canonical message a node signs in the assert step:
+// challenge, nonce and node ID joined with ':'
+func HandshakeMessage(challenge, nonce, nodeID string) []byte {
+	return []byte(challenge + ":" + nonce + ":" + nodeID)
+}
+
+// VerifyNodeSignature verifies a base64 signature over message with the node's public key
+func VerifyNodeSignature(key *NodeKey, message []byte, signatureB64 string) error {
+	signature, err := base64.StdEncoding.DecodeString(signatureB64)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	switch key.Algorithm {
+	case NodeKeyEd25519:
+		if len(key.PublicKey) != ed25519.PublicKeySize {
+			return errors.New("invalid Ed25519 public key")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key.PublicKey), message, signature) {
+			return ErrInvalidNodeSignature
+		}
+		return nil
+
+	case NodeKeyRSASHA256:
+		pub, err := x509.ParsePKIXPublicKey(key.PublicKey)
+		if err != nil {
+			return fmt.Errorf("failed to parse RSA public key: %w", err)
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("node key is not an RSA public key")
+		}
+		digest := sha256.Sum256(message)
+		if err := rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidNodeSignature
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported node key algorithm: %s", key.Algorithm)
+	}
+}
+
+// EncodeNodePrivateKey PEM-encodes an Ed25519 node private key (PKCS#8) for the bootstrap kit
+func EncodeNodePrivateKey(priv ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
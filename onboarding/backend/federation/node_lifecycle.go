@@ -0,0 +1,506 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Node lifecycle state machine
+// pending → joined → online ⇄ degraded → offline → decommissioned
+// Transitions are driven by kit generation, node join, heartbeats, missed heartbeats (sweeper)
+// and operator decommission. Every transition is emitted as a "node_state" FederationEvent.
+// With Postgres, replicas merge each other's heartbeats and transitions from federation_nodes
+// on every sweep, and only the replica holding the sweep advisory lock counts missed heartbeats.
+
+// NodeState is a node lifecycle state
+type NodeState string
+
+const (
+	NodePending        NodeState = "pending"        // kit generated, node has not joined yet
+	NodeJoined         NodeState = "joined"         // join token exchanged, no heartbeat yet
+	NodeOnline         NodeState = "online"         // heartbeating normally
+	NodeDegraded       NodeState = "degraded"       // missed some heartbeats
+	NodeOffline        NodeState = "offline"        // missed too many heartbeats
+	NodeDecommissioned NodeState = "decommissioned" // retired by an operator (terminal)
+)
+
+// EventNodeState is the FederationEvent type emitted on every lifecycle transition
+const EventNodeState = "node_state"
+
+// LifecycleSweepInterval is how often missed heartbeats are counted
+const LifecycleSweepInterval = 5 * time.Second
+
+// lifecycleSweepLock is the advisory lock key that elects the replica counting missed heartbeats
+const lifecycleSweepLock = "federation-lifecycle-sweep"
+
+// Lifecycle errors
+var (
+	ErrNodeNotFound       = errors.New("federation node not found")
+	ErrNodeDecommissioned = errors.New("federation node is decommissioned")
+	ErrInvalidTransition  = errors.New("invalid node lifecycle transition")
+)
+
+// lifecycleTransitions lists the states each state may move to
+var lifecycleTransitions = map[NodeState][]NodeState{
+	NodePending:        {NodeJoined, NodeOnline, NodeDecommissioned},
+	NodeJoined:         {NodeOnline, NodeOffline, NodeDecommissioned},
+	NodeOnline:         {NodeDegraded, NodeOffline, NodeDecommissioned},
+	NodeDegraded:       {NodeOnline, NodeOffline, NodeDecommissioned},
+	NodeOffline:        {NodeJoined, NodeOnline, NodeDecommissioned},
+	NodeDecommissioned: {},
+}
+
+// CanTransition reports whether a node may move from one state to another
+func CanTransition(from, to NodeState) bool {
+	for _, allowed := range lifecycleTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// LifecycleSettings are the heartbeat thresholds applied to a tenant's nodes
+type LifecycleSettings struct {
+	HeartbeatIntervalMs int64 `json:"heartbeatIntervalMs"`
+	DegradedAfterMissed int   `json:"degradedAfterMissed"`
+	OfflineAfterMissed  int   `json:"offlineAfterMissed"`
+}
+
+// DefaultLifecycleSettings applies to tenants without their own settings
+// (offline after 3 missed heartbeats = OFFLINE_THRESHOLD)
+var DefaultLifecycleSettings = LifecycleSettings{
+	HeartbeatIntervalMs: OFFLINE_THRESHOLD / 3,
+	DegradedAfterMissed: 2,
+	OfflineAfterMissed:  3,
+}
+
+// Validate checks the thresholds are usable
+func (s LifecycleSettings) Validate() error {
+	if s.HeartbeatIntervalMs < 1000 {
+		return errors.New("heartbeatIntervalMs must be at least 1000")
+	}
+	if s.DegradedAfterMissed < 1 {
+		return errors.New("degradedAfterMissed must be at least 1")
+	}
+	if s.OfflineAfterMissed <= s.DegradedAfterMissed {
+		return errors.New("offlineAfterMissed must be greater than degradedAfterMissed")
+	}
+	return nil
+}
+
+var (
+	tenantSettings      = make(map[string]LifecycleSettings)
+	tenantSettingsMutex sync.RWMutex
+	sweeperOnce         sync.Once
+)
+
+// GetLifecycleSettings returns the thresholds for a tenant (defaults if none are set)
+func GetLifecycleSettings(tenantID string) LifecycleSettings {
+	tenantSettingsMutex.RLock()
+	defer tenantSettingsMutex.RUnlock()
+	if s, ok := tenantSettings[tenantID]; ok {
+		return s
+	}
+	return DefaultLifecycleSettings
+}
+
+// SetLifecycleSettings stores a tenant's thresholds; sweepers on every replica apply them on their next pass
+func SetLifecycleSettings(ctx context.Context, tenantID string, s LifecycleSettings, updatedBy string) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	nodesMutex.RLock()
+	db := nodesDB
+	nodesMutex.RUnlock()
+	if db != nil {
+		_, err := db.Exec(ctx,
+			`INSERT INTO public.federation_tenant_settings
+			 (tenant_id, heartbeat_interval_ms, degraded_after_missed, offline_after_missed, updated_by, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, $6)
+			 ON CONFLICT (tenant_id) DO UPDATE SET
+			     heartbeat_interval_ms = EXCLUDED.heartbeat_interval_ms,
+			     degraded_after_missed = EXCLUDED.degraded_after_missed,
+			     offline_after_missed = EXCLUDED.offline_after_missed,
+			     updated_by = EXCLUDED.updated_by,
+			     updated_at = EXCLUDED.updated_at`,
+			tenantID, s.HeartbeatIntervalMs, s.DegradedAfterMissed, s.OfflineAfterMissed, updatedBy, time.Now(),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to store lifecycle settings: %w", err)
+		}
+	}
+
+	tenantSettingsMutex.Lock()
+	tenantSettings[tenantID] = s
+	tenantSettingsMutex.Unlock()
+	return nil
+}
+
+// loadLifecycleSettings reads all per-tenant thresholds into the cache (at startup and on every sweep)
+func loadLifecycleSettings(ctx context.Context) error {
+	nodesMutex.RLock()
+	db := nodesDB
+	nodesMutex.RUnlock()
+	if db == nil {
+		return nil
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT tenant_id, heartbeat_interval_ms, degraded_after_missed, offline_after_missed
+		 FROM public.federation_tenant_settings`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load lifecycle settings: %w", err)
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]LifecycleSettings)
+	for rows.Next() {
+		var tenantID string
+		var s LifecycleSettings
+		if err := rows.Scan(&tenantID, &s.HeartbeatIntervalMs, &s.DegradedAfterMissed, &s.OfflineAfterMissed); err != nil {
+			return fmt.Errorf("failed to scan lifecycle settings: %w", err)
+		}
+		loaded[tenantID] = s
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tenantSettingsMutex.Lock()
+	tenantSettings = loaded
+	tenantSettingsMutex.Unlock()
+	return nil
+}
+
+// nodeTransition records a state change to emit once the registry lock is released
+type nodeTransition struct {
+	node   NodeStatus
+	from   NodeState
+	reason string
+}
+
+// transitionLocked moves a cached node to a new state; callers hold nodesMutex
+func transitionLocked(node *NodeStatus, to NodeState, reason string, now int64) (*nodeTransition, bool) {
+	from := node.State
+	if from == to || !CanTransition(from, to) {
+		return nil, false
+	}
+	node.State = to
+	node.StateReason = reason
+	node.StateChangedAt = now
+	if to == NodeOnline || to == NodeJoined {
+		node.MissedHeartbeats = 0
+	}
+	return &nodeTransition{node: *node, from: from, reason: reason}, true
+}
+
+// emitTransitions publishes lifecycle events and persists the new states
+func emitTransitions(transitions []*nodeTransition) {
+	nodesMutex.RLock()
+	db := nodesDB
+	nodesMutex.RUnlock()
+
+	for _, t := range transitions {
+		publishTransition(t)
+		if db != nil {
+			snapshot := t.node
+			go persistNode(db, &snapshot)
+		}
+	}
+}
+
+// publishTransition logs a lifecycle transition and emits its event
+func publishTransition(t *nodeTransition) {
+	log.Printf("Federation node %s: %s -> %s (%s)", t.node.NodeID, t.from, t.node.State, t.reason)
+	AddEvent(EventNodeState, t.node.NodeID, t.node.TenantID, map[string]interface{}{
+		"from":             string(t.from),
+		"to":               string(t.node.State),
+		"reason":           t.reason,
+		"tenantId":         t.node.TenantID,
+		"missedHeartbeats": t.node.MissedHeartbeats,
+	})
+}
+
+// RegisterPendingNode records a node whose bootstrap kit was generated but has not joined yet
+func RegisterPendingNode(nodeID, tenantID string) {
+	nodesMutex.Lock()
+	if _, ok := nodes[nodeID]; ok {
+		nodesMutex.Unlock()
+		return
+	}
+	now := time.Now().UnixMilli()
+	node := &NodeStatus{NodeID: nodeID, TenantID: tenantID, State: NodePending, StateChangedAt: now, StateReason: "kit generated"}
+	nodes[nodeID] = node
+	snapshot := *node
+	db := nodesDB
+	nodesMutex.Unlock()
+
+	AddEvent(EventNodeState, nodeID, tenantID, map[string]interface{}{
+		"from":     "",
+		"to":       string(NodePending),
+		"reason":   snapshot.StateReason,
+		"tenantId": tenantID,
+	})
+	if db != nil {
+		go persistNode(db, &snapshot)
+	}
+}
+
+// MarkNodeJoined moves a node to joined after it exchanges its join token.
+// Nodes that are already online or degraded keep their state.
+func MarkNodeJoined(nodeID, tenantID string) error {
+	nodesMutex.Lock()
+	now := time.Now().UnixMilli()
+	node, ok := nodes[nodeID]
+	if !ok {
+		node = &NodeStatus{NodeID: nodeID, TenantID: tenantID, State: NodePending, StateChangedAt: now}
+		nodes[nodeID] = node
+	}
+	if node.State == NodeDecommissioned {
+		nodesMutex.Unlock()
+		return ErrNodeDecommissioned
+	}
+	if tenantID != "" {
+		node.TenantID = tenantID
+	}
+	t, changed := transitionLocked(node, NodeJoined, "node joined", now)
+	nodesMutex.Unlock()
+
+	if changed {
+		emitTransitions([]*nodeTransition{t})
+	}
+	return nil
+}
+
+// IsNodeDecommissioned reports whether a node has been retired
+func IsNodeDecommissioned(nodeID string) bool {
+	nodesMutex.RLock()
+	defer nodesMutex.RUnlock()
+	node, ok := nodes[nodeID]
+	return ok && node.State == NodeDecommissioned
+}
+
+// DecommissionNode retires a node; it is terminal and persisted synchronously
+// The persisted node is read first, so a node known to or retired by another replica is handled too.
+func DecommissionNode(ctx context.Context, nodeID, reason string) (*NodeStatus, error) {
+	nodesMutex.RLock()
+	db := nodesDB
+	nodesMutex.RUnlock()
+
+	var persisted *NodeStatus
+	if db != nil {
+		var err error
+		persisted, err = loadNode(ctx, db, nodeID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("failed to load federation node: %w", err)
+		}
+	}
+
+	nodesMutex.Lock()
+	node, ok := nodes[nodeID]
+	if persisted != nil && (!ok || persisted.State == NodeDecommissioned) {
+		nodes[nodeID] = persisted
+		node, ok = persisted, true
+	}
+	if !ok {
+		nodesMutex.Unlock()
+		return nil, ErrNodeNotFound
+	}
+	if node.State == NodeDecommissioned {
+		nodesMutex.Unlock()
+		return nil, ErrNodeDecommissioned
+	}
+	if reason == "" {
+		reason = "decommissioned by operator"
+	}
+	t, changed := transitionLocked(node, NodeDecommissioned, reason, time.Now().UnixMilli())
+	nodesMutex.Unlock()
+
+	if !changed {
+		return nil, ErrInvalidTransition
+	}
+	if db != nil {
+		if err := writeNode(ctx, db, &t.node); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Federation node %s: %s -> %s (%s)", nodeID, t.from, NodeDecommissioned, reason)
+	AddEvent(EventNodeState, nodeID, t.node.TenantID, map[string]interface{}{
+		"from":     string(t.from),
+		"to":       string(NodeDecommissioned),
+		"reason":   reason,
+		"tenantId": t.node.TenantID,
+	})
+	return nodeSnapshot(&t.node), nil
+}
+
+// sweepLifecycle counts missed heartbeats and degrades or offlines silent nodes (single replica)
+func sweepLifecycle(now int64) {
+	emitTransitions(collectSweepTransitions(now))
+}
+
+// collectSweepTransitions applies missed-heartbeat transitions to the cache and returns them.
+// Joined nodes that never heartbeat are counted from the time they joined.
+func collectSweepTransitions(now int64) []*nodeTransition {
+	var transitions []*nodeTransition
+
+	nodesMutex.Lock()
+	defer nodesMutex.Unlock()
+	for _, node := range nodes {
+		since := node.LastSeen
+		switch node.State {
+		case NodeOnline, NodeDegraded:
+		case NodeJoined:
+			if node.StateChangedAt > since {
+				since = node.StateChangedAt
+			}
+		default:
+			continue
+		}
+		settings := GetLifecycleSettings(node.TenantID)
+		missed := int((now - since) / settings.HeartbeatIntervalMs)
+		node.MissedHeartbeats = missed
+
+		var t *nodeTransition
+		var changed bool
+		switch {
+		case missed >= settings.OfflineAfterMissed:
+			t, changed = transitionLocked(node, NodeOffline, fmt.Sprintf("missed %d heartbeats", missed), now)
+		case missed >= settings.DegradedAfterMissed && node.State == NodeOnline:
+			t, changed = transitionLocked(node, NodeDegraded, fmt.Sprintf("missed %d heartbeats", missed), now)
+		}
+		if changed {
+			transitions = append(transitions, t)
+		}
+	}
+	return transitions
+}
+
+// sweepLifecycleShared runs the sweep on the one replica holding the sweep advisory lock.
+// Transitions are persisted before the lock is released, so the next holder sees them.
+func sweepLifecycleShared(ctx context.Context, db *pgxpool.Pool, now int64) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start sweep transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var leader bool
+	if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock(hashtext($1))`, lifecycleSweepLock).Scan(&leader); err != nil {
+		return fmt.Errorf("failed to take the lifecycle sweep lock: %w", err)
+	}
+	if !leader {
+		return nil // another replica is sweeping
+	}
+
+	for _, t := range collectSweepTransitions(now) {
+		if err := writeNode(ctx, db, &t.node); err != nil {
+			log.Printf("Failed to persist federation node %s: %v", t.node.NodeID, err)
+		}
+		publishTransition(t)
+	}
+	return tx.Commit(ctx)
+}
+
+// syncNodes merges heartbeats and transitions persisted by other replicas into the cache.
+// They were announced by the replica that made them, so no events are emitted here.
+func syncNodes(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx,
+		`SELECT `+nodeColumns+` FROM public.federation_nodes WHERE lifecycle_state IS NOT NULL`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load federation nodes: %w", err)
+	}
+	defer rows.Close()
+
+	var persisted []*NodeStatus
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan federation node: %w", err)
+		}
+		persisted = append(persisted, node)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	nodesMutex.Lock()
+	for _, node := range persisted {
+		mergePersistedNodeLocked(node)
+	}
+	nodesMutex.Unlock()
+	return nil
+}
+
+// mergePersistedNodeLocked folds a persisted node into the cache: the latest heartbeat wins,
+// the most recent transition wins, and decommissioned is final; callers hold nodesMutex
+func mergePersistedNodeLocked(persisted *NodeStatus) {
+	cached, ok := nodes[persisted.NodeID]
+	if !ok {
+		nodes[persisted.NodeID] = persisted
+		return
+	}
+	if persisted.LastSeen > cached.LastSeen {
+		cached.LastSeen = persisted.LastSeen
+		cached.TS = persisted.LastSeen
+	}
+	if cached.FirstSeen == 0 {
+		cached.FirstSeen = persisted.FirstSeen
+	}
+	if cached.State == NodeDecommissioned {
+		return
+	}
+	if persisted.State == NodeDecommissioned || persisted.StateChangedAt > cached.StateChangedAt {
+		cached.State = persisted.State
+		cached.StateReason = persisted.StateReason
+		cached.StateChangedAt = persisted.StateChangedAt
+		cached.MissedHeartbeats = persisted.MissedHeartbeats
+	}
+}
+
+// startLifecycleSweeper runs the missed-heartbeat sweeper in the background (once per process)
+// With Postgres, each pass first merges other replicas' heartbeats and transitions and reloads
+// the per-tenant thresholds, then sweeps only if this replica holds the sweep lock.
+func startLifecycleSweeper() {
+	sweeperOnce.Do(func() {
+		ticker := time.NewTicker(LifecycleSweepInterval)
+		go func() {
+			for range ticker.C {
+				nodesMutex.RLock()
+				db := nodesDB
+				nodesMutex.RUnlock()
+				if db == nil {
+					sweepLifecycle(time.Now().UnixMilli())
+					continue
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), LifecycleSweepInterval)
+				if err := syncNodes(ctx, db); err != nil {
+					// Sweeping without other replicas' heartbeats would offline healthy nodes
+					log.Printf("Failed to sync federation nodes, skipping sweep: %v", err)
+					cancel()
+					continue
+				}
+				if err := loadLifecycleSettings(ctx); err != nil {
+					log.Printf("Failed to reload federation lifecycle settings: %v", err)
+				}
+				if err := sweepLifecycleShared(ctx, db, time.Now().UnixMilli()); err != nil {
+					log.Printf("Failed to sweep federation node lifecycle: %v", err)
+				}
+				cancel()
+			}
+		}()
+	})
+}
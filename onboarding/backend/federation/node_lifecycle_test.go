@@ -0,0 +1,193 @@
+package federation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to NodeState
+		want     bool
+	}{
+		{NodePending, NodeJoined, true},
+		{NodePending, NodeOnline, true},
+		{NodePending, NodeDegraded, false},
+		{NodePending, NodeOffline, false},
+		{NodeJoined, NodeOnline, true},
+		{NodeJoined, NodeOffline, true},
+		{NodeJoined, NodePending, false},
+		{NodeOnline, NodeDegraded, true},
+		{NodeOnline, NodeOffline, true},
+		{NodeOnline, NodeJoined, false},
+		{NodeDegraded, NodeOnline, true},
+		{NodeDegraded, NodeOffline, true},
+		{NodeOffline, NodeOnline, true},
+		{NodeOffline, NodeJoined, true},
+		{NodeOffline, NodeDegraded, false},
+		{NodeOnline, NodeDecommissioned, true},
+		{NodeOffline, NodeDecommissioned, true},
+		{NodeDecommissioned, NodeOnline, false},
+		{NodeDecommissioned, NodeJoined, false},
+		{NodeOnline, NodeOnline, false},
+	}
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestTransitionLocked(t *testing.T) {
+	node := &NodeStatus{NodeID: "node-1", State: NodeDegraded, MissedHeartbeats: 2}
+
+	if _, changed := transitionLocked(node, NodeDegraded, "again", 1); changed {
+		t.Fatal("transition to the current state reported a change")
+	}
+	if _, changed := transitionLocked(node, NodeJoined, "rejoin", 1); changed {
+		t.Fatal("degraded -> joined is not allowed")
+	}
+
+	tr, changed := transitionLocked(node, NodeOnline, "heartbeat received", 42)
+	if !changed {
+		t.Fatal("degraded -> online not applied")
+	}
+	if tr.from != NodeDegraded || tr.node.State != NodeOnline || tr.reason != "heartbeat received" {
+		t.Fatalf("transition = %+v", tr)
+	}
+	if node.StateChangedAt != 42 || node.MissedHeartbeats != 0 || node.StateReason != "heartbeat received" {
+		t.Fatalf("node = %+v", node)
+	}
+}
+
+func TestLifecycleSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       LifecycleSettings
+		wantErr bool
+	}{
+		{"defaults", DefaultLifecycleSettings, false},
+		{"interval too short", LifecycleSettings{HeartbeatIntervalMs: 500, DegradedAfterMissed: 2, OfflineAfterMissed: 3}, true},
+		{"degraded below one", LifecycleSettings{HeartbeatIntervalMs: 1000, DegradedAfterMissed: 0, OfflineAfterMissed: 3}, true},
+		{"offline not after degraded", LifecycleSettings{HeartbeatIntervalMs: 1000, DegradedAfterMissed: 3, OfflineAfterMissed: 3}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.s.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	defaults := DefaultLifecycleSettings
+	if got := defaults.HeartbeatIntervalMs * int64(defaults.OfflineAfterMissed); got != OFFLINE_THRESHOLD {
+		t.Fatalf("default offline timeout = %dms, want OFFLINE_THRESHOLD (%dms)", got, OFFLINE_THRESHOLD)
+	}
+}
+
+func TestSweepLifecycle(t *testing.T) {
+	interval := DefaultLifecycleSettings.HeartbeatIntervalMs
+	now := int64(1_000_000_000)
+
+	nodesMutex.Lock()
+	saved := nodes
+	nodes = map[string]*NodeStatus{
+		"fresh":    {NodeID: "fresh", State: NodeOnline, LastSeen: now},
+		"late":     {NodeID: "late", State: NodeOnline, LastSeen: now - 2*interval},
+		"silent":   {NodeID: "silent", State: NodeDegraded, LastSeen: now - 3*interval},
+		"pending":  {NodeID: "pending", State: NodePending, LastSeen: 0},
+		"retired":  {NodeID: "retired", State: NodeDecommissioned, LastSeen: 0},
+		"too-late": {NodeID: "too-late", State: NodeOnline, LastSeen: now - 10*interval},
+		"joined":   {NodeID: "joined", State: NodeJoined, StateChangedAt: now - interval},
+		"mute":     {NodeID: "mute", State: NodeJoined, StateChangedAt: now - 3*interval},
+	}
+	nodesMutex.Unlock()
+	t.Cleanup(func() {
+		nodesMutex.Lock()
+		nodes = saved
+		nodesMutex.Unlock()
+	})
+
+	sweepLifecycle(now)
+
+	want := map[string]NodeState{
+		"fresh":    NodeOnline,
+		"late":     NodeDegraded,
+		"silent":   NodeOffline,
+		"pending":  NodePending,
+		"retired":  NodeDecommissioned,
+		"too-late": NodeOffline,
+		"joined":   NodeJoined,
+		"mute":     NodeOffline,
+	}
+	nodesMutex.RLock()
+	defer nodesMutex.RUnlock()
+	for nodeID, state := range want {
+		if got := nodes[nodeID].State; got != state {
+			t.Errorf("%s: state = %s, want %s", nodeID, got, state)
+		}
+	}
+}
+
+func TestMergePersistedNode(t *testing.T) {
+	tests := []struct {
+		name      string
+		cached    *NodeStatus
+		persisted NodeStatus
+		want      NodeStatus
+	}{
+		{
+			"heartbeat through another replica",
+			&NodeStatus{NodeID: "n", State: NodeDegraded, LastSeen: 100, StateChangedAt: 200},
+			NodeStatus{NodeID: "n", State: NodeDegraded, LastSeen: 300, StateChangedAt: 200},
+			NodeStatus{NodeID: "n", State: NodeDegraded, LastSeen: 300, TS: 300, StateChangedAt: 200},
+		},
+		{
+			"newer transition wins",
+			&NodeStatus{NodeID: "n", State: NodeOnline, LastSeen: 100, StateChangedAt: 100},
+			NodeStatus{NodeID: "n", State: NodeOffline, StateReason: "missed 3 heartbeats", LastSeen: 100, StateChangedAt: 400, MissedHeartbeats: 3},
+			NodeStatus{NodeID: "n", State: NodeOffline, StateReason: "missed 3 heartbeats", LastSeen: 100, StateChangedAt: 400, MissedHeartbeats: 3},
+		},
+		{
+			"older transition is ignored",
+			&NodeStatus{NodeID: "n", State: NodeOnline, StateReason: "heartbeat received", LastSeen: 500, StateChangedAt: 500},
+			NodeStatus{NodeID: "n", State: NodeOffline, LastSeen: 100, StateChangedAt: 400},
+			NodeStatus{NodeID: "n", State: NodeOnline, StateReason: "heartbeat received", LastSeen: 500, StateChangedAt: 500},
+		},
+		{
+			"decommissioned is final",
+			&NodeStatus{NodeID: "n", State: NodeDecommissioned, StateChangedAt: 100},
+			NodeStatus{NodeID: "n", State: NodeOnline, LastSeen: 600, StateChangedAt: 600},
+			NodeStatus{NodeID: "n", State: NodeDecommissioned, LastSeen: 600, TS: 600, StateChangedAt: 100},
+		},
+		{
+			"unknown node is added",
+			nil,
+			NodeStatus{NodeID: "n", State: NodeJoined, StateChangedAt: 100},
+			NodeStatus{NodeID: "n", State: NodeJoined, StateChangedAt: 100},
+		},
+	}
+
+	nodesMutex.Lock()
+	saved := nodes
+	nodesMutex.Unlock()
+	t.Cleanup(func() {
+		nodesMutex.Lock()
+		nodes = saved
+		nodesMutex.Unlock()
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodesMutex.Lock()
+			defer nodesMutex.Unlock()
+			nodes = map[string]*NodeStatus{}
+			if tt.cached != nil {
+				nodes["n"] = tt.cached
+			}
+			persisted := tt.persisted
+			mergePersistedNodeLocked(&persisted)
+			if got := *nodes["n"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merged = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
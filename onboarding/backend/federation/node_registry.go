@@ -1,77 +1,322 @@
 package federation
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
 	"sync"
 	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/metrics"
 )
 
-// Phase 14.2: In-memory node registry
+// Phase 14.2: Node registry
 // Phase 14.3: Extended with offline detection and status calculation
-// Later phases will move to real persistence if needed
+// Lifecycle states (pending/joined/online/degraded/offline/decommissioned) are in node_lifecycle.go
+// Nodes are persisted in public.federation_nodes (shared with FederationRouter) and kept in an
+// in-memory read cache so GetNodes stays cheap; heartbeats write through to Postgres.
 
-// OFFLINE_THRESHOLD is the timeout in milliseconds for considering a node offline
-// Default: 45 seconds (configurable for SAGE Prime tuning)
+// OFFLINE_THRESHOLD is the default timeout in milliseconds for considering a node offline
+// Default: 45 seconds; DefaultLifecycleSettings derive from it, per-tenant thresholds override it
 const OFFLINE_THRESHOLD = 45 * 1000 // 45 seconds in milliseconds
 
 // NodeStatus represents a node's current status
 type NodeStatus struct {
-	NodeID   string `json:"nodeId"`
-	TS       int64  `json:"ts"`
-	Status   string `json:"status"`   // "online" or "offline"
-	LastSeen int64  `json:"lastSeen"` // Timestamp of last heartbeat
+	NodeID       string                 `json:"nodeId"`
+	TenantID     string                 `json:"tenantId,omitempty"`
+	Region       string                 `json:"region,omitempty"`
+	AgentVersion string                 `json:"agentVersion,omitempty"`
+	Hardware     map[string]interface{} `json:"hardware,omitempty"`
+	IPAddress    string                 `json:"ipAddress,omitempty"`
+	FirstSeen    int64                  `json:"firstSeen"` // Timestamp of first heartbeat
+	TS           int64                  `json:"ts"`
+	Status       string                 `json:"status"`   // "online" or "offline" (legacy view of State)
+	LastSeen     int64                  `json:"lastSeen"` // Timestamp of last heartbeat
+
+	State            NodeState `json:"state"`
+	StateReason      string    `json:"stateReason,omitempty"`
+	StateChangedAt   int64     `json:"stateChangedAt"`
+	MissedHeartbeats int       `json:"missedHeartbeats"`
+
+	Relay bool `json:"relay"` // may forward bus messages for other nodes of its tenant
+}
+
+// HeartbeatInfo is node metadata reported with a heartbeat
+type HeartbeatInfo struct {
+	TenantID     string
+	Region       string
+	AgentVersion string
+	Hardware     map[string]interface{}
+	IPAddress    string
 }
 
 var (
-	nodes     = make(map[string]*NodeStatus)
+	nodes      = make(map[string]*NodeStatus)
 	nodesMutex sync.RWMutex
+	nodesDB    *pgxpool.Pool
 )
 
-// UpdateNodeHeartbeat updates the heartbeat timestamp for a node
-func UpdateNodeHeartbeat(nodeID string) {
+// nodeColumns are the federation_nodes columns read by scanNode
+const nodeColumns = `node_id, COALESCE(tenant_id, ''), COALESCE(NULLIF(reported_region, ''), region, ''), COALESCE(agent_version, ''),
+	hardware, COALESCE(ip_address, ''), first_seen_at, last_seen_at,
+	COALESCE(lifecycle_state, ''), COALESCE(state_reason, ''), state_changed_at, missed_heartbeats,
+	relay_enabled`
+
+// scanNode scans a federation_nodes row selected with nodeColumns
+func scanNode(row pgx.Row) (*NodeStatus, error) {
+	var node NodeStatus
+	var hardwareJSON []byte
+	var firstSeen, lastSeen, stateChangedAt *time.Time
+	if err := row.Scan(&node.NodeID, &node.TenantID, &node.Region, &node.AgentVersion,
+		&hardwareJSON, &node.IPAddress, &firstSeen, &lastSeen,
+		&node.State, &node.StateReason, &stateChangedAt, &node.MissedHeartbeats, &node.Relay); err != nil {
+		return nil, err
+	}
+	if len(hardwareJSON) > 0 {
+		if err := json.Unmarshal(hardwareJSON, &node.Hardware); err != nil {
+			log.Printf("Failed to unmarshal hardware for node %s: %v", node.NodeID, err)
+		}
+	}
+	node.FirstSeen = unixMilliOrZero(firstSeen)
+	node.TS = unixMilliOrZero(lastSeen)
+	node.LastSeen = node.TS
+	node.StateChangedAt = unixMilliOrZero(stateChangedAt)
+	if node.State == "" {
+		// Nodes seen before lifecycle tracking; the sweeper settles them on its first pass
+		node.State = NodeOnline
+	}
+	return &node, nil
+}
+
+// loadNode reads a persisted node; pgx.ErrNoRows when it is unknown
+func loadNode(ctx context.Context, db *pgxpool.Pool, nodeID string) (*NodeStatus, error) {
+	return scanNode(db.QueryRow(ctx,
+		`SELECT `+nodeColumns+` FROM public.federation_nodes WHERE node_id = $1`,
+		nodeID,
+	))
+}
+
+// InitNodeRegistry attaches the registry to Postgres and warms the cache with known nodes
+func InitNodeRegistry(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx,
+		`SELECT `+nodeColumns+`
+		 FROM public.federation_nodes
+		 WHERE last_seen_at IS NOT NULL OR lifecycle_state IS NOT NULL OR relay_enabled`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load federation nodes: %w", err)
+	}
+	defer rows.Close()
+
+	loaded := make(map[string]*NodeStatus)
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan federation node: %w", err)
+		}
+		loaded[node.NodeID] = node
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
 	nodesMutex.Lock()
-	defer nodesMutex.Unlock()
-	
+	for nodeID, node := range loaded {
+		if _, ok := nodes[nodeID]; !ok {
+			nodes[nodeID] = node
+		}
+	}
+	nodesDB = db
+	nodesMutex.Unlock()
+
+	if err := loadLifecycleSettings(ctx); err != nil {
+		return err
+	}
+	startLifecycleSweeper()
+	metrics.SetNodeStateSource(nodeStateNames, nodeStateCounts)
+
+	log.Printf("Federation node registry loaded %d node(s)", len(loaded))
+	return nil
+}
+
+// UpdateNodeHeartbeat updates the heartbeat timestamp and metadata for a node and moves it online
+// Empty metadata fields keep their previously recorded values; decommissioned nodes are ignored
+func UpdateNodeHeartbeat(nodeID string, info HeartbeatInfo) error {
+	nodesMutex.Lock()
+
 	now := time.Now().UnixMilli()
-	nodes[nodeID] = &NodeStatus{
-		NodeID:   nodeID,
-		TS:       now,
-		Status:   "online",
-		LastSeen: now,
+	node, ok := nodes[nodeID]
+	if !ok {
+		node = &NodeStatus{NodeID: nodeID, State: NodePending, StateChangedAt: now}
+		nodes[nodeID] = node
+	}
+	if node.State == NodeDecommissioned {
+		nodesMutex.Unlock()
+		return ErrNodeDecommissioned
+	}
+	if node.FirstSeen == 0 {
+		node.FirstSeen = now
+	}
+	node.TS = now
+	node.LastSeen = now
+	node.MissedHeartbeats = 0
+	if info.TenantID != "" {
+		node.TenantID = info.TenantID
+	}
+	if info.Region != "" {
+		node.Region = info.Region
+	}
+	if info.AgentVersion != "" {
+		node.AgentVersion = info.AgentVersion
+	}
+	if info.Hardware != nil {
+		node.Hardware = info.Hardware
+	}
+	if info.IPAddress != "" {
+		node.IPAddress = info.IPAddress
+	}
+	t, changed := transitionLocked(node, NodeOnline, "heartbeat received", now)
+	snapshot := *node
+	db := nodesDB
+
+	nodesMutex.Unlock()
+
+	if changed {
+		// emitTransitions also persists the node
+		emitTransitions([]*nodeTransition{t})
+	} else if db != nil {
+		go persistNode(db, &snapshot)
+	}
+	return nil
+}
+
+// persistNode writes the node in the background, logging failures
+func persistNode(db *pgxpool.Pool, node *NodeStatus) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := writeNode(ctx, db, node); err != nil {
+		log.Printf("Failed to persist federation node %s: %v", node.NodeID, err)
 	}
 }
 
+// writeNode upserts the node into federation_nodes without touching routing columns: a heartbeat may
+// carry the node ID of a routed database node, and must not move it. The reported region is kept in
+// reported_region; region, database_url, status and the pool settings belong to operators.
+// Lifecycle columns only move forward in time, and decommissioned is never overwritten.
+func writeNode(ctx context.Context, db *pgxpool.Pool, node *NodeStatus) error {
+	var hardwareJSON []byte
+	if node.Hardware != nil {
+		hardwareJSON, _ = json.Marshal(node.Hardware)
+	}
+
+	_, err := db.Exec(ctx,
+		`INSERT INTO public.federation_nodes
+		 (node_id, reported_region, tenant_id, agent_version, hardware, ip_address, first_seen_at, last_seen_at,
+		  lifecycle_state, state_reason, state_changed_at, missed_heartbeats)
+		 VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), $5, NULLIF($6, ''), $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11, $12)
+		 ON CONFLICT (node_id) DO UPDATE SET
+		     reported_region = COALESCE(EXCLUDED.reported_region, federation_nodes.reported_region),
+		     tenant_id = COALESCE(EXCLUDED.tenant_id, federation_nodes.tenant_id),
+		     agent_version = COALESCE(EXCLUDED.agent_version, federation_nodes.agent_version),
+		     hardware = COALESCE(EXCLUDED.hardware, federation_nodes.hardware),
+		     ip_address = COALESCE(EXCLUDED.ip_address, federation_nodes.ip_address),
+		     first_seen_at = COALESCE(federation_nodes.first_seen_at, EXCLUDED.first_seen_at),
+		     last_seen_at = GREATEST(federation_nodes.last_seen_at, EXCLUDED.last_seen_at),
+		     lifecycle_state = CASE WHEN federation_nodes.lifecycle_state = 'decommissioned'
+		                              OR EXCLUDED.state_changed_at < federation_nodes.state_changed_at
+		                            THEN federation_nodes.lifecycle_state ELSE EXCLUDED.lifecycle_state END,
+		     state_reason = CASE WHEN federation_nodes.lifecycle_state = 'decommissioned'
+		                           OR EXCLUDED.state_changed_at < federation_nodes.state_changed_at
+		                         THEN federation_nodes.state_reason ELSE EXCLUDED.state_reason END,
+		     missed_heartbeats = EXCLUDED.missed_heartbeats,
+		     state_changed_at = GREATEST(federation_nodes.state_changed_at, EXCLUDED.state_changed_at)`,
+		node.NodeID,
+		node.Region,
+		node.TenantID,
+		node.AgentVersion,
+		hardwareJSON,
+		node.IPAddress,
+		timeOrNil(node.FirstSeen),
+		timeOrNil(node.LastSeen),
+		string(node.State),
+		node.StateReason,
+		timeOrNil(node.StateChangedAt),
+		node.MissedHeartbeats,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to persist federation node: %w", err)
+	}
+	return nil
+}
+
+// timeOrNil converts a millisecond timestamp to a nullable time (0 = NULL)
+func timeOrNil(ms int64) *time.Time {
+	if ms == 0 {
+		return nil
+	}
+	t := time.UnixMilli(ms)
+	return &t
+}
+
+func unixMilliOrZero(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
 // GetNodes returns all registered nodes with calculated status
-// Phase 14.3: Automatically calculates online/offline status based on timeout
+// Phase 14.3: Status is the legacy online/offline view; State carries the full lifecycle
 func GetNodes() []*NodeStatus {
 	nodesMutex.RLock()
 	defer nodesMutex.RUnlock()
-	
-	now := time.Now().UnixMilli()
+
 	result := make([]*NodeStatus, 0, len(nodes))
-	
+
 	for _, node := range nodes {
-		// Calculate if node is offline based on threshold
-		offline := (now - node.TS) > OFFLINE_THRESHOLD
-		
-		status := "online"
-		if offline {
-			status = "offline"
-		}
-		
-		result = append(result, &NodeStatus{
-			NodeID:   node.NodeID,
-			TS:       node.TS,
-			Status:   status,
-			LastSeen: node.TS,
-		})
+		result = append(result, nodeSnapshot(node))
 	}
 	return result
 }
 
+// nodeStateNames lists every lifecycle state exported as a node gauge
+var nodeStateNames = []string{string(NodePending), string(NodeJoined), string(NodeOnline), string(NodeDegraded),
+	string(NodeOffline), string(NodeDecommissioned)}
+
+// nodeStateCounts counts registered nodes by lifecycle state
+func nodeStateCounts() map[string]int {
+	nodesMutex.RLock()
+	defer nodesMutex.RUnlock()
+	counts := make(map[string]int, len(nodeStateNames))
+	for _, node := range nodes {
+		counts[string(node.State)]++
+	}
+	return counts
+}
+
 // GetNode returns a specific node's status
 func GetNode(nodeID string) *NodeStatus {
 	nodesMutex.RLock()
 	defer nodesMutex.RUnlock()
-	return nodes[nodeID]
+	node, ok := nodes[nodeID]
+	if !ok {
+		return nil
+	}
+	return nodeSnapshot(node)
 }
 
+// nodeSnapshot copies a cached node with its legacy status derived from the lifecycle state
+func nodeSnapshot(node *NodeStatus) *NodeStatus {
+	snapshot := *node
+
+	snapshot.Status = "offline"
+	if node.State == NodeOnline || node.State == NodeDegraded {
+		snapshot.Status = "online"
+	}
+	return &snapshot
+}
@@ -0,0 +1,273 @@
+package federation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Durable token revocation list backed by public.federation_revocations
+// Revocations apply by token hash, node ID or tenant ID. Lookups hit an in-process cache
+// that is refreshed from Postgres on a short interval so every replica converges quickly.
+// Token entries carry the token's expiry and are purged once the token could no longer verify anyway.
+
+// RevocationKind is what a revocation entry matches on
+type RevocationKind string
+
+const (
+	RevokeToken  RevocationKind = "token"
+	RevokeNode   RevocationKind = "node"
+	RevokeTenant RevocationKind = "tenant"
+)
+
+// ErrTokenRevoked is returned when a token, its node or its tenant has been revoked
+var ErrTokenRevoked = errors.New("federation token revoked")
+
+// Revocation is a single revocation list entry
+type Revocation struct {
+	ID        string         `json:"id"`
+	Kind      RevocationKind `json:"kind"`
+	Value     string         `json:"value"`
+	Reason    string         `json:"reason,omitempty"`
+	RevokedBy string         `json:"revokedBy"`
+	RevokedAt time.Time      `json:"revokedAt"`
+	ExpiresAt *time.Time     `json:"expiresAt,omitempty"` // token entries only
+}
+
+// RevocationList caches the revocation table in memory
+type RevocationList struct {
+	db      *pgxpool.Pool
+	mu      sync.RWMutex
+	entries map[RevocationKind]map[string]*Revocation
+}
+
+var revocations *RevocationList
+
+// DefaultRevocationRefresh is how often the in-process cache reloads from Postgres
+const DefaultRevocationRefresh = 10 * time.Second
+
+// InitRevocations loads the revocation list and refreshes it in the background
+func InitRevocations(ctx context.Context, db *pgxpool.Pool, refresh time.Duration) error {
+	rl := &RevocationList{db: db, entries: emptyRevocationEntries()}
+	if err := rl.reload(ctx); err != nil {
+		return err
+	}
+	revocations = rl
+
+	if refresh <= 0 {
+		refresh = DefaultRevocationRefresh
+	}
+	ticker := time.NewTicker(refresh)
+	go func() {
+		for range ticker.C {
+			if err := rl.reload(context.Background()); err != nil {
+				log.Printf("Failed to refresh federation revocation list: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func emptyRevocationEntries() map[RevocationKind]map[string]*Revocation {
+	return map[RevocationKind]map[string]*Revocation{
+		RevokeToken:  {},
+		RevokeNode:   {},
+		RevokeTenant: {},
+	}
+}
+
+// reload purges token entries past their expiry and replaces the cache with the remaining rows
+func (rl *RevocationList) reload(ctx context.Context) error {
+	// Expired tokens fail verification before the revocation check, so their entries are dead weight
+	cutoff := time.Now().Add(-clockSkew)
+	if _, err := rl.db.Exec(ctx,
+		`DELETE FROM public.federation_revocations WHERE kind = 'token' AND expires_at < $1`,
+		cutoff,
+	); err != nil {
+		return fmt.Errorf("failed to purge expired token revocations: %w", err)
+	}
+
+	rows, err := rl.db.Query(ctx,
+		`SELECT id, kind, value, COALESCE(reason, ''), revoked_by, revoked_at, expires_at
+		 FROM public.federation_revocations
+		 WHERE expires_at IS NULL OR expires_at >= $1`,
+		cutoff,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query revocations: %w", err)
+	}
+	defer rows.Close()
+
+	entries := emptyRevocationEntries()
+	for rows.Next() {
+		var rev Revocation
+		if err := rows.Scan(&rev.ID, &rev.Kind, &rev.Value, &rev.Reason, &rev.RevokedBy, &rev.RevokedAt, &rev.ExpiresAt); err != nil {
+			return fmt.Errorf("failed to scan revocation: %w", err)
+		}
+		if m, ok := entries[rev.Kind]; ok {
+			m[rev.Value] = &rev
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rl.mu.Lock()
+	rl.entries = entries
+	rl.mu.Unlock()
+	return nil
+}
+
+// lookup returns the revocation matching the token, its node or its tenant
+func (rl *RevocationList) lookup(tokenHash, nodeID, tenantID string) *Revocation {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	if rev := rl.entries[RevokeToken][tokenHash]; rev != nil && tokenHash != "" {
+		return rev
+	}
+	if rev := rl.entries[RevokeNode][nodeID]; rev != nil && nodeID != "" {
+		return rev
+	}
+	if rev := rl.entries[RevokeTenant][tenantID]; rev != nil && tenantID != "" {
+		return rev
+	}
+	return nil
+}
+
+// TokenHash returns the hex SHA-256 of a token, used to reference tokens without storing them
+func TokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindRevocation returns the revocation entry affecting this token, or nil
+func FindRevocation(token string, payload *FederationTokenPayload) *Revocation {
+	if revocations == nil || payload == nil {
+		return nil
+	}
+	return revocations.lookup(TokenHash(token), payload.NodeID, payload.TenantID)
+}
+
+// IsRevoked reports whether a token hash, node or tenant is on the revocation list
+func IsRevoked(tokenHash, nodeID, tenantID string) bool {
+	if revocations == nil {
+		return false
+	}
+	return revocations.lookup(tokenHash, nodeID, tenantID) != nil
+}
+
+// CheckRevocation returns ErrTokenRevoked if the token, its node or its tenant is revoked
+func CheckRevocation(token string, payload *FederationTokenPayload) error {
+	if rev := FindRevocation(token, payload); rev != nil {
+		return fmt.Errorf("%w (%s %s)", ErrTokenRevoked, rev.Kind, rev.Value)
+	}
+	return nil
+}
+
+// Revoke adds an entry to the revocation list; it takes effect locally immediately
+// and on other replicas at their next refresh.
+// tokenExpiresAt is the revoked token's expiry (kind=token); when zero it is taken from
+// public.federation_tokens, and entries whose expiry stays unknown are kept indefinitely.
+func Revoke(ctx context.Context, kind RevocationKind, value, reason, revokedBy string, tokenExpiresAt time.Time) (*Revocation, error) {
+	if revocations == nil {
+		return nil, errors.New("revocation list not initialized")
+	}
+	switch kind {
+	case RevokeToken, RevokeNode, RevokeTenant:
+	default:
+		return nil, fmt.Errorf("unknown revocation kind: %s", kind)
+	}
+
+	var expiresAt *time.Time
+	if kind == RevokeToken && !tokenExpiresAt.IsZero() {
+		expiresAt = &tokenExpiresAt
+	}
+
+	rev := &Revocation{Kind: kind, Value: value, Reason: reason, RevokedBy: revokedBy}
+	err := revocations.db.QueryRow(ctx,
+		`INSERT INTO public.federation_revocations (kind, value, reason, revoked_by, revoked_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5,
+		         CASE WHEN $1 = 'token'
+		              THEN COALESCE($6::timestamptz, (SELECT expires_at FROM public.federation_tokens WHERE token_hash = $2))
+		         END)
+		 ON CONFLICT (kind, value) DO UPDATE SET reason = EXCLUDED.reason, revoked_by = EXCLUDED.revoked_by,
+		     expires_at = COALESCE(EXCLUDED.expires_at, federation_revocations.expires_at)
+		 RETURNING id, revoked_at, expires_at`,
+		string(kind), value, reason, revokedBy, time.Now(), expiresAt,
+	).Scan(&rev.ID, &rev.RevokedAt, &rev.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store revocation: %w", err)
+	}
+
+	revocations.mu.Lock()
+	revocations.entries[kind][value] = rev
+	revocations.mu.Unlock()
+
+	AddEvent("revocation", nodeIDForRevocation(rev), tenantIDForRevocation(rev), map[string]interface{}{
+		"kind":   string(kind),
+		"value":  value,
+		"reason": reason,
+	})
+	return rev, nil
+}
+
+// Unrevoke removes a revocation entry by ID
+func Unrevoke(ctx context.Context, id string) (*Revocation, error) {
+	if revocations == nil {
+		return nil, errors.New("revocation list not initialized")
+	}
+
+	var rev Revocation
+	err := revocations.db.QueryRow(ctx,
+		`DELETE FROM public.federation_revocations WHERE id = $1
+		 RETURNING id, kind, value, COALESCE(reason, ''), revoked_by, revoked_at, expires_at`,
+		id,
+	).Scan(&rev.ID, &rev.Kind, &rev.Value, &rev.Reason, &rev.RevokedBy, &rev.RevokedAt, &rev.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	revocations.mu.Lock()
+	if m, ok := revocations.entries[rev.Kind]; ok {
+		delete(m, rev.Value)
+	}
+	revocations.mu.Unlock()
+	return &rev, nil
+}
+
+// ListRevocations returns the cached revocation entries
+func ListRevocations() []*Revocation {
+	result := []*Revocation{}
+	if revocations == nil {
+		return result
+	}
+	revocations.mu.RLock()
+	defer revocations.mu.RUnlock()
+	for _, m := range revocations.entries {
+		for _, rev := range m {
+			result = append(result, rev)
+		}
+	}
+	return result
+}
+
+func nodeIDForRevocation(rev *Revocation) string {
+	if rev.Kind == RevokeNode {
+		return rev.Value
+	}
+	return ""
+}
+
+func tenantIDForRevocation(rev *Revocation) string {
+	if rev.Kind == RevokeTenant {
+		return rev.Value
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package federation
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRevocationRefresh(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	// Two replicas sharing the table; revocations go through replica A
+	replicaA := &RevocationList{db: pool, entries: emptyRevocationEntries()}
+	replicaB := &RevocationList{db: pool, entries: emptyRevocationEntries()}
+	saved := revocations
+	revocations = replicaA
+	t.Cleanup(func() { revocations = saved })
+
+	nodeID := testID("node")
+	liveToken := TokenHash(testID("live"))
+	expiredToken := TokenHash(testID("expired"))
+	t.Cleanup(func() {
+		_, _ = pool.Exec(ctx, "DELETE FROM public.federation_revocations WHERE value = ANY($1)",
+			[]string{nodeID, liveToken, expiredToken})
+	})
+
+	if _, err := Revoke(ctx, RevokeNode, nodeID, "test", "tester", time.Time{}); err != nil {
+		t.Fatalf("Revoke node: %v", err)
+	}
+	live, err := Revoke(ctx, RevokeToken, liveToken, "test", "tester", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Revoke live token: %v", err)
+	}
+	if live.ExpiresAt == nil {
+		t.Fatal("token revocation stored without expiry")
+	}
+	if _, err := Revoke(ctx, RevokeToken, expiredToken, "test", "tester", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("Revoke expired token: %v", err)
+	}
+
+	if err := replicaB.reload(ctx); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if replicaB.lookup("", nodeID, "") == nil {
+		t.Error("node revocation not picked up by the other replica")
+	}
+	if replicaB.lookup(liveToken, "", "") == nil {
+		t.Error("live token revocation not picked up by the other replica")
+	}
+	if replicaB.lookup(expiredToken, "", "") != nil {
+		t.Error("expired token revocation still cached")
+	}
+
+	var remaining int
+	if err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM public.federation_revocations WHERE value = $1", expiredToken).Scan(&remaining); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if remaining != 0 {
+		t.Error("expired token revocation not purged")
+	}
+
+	// Lifting a revocation on A reaches B at its next refresh
+	rev := replicaA.lookup("", nodeID, "")
+	if _, err := Unrevoke(ctx, rev.ID); err != nil {
+		t.Fatalf("Unrevoke: %v", err)
+	}
+	if err := replicaB.reload(ctx); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if replicaB.lookup("", nodeID, "") != nil {
+		t.Error("lifted node revocation still cached")
+	}
+}
@@ -1,40 +1,149 @@
 package federation
 
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
 // Phase 14.6: Federation Routing Rules & Bus API
-// Central router module for clean classification and routing of federation messages
+// Built-in bus handlers for clean classification and routing of federation messages
 // Internal routing only - no execution, just classification
 
-// RouteMessage routes a federation message to the appropriate handler
-// This is NOT execution—just classification and internal routing
-func RouteMessage(eventType string, nodeID string, data map[string]interface{}) {
-	// Always record events first
-	AddEvent(eventType, nodeID, data)
+// Origin describes where a bus message came from (taken from the verified token and request)
+type Origin struct {
+	NodeID   string
+	TenantID string
+	Scopes   []string
+	RemoteIP string
+}
+
+// InitBus registers the built-in message handlers, payload schemas and default middleware on Bus and
+// enables dead-lettering and deduplication. Middleware order: validation, node/tenant authorization,
+// decommission check, metrics, schema validation, deduplication, event recording.
+func InitBus(db *pgxpool.Pool, policy UnknownTypePolicy, audit RejectionAuditor) error {
+	Bus.AttachStore(db)
+	Bus.SetUnknownTypePolicy(policy)
+	Bus.Use(ValidateMessage(), AuthorizeNode(audit), RequireActiveNode(), Bus.Instrument(), Bus.ValidateSchema(),
+		Bus.Deduplicate(), Bus.RecordEvents())
+	if err := Bus.registerBuiltinSchemas(); err != nil {
+		return err
+	}
+	Bus.startDedupPurger()
+
+	builtins := map[string]HandlerFunc{
+		"heartbeat": handleHeartbeatMessage,
+		"telemetry": handleTelemetryMessage,
+		"command":   handleCommandMessage,
+		"event":     handleEventMessage,
+	}
+	for msgType, h := range builtins {
+		if err := Bus.Register(msgType, 1, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// Route to appropriate handler based on type
-	switch eventType {
-	case "heartbeat":
-		// Route to node registry
-		UpdateNodeHeartbeat(nodeID)
-		return
+// HeartbeatResponse is the reply to a heartbeat (v1)
+type HeartbeatResponse struct {
+	OK     bool   `json:"ok"`
+	Status string `json:"status"`
+}
 
-	case "telemetry":
-		// Telemetry placeholder - safe no-op
-		// Future: route to telemetry store
-		return
+// TelemetryResponse is the reply to a telemetry batch (v1)
+type TelemetryResponse struct {
+	OK       bool   `json:"ok"`
+	NodeID   string `json:"nodeId"`
+	TenantID string `json:"tenantId"`
+	Type     string `json:"type"`
+	Accepted int    `json:"accepted"`
+}
 
-	case "command":
-		// Command is not executed, just acknowledged
-		// Future: route to command router
-		return
+// CommandResponse is the reply to a command acknowledgement (v1)
+type CommandResponse struct {
+	OK       bool   `json:"ok"`
+	Accepted bool   `json:"accepted"`
+	Cmd      string `json:"cmd"`
+}
 
-	case "event":
-		// Event is already recorded in stream
-		// Future: additional event processing
-		return
+// EventResponse is the reply to a node event (v1)
+type EventResponse struct {
+	OK       bool   `json:"ok"`
+	NodeID   string `json:"nodeId"`
+	TenantID string `json:"tenantId"`
+	Type     string `json:"type"`
+}
 
-	default:
-		// Unknown types are recorded but not routed
-		return
+// handleHeartbeatMessage routes heartbeats to the node registry
+func handleHeartbeatMessage(ctx context.Context, msg *Message) (*Response, error) {
+	origin := msg.Origin
+	if msg.RelayedBy != "" {
+		origin.RemoteIP = "" // the gateway's address, not the node's
+	}
+	if err := UpdateNodeHeartbeat(msg.NodeID, heartbeatInfo(msg.Data, origin)); err != nil {
+		return nil, err
 	}
+	return &Response{Body: HeartbeatResponse{OK: true, Status: "alive"}}, nil
 }
 
+// handleTelemetryMessage routes telemetry to the telemetry store
+// Tenant comes from the origin, never the message body
+func handleTelemetryMessage(ctx context.Context, msg *Message) (*Response, error) {
+	batch, err := ParseTelemetry(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+	accepted, err := IngestTelemetry(msg.NodeID, msg.Origin.TenantID, batch)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Body: TelemetryResponse{
+		OK:       true,
+		NodeID:   msg.NodeID,
+		TenantID: msg.Origin.TenantID,
+		Type:     msg.Type,
+		Accepted: accepted,
+	}}, nil
+}
+
+// handleCommandMessage acknowledges queued commands
+// Commands are never executed here; a bus "command" message carrying a commandId
+// acknowledges delivery of a queued command (same as /agents/commands/{id}/ack)
+func handleCommandMessage(ctx context.Context, msg *Message) (*Response, error) {
+	cmd, _ := msg.Data["cmd"].(string)
+	log.Printf("Command received from node=%s tenant=%s cmd=%s", msg.NodeID, msg.Origin.TenantID, cmd)
+
+	if commandID, ok := msg.Data["commandId"].(string); ok && commandID != "" {
+		if _, err := AcknowledgeCommand(ctx, msg.NodeID, commandID); err != nil {
+			return nil, err
+		}
+	}
+	return &Response{Body: CommandResponse{OK: true, Accepted: true, Cmd: cmd}}, nil
+}
+
+// handleEventMessage accepts node events; they are already recorded in the stream by RecordEvents
+func handleEventMessage(ctx context.Context, msg *Message) (*Response, error) {
+	return &Response{Body: EventResponse{
+		OK:       true,
+		NodeID:   msg.NodeID,
+		TenantID: msg.Origin.TenantID,
+		Type:     msg.Type,
+	}}, nil
+}
+
+// heartbeatInfo extracts node metadata from a heartbeat payload
+// Tenant and IP always come from the origin, never from the message body
+func heartbeatInfo(data map[string]interface{}, origin Origin) HeartbeatInfo {
+	info := HeartbeatInfo{
+		TenantID:  origin.TenantID,
+		IPAddress: origin.RemoteIP,
+	}
+	info.Region, _ = data["region"].(string)
+	info.AgentVersion, _ = data["agentVersion"].(string)
+	if hardware, ok := data["hardware"].(map[string]interface{}); ok {
+		info.Hardware = hardware
+	}
+	return info
+}
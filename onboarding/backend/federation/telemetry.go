@@ -0,0 +1,570 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/metrics"
+)
+
+// Agent telemetry store
+// Agents report typed samples (metric name, labels, value, timestamp) via /api/federation/agents/telemetry
+// or bus "telemetry" messages. Accepted batches are queued and written by a background writer: raw
+// samples go to public.federation_telemetry_samples and the same transaction folds them into 1-minute
+// and 1-hour rollups. Raw samples are kept briefly; queries over longer ranges read the rollups.
+
+// Telemetry limits and retention
+const (
+	MaxTelemetrySamples      = 1000 // per batch
+	maxTelemetryLabels       = 16
+	maxTelemetryLabelValue   = 256
+	maxTelemetryFutureSkew   = 5 * time.Minute
+	maxTelemetryQueryPoints  = 10000
+	telemetryQueueSize       = 1024 // batches
+	telemetryWriteRows       = 2000
+	telemetryFlushInterval   = time.Second
+	telemetryWriteAttempts   = 5 // per batch before it is dropped
+	telemetryRetryBackoff    = time.Second
+	telemetryMaxRetryBackoff = 30 * time.Second
+	telemetryMaintenance     = time.Hour
+	TelemetryRawRetention    = 48 * time.Hour
+	telemetryMinuteRetention = 14 * 24 * time.Hour
+	telemetryHourRetention   = 400 * 24 * time.Hour
+)
+
+// Telemetry resolutions
+const (
+	TelemetryRaw    = "raw"
+	TelemetryMinute = "1m"
+	TelemetryHour   = "1h"
+)
+
+// Telemetry errors
+var (
+	ErrInvalidTelemetry      = errors.New("invalid telemetry")
+	ErrInvalidTelemetryQuery = errors.New("invalid telemetry query")
+	ErrTelemetryQueueFull    = errors.New("telemetry ingest queue full")
+	ErrTelemetryNotUp        = errors.New("telemetry store not initialized")
+)
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:.]{0,199}$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,63}$`)
+)
+
+// TelemetrySample is a single metric observation
+type TelemetrySample struct {
+	Metric string            `json:"metric"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+	TS     int64             `json:"ts,omitempty"` // Unix milliseconds; 0 = time of receipt
+}
+
+// TelemetryBatch is what agents submit; a bare sample (metric/value at the top level) is also accepted
+type TelemetryBatch struct {
+	AgentID string            `json:"agentId,omitempty"`
+	Samples []TelemetrySample `json:"samples"`
+}
+
+// ParseTelemetry decodes a telemetry payload from a bus message or request body
+func ParseTelemetry(data map[string]interface{}) (TelemetryBatch, error) {
+	var batch TelemetryBatch
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return batch, fmt.Errorf("%w: %v", ErrInvalidTelemetry, err)
+	}
+	if err := json.Unmarshal(raw, &batch); err != nil {
+		return batch, fmt.Errorf("%w: %v", ErrInvalidTelemetry, err)
+	}
+	if len(batch.Samples) == 0 {
+		if _, ok := data["metric"]; ok {
+			var sample TelemetrySample
+			if err := json.Unmarshal(raw, &sample); err != nil {
+				return batch, fmt.Errorf("%w: %v", ErrInvalidTelemetry, err)
+			}
+			batch.Samples = []TelemetrySample{sample}
+		}
+	}
+	return batch, nil
+}
+
+// telemetryRow is a validated sample attributed to a node
+type telemetryRow struct {
+	ts        time.Time
+	tenantID  string
+	nodeID    string
+	agentID   string
+	metric    string
+	labelsKey string // canonical JSON (encoding/json sorts map keys)
+	value     float64
+}
+
+// TelemetryStore batches telemetry into Postgres
+type TelemetryStore struct {
+	db       *pgxpool.Pool
+	queue    chan []telemetryRow
+	dropped  atomic.Uint64
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+var telemetryStore *TelemetryStore
+
+// InitTelemetryStore enforces retention and starts the background writer
+func InitTelemetryStore(ctx context.Context, db *pgxpool.Pool) error {
+	ts := &TelemetryStore{
+		db:    db,
+		queue: make(chan []telemetryRow, telemetryQueueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := ts.maintain(ctx, time.Now()); err != nil {
+		return err
+	}
+	telemetryStore = ts
+
+	go ts.run()
+	go func() {
+		ticker := time.NewTicker(telemetryMaintenance)
+		for range ticker.C {
+			if err := ts.maintain(context.Background(), time.Now()); err != nil {
+				log.Printf("Federation telemetry maintenance failed: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// CloseTelemetryStore writes out everything already queued and stops the writer.
+// Call it after the HTTP server has stopped accepting requests; it returns when the queue is
+// flushed or ctx is done, whichever comes first.
+func CloseTelemetryStore(ctx context.Context) error {
+	ts := telemetryStore
+	if ts == nil {
+		return nil
+	}
+	ts.stopOnce.Do(func() { close(ts.stop) })
+	select {
+	case <-ts.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("telemetry queue not flushed: %w", ctx.Err())
+	}
+}
+
+// IngestTelemetry validates a batch from a node and queues it for storage.
+// Batches are accepted or rejected whole; a full queue returns ErrTelemetryQueueFull so agents retry.
+func IngestTelemetry(nodeID, tenantID string, batch TelemetryBatch) (int, error) {
+	if telemetryStore == nil {
+		return 0, ErrTelemetryNotUp
+	}
+	if len(batch.Samples) == 0 {
+		return 0, fmt.Errorf("%w: no samples", ErrInvalidTelemetry)
+	}
+	if len(batch.Samples) > MaxTelemetrySamples {
+		return 0, fmt.Errorf("%w: batch exceeds %d samples", ErrInvalidTelemetry, MaxTelemetrySamples)
+	}
+
+	now := time.Now()
+	rows := make([]telemetryRow, 0, len(batch.Samples))
+	for i, s := range batch.Samples {
+		row, err := telemetryRowFor(s, now)
+		if err != nil {
+			return 0, fmt.Errorf("%w: sample %d: %v", ErrInvalidTelemetry, i, err)
+		}
+		row.nodeID, row.tenantID, row.agentID = nodeID, tenantID, batch.AgentID
+		rows = append(rows, row)
+	}
+
+	select {
+	case telemetryStore.queue <- rows:
+		return len(rows), nil
+	default:
+		if n := telemetryStore.dropped.Add(1); n == 1 || n%100 == 0 {
+			log.Printf("Warning: federation telemetry queue full, %d batch(es) rejected", n)
+		}
+		return 0, ErrTelemetryQueueFull
+	}
+}
+
+// telemetryRowFor validates a sample
+func telemetryRowFor(s TelemetrySample, now time.Time) (telemetryRow, error) {
+	if !metricNamePattern.MatchString(s.Metric) {
+		return telemetryRow{}, fmt.Errorf("invalid metric name %q", s.Metric)
+	}
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return telemetryRow{}, fmt.Errorf("value for %s is not finite", s.Metric)
+	}
+	if len(s.Labels) > maxTelemetryLabels {
+		return telemetryRow{}, fmt.Errorf("more than %d labels", maxTelemetryLabels)
+	}
+	for k, v := range s.Labels {
+		if !labelNamePattern.MatchString(k) {
+			return telemetryRow{}, fmt.Errorf("invalid label name %q", k)
+		}
+		if len(v) > maxTelemetryLabelValue {
+			return telemetryRow{}, fmt.Errorf("label %s exceeds %d bytes", k, maxTelemetryLabelValue)
+		}
+	}
+
+	ts := now
+	if s.TS != 0 {
+		ts = time.UnixMilli(s.TS)
+		if ts.After(now.Add(maxTelemetryFutureSkew)) {
+			return telemetryRow{}, errors.New("timestamp is in the future")
+		}
+		if ts.Before(now.Add(-TelemetryRawRetention)) {
+			return telemetryRow{}, errors.New("timestamp is older than the retention window")
+		}
+	}
+
+	labels := s.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	key, err := json.Marshal(labels)
+	if err != nil {
+		return telemetryRow{}, err
+	}
+	return telemetryRow{ts: ts, metric: s.Metric, labelsKey: string(key), value: s.Value}, nil
+}
+
+// run batches queued telemetry and writes it; on stop it drains the queue before returning
+func (ts *TelemetryStore) run() {
+	defer close(ts.done)
+	ticker := time.NewTicker(telemetryFlushInterval)
+	defer ticker.Stop()
+
+	pending := make([]telemetryRow, 0, telemetryWriteRows)
+	for {
+		select {
+		case <-ts.stop:
+			for len(ts.queue) > 0 {
+				pending = append(pending, <-ts.queue...)
+			}
+			if len(pending) > 0 {
+				ts.flush(pending)
+			}
+			return
+		case rows := <-ts.queue:
+			pending = append(pending, rows...)
+			if len(pending) < telemetryWriteRows {
+				continue
+			}
+		case <-ticker.C:
+			if len(pending) == 0 {
+				continue
+			}
+		}
+		ts.flush(pending)
+		pending = pending[:0]
+	}
+}
+
+// flush writes rows, retrying with backoff. While it retries the queue is not drained, so a full
+// queue pushes back on agents instead of losing their samples; the rows are dropped (and counted)
+// only once every attempt has failed.
+func (ts *TelemetryStore) flush(rows []telemetryRow) {
+	for attempt := 1; ; attempt++ {
+		err := ts.write(rows)
+		if err == nil {
+			return
+		}
+		if attempt >= telemetryWriteAttempts {
+			log.Printf("Dropping %d telemetry sample(s) after %d failed writes: %v", len(rows), attempt, err)
+			metrics.ObserveTelemetryDropped(len(rows))
+			return
+		}
+		delay := telemetryRetryDelay(attempt)
+		log.Printf("Failed to persist %d telemetry sample(s), retrying in %s: %v", len(rows), delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// telemetryRetryDelay is the wait after a failed write attempt (1-based), doubling up to a cap
+func telemetryRetryDelay(attempt int) time.Duration {
+	delay := telemetryRetryBackoff
+	for i := 1; i < attempt && delay < telemetryMaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, telemetryMaxRetryBackoff)
+}
+
+// rollupKey identifies a series bucket at one resolution
+type rollupKey struct {
+	resolution string
+	bucket     time.Time
+	nodeID     string
+	agentID    string
+	metric     string
+	labelsKey  string
+}
+
+// rollup accumulates samples for one series bucket
+type rollup struct {
+	tenantID string
+	count    int64
+	sum      float64
+	min      float64
+	max      float64
+	last     float64
+	lastTS   time.Time
+}
+
+// write stores raw samples and folds them into the rollups in one transaction
+func (ts *TelemetryStore) write(rows []telemetryRow) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	rollups := make(map[rollupKey]*rollup)
+	copyRows := make([][]interface{}, 0, len(rows))
+	for _, row := range rows {
+		copyRows = append(copyRows, []interface{}{
+			row.ts, row.tenantID, row.nodeID, row.agentID, row.metric, row.labelsKey, row.value,
+		})
+		for resolution, width := range map[string]time.Duration{TelemetryMinute: time.Minute, TelemetryHour: time.Hour} {
+			key := rollupKey{resolution, row.ts.Truncate(width), row.nodeID, row.agentID, row.metric, row.labelsKey}
+			r, ok := rollups[key]
+			if !ok {
+				rollups[key] = &rollup{tenantID: row.tenantID, count: 1, sum: row.value,
+					min: row.value, max: row.value, last: row.value, lastTS: row.ts}
+				continue
+			}
+			r.count++
+			r.sum += row.value
+			r.min = math.Min(r.min, row.value)
+			r.max = math.Max(r.max, row.value)
+			if !row.ts.Before(r.lastTS) {
+				r.last, r.lastTS = row.value, row.ts
+			}
+		}
+	}
+
+	tx, err := ts.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.CopyFrom(ctx,
+		pgx.Identifier{"public", "federation_telemetry_samples"},
+		[]string{"ts", "tenant_id", "node_id", "agent_id", "metric", "labels", "value"},
+		pgx.CopyFromRows(copyRows),
+	); err != nil {
+		return fmt.Errorf("failed to copy samples: %w", err)
+	}
+
+	batch := &pgx.Batch{}
+	for k, r := range rollups {
+		batch.Queue(
+			`INSERT INTO public.federation_telemetry_rollups AS r
+			 (resolution, bucket, tenant_id, node_id, agent_id, metric, labels_key, labels,
+			  sample_count, sum, min, max, last, last_ts)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8::jsonb, $9, $10, $11, $12, $13, $14)
+			 ON CONFLICT (resolution, node_id, metric, agent_id, labels_key, bucket) DO UPDATE SET
+			     sample_count = r.sample_count + EXCLUDED.sample_count,
+			     sum = r.sum + EXCLUDED.sum,
+			     min = LEAST(r.min, EXCLUDED.min),
+			     max = GREATEST(r.max, EXCLUDED.max),
+			     last = CASE WHEN EXCLUDED.last_ts >= r.last_ts THEN EXCLUDED.last ELSE r.last END,
+			     last_ts = GREATEST(r.last_ts, EXCLUDED.last_ts)`,
+			k.resolution, k.bucket, r.tenantID, k.nodeID, k.agentID, k.metric, k.labelsKey, k.labelsKey,
+			r.count, r.sum, r.min, r.max, r.last, r.lastTS,
+		)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to update rollups: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// maintain deletes samples and rollups outside their retention windows
+func (ts *TelemetryStore) maintain(ctx context.Context, now time.Time) error {
+	if _, err := ts.db.Exec(ctx,
+		`DELETE FROM public.federation_telemetry_samples WHERE ts < $1`,
+		now.Add(-TelemetryRawRetention),
+	); err != nil {
+		return fmt.Errorf("failed to expire telemetry samples: %w", err)
+	}
+	if _, err := ts.db.Exec(ctx,
+		`DELETE FROM public.federation_telemetry_rollups
+		 WHERE (resolution = '1m' AND bucket < $1) OR (resolution = '1h' AND bucket < $2)`,
+		now.Add(-telemetryMinuteRetention), now.Add(-telemetryHourRetention),
+	); err != nil {
+		return fmt.Errorf("failed to expire telemetry rollups: %w", err)
+	}
+	return nil
+}
+
+// TelemetryQuery selects series for a tenant, node or agent over a time range
+type TelemetryQuery struct {
+	TenantID   string
+	NodeID     string
+	AgentID    string
+	Metric     string            // empty = all metrics
+	Labels     map[string]string // series must carry all of these labels
+	From       time.Time         // zero = one hour before To
+	To         time.Time         // zero = now
+	Resolution string            // raw | 1m | 1h; empty picks one from the range
+}
+
+// TelemetryPoint is one point of a series; for rollups Value is the bucket average
+type TelemetryPoint struct {
+	TS    int64   `json:"ts"` // Unix milliseconds (bucket start for rollups)
+	Value float64 `json:"value"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Last  float64 `json:"last"`
+	Count int64   `json:"count"`
+}
+
+// TelemetrySeries is the points of one metric/labels combination from one node and agent
+type TelemetrySeries struct {
+	Metric   string            `json:"metric"`
+	Labels   map[string]string `json:"labels"`
+	TenantID string            `json:"tenantId,omitempty"`
+	NodeID   string            `json:"nodeId"`
+	AgentID  string            `json:"agentId,omitempty"`
+	Points   []TelemetryPoint  `json:"points"`
+}
+
+// TelemetryResult is the answer to a TelemetryQuery
+type TelemetryResult struct {
+	Resolution string             `json:"resolution"`
+	From       time.Time          `json:"from"`
+	To         time.Time          `json:"to"`
+	Series     []*TelemetrySeries `json:"series"`
+	Truncated  bool               `json:"truncated"` // more than maxTelemetryQueryPoints points matched
+}
+
+// QueryTelemetry returns series matching the query, oldest point first
+func QueryTelemetry(ctx context.Context, q TelemetryQuery) (*TelemetryResult, error) {
+	if telemetryStore == nil {
+		return nil, ErrTelemetryNotUp
+	}
+	if q.TenantID == "" && q.NodeID == "" && q.AgentID == "" {
+		return nil, fmt.Errorf("%w: tenantId, nodeId or agentId is required", ErrInvalidTelemetryQuery)
+	}
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-time.Hour)
+	}
+	if !q.From.Before(q.To) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidTelemetryQuery)
+	}
+	if q.Resolution == "" {
+		q.Resolution = TelemetryHour
+		if q.To.Sub(q.From) <= 6*time.Hour {
+			q.Resolution = TelemetryMinute
+		}
+	}
+	labels := q.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labelsJSON, _ := json.Marshal(labels)
+
+	var sql string
+	from := q.From
+	switch q.Resolution {
+	case TelemetryRaw:
+		sql = `SELECT ts, tenant_id, node_id, agent_id, metric, labels::text, value, value, value, value, 1::bigint
+		 FROM public.federation_telemetry_samples
+		 WHERE ts >= $1 AND ts < $2`
+	case TelemetryMinute, TelemetryHour:
+		width := time.Minute
+		if q.Resolution == TelemetryHour {
+			width = time.Hour
+		}
+		from = q.From.Truncate(width)
+		sql = `SELECT bucket, tenant_id, node_id, agent_id, metric, labels_key, sum / sample_count, min, max, last, sample_count
+		 FROM public.federation_telemetry_rollups
+		 WHERE resolution = '` + q.Resolution + `' AND bucket >= $1 AND bucket < $2`
+	default:
+		return nil, fmt.Errorf("%w: unknown resolution %q", ErrInvalidTelemetryQuery, q.Resolution)
+	}
+
+	rows, err := telemetryStore.db.Query(ctx,
+		sql+` AND ($3 = '' OR tenant_id = $3) AND ($4 = '' OR node_id = $4) AND ($5 = '' OR agent_id = $5)
+		   AND ($6 = '' OR metric = $6) AND labels @> $7::jsonb
+		 ORDER BY 1
+		 LIMIT $8`,
+		from, q.To, q.TenantID, q.NodeID, q.AgentID, q.Metric, string(labelsJSON), maxTelemetryQueryPoints+1,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query telemetry: %w", err)
+	}
+	defer rows.Close()
+
+	result := &TelemetryResult{Resolution: q.Resolution, From: from, To: q.To, Series: []*TelemetrySeries{}}
+	index := make(map[rollupKey]*TelemetrySeries)
+	points := 0
+	for rows.Next() {
+		if points == maxTelemetryQueryPoints {
+			result.Truncated = true
+			break
+		}
+		var ts time.Time
+		var s TelemetrySeries
+		var labelsKey string
+		var p TelemetryPoint
+		if err := rows.Scan(&ts, &s.TenantID, &s.NodeID, &s.AgentID, &s.Metric, &labelsKey,
+			&p.Value, &p.Min, &p.Max, &p.Last, &p.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan telemetry: %w", err)
+		}
+		p.TS = ts.UnixMilli()
+		points++
+
+		key := rollupKey{nodeID: s.NodeID, agentID: s.AgentID, metric: s.Metric, labelsKey: labelsKey}
+		series, ok := index[key]
+		if !ok {
+			series = &s
+			if err := json.Unmarshal([]byte(labelsKey), &series.Labels); err != nil {
+				return nil, fmt.Errorf("failed to decode telemetry labels: %w", err)
+			}
+			index[key] = series
+			result.Series = append(result.Series, series)
+		}
+		series.Points = append(series.Points, p)
+	}
+	return result, rows.Err()
+}
+
+// TelemetrySummary describes recent telemetry activity for a tenant
+type TelemetrySummary struct {
+	LastSampleAt   *time.Time `json:"lastSampleAt,omitempty"`
+	ReportingNodes int        `json:"reportingNodes"`
+	Samples        int64      `json:"samples"`
+}
+
+// SummarizeTelemetry reports a tenant's telemetry activity since the given time (from 1-minute rollups)
+func SummarizeTelemetry(ctx context.Context, tenantID string, since time.Time) (*TelemetrySummary, error) {
+	if telemetryStore == nil {
+		return nil, ErrTelemetryNotUp
+	}
+	var summary TelemetrySummary
+	err := telemetryStore.db.QueryRow(ctx,
+		`SELECT MAX(last_ts), COUNT(DISTINCT node_id), COALESCE(SUM(sample_count), 0)
+		 FROM public.federation_telemetry_rollups
+		 WHERE resolution = '1m' AND tenant_id = $1 AND bucket >= $2`,
+		tenantID, since.Truncate(time.Minute),
+	).Scan(&summary.LastSampleAt, &summary.ReportingNodes, &summary.Samples)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize telemetry: %w", err)
+	}
+	return &summary, nil
+}
@@ -0,0 +1,25 @@
+package federation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTelemetryRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 16 * time.Second},
+		{6, telemetryMaxRetryBackoff},
+		{50, telemetryMaxRetryBackoff},
+	}
+	for _, tt := range tests {
+		if got := telemetryRetryDelay(tt.attempt); got != tt.want {
+			t.Errorf("telemetryRetryDelay(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
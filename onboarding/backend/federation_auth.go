@@ -1,35 +1,56 @@
 package main
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"sync"
+	"os"
+	"strconv"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	fedmw "github.com/silentsage432/sage-gitops/onboarding/backend/middleware"
 	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/metrics"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/tracing"
 )
 
-// Phase 13.1: Stateless handshake store
-// - no DB
+// Phase 13.1: Handshake challenge store
 // - no JWT verification yet
-// - verification happens via cryptographic signing only
-type pendingChallenge struct {
-	challenge   string
-	tenantID    string
-	fingerprint string
-	issuedAt    time.Time
+// - verification happens via cryptographic signing only:
+//   the node signs challenge:nonce:nodeId with the private key from its bootstrap kit
+//   (HMAC-with-fingerprint only when FEDERATION_HANDSHAKE_HMAC_COMPAT=true)
+// - challenges are single-use, bound to their nonce, and limited per node;
+//   FEDERATION_CHALLENGE_STORE=postgres shares them across backend replicas
+// - challenge requests are rate-limited per peer address; forwarding headers only count
+//   when the peer is listed in FEDERATION_TRUSTED_PROXIES
+var challengeStore federation.ChallengeStore = federation.NewMemoryChallengeStore()
+
+// challengeLimiter rate-limits challenge requests per source address
+var challengeLimiter = federation.NewSourceRateLimiter(federation.DefaultChallengesPerSource, federation.ChallengeRateWindow)
+
+// initChallengeStore selects the handshake challenge store (memory by default)
+func initChallengeStore(db *pgxpool.Pool) {
+	if os.Getenv("FEDERATION_CHALLENGE_STORE") == "postgres" {
+		challengeStore = federation.NewPostgresChallengeStore(db)
+		log.Println("Federation handshake challenges stored in Postgres")
+	}
 }
 
-var (
-	pendingChallenges = make(map[string]*pendingChallenge)
-	challengeMutex    sync.RWMutex
-)
+// hmacHandshakeCompat reports whether legacy HMAC handshakes are allowed for nodes without a registered key
+func hmacHandshakeCompat() bool {
+	return os.Getenv("FEDERATION_HANDSHAKE_HMAC_COMPAT") == "true"
+}
 
 // Helper utilities
 func generateNonce() (string, error) {
@@ -46,25 +67,45 @@ func signChallenge(secret, challenge string) string {
 	return hex.EncodeToString(mac.Sum(nil))
 }
 
+// startHandshakeSpan starts the span of a handshake step; handlers continue with the returned request
+func startHandshakeSpan(r *http.Request, stage string) (*http.Request, trace.Span) {
+	ctx, span := tracing.Start(r.Context(), "federation.handshake."+stage)
+	return r.WithContext(ctx), span
+}
+
+// endHandshakeSpan counts a handshake step and ends its span; outcome is "ok" or the error code returned
+func endHandshakeSpan(span trace.Span, stage, outcome string) {
+	metrics.ObserveHandshake(stage, outcome)
+	tracing.EndOutcome(span, outcome)
+}
+
 // STEP 1: REQUEST HANDSHAKE CHALLENGE
 // Node sends:
 // - nodeId
 // - tenantId
-// - bootstrapFingerprint (from onboarding kit)
+// - algo (optional: ed25519 | rsa-sha256, defaults to the node's registered key)
+// - bootstrapFingerprint (from onboarding kit; required for legacy HMAC mode only)
 func handleFederationHandshake(w http.ResponseWriter, r *http.Request) {
+	r, span := startHandshakeSpan(r, "challenge")
+	outcome := "INTERNAL_ERROR"
+	defer func() { endHandshakeSpan(span, "challenge", outcome) }()
+
 	var req struct {
 		NodeID      string `json:"nodeId"`
 		TenantID    string `json:"tenantId"`
 		Fingerprint string `json:"fingerprint"`
+		Algo        string `json:"algo"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Error decoding handshake request: %v", err)
+		outcome = "INVALID_REQUEST"
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	if req.NodeID == "" || req.TenantID == "" || req.Fingerprint == "" {
+	if req.NodeID == "" || req.TenantID == "" {
+		outcome = "MISSING_FIELDS"
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -72,6 +113,79 @@ func handleFederationHandshake(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	span.SetAttributes(tracing.NodeID(req.NodeID), tracing.TenantID(req.TenantID))
+
+	source := GetTrustedClientIP(r)
+	if !challengeLimiter.Allow(source, time.Now()) {
+		outcome = "TOO_MANY_CHALLENGES"
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(federation.ChallengeRateWindow.Seconds())))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "TOO_MANY_CHALLENGES",
+		})
+		return
+	}
+
+	// Select the verification mode: the node's registered key; legacy HMAC only for nodes without one
+	algo := federation.NodeKeyHMAC
+	nodeKey, err := LookupNodeKey(r.Context(), req.NodeID, "")
+	if err == nil && req.Algo != "" && req.Algo != nodeKey.Algorithm {
+		if req.Algo == federation.NodeKeyHMAC {
+			// A node with a registered key never downgrades to the fingerprint secret
+			outcome = "HMAC_NOT_ALLOWED"
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "HMAC_NOT_ALLOWED",
+			})
+			return
+		}
+		nodeKey, err = LookupNodeKey(r.Context(), req.NodeID, req.Algo)
+		if errors.Is(err, pgx.ErrNoRows) {
+			outcome = "NODE_KEY_NOT_REGISTERED"
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "NODE_KEY_NOT_REGISTERED",
+			})
+			return
+		}
+	}
+	switch {
+	case err == nil:
+		if nodeKey.TenantID != req.TenantID {
+			outcome = "TENANT_MISMATCH"
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "TENANT_MISMATCH",
+			})
+			return
+		}
+		algo = nodeKey.Algorithm
+	case errors.Is(err, pgx.ErrNoRows):
+		nodeKey = nil
+		if !hmacHandshakeCompat() || req.Fingerprint == "" {
+			outcome = "NODE_KEY_NOT_REGISTERED"
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "NODE_KEY_NOT_REGISTERED",
+			})
+			return
+		}
+	default:
+		// Never fall back to HMAC because the key could not be read
+		log.Printf("Failed to look up node key for %s: %v", req.NodeID, err)
+		outcome = "NODE_KEY_LOOKUP_FAILED"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "NODE_KEY_LOOKUP_FAILED",
+		})
+		return
+	}
 
 	challenge, err := generateNonce()
 	if err != nil {
@@ -95,43 +209,84 @@ func handleFederationHandshake(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	challengeMutex.Lock()
-	pendingChallenges[req.NodeID] = &pendingChallenge{
-		challenge:   challenge,
-		tenantID:    req.TenantID,
-		fingerprint: req.Fingerprint,
-		issuedAt:    time.Now(),
+	now := time.Now()
+	err = challengeStore.Create(r.Context(), &federation.HandshakeChallenge{
+		Challenge:   challenge,
+		Nonce:       nonce,
+		NodeID:      req.NodeID,
+		TenantID:    req.TenantID,
+		Fingerprint: req.Fingerprint,
+		Algo:        algo,
+		NodeKey:     nodeKey,
+		Source:      source,
+		IssuedAt:    now,
+		ExpiresAt:   now.Add(federation.ChallengeTTL),
+	}, federation.DefaultMaxChallengesPerNode)
+	if errors.Is(err, federation.ErrTooManyChallenges) || errors.Is(err, federation.ErrChallengeStoreFull) {
+		outcome = "TOO_MANY_CHALLENGES"
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(federation.ChallengeTTL.Seconds())))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "TOO_MANY_CHALLENGES",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to store challenge: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Failed to store challenge",
+		})
+		return
 	}
-	challengeMutex.Unlock()
 
+	outcome = "ok"
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"challenge":  challenge,
 		"nonce":      nonce,
-		"algo":       "HMAC-SHA256",
-		"expiresIn":  30000,
+		"algo":       algo,
+		"expiresIn":  federation.ChallengeTTL.Milliseconds(),
 	})
 }
 
 // STEP 2: ASSERT SOLUTION
-// Node signs the challenge and returns signed blob
+// Node signs challenge:nonce:nodeId with its node key and returns the base64 signature
+// together with the challenge and nonce it was issued
+// (legacy HMAC mode: hex HMAC-SHA256 of the challenge keyed by the fingerprint)
+// The challenge is consumed before verification, so every challenge gets exactly one attempt
 func handleFederationAssert(w http.ResponseWriter, r *http.Request) {
+	r, span := startHandshakeSpan(r, "assert")
+	outcome := "INTERNAL_ERROR"
+	defer func() { endHandshakeSpan(span, "assert", outcome) }()
+
 	var req struct {
 		NodeID    string `json:"nodeId"`
+		Challenge string `json:"challenge"`
+		Nonce     string `json:"nonce"`
 		Signature string `json:"signature"`
 		Fingerprint string `json:"fingerprint"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		outcome = "INVALID_REQUEST"
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	challengeMutex.RLock()
-	entry, exists := pendingChallenges[req.NodeID]
-	challengeMutex.RUnlock()
-
-	if !exists {
+	// Only legacy HMAC clients may omit the challenge
+	var entry *federation.HandshakeChallenge
+	err := federation.ErrChallengeNotFound
+	if req.Challenge != "" || hmacHandshakeCompat() {
+		entry, err = challengeStore.Consume(r.Context(), req.NodeID, req.Challenge, GetTrustedClientIP(r))
+	}
+	if err != nil {
+		if !errors.Is(err, federation.ErrChallengeNotFound) {
+			log.Printf("Failed to consume challenge for node %s: %v", req.NodeID, err)
+		}
+		outcome = "NO_CHALLENGE_FOUND"
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -139,12 +294,12 @@ func handleFederationAssert(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	span.SetAttributes(tracing.NodeID(req.NodeID), tracing.TenantID(entry.TenantID),
+		attribute.String("sage.handshake.algo", entry.Algo))
 
 	// Challenge expiration (30s)
-	if time.Since(entry.issuedAt) > 30*time.Second {
-		challengeMutex.Lock()
-		delete(pendingChallenges, req.NodeID)
-		challengeMutex.Unlock()
+	if entry.Expired(time.Now()) {
+		outcome = "CHALLENGE_TIMEOUT"
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -153,8 +308,20 @@ func handleFederationAssert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Must match bootstrap fingerprint from onboarding
-	if req.Fingerprint != entry.fingerprint {
+	// Nonce must be the one bound to this challenge (legacy HMAC clients never echo it)
+	if req.Nonce != entry.Nonce && (entry.Algo != federation.NodeKeyHMAC || req.Nonce != "") {
+		outcome = "NONCE_MISMATCH"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "NONCE_MISMATCH",
+		})
+		return
+	}
+
+	// Must match bootstrap fingerprint from onboarding (when one was presented)
+	if req.Fingerprint != entry.Fingerprint && (entry.Algo == federation.NodeKeyHMAC || req.Fingerprint != "") {
+		outcome = "FINGERPRINT_MISMATCH"
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -164,8 +331,16 @@ func handleFederationAssert(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify signature
-	expected := signChallenge(req.Fingerprint, entry.challenge)
-	if expected != req.Signature {
+	validSignature := false
+	if entry.Algo == federation.NodeKeyHMAC {
+		// Legacy mode for existing Pi images
+		validSignature = hmac.Equal([]byte(signChallenge(entry.Fingerprint, entry.Challenge)), []byte(req.Signature))
+	} else {
+		message := federation.HandshakeMessage(entry.Challenge, entry.Nonce, req.NodeID)
+		validSignature = federation.VerifyNodeSignature(entry.NodeKey, message, req.Signature) == nil
+	}
+	if !validSignature {
+		outcome = "INVALID_SIGNATURE"
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -175,17 +350,10 @@ func handleFederationAssert(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// At this point, handshake is cryptographically proven
-	challengeMutex.Lock()
-	delete(pendingChallenges, req.NodeID)
-	challengeMutex.Unlock()
 
-	// Phase 13.4: Ed25519 signed token
-	payload := federation.FederationTokenPayload{
-		NodeID:      req.NodeID,
-		TenantID:    entry.tenantID,
-		Fingerprint: req.Fingerprint,
-		IssuedAt:    time.Now().UnixMilli(),
-	}
+	// Phase 13.4: Ed25519 signed token, valid for the bus, agents API and protected federation APIs
+	payload := federation.NewTokenPayload(req.NodeID, entry.TenantID, entry.Fingerprint,
+		federation.SessionTokenTTL, federation.SessionAudiences, federation.SessionScopes(req.NodeID))
 
 	signedToken, err := federation.SignFederationToken(payload)
 	if err != nil {
@@ -195,22 +363,27 @@ func handleFederationAssert(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Phase 13.2: Store session as valid
-	fedmw.RegisterFederationSession(signedToken)
+	fedmw.RegisterFederationSession(signedToken, &payload)
+	RecordFederationToken(r.Context(), signedToken, &payload, FederationTokenSourceHandshake)
 
+	outcome = "ok"
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"ok":             true,
-		"tenantId":       entry.tenantID,
+		"tenantId":       entry.TenantID,
 		"nodeId":         req.NodeID,
 		"federationToken": signedToken,
 		"federated":      true,
 		"cipher":         "ed25519",
-		"expiresIn":      3600000,
+		"handshakeAlgo":  entry.Algo,
+		"expiresIn":      federation.SessionTokenTTL.Milliseconds(),
+		"expiresAt":      payload.ExpiresAt,
 	})
 }
 
 // STEP 3: VERIFY FEDERATION TOKEN
-// Used by protected federation APIs
+// Used by protected federation APIs and the CLI to check a token before use
+// Returns the decoded payload, remaining lifetime and revocation state
 func handleFederationVerify(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		FederationToken string `json:"federationToken"`
@@ -230,12 +403,46 @@ func handleFederationVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// This is where we don't verify in DB — stateless verification lives here.
-	// Later we add distributed token propagation / Pi support.
+	payload, err := federation.VerifyFederationToken(req.FederationToken)
+	if payload == nil {
+		// Signature, format or key failure: nothing trustworthy to decode
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"valid": false,
+			"error": "INVALID_FEDERATION_TOKEN",
+		})
+		return
+	}
+
+	result := federationTokenSummary(req.FederationToken, payload)
+	result["valid"] = err == nil
+	if err != nil {
+		result["error"] = federation.TokenErrorCode(err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"valid": true,
-	})
+	json.NewEncoder(w).Encode(result)
+}
+
+// federationTokenSummary describes a signature-verified token for API responses
+func federationTokenSummary(token string, payload *federation.FederationTokenPayload) map[string]interface{} {
+	summary := map[string]interface{}{
+		"tokenHash":   federation.TokenHash(token),
+		"kid":         payload.KeyID,
+		"nodeId":      payload.NodeID,
+		"tenantId":    payload.TenantID,
+		"issuedAt":    payload.IssuedAt,
+		"expiresAt":   payload.ExpiresAt,
+		"remainingMs": payload.RemainingLifetime(time.Now()).Milliseconds(),
+		"audience":    payload.Audience,
+		"scopes":      payload.Scopes,
+		"revoked":     false,
+	}
+	if rev := federation.FindRevocation(token, payload); rev != nil {
+		summary["revoked"] = true
+		summary["revocation"] = rev
+	}
+	return summary
 }
 
 // Phase 13.5: Export federation token (operator can retrieve token after handshake)
@@ -257,12 +464,14 @@ func handleFederationExport(w http.ResponseWriter, r *http.Request) {
 }
 
 // Phase 13.5: Import federation token from remote source (CLI, other UI)
+// The token is validated, persisted in the token registry and registered as a session
 func handleFederationImport(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Token string `json:"token"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error": "Invalid request",
@@ -279,12 +488,33 @@ func handleFederationImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// We do NOT validate here — token validation happens at use time
+	payload, err := federation.VerifyFederationToken(req.Token)
+	if err != nil || payload == nil {
+		errorCode := federation.TokenErrorCode(err)
+		if errorCode == "" {
+			errorCode = "INVALID_FEDERATION_TOKEN"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": errorCode,
+		})
+		return
+	}
+
+	if err := RecordFederationToken(r.Context(), req.Token, payload, FederationTokenSourceImport); err != nil {
+		http.Error(w, "Failed to persist token", http.StatusInternalServerError)
+		return
+	}
+
+	fedmw.RegisterFederationSession(req.Token, payload)
+
+	result := federationTokenSummary(req.Token, payload)
+	result["ok"] = true
+	result["tokenAccepted"] = true
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"ok":            true,
-		"tokenAccepted": true,
-	})
+	json.NewEncoder(w).Encode(result)
 }
 
 // Cleanup expired challenges (runs periodically)
@@ -292,15 +522,15 @@ func cleanupExpiredChallenges() {
 	ticker := time.NewTicker(1 * time.Minute)
 	go func() {
 		for range ticker.C {
-			challengeMutex.Lock()
-			now := time.Now()
-			for nodeID, entry := range pendingChallenges {
-				if now.Sub(entry.issuedAt) > 30*time.Second {
-					delete(pendingChallenges, nodeID)
-					log.Printf("Cleaned up expired challenge for node: %s", nodeID)
-				}
+			challengeLimiter.Purge(time.Now())
+			removed, err := challengeStore.PurgeExpired(context.Background(), time.Now())
+			if err != nil {
+				log.Printf("Failed to clean up expired challenges: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("Cleaned up %d expired handshake challenge(s)", removed)
 			}
-			challengeMutex.Unlock()
 		}
 	}()
 }
@@ -308,6 +538,10 @@ func cleanupExpiredChallenges() {
 // Phase 13.10: Node Join Handler
 // Pi nodes use this endpoint to join the federation using their bootstrap token
 func handleFederationNodeJoin(w http.ResponseWriter, r *http.Request) {
+	r, span := startHandshakeSpan(r, "join")
+	outcome := "INTERNAL_ERROR"
+	defer func() { endHandshakeSpan(span, "join", outcome) }()
+
 	var req struct {
 		Federation struct {
 			Token string `json:"token"`
@@ -315,11 +549,13 @@ func handleFederationNodeJoin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		outcome = "INVALID_REQUEST"
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
 	if req.Federation.Token == "" {
+		outcome = "MISSING_FEDERATION"
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -328,26 +564,59 @@ func handleFederationNodeJoin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify federation token using Ed25519 signature
-	payload, err := federation.VerifyFederationToken(req.Federation.Token)
+	// Verify federation token using Ed25519 signature (must be a node join token)
+	payload, err := federation.VerifyFederationTokenFor(req.Federation.Token, federation.AudienceNodeJoin)
 	if err != nil || payload == nil {
+		errorCode := federation.TokenErrorCode(err)
+		if errorCode == "" {
+			errorCode = "INVALID_FEDERATION"
+		}
+		outcome = errorCode
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": "INVALID_FEDERATION",
+			"error": errorCode,
 		})
 		return
 	}
 
+	span.SetAttributes(tracing.NodeID(payload.NodeID), tracing.TenantID(payload.TenantID))
+
+	// Move the node to joined (decommissioned nodes cannot rejoin)
+	if err := federation.MarkNodeJoined(payload.NodeID, payload.TenantID); err != nil {
+		outcome = "NODE_DECOMMISSIONED"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "NODE_DECOMMISSIONED",
+		})
+		return
+	}
+
+	// Exchange the long-lived join token for a short-lived session token
+	session := federation.NewTokenPayload(payload.NodeID, payload.TenantID, payload.Fingerprint,
+		federation.SessionTokenTTL, federation.SessionAudiences, federation.SessionScopes(payload.NodeID))
+	sessionToken, err := federation.SignFederationToken(session)
+	if err != nil {
+		log.Printf("Failed to sign federation session token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
 	// Register the node session
-	fedmw.RegisterFederationSession(req.Federation.Token)
+	fedmw.RegisterFederationSession(sessionToken, &session)
+	RecordFederationToken(r.Context(), sessionToken, &session, FederationTokenSourceNodeJoin)
 
+	outcome = "ok"
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"ok":        true,
 		"nodeJoined": payload.NodeID,
 		"tenantId":  payload.TenantID,
 		"region":    payload.Fingerprint, // Note: region not in payload, using fingerprint as placeholder
+		"federationToken": sessionToken,
+		"expiresIn":       federation.SessionTokenTTL.Milliseconds(),
+		"expiresAt":       session.ExpiresAt,
 	})
 }
 
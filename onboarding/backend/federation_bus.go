@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+)
+
+// busUnknownTypePolicy reads FEDERATION_BUS_UNKNOWN_POLICY (reject | dead_letter, default dead_letter)
+func busUnknownTypePolicy() federation.UnknownTypePolicy {
+	if v := os.Getenv("FEDERATION_BUS_UNKNOWN_POLICY"); v != "" {
+		policy, err := federation.ParseUnknownTypePolicy(v)
+		if err == nil {
+			return policy
+		}
+		log.Printf("Warning: %v, using default", err)
+	}
+	return federation.UnknownDeadLetter
+}
+
+// auditBusRejection records a bus message refused by node/tenant authorization
+func auditBusRejection(ctx context.Context, msg *federation.Message, reason string) {
+	db := getDB(ctx)
+	if db == nil {
+		return
+	}
+	// Node and message fields are client-supplied, so details are marshalled rather than formatted
+	details, _ := json.Marshal(map[string]interface{}{
+		"tokenNodeId":  msg.Origin.NodeID,
+		"tenantId":     msg.Origin.TenantID,
+		"targetNodeId": msg.NodeID,
+		"messageId":    msg.ID,
+		"messageType":  msg.Type,
+		"remoteIp":     msg.Origin.RemoteIP,
+		"reason":       reason,
+	})
+	if _, err := db.Exec(ctx,
+		"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+		"federation_bus_rejected",
+		msg.Origin.NodeID,
+		string(details),
+		time.Now(),
+	); err != nil {
+		log.Printf("Failed to audit rejected bus message from node=%s: %v", msg.Origin.NodeID, err)
+	}
+}
+
+// writeBusError maps bus dispatch errors to JSON error responses
+func writeBusError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, federation.ErrInvalidTelemetry), errors.Is(err, federation.ErrTelemetryQueueFull),
+		errors.Is(err, federation.ErrTelemetryNotUp):
+		writeTelemetryError(w, err)
+		return
+	case errors.Is(err, federation.ErrCommandNotFound), errors.Is(err, federation.ErrCommandState),
+		errors.Is(err, federation.ErrCommandQueueNotUp):
+		writeCommandError(w, err)
+		return
+	}
+
+	var schemaErr *federation.SchemaError
+	if errors.As(err, &schemaErr) {
+		status, code := http.StatusBadRequest, "INVALID_ENVELOPE"
+		if errors.Is(err, federation.ErrSchemaValidation) {
+			status, code = http.StatusUnprocessableEntity, "SCHEMA_VALIDATION_FAILED"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":      code,
+			"violations": schemaErr.Violations,
+		})
+		return
+	}
+
+	status := http.StatusInternalServerError
+	code := "BUS_ERROR"
+	message := ""
+	switch {
+	case errors.Is(err, federation.ErrInvalidEnvelope):
+		status, code, message = http.StatusBadRequest, "INVALID_ENVELOPE", err.Error()
+	case errors.Is(err, federation.ErrMessageInProgress):
+		// The first delivery is still being handled; retry later to get its response
+		status, code = http.StatusConflict, "MESSAGE_IN_PROGRESS"
+		w.Header().Set("Retry-After", "2")
+	case errors.Is(err, federation.ErrMessageIDReused):
+		status, code, message = http.StatusConflict, "MESSAGE_ID_REUSED", err.Error()
+	case errors.Is(err, federation.ErrNodeDecommissioned):
+		status, code = http.StatusForbidden, "NODE_DECOMMISSIONED"
+	case errors.Is(err, federation.ErrNodeNotAuthorized):
+		status, code, message = http.StatusForbidden, "NODE_NOT_AUTHORIZED", err.Error()
+	case errors.Is(err, federation.ErrUnknownMessageType):
+		status, code, message = http.StatusBadRequest, "UNKNOWN_MESSAGE_TYPE", err.Error()
+	case errors.Is(err, federation.ErrUnsupportedVersion):
+		status, code, message = http.StatusBadRequest, "UNSUPPORTED_MESSAGE_VERSION", err.Error()
+	case errors.Is(err, federation.ErrDeadLetterQuota):
+		status, code, message = http.StatusTooManyRequests, "DEAD_LETTER_QUOTA_EXCEEDED", err.Error()
+		w.Header().Set("Retry-After", "3600")
+	case errors.Is(err, federation.ErrInvalidMessage):
+		status, code, message = http.StatusBadRequest, "INVALID_MESSAGE", err.Error()
+	default:
+		log.Printf("Federation bus error: %v", err)
+	}
+	body := map[string]interface{}{
+		"error": code,
+	}
+	if message != "" {
+		body["message"] = message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// List Bus Handlers Handler
+// Registered bus message types with their versions and dispatch counters
+func handleListBusHandlers(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"handlers": federation.Bus.Handlers(),
+	})
+}
+
+// List Dead Letters Handler
+// Supports ?nodeId=, ?type= and ?limit= filters
+func handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	letters, err := federation.Bus.ListDeadLetters(r.Context(), query.Get("nodeId"), query.Get("type"), limit)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deadLetters": letters,
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+)
+
+// writeCommandError maps command queue errors to JSON error responses
+func writeCommandError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	code := "COMMAND_QUEUE_ERROR"
+	switch {
+	case errors.Is(err, federation.ErrCommandNotFound):
+		status, code = http.StatusNotFound, "COMMAND_NOT_FOUND"
+	case errors.Is(err, federation.ErrCommandState):
+		status, code = http.StatusConflict, "INVALID_COMMAND_STATE"
+	default:
+		log.Printf("Federation command queue error: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": code,
+	})
+}
+
+// Enqueue Command Handler
+// Queues a command for a node (optionally a single agent) with TTL, priority and idempotency key
+func handleEnqueueCommand(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+	if !ok {
+		return
+	}
+
+	var req federation.CommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.NodeID == "" || req.Type == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "MISSING_FIELDS",
+		})
+		return
+	}
+	if federation.IsNodeDecommissioned(req.NodeID) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "NODE_DECOMMISSIONED",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	command, created, err := federation.EnqueueCommand(ctx, req, operatorID(claims))
+	if err != nil {
+		log.Printf("Failed to enqueue command for node %s: %v", req.NodeID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "ENQUEUE_FAILED",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+
+		// Log audit event
+		_, _ = getDB(ctx).Exec(ctx,
+			"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+			"federation_command_enqueued",
+			operatorID(claims),
+			fmt.Sprintf(`{"commandId": "%s", "nodeId": "%s", "type": "%s"}`, command.ID, command.NodeID, command.Type),
+			time.Now(),
+		)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":      true,
+		"created": created,
+		"command": command,
+	})
+}
+
+// List Commands Handler
+// Supports ?nodeId=, ?tenantId=, ?status= and ?limit= filters
+func handleListCommands(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	commands, err := federation.ListCommands(r.Context(), query.Get("nodeId"), query.Get("tenantId"),
+		federation.CommandStatus(query.Get("status")), limit)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"commands": commands,
+	})
+}
+
+// Get Command Handler
+func handleGetCommand(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	command, err := federation.GetCommand(r.Context(), chi.URLParam(r, "commandId"))
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"command": command,
+	})
+}
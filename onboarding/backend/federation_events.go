@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+)
+
+// eventRetentionDays reads FEDERATION_EVENT_RETENTION_DAYS (default 30)
+func eventRetentionDays() int {
+	if v := os.Getenv("FEDERATION_EVENT_RETENTION_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Warning: invalid FEDERATION_EVENT_RETENTION_DAYS %q, using default", v)
+	}
+	return federation.DefaultEventRetentionDays
+}
+
+// sseKeepAlive is how often an idle event stream sends a comment to keep proxies from closing it
+const sseKeepAlive = 15 * time.Second
+
+// Federation Event Stream Ticket Handler
+// EventSource cannot send an Authorization header: a federation admin exchanges their OCT for a
+// short-lived ticket and opens the stream with ?ticket=; reconnects reuse it until it expires.
+func handleIssueEventStreamTicket(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+	if !ok {
+		return
+	}
+
+	ticket, expiresAt, err := issueEventStreamTicket(operatorID(claims))
+	if err != nil {
+		log.Printf("Failed to sign event stream ticket: %v", err)
+		http.Error(w, "Failed to issue ticket", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ticket":    ticket,
+		"expiresAt": expiresAt.UnixMilli(),
+	})
+}
+
+// Federation Event Stream Handler (Server-Sent Events)
+// Pushes federation events as they happen. Filters: ?type=a,b&nodeId=&tenantId=
+// Resumes after the Last-Event-ID header (or ?lastEventId= for the first connect).
+// Only this replica's recent events can be replayed: when the cursor is older than its buffer or
+// came from another replica, a "reset" event is sent first and the client should backfill from
+// GET /api/federation/admin/events. Slow clients are disconnected instead of blocking producers.
+// Events carry job errors, bus payloads and rejected sources of every tenant, so the stream is
+// limited to federation admins (OCT bearer token or ?ticket=); tenantId only narrows it.
+func handleFederationEventStream(w http.ResponseWriter, r *http.Request) {
+	if !requireEventStreamAccess(w, r) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := federation.EventFilter{
+		NodeID:   query.Get("nodeId"),
+		TenantID: query.Get("tenantId"),
+	}
+	if types := query.Get("type"); types != "" {
+		filter.Types = strings.Split(types, ",")
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = query.Get("lastEventId")
+	}
+	var cursor uint64
+	if lastID != "" {
+		parsed, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		cursor = parsed
+	}
+
+	backlog, sub, resumed := federation.Subscribe(filter, cursor, 0)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !resumed {
+		// No id line, so the client keeps its cursor until the next real event
+		if _, err := fmt.Fprintf(w, "event: reset\ndata: {\"reason\":\"CURSOR_NOT_AVAILABLE\",\"lastEventId\":%d}\n\n", cursor); err != nil {
+			return
+		}
+	}
+	for _, event := range backlog {
+		if err := writeSSEEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, open := <-sub.Events():
+			if !open {
+				if sub.Lagged() {
+					log.Printf("Federation event stream client %s lagged, disconnecting", GetClientIP(r))
+				}
+				return
+			}
+			if err := writeSSEEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSEEvent writes a single event in text/event-stream format
+func writeSSEEvent(w http.ResponseWriter, event *federation.FederationEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding federation event %d: %v", event.ID, err)
+		return nil
+	}
+	// Event types come from bus messages; keep them on one line
+	eventType := strings.NewReplacer("\r", "", "\n", "").Replace(event.Type)
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, eventType, data)
+	return err
+}
+
+// Query Federation Events Handler
+// Searches the durable event log for incident review, newest first.
+// Filters: ?from=&to= (RFC3339), ?type=a,b, ?nodeId=, ?tenantId=; paginate with ?cursor= and ?limit=
+func handleQueryFederationEvents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	q := federation.EventQuery{
+		Filter: federation.EventFilter{
+			NodeID:   query.Get("nodeId"),
+			TenantID: query.Get("tenantId"),
+		},
+		Cursor: query.Get("cursor"),
+	}
+	if types := query.Get("type"); types != "" {
+		q.Filter.Types = strings.Split(types, ",")
+	}
+	for param, dst := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := query.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeEventQueryError(w, "INVALID_TIME_RANGE")
+				return
+			}
+			*dst = t
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			writeEventQueryError(w, "INVALID_LIMIT")
+			return
+		}
+		q.Limit = limit
+	}
+
+	events, next, err := federation.QueryEvents(r.Context(), q)
+	if err != nil {
+		if errors.Is(err, federation.ErrInvalidCursor) {
+			writeEventQueryError(w, "INVALID_CURSOR")
+			return
+		}
+		log.Printf("Failed to query federation events: %v", err)
+		http.Error(w, "Failed to query events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events":     events,
+		"nextCursor": next,
+	})
+}
+
+func writeEventQueryError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": code,
+	})
+}
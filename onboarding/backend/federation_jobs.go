@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+)
+
+// writeJobError maps job tracking errors to JSON error responses
+func writeJobError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	code := "JOB_TRACKING_ERROR"
+	switch {
+	case errors.Is(err, federation.ErrJobNotFound):
+		status, code = http.StatusNotFound, "JOB_NOT_FOUND"
+	case errors.Is(err, federation.ErrJobState):
+		status, code = http.StatusConflict, "INVALID_JOB_STATE"
+	case errors.Is(err, federation.ErrInvalidJobReport):
+		status, code = http.StatusBadRequest, "INVALID_JOB_REPORT"
+	case errors.Is(err, federation.ErrCommandNotFound), errors.Is(err, federation.ErrCommandState):
+		writeCommandError(w, err)
+		return
+	default:
+		log.Printf("Federation job tracking error: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": code,
+	})
+}
+
+// List Jobs Handler
+// Supports ?nodeId=, ?tenantId=, ?status= and ?limit= filters; also mounted under /nodes/{nodeId} and /tenants/{tenantId}
+func handleListJobs(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	nodeID := chi.URLParam(r, "nodeId")
+	if nodeID == "" {
+		nodeID = query.Get("nodeId")
+	}
+	tenantID := chi.URLParam(r, "tenantId")
+	if tenantID == "" {
+		tenantID = query.Get("tenantId")
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	jobs, err := federation.ListJobs(r.Context(), nodeID, tenantID, federation.JobStatus(query.Get("status")), limit)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobs": jobs,
+	})
+}
+
+// Get Job Handler
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	job, err := federation.GetJob(r.Context(), chi.URLParam(r, "jobId"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"job": job,
+	})
+}
+
+// Cancel Job Handler
+// Cancels a queued or running job; running jobs are told to stop via a job.cancel command
+func handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	job, err := federation.CancelJob(ctx, chi.URLParam(r, "jobId"), operatorID(claims))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	// Log audit event
+	_, _ = getDB(ctx).Exec(ctx,
+		"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+		"federation_job_cancelled",
+		operatorID(claims),
+		fmt.Sprintf(`{"jobId": "%s", "commandId": "%s", "nodeId": "%s"}`, job.ID, job.CommandID, job.NodeID),
+		time.Now(),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":  true,
+		"job": job,
+	})
+}
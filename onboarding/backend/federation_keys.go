@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+)
+
+// initFederationKeyring loads the federation signing keyring from the configured keystore
+// FEDERATION_KEYSTORE: "file" (default), "postgres" or "memory"
+// FEDERATION_KEYSTORE_PATH: keystore file path (file mode)
+// FEDERATION_KEY_RETAIN: number of previous keys still accepted for verification
+// FEDERATION_KEY_REFRESH: how often the keyring reloads rotations made by other replicas
+func initFederationKeyring(ctx context.Context, db *pgxpool.Pool) error {
+	var store federation.KeyStore
+	switch mode := os.Getenv("FEDERATION_KEYSTORE"); mode {
+	case "", "file":
+		path := os.Getenv("FEDERATION_KEYSTORE_PATH")
+		if path == "" {
+			path = "federation-keys.json"
+		}
+		store = federation.NewFileKeyStore(path)
+	case "postgres":
+		store = federation.NewPostgresKeyStore(db)
+	case "memory":
+		store = federation.NewMemoryKeyStore()
+	default:
+		return fmt.Errorf("unknown FEDERATION_KEYSTORE mode: %s", mode)
+	}
+
+	retain := federation.DefaultKeyRetention
+	if v := os.Getenv("FEDERATION_KEY_RETAIN"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid FEDERATION_KEY_RETAIN: %w", err)
+		}
+		retain = n
+	}
+
+	refresh := federation.DefaultKeyRefresh
+	if v := os.Getenv("FEDERATION_KEY_REFRESH"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid FEDERATION_KEY_REFRESH: %w", err)
+		}
+		refresh = d
+	}
+
+	return federation.InitKeyring(ctx, store, retain, refresh)
+}
+
+// List Federation Signing Keys Handler
+// Public keys currently accepted for verification (for distribution to nodes)
+func handleListFederationKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"algo": "ed25519",
+		"keys": federation.GetPublicKeys(),
+	})
+}
+
+// Rotate Federation Signing Key Handler
+// Operator-triggered: a new key becomes current, previous keys keep verifying within the retention window
+func handleRotateFederationKey(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	key, err := federation.RotateSigningKey(ctx)
+	if err != nil {
+		log.Printf("Failed to rotate federation signing key: %v", err)
+		http.Error(w, "Failed to rotate signing key", http.StatusInternalServerError)
+		return
+	}
+
+	// Log audit event
+	_, _ = getDB(ctx).Exec(ctx,
+		"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+		"federation_key_rotated",
+		operatorID(claims),
+		fmt.Sprintf(`{"kid": "%s"}`, key.KeyID),
+		time.Now(),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":   true,
+		"kid":  key.KeyID,
+		"keys": federation.GetPublicKeys(),
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+)
+
+// errNodeKeyTenantConflict is returned when a node ID already has keys registered for another tenant
+var errNodeKeyTenantConflict = errors.New("node ID is registered to another tenant")
+
+// RegisterNodeKey stores a node public key; regenerating a kit replaces the previous key.
+// A node ID whose keys belong to another tenant is refused rather than taken over.
+func RegisterNodeKey(ctx context.Context, key federation.NodeKey, kitFingerprint string) error {
+	db := getDB(ctx)
+	if db == nil {
+		log.Printf("Warning: Database pool not initialized, skipping node key registration")
+		return nil
+	}
+
+	tag, err := db.Exec(ctx,
+		`INSERT INTO public.federation_node_keys (node_id, tenant_id, algorithm, public_key, kit_fingerprint)
+		 SELECT $1, $2, $3, $4, $5
+		 WHERE NOT EXISTS (
+		     SELECT 1 FROM public.federation_node_keys WHERE node_id = $1 AND tenant_id::text <> $2
+		 )
+		 ON CONFLICT (node_id, algorithm) DO UPDATE
+		 SET public_key = EXCLUDED.public_key,
+		     kit_fingerprint = EXCLUDED.kit_fingerprint, created_at = CURRENT_TIMESTAMP
+		 WHERE federation_node_keys.tenant_id = EXCLUDED.tenant_id`,
+		key.NodeID,
+		key.TenantID,
+		key.Algorithm,
+		key.PublicKey,
+		kitFingerprint,
+	)
+	if err != nil {
+		log.Printf("Failed to register node key for %s: %v", key.NodeID, err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		log.Printf("Refused node key for %s: node ID is registered to another tenant than %s", key.NodeID, key.TenantID)
+		return errNodeKeyTenantConflict
+	}
+	return nil
+}
+
+// LookupNodeKey returns the node's registered key for the algorithm
+// With an empty algorithm, Ed25519 is preferred over RSA
+func LookupNodeKey(ctx context.Context, nodeID, algorithm string) (*federation.NodeKey, error) {
+	key := federation.NodeKey{NodeID: nodeID}
+	err := getDB(ctx).QueryRow(ctx,
+		`SELECT tenant_id::text, algorithm, public_key
+		 FROM public.federation_node_keys
+		 WHERE node_id = $1 AND ($2 = '' OR algorithm = $2)
+		 ORDER BY (algorithm = 'ed25519') DESC
+		 LIMIT 1`,
+		nodeID,
+		algorithm,
+	).Scan(&key.TenantID, &key.Algorithm, &key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
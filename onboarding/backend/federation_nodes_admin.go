@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+)
+
+// Decommission Node Handler
+// Retires a node permanently and revokes its federation tokens
+func handleDecommissionNode(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	nodeID := chi.URLParam(r, "nodeId")
+	node, err := federation.DecommissionNode(ctx, nodeID, req.Reason)
+	if err != nil {
+		status := http.StatusInternalServerError
+		code := "DECOMMISSION_FAILED"
+		switch {
+		case errors.Is(err, federation.ErrNodeNotFound):
+			status, code = http.StatusNotFound, "NODE_NOT_FOUND"
+		case errors.Is(err, federation.ErrNodeDecommissioned):
+			status, code = http.StatusConflict, "NODE_DECOMMISSIONED"
+		default:
+			log.Printf("Failed to decommission node %s: %v", nodeID, err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": code,
+		})
+		return
+	}
+
+	// A retired node must not keep using tokens it already holds
+	revoked := true
+	if _, err := federation.Revoke(ctx, federation.RevokeNode, nodeID, "node decommissioned", operatorID(claims), time.Time{}); err != nil {
+		log.Printf("Failed to revoke tokens of decommissioned node %s: %v", nodeID, err)
+		revoked = false
+	}
+
+	// Log audit event
+	_, _ = getDB(ctx).Exec(ctx,
+		"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+		"federation_node_decommissioned",
+		operatorID(claims),
+		fmt.Sprintf(`{"nodeId": "%s", "tenantId": "%s"}`, node.NodeID, node.TenantID),
+		time.Now(),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":            true,
+		"node":          node,
+		"tokensRevoked": revoked,
+	})
+}
+
+// Get Lifecycle Settings Handler
+// Returns the heartbeat thresholds applied to a tenant's nodes
+func handleGetLifecycleSettings(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	tenantID := chi.URLParam(r, "tenantId")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tenantId": tenantID,
+		"settings": federation.GetLifecycleSettings(tenantID),
+	})
+}
+
+// Set Lifecycle Settings Handler
+// Configures heartbeat interval and missed-heartbeat thresholds for a tenant
+func handleSetLifecycleSettings(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+	if !ok {
+		return
+	}
+
+	var settings federation.LifecycleSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := settings.Validate(); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "INVALID_SETTINGS",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx := r.Context()
+	tenantID := chi.URLParam(r, "tenantId")
+	if err := federation.SetLifecycleSettings(ctx, tenantID, settings, operatorID(claims)); err != nil {
+		log.Printf("Failed to store lifecycle settings for tenant %s: %v", tenantID, err)
+		http.Error(w, "Failed to store settings", http.StatusInternalServerError)
+		return
+	}
+
+	// Log audit event
+	_, _ = getDB(ctx).Exec(ctx,
+		"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+		"federation_lifecycle_settings_updated",
+		operatorID(claims),
+		fmt.Sprintf(`{"tenantId": "%s", "heartbeatIntervalMs": %d, "degradedAfterMissed": %d, "offlineAfterMissed": %d}`,
+			tenantID, settings.HeartbeatIntervalMs, settings.DegradedAfterMissed, settings.OfflineAfterMissed),
+		time.Now(),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":       true,
+		"tenantId": tenantID,
+		"settings": settings,
+	})
+}
+
+// Set Node Relay Handler
+// Grants or withdraws a node's gateway capability to forward bus messages for other nodes of its tenant.
+// New session tokens carry the relay scope; withdrawing takes effect immediately for existing tokens.
+func handleSetNodeRelay(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	nodeID := chi.URLParam(r, "nodeId")
+	node, err := federation.SetNodeRelay(ctx, nodeID, *req.Enabled)
+	if err != nil {
+		status := http.StatusInternalServerError
+		code := "RELAY_UPDATE_FAILED"
+		switch {
+		case errors.Is(err, federation.ErrNodeNotFound):
+			status, code = http.StatusNotFound, "NODE_NOT_FOUND"
+		case errors.Is(err, federation.ErrNodeDecommissioned):
+			status, code = http.StatusConflict, "NODE_DECOMMISSIONED"
+		default:
+			log.Printf("Failed to update relay capability of node %s: %v", nodeID, err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": code,
+		})
+		return
+	}
+
+	// Log audit event
+	_, _ = getDB(ctx).Exec(ctx,
+		"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+		"federation_node_relay_updated",
+		operatorID(claims),
+		fmt.Sprintf(`{"nodeId": "%s", "tenantId": "%s", "relay": %t}`, node.NodeID, node.TenantID, node.Relay),
+		time.Now(),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":   true,
+		"node": node,
+	})
+}
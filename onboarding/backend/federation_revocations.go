@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+)
+
+// revocationRefreshInterval reads FEDERATION_REVOCATION_REFRESH (Go duration, default 10s)
+func revocationRefreshInterval() time.Duration {
+	if v := os.Getenv("FEDERATION_REVOCATION_REFRESH"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		log.Printf("Warning: invalid FEDERATION_REVOCATION_REFRESH %q, using default", v)
+	}
+	return federation.DefaultRevocationRefresh
+}
+
+// Create Revocation Handler
+// Revokes a single token, every token of a node (e.g. a stolen Pi) or a whole tenant
+func handleCreateRevocation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Kind   string `json:"kind"`  // token | node | tenant
+		Value  string `json:"value"` // token hash, node ID or tenant ID
+		Token  string `json:"token"` // raw token (kind=token), hashed before storage
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	kind := federation.RevocationKind(req.Kind)
+	value := req.Value
+	var tokenExpiresAt time.Time
+	if kind == federation.RevokeToken && req.Token != "" {
+		value = federation.TokenHash(req.Token)
+		// The payload comes back for expired or already revoked tokens too; only a bad signature drops it
+		if payload, _ := federation.VerifyFederationToken(req.Token); payload != nil {
+			tokenExpiresAt = time.UnixMilli(payload.ExpiresAt)
+		}
+	}
+
+	if value == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "MISSING_FIELDS",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	rev, err := federation.Revoke(ctx, kind, value, req.Reason, operatorID(claims), tokenExpiresAt)
+	if err != nil {
+		log.Printf("Failed to revoke %s %s: %v", req.Kind, value, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "REVOCATION_FAILED",
+		})
+		return
+	}
+
+	// Log audit event (the revoked value is client-supplied, so details are marshalled rather than formatted)
+	details, _ := json.Marshal(map[string]interface{}{
+		"kind":  rev.Kind,
+		"value": rev.Value,
+	})
+	_, _ = getDB(ctx).Exec(ctx,
+		"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+		"federation_revoked",
+		operatorID(claims),
+		string(details),
+		time.Now(),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":         true,
+		"revocation": rev,
+	})
+}
+
+// List Revocations Handler
+func handleListRevocations(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"revocations": federation.ListRevocations(),
+	})
+}
+
+// Delete Revocation Handler
+// Lifts a revocation (e.g. a node recovered after a false alarm)
+func handleDeleteRevocation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	id := chi.URLParam(r, "revocationId")
+	rev, err := federation.Unrevoke(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Revocation not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to delete revocation %s: %v", id, err)
+		http.Error(w, "Failed to delete revocation", http.StatusInternalServerError)
+		return
+	}
+
+	// Log audit event
+	details, _ := json.Marshal(map[string]interface{}{
+		"kind":  rev.Kind,
+		"value": rev.Value,
+	})
+	_, _ = getDB(ctx).Exec(ctx,
+		"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+		"federation_revocation_lifted",
+		operatorID(claims),
+		string(details),
+		time.Now(),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":         true,
+		"revocation": rev,
+	})
+}
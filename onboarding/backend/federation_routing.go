@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	fedmw "github.com/silentsage432/sage-gitops/onboarding/backend/middleware"
+)
+
+// nodePoolConfig reads the FederationRouter node pool settings
+// FEDERATION_NODE_POOL_PROBE_INTERVAL (Go duration, default 15s), FEDERATION_NODE_POOL_MAX_CONNS and
+// FEDERATION_NODE_POOL_MIN_CONNS (defaults for nodes without pool_max_conns/pool_min_conns),
+// FEDERATION_NODE_BREAKER_THRESHOLD (failed probes, default 3) and FEDERATION_NODE_BREAKER_BACKOFF
+// (first open period, default 30s)
+func nodePoolConfig() fedmw.NodePoolConfig {
+	cfg := fedmw.DefaultNodePoolConfig()
+	cfg.ProbeInterval = envDuration("FEDERATION_NODE_POOL_PROBE_INTERVAL", cfg.ProbeInterval)
+	cfg.MaxConns = int32(envInt("FEDERATION_NODE_POOL_MAX_CONNS", int(cfg.MaxConns), 1))
+	cfg.MinConns = int32(envInt("FEDERATION_NODE_POOL_MIN_CONNS", int(cfg.MinConns), 0))
+	cfg.BreakerThreshold = envInt("FEDERATION_NODE_BREAKER_THRESHOLD", cfg.BreakerThreshold, 1)
+	cfg.BreakerBackoff = envDuration("FEDERATION_NODE_BREAKER_BACKOFF", cfg.BreakerBackoff)
+	if cfg.BreakerMaxBackoff < cfg.BreakerBackoff {
+		cfg.BreakerMaxBackoff = cfg.BreakerBackoff
+	}
+	return cfg
+}
+
+// envDuration reads a positive Go duration, falling back to def
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Warning: invalid %s %q, using default", name, v)
+	}
+	return def
+}
+
+// envInt reads an integer of at least minimum, falling back to def
+func envInt(name string, def, minimum int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= minimum {
+			return n
+		}
+		log.Printf("Warning: invalid %s %q, using default", name, v)
+	}
+	return def
+}
+
+// recordRoutingFallback records a tenant's default-database fallback or residency refusal in its activity feed
+func recordRoutingFallback(ctx context.Context, d fedmw.FallbackDecision) {
+	if !d.KnownTenant {
+		return
+	}
+
+	eventType, severity := ActivityEventRoutingFallback, ActivitySeverityWarning
+	summary := "Request served from the default database"
+	if d.Refused {
+		eventType, severity = ActivityEventResidencyRefused, ActivitySeverityError
+		summary = "Request refused: no database in the residency region is available"
+	}
+	detail := ""
+	if d.Cause != nil {
+		detail = d.Cause.Error()
+	}
+
+	_ = RecordActivityEvent(ctx, d.TenantID, eventType, summary, detail, severity, map[string]interface{}{
+		"region":          d.Region,
+		"reason":          d.Reason,
+		"strict":          d.Policy.Strict,
+		"residencyReason": d.Policy.Reason,
+	})
+}
+
+// Get Tenant Residency Handler
+// Returns a tenant's data residency mode and whether it was set by an operator or derived from config
+func handleGetTenantResidency(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	ctx := r.Context()
+	tenantID := chi.URLParam(r, "tenantId")
+	policy, err := fedmw.LoadResidencyPolicy(ctx, getDB(ctx), tenantID)
+	if err != nil {
+		writeResidencyError(w, tenantID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tenantId":  tenantID,
+		"residency": policy,
+	})
+}
+
+// Set Tenant Residency Handler
+// Forces strict (fail-closed) or relaxed residency for a tenant; null restores the config-derived mode
+func handleSetTenantResidency(fr *fedmw.FederationRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+		if !ok {
+			return
+		}
+
+		var req map[string]*bool
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		strict, present := req["strict"]
+		if !present {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		tenantID := chi.URLParam(r, "tenantId")
+		policy, err := fedmw.SetResidencyOverride(ctx, getDB(ctx), tenantID, strict)
+		if err != nil {
+			writeResidencyError(w, tenantID, err)
+			return
+		}
+		fr.ForgetResidencyPolicy(tenantID)
+
+		override := "null"
+		if strict != nil {
+			override = strconv.FormatBool(*strict)
+		}
+
+		// Log audit event
+		_, _ = getDB(ctx).Exec(ctx,
+			"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+			"tenant_residency_updated",
+			operatorID(claims),
+			fmt.Sprintf(`{"tenantId": "%s", "override": %s, "strict": %t}`, tenantID, override, policy.Strict),
+			time.Now(),
+		)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":        true,
+			"tenantId":  tenantID,
+			"residency": policy,
+		})
+	}
+}
+
+func writeResidencyError(w http.ResponseWriter, tenantID string, err error) {
+	status, code := http.StatusInternalServerError, "RESIDENCY_LOOKUP_FAILED"
+	if errors.Is(err, fedmw.ErrTenantNotFound) {
+		status, code = http.StatusNotFound, "TENANT_NOT_FOUND"
+	} else {
+		log.Printf("Failed to access residency mode of tenant %s: %v", tenantID, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": code,
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+)
+
+// writeTelemetryError maps telemetry store errors to JSON error responses
+func writeTelemetryError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	code := "TELEMETRY_ERROR"
+	message := ""
+	switch {
+	case errors.Is(err, federation.ErrInvalidTelemetry):
+		status, code, message = http.StatusBadRequest, "INVALID_TELEMETRY", err.Error()
+	case errors.Is(err, federation.ErrInvalidTelemetryQuery):
+		status, code, message = http.StatusBadRequest, "INVALID_TELEMETRY_QUERY", err.Error()
+	case errors.Is(err, federation.ErrTelemetryQueueFull):
+		// Agents should back off and resend the batch
+		status, code = http.StatusServiceUnavailable, "TELEMETRY_BACKPRESSURE"
+		w.Header().Set("Retry-After", "5")
+	default:
+		log.Printf("Federation telemetry error: %v", err)
+	}
+	body := map[string]interface{}{
+		"error": code,
+	}
+	if message != "" {
+		body["message"] = message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Query Telemetry Handler
+// Returns series for a tenant, node or agent: ?tenantId=&nodeId=&agentId= (at least one),
+// ?metric=, ?labels=k=v,k2=v2, ?from=&to= (RFC3339, default last hour) and ?resolution=raw|1m|1h
+func handleQueryTelemetry(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	q := federation.TelemetryQuery{
+		TenantID:   query.Get("tenantId"),
+		NodeID:     query.Get("nodeId"),
+		AgentID:    query.Get("agentId"),
+		Metric:     query.Get("metric"),
+		Resolution: query.Get("resolution"),
+	}
+	if labels := query.Get("labels"); labels != "" {
+		q.Labels = make(map[string]string)
+		for _, pair := range strings.Split(labels, ",") {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok || k == "" {
+				writeTelemetryError(w, fmt.Errorf("%w: labels must be k=v pairs", federation.ErrInvalidTelemetryQuery))
+				return
+			}
+			q.Labels[k] = v
+		}
+	}
+	for param, dst := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := query.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeTelemetryError(w, fmt.Errorf("%w: %s must be RFC3339", federation.ErrInvalidTelemetryQuery, param))
+				return
+			}
+			*dst = t
+		}
+	}
+
+	result, err := federation.QueryTelemetry(r.Context(), q)
+	if err != nil {
+		writeTelemetryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	fedmw "github.com/silentsage432/sage-gitops/onboarding/backend/middleware"
+)
+
+// recordMigrationEvent records a tenant migration status change in the tenant's activity feed
+// The event lands in the database serving the tenant at that point, so it moves with the tenant.
+func recordMigrationEvent(ctx context.Context, m fedmw.TenantMigration) {
+	eventType, severity := ActivityEventMigrationProgress, ActivitySeverityInfo
+	summary := fmt.Sprintf("Migration to node %s: %s", m.TargetNodeID, m.Status)
+	switch m.Status {
+	case fedmw.MigrationPending:
+		return
+	case fedmw.MigrationCopying:
+		eventType = ActivityEventMigrationStarted
+		summary = fmt.Sprintf("Migration from %s to node %s started", m.SourceNodeID, m.TargetNodeID)
+	case fedmw.MigrationCompleted:
+		eventType, severity = ActivityEventMigrationCompleted, ActivitySeveritySuccess
+		summary = fmt.Sprintf("Migrated to node %s (%s)", m.TargetNodeID, m.TargetRegion)
+	case fedmw.MigrationFailed:
+		eventType, severity = ActivityEventMigrationFailed, ActivitySeverityError
+		summary = fmt.Sprintf("Migration to node %s failed", m.TargetNodeID)
+	case fedmw.MigrationRolledBack:
+		eventType, severity = ActivityEventMigrationRolledBack, ActivitySeverityWarning
+		summary = fmt.Sprintf("Migration to node %s rolled back", m.TargetNodeID)
+	}
+
+	_ = RecordActivityEvent(ctx, m.TenantID, eventType, summary, m.Error, severity, map[string]interface{}{
+		"migrationId":  m.ID,
+		"status":       m.Status,
+		"sourceNodeId": m.SourceNodeID,
+		"targetNodeId": m.TargetNodeID,
+		"passes":       m.Progress.Passes,
+		"verified":     m.Progress.Verified,
+	})
+}
+
+// Start Tenant Migration Handler
+// Moves a tenant's data to another node: copy with dual-write, verify, then cut routing over
+func handleStartTenantMigration(fr *fedmw.FederationRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			TargetNodeID string `json:"targetNodeId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TargetNodeID == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		tenantID := chi.URLParam(r, "tenantId")
+		m, err := fr.StartTenantMigration(ctx, tenantID, req.TargetNodeID, operatorID(claims))
+		if err != nil {
+			writeMigrationError(w, err)
+			return
+		}
+
+		// Log audit event
+		_, _ = getDB(ctx).Exec(ctx,
+			"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+			"tenant_migration_started",
+			operatorID(claims),
+			fmt.Sprintf(`{"migrationId": "%s", "tenantId": "%s", "sourceNodeId": "%s", "targetNodeId": "%s"}`,
+				m.ID, m.TenantID, m.SourceNodeID, m.TargetNodeID),
+			time.Now(),
+		)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":        true,
+			"migration": m,
+		})
+	}
+}
+
+// List Tenant Migrations Handler
+// Returns a tenant's migrations with their progress, newest first
+func handleListTenantMigrations(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	ctx := r.Context()
+	tenantID := chi.URLParam(r, "tenantId")
+	migrations, err := fedmw.ListTenantMigrations(ctx, getDB(ctx), tenantID)
+	if err != nil {
+		writeMigrationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tenantId":   tenantID,
+		"migrations": migrations,
+	})
+}
+
+// Get Tenant Migration Handler
+// Returns a migration's status and per-table row counts and checksums
+func handleGetTenantMigration(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	ctx := r.Context()
+	m, err := fedmw.GetTenantMigration(ctx, getDB(ctx), chi.URLParam(r, "migrationId"))
+	if err != nil {
+		writeMigrationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"migration": m,
+	})
+}
+
+// Rollback Tenant Migration Handler
+// Stops a migration that has not completed and deletes the copy on the target node
+func handleRollbackTenantMigration(fr *fedmw.FederationRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+		if !ok {
+			return
+		}
+
+		ctx := r.Context()
+		migrationID := chi.URLParam(r, "migrationId")
+		m, err := fr.RollbackTenantMigration(ctx, migrationID)
+
+		// Log audit event
+		if m != nil {
+			_, _ = getDB(ctx).Exec(ctx,
+				"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+				"tenant_migration_rolled_back",
+				operatorID(claims),
+				fmt.Sprintf(`{"migrationId": "%s", "tenantId": "%s", "status": "%s"}`, m.ID, m.TenantID, m.Status),
+				time.Now(),
+			)
+		}
+
+		if err != nil {
+			writeMigrationError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":        true,
+			"migration": m,
+		})
+	}
+}
+
+func writeMigrationError(w http.ResponseWriter, err error) {
+	status, code, message := http.StatusInternalServerError, "MIGRATION_FAILED", ""
+	switch {
+	case errors.Is(err, fedmw.ErrTenantNotFound):
+		status, code = http.StatusNotFound, "TENANT_NOT_FOUND"
+	case errors.Is(err, fedmw.ErrMigrationNotFound):
+		status, code = http.StatusNotFound, "MIGRATION_NOT_FOUND"
+	case errors.Is(err, fedmw.ErrMigrationActive):
+		status, code = http.StatusConflict, "MIGRATION_IN_PROGRESS"
+	case errors.Is(err, fedmw.ErrMigrationState):
+		status, code = http.StatusConflict, "INVALID_MIGRATION_STATE"
+	case errors.Is(err, fedmw.ErrMigrationTarget):
+		status, code, message = http.StatusBadRequest, "INVALID_TARGET", err.Error()
+	default:
+		log.Printf("Tenant migration request failed: %v", err)
+		message = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   code,
+		"message": message,
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+)
+
+// Federation token registry
+// Tokens are tracked by SHA256 hash (never stored in clear) so they can be listed and revoked
+
+// Token sources
+const (
+	FederationTokenSourceHandshake = "handshake"
+	FederationTokenSourceNodeJoin  = "node_join"
+	FederationTokenSourceImport    = "import"
+)
+
+// FederationTokenRecord is a registered federation token
+type FederationTokenRecord struct {
+	TokenHash    string    `json:"tokenHash"`
+	KeyID        string    `json:"kid"`
+	NodeID       string    `json:"nodeId"`
+	TenantID     string    `json:"tenantId"`
+	Audience     []string  `json:"audience"`
+	Source       string    `json:"source"`
+	IssuedAt     int64     `json:"issuedAt"`
+	ExpiresAt    int64     `json:"expiresAt"`
+	RegisteredAt time.Time `json:"registeredAt"`
+	Revoked      bool      `json:"revoked"`
+}
+
+// RecordFederationToken stores token metadata in the registry (idempotent per token)
+func RecordFederationToken(ctx context.Context, token string, payload *federation.FederationTokenPayload, source string) error {
+	db := getDB(ctx)
+	if db == nil {
+		log.Printf("Warning: Database pool not initialized, skipping federation token registry")
+		return nil
+	}
+
+	_, err := db.Exec(ctx,
+		`INSERT INTO public.federation_tokens
+		 (token_hash, kid, node_id, tenant_id, audience, source, issued_at, expires_at, registered_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 ON CONFLICT (token_hash) DO NOTHING`,
+		federation.TokenHash(token),
+		payload.KeyID,
+		payload.NodeID,
+		payload.TenantID,
+		payload.Audience,
+		source,
+		time.UnixMilli(payload.IssuedAt),
+		time.UnixMilli(payload.ExpiresAt),
+		time.Now(),
+	)
+	if err != nil {
+		log.Printf("Failed to record federation token: %v", err)
+		return err
+	}
+	return nil
+}
+
+// QueryFederationTokens lists registered tokens, optionally filtered by node and tenant
+func QueryFederationTokens(ctx context.Context, nodeID, tenantID string, limit int) ([]FederationTokenRecord, error) {
+	db := getDB(ctx)
+	if db == nil {
+		return []FederationTokenRecord{}, nil
+	}
+
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT token_hash, kid, node_id, tenant_id, audience, source, issued_at, expires_at, registered_at
+		 FROM public.federation_tokens
+		 WHERE ($1 = '' OR node_id = $1) AND ($2 = '' OR tenant_id = $2)
+		 ORDER BY registered_at DESC
+		 LIMIT $3`,
+		nodeID,
+		tenantID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []FederationTokenRecord{}
+	for rows.Next() {
+		var rec FederationTokenRecord
+		var issuedAt, expiresAt time.Time
+		if err := rows.Scan(&rec.TokenHash, &rec.KeyID, &rec.NodeID, &rec.TenantID, &rec.Audience,
+			&rec.Source, &issuedAt, &expiresAt, &rec.RegisteredAt); err != nil {
+			log.Printf("Failed to scan federation token: %v", err)
+			continue
+		}
+		rec.IssuedAt = issuedAt.UnixMilli()
+		rec.ExpiresAt = expiresAt.UnixMilli()
+		rec.Revoked = federation.IsRevoked(rec.TokenHash, rec.NodeID, rec.TenantID)
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+// List Federation Tokens Handler
+// Supports ?nodeId= and ?tenantId= filters; revoke entries via /api/federation/admin/revocations (kind=token)
+func handleListFederationTokens(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeFederationAdmin); !ok {
+		return
+	}
+
+	records, err := QueryFederationTokens(r.Context(), r.URL.Query().Get("nodeId"), r.URL.Query().Get("tenantId"), 0)
+	if err != nil {
+		log.Printf("Failed to query federation tokens: %v", err)
+		http.Error(w, "Failed to list tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tokens": records,
+	})
+}
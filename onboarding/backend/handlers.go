@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -175,13 +176,14 @@ func handleWebAuthnVerify(w http.ResponseWriter, r *http.Request) {
 		userID:      "tyson",
 	}
 
-	// Load credentials from database if they exist
+	// Load credentials from database if they exist, with the operator who registered them
+	var credentialOwner string
 	if hasCredential {
 		var credentialBytes []byte
 		err = getDB(ctx).QueryRow(ctx,
-			"SELECT credential_data FROM public.operator_keys WHERE user_id = $1 AND credential_data IS NOT NULL ORDER BY created_at DESC LIMIT 1",
+			"SELECT user_id, credential_data FROM public.operator_keys WHERE user_id = $1 AND credential_data IS NOT NULL ORDER BY created_at DESC LIMIT 1",
 			"tyson",
-		).Scan(&credentialBytes)
+		).Scan(&credentialOwner, &credentialBytes)
 
 		if err == nil {
 			var cred webauthn.Credential
@@ -191,9 +193,11 @@ func handleWebAuthnVerify(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Only an authentication (not a registration) yields a ticket for /rho2/auth/issue
+	var octTicket string
 	if hasCredential {
 		// Authentication flow
-		_, err := webAuthn.FinishLogin(user, session, r)
+		credential, err := webAuthn.FinishLogin(user, session, r)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]interface{}{
@@ -203,14 +207,34 @@ func handleWebAuthnVerify(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// The ticket names the operator whose stored credential produced the assertion
+		if credentialOwner == "" || len(user.credentials) == 0 || !bytes.Equal(credential.ID, user.credentials[0].ID) {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Authentication failed: credential has no registered operator",
+			})
+			return
+		}
+
 		// Log audit event
 		_, _ = getDB(ctx).Exec(ctx,
 			"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
 			"webauthn_success",
-			"tyson",
+			credentialOwner,
 			`{"action": "authentication", "device": "yubikey"}`,
 			time.Now(),
 		)
+
+		octTicket, err = issueOCTTicket(ctx, credentialOwner)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Failed to store OCT ticket",
+			})
+			return
+		}
 	} else {
 		// Registration flow
 		credentialData, err := webAuthn.FinishRegistration(user, session, r)
@@ -255,10 +279,12 @@ func handleWebAuthnVerify(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success":    true,
 		"deviceName": "YubiKey",
+		"octTicket":  octTicket,
 	})
 }
 
 // Issue OCT Handler
+// Exchanges the ticket returned by a successful WebAuthn verification for an OCT naming that operator
 func handleIssueOCT(w http.ResponseWriter, r *http.Request) {
 	// Force bypass for development - return immediately before any DB checks
 	if os.Getenv("BYPASS_OCT") == "true" {
@@ -267,7 +293,7 @@ func handleIssueOCT(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"token":     "mock-oct-token",
 			"expiresAt": time.Now().Add(10*time.Minute).Unix() * 1000, // JavaScript timestamp
-			"scopes":    []string{"tenant.create", "agent.plan.create", "bootstrap.sign"},
+			"scopes":    octScopes("dev-operator"),
 		})
 		return
 	}
@@ -278,12 +304,13 @@ func handleIssueOCT(w http.ResponseWriter, r *http.Request) {
 	if os.Getenv("BYPASS_YUBIKEY") == "true" {
 		now := time.Now()
 		expiresAt := now.Add(10 * time.Minute)
+		scopes := octScopes("dev-operator")
 
 		claims := jwt.MapClaims{
 			"sub":    "dev-operator",
 			"iat":    now.Unix(),
 			"exp":    expiresAt.Unix(),
-			"scopes": []string{"tenant.create", "agent.plan.create", "bootstrap.sign"},
+			"scopes": scopes,
 			"type":   "oct",
 			"jti":    uuid.New().String(),
 		}
@@ -300,7 +327,7 @@ func handleIssueOCT(w http.ResponseWriter, r *http.Request) {
 			"INSERT INTO public.capability_tokens (token_id, user_id, scopes, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)",
 			claims["jti"],
 			"dev-operator",
-			[]string{"tenant.create", "agent.plan.create", "bootstrap.sign"},
+			scopes,
 			expiresAt,
 			now,
 		)
@@ -309,32 +336,40 @@ func handleIssueOCT(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"token":     tokenString,
 			"expiresAt": expiresAt.Unix() * 1000, // JavaScript timestamp
-			"scopes":    []string{"tenant.create", "agent.plan.create", "bootstrap.sign"},
+			"scopes":    scopes,
 		})
 		return
 	}
 
-	// Normal flow: Verify that user is authenticated (has credential in database)
-	var hasCredential bool
-	err := getDB(ctx).QueryRow(ctx,
-		"SELECT EXISTS(SELECT 1 FROM public.operator_keys WHERE user_id = $1 AND credential_data IS NOT NULL)",
-		"tyson",
-	).Scan(&hasCredential)
-
-	if err != nil || !hasCredential {
+	// Normal flow: the ticket proves which operator just completed a WebAuthn verification
+	var req struct {
+		Ticket string `json:"ticket"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Ticket == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	operator, err := redeemOCTTicket(ctx, req.Ticket)
+	if err != nil {
+		if errors.Is(err, errInvalidOCTTicket) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Issue OCT: failed to redeem ticket: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
 	// Create OCT token
 	now := time.Now()
 	expiresAt := now.Add(10 * time.Minute)
+	scopes := octScopes(operator)
 
 	claims := jwt.MapClaims{
-		"sub":    "tyson",
+		"sub":    operator,
 		"iat":    now.Unix(),
 		"exp":    expiresAt.Unix(),
-		"scopes": []string{"tenant.create", "agent.plan.create", "bootstrap.sign"},
+		"scopes": scopes,
 		"type":   "oct",
 		"jti":    uuid.New().String(),
 	}
@@ -350,8 +385,8 @@ func handleIssueOCT(w http.ResponseWriter, r *http.Request) {
 	_, err = getDB(ctx).Exec(ctx,
 		"INSERT INTO public.capability_tokens (token_id, user_id, scopes, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)",
 		claims["jti"],
-		"tyson",
-		[]string{"tenant.create", "agent.plan.create", "bootstrap.sign"},
+		operator,
+		scopes,
 		expiresAt,
 		now,
 	)
@@ -361,11 +396,15 @@ func handleIssueOCT(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Log audit event
+	details, _ := json.Marshal(map[string]interface{}{
+		"token_id": claims["jti"],
+		"scopes":   scopes,
+	})
 	_, _ = getDB(ctx).Exec(ctx,
 		"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
 		"oct_issued",
-		"tyson",
-		fmt.Sprintf(`{"token_id": "%s", "scopes": ["tenant.create", "agent.plan.create", "bootstrap.sign"]}`, claims["jti"]),
+		operator,
+		string(details),
 		now,
 	)
 
@@ -373,7 +412,7 @@ func handleIssueOCT(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"token":     tokenString,
 		"expiresAt": expiresAt.Unix() * 1000, // JavaScript timestamp
-		"scopes":    []string{"tenant.create", "agent.plan.create", "bootstrap.sign"},
+		"scopes":    scopes,
 	})
 }
 
@@ -987,6 +1026,25 @@ func handleBootstrapKit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Register node public keys for the asymmetric federation handshake
+	for _, nodeKey := range kit.NodeKeys {
+		if err := RegisterNodeKey(ctx, nodeKey, kit.Fingerprint); err != nil {
+			if errors.Is(err, errNodeKeyTenantConflict) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error": "NODE_ID_IN_USE",
+				})
+				return
+			}
+			http.Error(w, "Failed to register node keys", http.StatusInternalServerError)
+			return
+		}
+	}
+	if kit.NodeID != "" {
+		federation.RegisterPendingNode(kit.NodeID, tenantInfo.ID)
+	}
+
 	// Store kit in database
 	now := time.Now()
 	expiresAt := now.Add(15 * time.Minute) // 15 minute expiry
@@ -1489,6 +1547,16 @@ func handleTenantTelemetry(w http.ResponseWriter, r *http.Request) {
 		lastSignal = signals[0]["timestamp"].(string)
 	}
 
+	// Agent telemetry over the last hour (from the telemetry store rollups)
+	telemetrySummary, err := federation.SummarizeTelemetry(ctx, tenantID, time.Now().Add(-time.Hour))
+	if err != nil {
+		log.Printf("Failed to summarize telemetry for tenant %s: %v", tenantID, err)
+		telemetrySummary = &federation.TelemetrySummary{}
+	}
+	if telemetrySummary.LastSampleAt != nil {
+		lastSignal = telemetrySummary.LastSampleAt.Format(time.RFC3339)
+	}
+
 	// Return enhanced telemetry data (Phase 8)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1498,6 +1566,7 @@ func handleTenantTelemetry(w http.ResponseWriter, r *http.Request) {
 		"healthScore": healthScore,
 		"alerts":      alerts,
 		"signals":     signals,
+		"telemetry":   telemetrySummary,
 	})
 }
 
@@ -2372,8 +2441,8 @@ func handleAgentTelemetry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var telemetryData map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&telemetryData); err != nil {
+	var batch federation.TelemetryBatch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
 		http.Error(w, "Invalid telemetry data", http.StatusBadRequest)
 		return
 	}
@@ -2381,14 +2450,20 @@ func handleAgentTelemetry(w http.ResponseWriter, r *http.Request) {
 	// Log telemetry receipt
 	log.Printf("Agent telemetry received from node=%s tenant=%s", fedPayload.NodeID, fedPayload.TenantID)
 
-	// Process telemetry data (store in database, forward to message mesh, etc.)
-	// For now, just acknowledge receipt
+	// Queue for the telemetry store; node and tenant come from the verified token
+	accepted, err := federation.IngestTelemetry(fedPayload.NodeID, fedPayload.TenantID, batch)
+	if err != nil {
+		writeTelemetryError(w, err)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"ok":      true,
-		"nodeId":  fedPayload.NodeID,
+		"ok":       true,
+		"nodeId":   fedPayload.NodeID,
 		"tenantId": fedPayload.TenantID,
-		"message": "Telemetry received",
+		"accepted": accepted,
+		"message":  "Telemetry received",
 	})
 }
 
@@ -2402,14 +2477,56 @@ func handleAgentCommands(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Return pending commands for this agent/node
-	// In a full implementation, this would query a command queue
+	// Optional pull options; an empty body pulls node-wide commands without waiting
+	var req struct {
+		AgentID     string `json:"agentId"`
+		Max         int    `json:"max"`
+		WaitSeconds int    `json:"waitSeconds"` // long-poll up to 30s
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Return pending commands for this agent/node (marked delivered; acknowledge via /commands/{id}/ack)
+	commands, err := federation.PullCommands(r.Context(), fedPayload.NodeID, req.AgentID, req.Max,
+		time.Duration(req.WaitSeconds)*time.Second)
+	if err != nil {
+		log.Printf("Failed to pull commands for node=%s: %v", fedPayload.NodeID, err)
+		http.Error(w, "Failed to pull commands", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"ok":       true,
 		"nodeId":   fedPayload.NodeID,
 		"tenantId": fedPayload.TenantID,
-		"commands": []interface{}{}, // Empty for now
+		"commands": commands,
+	})
+}
+
+// Agent Command Ack Handler
+// Agents acknowledge receipt of a delivered command; unacknowledged commands are redelivered
+func handleAgentCommandAck(w http.ResponseWriter, r *http.Request) {
+	fedPayload := fedmw.GetFederationPayload(r)
+	if fedPayload == nil {
+		http.Error(w, "Federation payload not found", http.StatusInternalServerError)
+		return
+	}
+
+	command, err := federation.AcknowledgeCommand(r.Context(), fedPayload.NodeID, chi.URLParam(r, "commandId"))
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":      true,
+		"command": command,
 	})
 }
 
@@ -2423,8 +2540,8 @@ func handleAgentJobs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var jobData map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&jobData); err != nil {
+	var report federation.JobReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
 		http.Error(w, "Invalid job data", http.StatusBadRequest)
 		return
 	}
@@ -2432,6 +2549,25 @@ func handleAgentJobs(w http.ResponseWriter, r *http.Request) {
 	// Process job results
 	log.Printf("Agent job result received from node=%s tenant=%s", fedPayload.NodeID, fedPayload.TenantID)
 
+	// Reports for queued commands update the job: {"jobId"|"commandId", "status": "running"|"succeeded"|"failed",
+	// "exitCode", "result", "stdout", "stderr", "error"}
+	if report.JobID != "" || report.CommandID != "" {
+		job, err := federation.ReportJob(r.Context(), fedPayload.NodeID, report)
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":       true,
+			"nodeId":   fedPayload.NodeID,
+			"tenantId": fedPayload.TenantID,
+			"job":      job,
+		})
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"ok":       true,
@@ -2473,75 +2609,45 @@ func handleFederationBus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Type string                 `json:"type"`
-		Data map[string]interface{} `json:"data"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	// Envelope: {messageId, type, version, sentAt, correlationId, nodeId, payload}
+	envelope, err := federation.DecodeEnvelope(r.Body)
+	if err != nil {
+		writeBusError(w, err)
 		return
 	}
 
-	// Phase 14.6: Route message through central router
-	// Extract nodeId from data if present, otherwise use federation payload
+	// Phase 14.6: Route message through the bus handler registry
+	// The envelope nodeId defaults to the token's node; acting for any other node is authorized
+	// by middleware (relay capability, same tenant) and audited when refused
 	nodeID := fedPayload.NodeID
-	if dataNodeID, ok := req.Data["nodeId"].(string); ok && dataNodeID != "" {
-		nodeID = dataNodeID
-	}
-	
-	// Route message (includes event recording and internal routing)
-	federation.RouteMessage(req.Type, nodeID, req.Data)
-
-	// Phase 14.6: Response handling based on message type
-	// Routing is handled by RouteMessage, here we just return appropriate responses
-	switch req.Type {
-	case "heartbeat":
-		// Routing already handled by RouteMessage (includes UpdateNodeHeartbeat)
-		log.Printf("Heartbeat received from node=%s tenant=%s", fedPayload.NodeID, fedPayload.TenantID)
-		
-		// Return success with status confirmation
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"ok":     true,
-			"status": "alive",
-		})
-		return
-
-	case "command":
-		// Command routing already handled by RouteMessage (acknowledged, not executed)
-		cmd, _ := req.Data["cmd"].(string)
-		log.Printf("Command received from node=%s tenant=%s cmd=%s", fedPayload.NodeID, fedPayload.TenantID, cmd)
-		
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"ok":      true,
-			"accepted": true,
-			"cmd":     cmd,
-		})
+	if envelope.NodeID != "" {
+		nodeID = envelope.NodeID
+	}
+
+	// Middleware validates (envelope rules and payload schema), authorizes the token for the node,
+	// refuses decommissioned nodes, deduplicates on messageId and records the message; unknown types
+	// are rejected or dead-lettered by policy
+	resp, err := federation.Bus.Dispatch(r.Context(), envelope.Message(nodeID, federation.Origin{
+		NodeID:   fedPayload.NodeID,
+		TenantID: fedPayload.TenantID,
+		Scopes:   fedPayload.Scopes,
+		RemoteIP: GetClientIP(r),
+	}))
+	if err != nil {
+		writeBusError(w, err)
 		return
-
-	case "telemetry":
-		// Telemetry routing already handled by RouteMessage
-		log.Printf("Telemetry received from node=%s tenant=%s", fedPayload.NodeID, fedPayload.TenantID)
-		break
-
-	case "event":
-		// Event routing already handled by RouteMessage
-		log.Printf("Event received from node=%s tenant=%s", fedPayload.NodeID, fedPayload.TenantID)
-		break
-
-	default:
-		log.Printf("Unknown message type '%s' from node=%s tenant=%s", req.Type, fedPayload.NodeID, fedPayload.TenantID)
 	}
 
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"ok":       true,
-		"nodeId":   fedPayload.NodeID,
-		"tenantId": fedPayload.TenantID,
-		"type":     req.Type,
-	})
+	if resp.Replayed {
+		w.Header().Set("Idempotent-Replay", "true")
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp.Body)
 }
 
 // Phase 14.2: List Federation Nodes Handler
@@ -1,17 +1,192 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 
+	"github.com/go-chi/chi/v5"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
 	"github.com/silentsage432/sage-gitops/onboarding/backend/internal/consent"
 )
 
+// OCT scopes for the intent approval workflow
+const (
+	scopeIntentRequest = "intent.request"
+	scopeIntentApprove = "intent.approve"
+)
+
+// writeIntentError maps intent approval errors to JSON error responses
+func writeIntentError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	code := "INTENT_STORE_ERROR"
+	switch {
+	case errors.Is(err, consent.ErrIntentNotFound):
+		status, code = http.StatusNotFound, "INTENT_NOT_FOUND"
+	case errors.Is(err, consent.ErrInvalidTransition):
+		status, code = http.StatusConflict, "INVALID_INTENT_TRANSITION"
+	case errors.Is(err, consent.ErrReasonRequired):
+		status, code = http.StatusBadRequest, "REASON_REQUIRED"
+	case errors.Is(err, consent.ErrInvalidIntent):
+		status, code = http.StatusBadRequest, "INVALID_INTENT"
+	case errors.Is(err, consent.ErrInvalidPolicy):
+		status, code = http.StatusBadRequest, "INVALID_POLICY"
+	case errors.Is(err, consent.ErrNotApprover):
+		status, code = http.StatusForbidden, "NOT_AN_APPROVER"
+	case errors.Is(err, consent.ErrAlreadyApproved):
+		status, code = http.StatusConflict, "ALREADY_APPROVED"
+	case errors.Is(err, consent.ErrChallengeNotFound):
+		status, code = http.StatusUnauthorized, "NO_APPROVAL_CHALLENGE"
+	case errors.Is(err, consent.ErrIntentChanged):
+		status, code = http.StatusConflict, "INTENT_HASH_MISMATCH"
+	default:
+		log.Printf("Intent approval error: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   code,
+		"message": err.Error(),
+	})
+}
+
 // GetPendingIntent returns all pending intent approvals
 // The API returns data. Still no action.
 func GetPendingIntent(w http.ResponseWriter, r *http.Request) {
-	approveList := consent.GetPending()
+	approveList, err := consent.GetPending(r.Context())
+	if err != nil {
+		writeIntentError(w, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(approveList)
 }
 
+// Create Intent Handler
+// Records a pending intent; nothing runs until it is approved, then it is dispatched to its target nodes
+func handleCreateIntent(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeIntentRequest)
+	if !ok {
+		return
+	}
+
+	var req consent.CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "INVALID_REQUEST",
+		})
+		return
+	}
+
+	for _, target := range req.Targets {
+		node := federation.GetNode(target.NodeID)
+		if node == nil || node.State == federation.NodeDecommissioned {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":  "INVALID_TARGET",
+				"nodeId": target.NodeID,
+			})
+			return
+		}
+	}
+
+	intent, err := consent.Create(r.Context(), req, operatorID(claims))
+	if err != nil {
+		writeIntentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":     true,
+		"intent": intent,
+	})
+}
+
+// List Intents Handler
+// Supports ?status= and ?limit= filters
+func handleListIntents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeIntentApprove); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	intents, err := consent.List(r.Context(), consent.Status(query.Get("status")), limit)
+	if err != nil {
+		writeIntentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"intents": intents,
+	})
+}
+
+// Get Intent Handler
+func handleGetIntent(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeIntentApprove); !ok {
+		return
+	}
+
+	intent, err := consent.Get(r.Context(), chi.URLParam(r, "intentId"))
+	if err != nil {
+		writeIntentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"intent": intent,
+	})
+}
+
+// intentDecision is the request body for deny and expire
+type intentDecision struct {
+	Reason string `json:"reason"`
+}
+
+// intentDecisionHandler returns a handler applying one decision to the intent in the URL
+// Approval is not a plain decision: it needs a WebAuthn assertion, see handleApproveIntent
+// The decision, operator, time and reason are recorded on the intent and in the audit log
+func intentDecisionHandler(decide func(ctx context.Context, id, operator, reason string) (*consent.IntentApproval, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := requireOperatorScope(w, r, scopeIntentApprove)
+		if !ok {
+			return
+		}
+
+		var req intentDecision
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error": "INVALID_REQUEST",
+				})
+				return
+			}
+		}
+
+		intent, err := decide(r.Context(), chi.URLParam(r, "intentId"), operatorID(claims), req.Reason)
+		if err != nil {
+			writeIntentError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":     true,
+			"intent": intent,
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/internal/consent"
+)
+
+// Intent execution through the federation command queue
+// An approved intent becomes one command per target (type = intent action, payload = intent payload
+// plus intentId), pulled by agents from /api/federation/agents/commands. Job state changes reported on
+// /api/federation/agents/jobs are linked back onto the intent's work items.
+
+// intentExecutor implements consent.Executor on the federation command queue
+type intentExecutor struct{}
+
+// Dispatch enqueues one command per target; idempotency keys make retries return the same commands
+func (intentExecutor) Dispatch(ctx context.Context, intent *consent.IntentApproval) ([]consent.WorkItem, error) {
+	items := make([]consent.WorkItem, 0, len(intent.Targets))
+	for i, target := range intent.Targets {
+		item := consent.WorkItem{Target: target}
+
+		payload := map[string]interface{}{}
+		for k, v := range intent.Payload {
+			payload[k] = v
+		}
+		payload["intentId"] = intent.ID
+
+		cmd, _, err := federation.EnqueueCommand(ctx, federation.CommandRequest{
+			NodeID:         target.NodeID,
+			AgentID:        target.AgentID,
+			Type:           intent.Action,
+			Payload:        payload,
+			IdempotencyKey: fmt.Sprintf("intent:%s:%d", intent.ID, i),
+		}, "intent:"+intent.ID)
+		if errors.Is(err, federation.ErrCommandQueueNotUp) {
+			return nil, err
+		}
+		if err != nil {
+			item.Status, item.Error = consent.WorkFailed, err.Error()
+			items = append(items, item)
+			continue
+		}
+
+		item.CommandID = cmd.ID
+		item.Status = consent.WorkPending
+		if job, err := federation.GetCommandJob(ctx, cmd.ID); err == nil {
+			item.JobID = job.ID
+			item.Status, item.Error = workStatus(job)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Status reads the job behind a work item
+func (intentExecutor) Status(ctx context.Context, item consent.WorkItem) (consent.WorkItem, error) {
+	job, err := federation.GetCommandJob(ctx, item.CommandID)
+	if err != nil {
+		return item, err
+	}
+	item.JobID = job.ID
+	item.Status, item.Error = workStatus(job)
+	return item, nil
+}
+
+// workStatus maps a job state onto a work item state
+func workStatus(job *federation.Job) (consent.WorkStatus, string) {
+	return jobWorkStatus(job.Status, job.Error)
+}
+
+func jobWorkStatus(status federation.JobStatus, errMsg string) (consent.WorkStatus, string) {
+	switch status {
+	case federation.JobRunning:
+		return consent.WorkRunning, ""
+	case federation.JobSucceeded:
+		return consent.WorkSucceeded, ""
+	case federation.JobFailed, federation.JobTimedOut, federation.JobCancelled:
+		if errMsg == "" {
+			errMsg = "job " + string(status)
+		}
+		return consent.WorkFailed, errMsg
+	}
+	return consent.WorkPending, ""
+}
+
+// followIntentJobs links job state changes on this replica back onto intent work items
+// Missed changes (a lagging subscription, another replica) are picked up by the consent sweep.
+func followIntentJobs() {
+	filter := federation.EventFilter{Types: []string{federation.EventJobState}}
+	go func() {
+		for {
+			_, sub, _ := federation.Subscribe(filter, 0, 0)
+			for e := range sub.Events() {
+				commandID, _ := e.Data["commandId"].(string)
+				to, _ := e.Data["to"].(string)
+				errMsg, _ := e.Data["error"].(string)
+				status, errMsg := jobWorkStatus(federation.JobStatus(to), errMsg)
+				if err := consent.UpdateWork(context.Background(), commandID, status, errMsg); err != nil {
+					log.Printf("Failed to link job state of command %s to its intent: %v", commandID, err)
+				}
+			}
+			log.Printf("Intent job subscription lagged, resubscribing")
+		}
+	}()
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/handlers"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/internal/consent"
+)
+
+// WebAuthn-signed intent approvals
+// Approving an intent takes a fresh WebAuthn assertion from the approving operator, on top of their OCT.
+// The login ceremony is the one used by /api/auth/verify, except that the challenge is the intent hash
+// followed by a random nonce, so the assertion can only ever count towards that exact intent.
+
+// OCT scope for managing intent approval policies
+const scopeIntentAdmin = "intent.admin"
+
+// How long an operator has to complete an approval ceremony
+const approvalChallengeTTL = 5 * time.Minute
+
+// Bytes of randomness appended to the intent hash in an approval challenge
+const approvalNonceSize = 16
+
+// writeIntentFailure writes an error response that does not come from the consent store
+func writeIntentFailure(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": code,
+	})
+}
+
+// Begin Intent Approval Handler
+// Starts a WebAuthn assertion over the intent hash for the operator holding the OCT
+func handleBeginIntentApproval(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeIntentApprove)
+	if !ok {
+		return
+	}
+	if WAuth == nil {
+		writeIntentFailure(w, http.StatusServiceUnavailable, "WEBAUTHN_UNAVAILABLE")
+		return
+	}
+
+	ctx := r.Context()
+	operator := operatorID(claims)
+	intent, err := consent.BeginApproval(ctx, chi.URLParam(r, "intentId"), operator)
+	if err != nil {
+		writeIntentError(w, err)
+		return
+	}
+
+	user, err := handlers.GetOperatorKey(ctx, getDB(ctx), operator)
+	if err != nil {
+		log.Printf("Intent approval: GetOperatorKey failed for operator %s: %v", operator, err)
+		writeIntentFailure(w, http.StatusInternalServerError, "OPERATOR_LOOKUP_FAILED")
+		return
+	}
+	if len(user.WebAuthnCredentials()) == 0 {
+		writeIntentFailure(w, http.StatusForbidden, "WEBAUTHN_NOT_REGISTERED")
+		return
+	}
+
+	hash, _ := hex.DecodeString(intent.Hash)
+	challenge := make([]byte, len(hash)+approvalNonceSize)
+	copy(challenge, hash)
+	if _, err := rand.Read(challenge[len(hash):]); err != nil {
+		writeIntentFailure(w, http.StatusInternalServerError, "CHALLENGE_GENERATION_FAILED")
+		return
+	}
+
+	options, session, err := WAuth.BeginLogin(user,
+		webauthn.WithChallenge(challenge),
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		log.Printf("Intent approval: BeginLogin failed for operator %s: %v", operator, err)
+		writeIntentFailure(w, http.StatusInternalServerError, "CHALLENGE_GENERATION_FAILED")
+		return
+	}
+	session.Expires = time.Now().Add(approvalChallengeTTL)
+
+	sessionJSON, err := json.Marshal(session)
+	if err == nil {
+		err = consent.SaveChallenge(ctx, intent.ID, operator, sessionJSON, session.Expires)
+	}
+	if err != nil {
+		writeIntentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"intent":     intent,
+		"intentHash": intent.Hash,
+		"options":    options,
+		"expiresAt":  session.Expires.UnixMilli(),
+	})
+}
+
+// intentApprovalRequest is the request body completing an approval ceremony
+type intentApprovalRequest struct {
+	Reason     string          `json:"reason"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+// Approve Intent Handler
+// Verifies the operator's WebAuthn assertion and records their approval; the intent is approved once
+// the number of distinct approvals required by its policy is reached
+func handleApproveIntent(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeIntentApprove)
+	if !ok {
+		return
+	}
+	if WAuth == nil {
+		writeIntentFailure(w, http.StatusServiceUnavailable, "WEBAUTHN_UNAVAILABLE")
+		return
+	}
+
+	var req intentApprovalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Credential) == 0 {
+		writeIntentFailure(w, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+	if req.Reason == "" {
+		writeIntentError(w, consent.ErrReasonRequired)
+		return
+	}
+
+	ctx := r.Context()
+	operator := operatorID(claims)
+	intentID := chi.URLParam(r, "intentId")
+
+	// The challenge is consumed whatever the outcome, so an assertion can never be replayed
+	sessionJSON, err := consent.TakeChallenge(ctx, intentID, operator)
+	if err != nil {
+		writeIntentError(w, err)
+		return
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(sessionJSON, &session); err != nil {
+		log.Printf("Intent approval: invalid session for intent %s operator %s: %v", intentID, operator, err)
+		writeIntentFailure(w, http.StatusInternalServerError, "INVALID_SESSION")
+		return
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(session.Challenge)
+	if err != nil || len(challenge) <= approvalNonceSize {
+		writeIntentFailure(w, http.StatusInternalServerError, "INVALID_SESSION")
+		return
+	}
+
+	user, err := handlers.GetOperatorKey(ctx, getDB(ctx), operator)
+	if err != nil {
+		log.Printf("Intent approval: GetOperatorKey failed for operator %s: %v", operator, err)
+		writeIntentFailure(w, http.StatusInternalServerError, "OPERATOR_LOOKUP_FAILED")
+		return
+	}
+
+	// FinishLogin parses the credential from the request body
+	newReq := r.Clone(ctx)
+	newReq.Body = io.NopCloser(bytes.NewReader(req.Credential))
+	newReq.ContentLength = int64(len(req.Credential))
+
+	cred, err := WAuth.FinishLogin(user, session, newReq)
+	if err != nil {
+		log.Printf("Intent approval: assertion failed for intent %s operator %s: %v", intentID, operator, err)
+		writeIntentFailure(w, http.StatusUnauthorized, "ASSERTION_FAILED")
+		return
+	}
+
+	intent, err := consent.Approve(ctx, consent.ApprovalRequest{
+		IntentID:     intentID,
+		Operator:     operator,
+		CredentialID: base64.RawURLEncoding.EncodeToString(cred.ID),
+		Hash:         hex.EncodeToString(challenge[:len(challenge)-approvalNonceSize]),
+		Reason:       req.Reason,
+	})
+	if err != nil {
+		writeIntentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":                true,
+		"intent":            intent,
+		"approvals":         len(intent.Approvals),
+		"requiredApprovals": intent.RequiredApprovals,
+	})
+}
+
+// List Intent Policies Handler
+func handleListIntentPolicies(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperatorScope(w, r, scopeIntentApprove); !ok {
+		return
+	}
+
+	policies, err := consent.ListPolicies(r.Context())
+	if err != nil {
+		writeIntentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"policies": policies,
+	})
+}
+
+// Set Intent Policy Handler
+// Proposes the M-of-N quorum for an action ("*" for the default). The change is itself an intent
+// (action intent.policy.set) that takes WebAuthn-signed approvals from the quorum of the policy it
+// replaces; the new policy applies once that intent is approved. Pending intents keep their quorum.
+func handleSetIntentPolicy(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireOperatorScope(w, r, scopeIntentAdmin)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		consent.Policy
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeIntentFailure(w, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+	req.Policy.Action = chi.URLParam(r, "action")
+
+	intent, err := consent.ProposePolicy(r.Context(), req.Policy, operatorID(claims), req.Reason)
+	if err != nil {
+		writeIntentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":     true,
+		"intent": intent,
+	})
+}
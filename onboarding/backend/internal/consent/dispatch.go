@@ -0,0 +1,357 @@
+package consent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Execution of approved intents
+// Once approved, an intent with targets is handed to the Executor, which turns it into one work item
+// per target (an agent command in the federation queue). The intent moves to dispatched and, once
+// every work item has finished, to completed if all succeeded or to failed otherwise.
+// Outcomes arrive through UpdateWork; the expiry sweep also retries dispatch and reconciles work items
+// whose updates were missed.
+
+// Executor delivers approved intents to agents and reports on the resulting work
+type Executor interface {
+	// Dispatch creates one work item per intent target. It must be idempotent: it is retried with
+	// the same intent until its items are recorded. Per-target failures are returned as failed items.
+	Dispatch(ctx context.Context, intent *IntentApproval) ([]WorkItem, error)
+	// Status returns the current state of a dispatched work item
+	Status(ctx context.Context, item WorkItem) (WorkItem, error)
+}
+
+var executor Executor
+
+// Unfinished work items not updated for this long are reconciled against the executor
+const workReconcileAfter = time.Minute
+
+// SetExecutor installs the executor for approved intents; without one, approved intents stay approved
+func SetExecutor(e Executor) {
+	executor = e
+}
+
+// normalizeTargets validates intent targets and drops duplicates
+func normalizeTargets(targets []Target) ([]Target, error) {
+	if len(targets) > MaxIntentTargets {
+		return nil, fmt.Errorf("%w: at most %d targets", ErrInvalidIntent, MaxIntentTargets)
+	}
+	normalized := []Target{}
+	seen := map[Target]bool{}
+	for _, target := range targets {
+		if target.NodeID == "" {
+			return nil, fmt.Errorf("%w: every target needs a node_id", ErrInvalidIntent)
+		}
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+		normalized = append(normalized, target)
+	}
+	return normalized, nil
+}
+
+const workItemColumns = `intent_id::text, node_id, COALESCE(agent_id, ''), COALESCE(command_id::text, ''),
+	COALESCE(job_id::text, ''), status, COALESCE(error, ''), updated_at`
+
+func scanWorkItem(row pgx.Row) (string, WorkItem, error) {
+	var intentID string
+	var item WorkItem
+	var updatedAt time.Time
+	err := row.Scan(&intentID, &item.NodeID, &item.AgentID, &item.CommandID, &item.JobID, &item.Status,
+		&item.Error, &updatedAt)
+	item.UpdatedAt = updatedAt.UnixMilli()
+	return intentID, item, err
+}
+
+// attachWorkItems loads the work items of the given intents
+func attachWorkItems(ctx context.Context, q querier, ids []string, byID map[string]*IntentApproval) error {
+	rows, err := q.Query(ctx,
+		`SELECT `+workItemColumns+` FROM public.intent_work_items
+		 WHERE intent_id = ANY($1::uuid[])
+		 ORDER BY seq`,
+		ids,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load work items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		intentID, item, err := scanWorkItem(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan work item: %w", err)
+		}
+		if intent, ok := byID[intentID]; ok {
+			intent.WorkItems = append(intent.WorkItems, item)
+		}
+	}
+	return rows.Err()
+}
+
+// dispatch hands an approved intent to the executor and records its work items
+// It returns the intent unchanged when there is nothing to dispatch.
+func dispatch(ctx context.Context, intent *IntentApproval) (*IntentApproval, error) {
+	if executor == nil || intent.Status != StatusApproved || len(intent.Targets) == 0 {
+		return intent, nil
+	}
+	items, err := executor.Dispatch(ctx, intent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dispatch intent %s: %w", intent.ID, err)
+	}
+	return recordDispatch(ctx, intent.ID, items)
+}
+
+// recordDispatch stores the work items of an approved intent and moves it to dispatched
+func recordDispatch(ctx context.Context, id string, items []WorkItem) (*IntentApproval, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	intent, err := lockIntent(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !CanTransition(intent.Status, StatusDispatched) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, intent.Status, StatusDispatched)
+	}
+
+	now := time.Now()
+	for i, item := range items {
+		if item.Status == "" {
+			item.Status = WorkPending
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO public.intent_work_items
+			 (intent_id, seq, node_id, agent_id, command_id, job_id, status, error, created_at, updated_at)
+			 VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, '')::uuid, NULLIF($6, '')::uuid, $7, NULLIF($8, ''), $9, $9)`,
+			id, i, item.NodeID, item.AgentID, item.CommandID, item.JobID, string(item.Status), item.Error, now,
+		); err != nil {
+			return nil, fmt.Errorf("failed to record work item: %w", err)
+		}
+	}
+	intent, err = setIntentStatus(ctx, tx, id, StatusDispatched, "dispatched_at = $3")
+	if err != nil {
+		return nil, err
+	}
+	if err := attachDetails(ctx, tx, intent); err != nil {
+		return nil, err
+	}
+
+	commands := make([]string, 0, len(intent.WorkItems))
+	for _, item := range intent.WorkItems {
+		if item.CommandID != "" {
+			commands = append(commands, item.CommandID)
+		}
+	}
+	if err := audit(ctx, tx, "intent_dispatched", SystemOperator, map[string]interface{}{
+		"intentId":   intent.ID,
+		"action":     intent.Action,
+		"from":       string(StatusApproved),
+		"to":         string(StatusDispatched),
+		"workItems":  len(intent.WorkItems),
+		"commandIds": commands,
+	}, now); err != nil {
+		return nil, err
+	}
+	// Items may have failed at dispatch already
+	if intent, err = settle(ctx, tx, intent, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record dispatch: %w", err)
+	}
+	return intent, nil
+}
+
+// UpdateWork records the latest state of the work item created for a command and settles its intent
+// Commands that do not belong to an intent are ignored.
+func UpdateWork(ctx context.Context, commandID string, status WorkStatus, errMsg string) error {
+	if db == nil {
+		return ErrNotInitialized
+	}
+	if _, err := uuid.Parse(commandID); err != nil {
+		return nil
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var intentID string
+	err = tx.QueryRow(ctx,
+		`SELECT intent_id::text FROM public.intent_work_items WHERE command_id = $1`,
+		commandID,
+	).Scan(&intentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load work item: %w", err)
+	}
+	// Lock the intent first so concurrent updates of its items settle it exactly once
+	intent, err := lockIntent(ctx, tx, intentID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tag, err := tx.Exec(ctx,
+		`UPDATE public.intent_work_items
+		 SET status = $2, error = NULLIF($3, ''), updated_at = $4
+		 WHERE command_id = $1 AND status NOT IN ('succeeded', 'failed') AND (status <> $2 OR COALESCE(error, '') <> $3)`,
+		commandID, string(status), errMsg, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update work item: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+	if err := attachDetails(ctx, tx, intent); err != nil {
+		return err
+	}
+	if _, err := settle(ctx, tx, intent, now); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// settle completes or fails a dispatched intent once all its work items have finished
+func settle(ctx context.Context, tx pgx.Tx, intent *IntentApproval, now time.Time) (*IntentApproval, error) {
+	if intent.Status != StatusDispatched {
+		return intent, nil
+	}
+	failed := 0
+	for _, item := range intent.WorkItems {
+		if !item.Status.Terminal() {
+			return intent, nil
+		}
+		if item.Status == WorkFailed {
+			failed++
+		}
+	}
+
+	to := StatusCompleted
+	if failed > 0 {
+		to = StatusFailed
+	}
+	settled, err := setIntentStatus(ctx, tx, intent.ID, to, "completed_at = $3")
+	if err != nil {
+		return nil, err
+	}
+	settled.Approvals = intent.Approvals
+	settled.WorkItems = intent.WorkItems
+
+	if err := audit(ctx, tx, "intent_"+string(to), SystemOperator, map[string]interface{}{
+		"intentId":  settled.ID,
+		"action":    settled.Action,
+		"from":      string(StatusDispatched),
+		"to":        string(to),
+		"workItems": len(settled.WorkItems),
+		"failed":    failed,
+	}, now); err != nil {
+		return nil, err
+	}
+	return settled, nil
+}
+
+// lockIntent loads an intent for update
+func lockIntent(ctx context.Context, tx pgx.Tx, id string) (*IntentApproval, error) {
+	intent, err := scanIntent(tx.QueryRow(ctx,
+		`SELECT `+intentColumns+` FROM public.intent_approvals WHERE id = $1 FOR UPDATE`,
+		id,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIntentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load intent: %w", err)
+	}
+	return intent, nil
+}
+
+// setIntentStatus updates a locked intent; set may reference $3 (now)
+func setIntentStatus(ctx context.Context, tx pgx.Tx, id string, to Status, set string) (*IntentApproval, error) {
+	intent, err := scanIntent(tx.QueryRow(ctx,
+		`UPDATE public.intent_approvals SET status = $2, updated_at = $3, `+set+`
+		 WHERE id = $1
+		 RETURNING `+intentColumns,
+		id, string(to), time.Now(),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update intent: %w", err)
+	}
+	return intent, nil
+}
+
+// sweepExecution retries dispatch of approved intents and reconciles unfinished work items
+func sweepExecution(ctx context.Context) error {
+	if executor == nil {
+		return nil
+	}
+
+	rows, err := db.Query(ctx,
+		`SELECT `+intentColumns+` FROM public.intent_approvals
+		 WHERE status = 'approved' AND jsonb_array_length(targets) > 0`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to find undispatched intents: %w", err)
+	}
+	approved, err := collectIntents(ctx, rows)
+	if err != nil {
+		return err
+	}
+	for i := range approved {
+		if _, err := dispatch(ctx, &approved[i]); err != nil && !errors.Is(err, ErrInvalidTransition) {
+			log.Printf("Intent dispatch retry failed: %v", err)
+		}
+	}
+
+	// Items updated recently are being kept current by UpdateWork already
+	rows, err = db.Query(ctx,
+		`SELECT `+workItemColumns+` FROM public.intent_work_items
+		 WHERE status NOT IN ('succeeded', 'failed') AND command_id IS NOT NULL AND updated_at < $1`,
+		time.Now().Add(-workReconcileAfter),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to find unfinished work items: %w", err)
+	}
+	var open []WorkItem
+	for rows.Next() {
+		_, item, err := scanWorkItem(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan work item: %w", err)
+		}
+		open = append(open, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, item := range open {
+		current, err := executor.Status(ctx, item)
+		if err != nil {
+			log.Printf("Intent work item status for command %s failed: %v", item.CommandID, err)
+			continue
+		}
+		if current.Status != item.Status || current.Error != item.Error {
+			if err := UpdateWork(ctx, item.CommandID, current.Status, current.Error); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
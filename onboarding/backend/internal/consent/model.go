@@ -1,32 +1,156 @@
 package consent
 
-// IntentApproval represents a pending, approved, or denied execution intent
-// Status is not enforced. No policy logic. No action dispatch. Just stored state.
+import (
+	"errors"
+	"time"
+)
+
+// IntentApproval represents a pending, approved, denied or expired execution intent
+// Intents are persisted in public.intent_approvals. Status only moves out of pending, once, through
+// a recorded decision (operator, time, reason). Approval needs RequiredApprovals distinct operators, each
+// signing the intent hash with a WebAuthn assertion. An approved intent with targets is then dispatched
+// as one work item per target, and its status follows their execution (dispatched, completed, failed).
 type IntentApproval struct {
-	ID          string                 `json:"id"`
-	RequestedBy string                 `json:"requested_by"`
-	Timestamp   int64                  `json:"timestamp"`
-	Action      string                 `json:"action"`
-	Status      string                 `json:"status"` // pending | approved | denied
-	Metadata    map[string]interface{} `json:"metadata"`
-}
-
-// In-memory store for intents (will be replaced with DB later)
-var intentStore []IntentApproval
-
-// GetPending returns all pending intent approvals
-func GetPending() []IntentApproval {
-	pending := []IntentApproval{}
-	for _, intent := range intentStore {
-		if intent.Status == "pending" {
-			pending = append(pending, intent)
+	ID             string                 `json:"id"`
+	RequestedBy    string                 `json:"requested_by"`
+	Timestamp      int64                  `json:"timestamp"`
+	Action         string                 `json:"action"`
+	Status         Status                 `json:"status"` // pending | approved | denied | expired | dispatched | completed | failed
+	Metadata       map[string]interface{} `json:"metadata"`
+	Reason         string                 `json:"reason,omitempty"` // why the intent was requested
+	ExpiresAt      int64                  `json:"expires_at"`
+	DecidedBy      string                 `json:"decided_by,omitempty"`
+	DecidedAt      int64                  `json:"decided_at,omitempty"`
+	DecisionReason string                 `json:"decision_reason,omitempty"`
+	// Quorum copied from the action's policy at creation: RequiredApprovals of Approvers (empty = any)
+	RequiredApprovals int         `json:"required_approvals"`
+	Approvers         []string    `json:"approvers"`
+	Approvals         []Signature `json:"approvals"`
+	Hash              string      `json:"hash"` // what approving operators sign, see Hash
+	// What runs once approved: Action with Payload, on each of Targets
+	Targets      []Target               `json:"targets"`
+	Payload      map[string]interface{} `json:"payload,omitempty"`
+	WorkItems    []WorkItem             `json:"work_items"`
+	DispatchedAt int64                  `json:"dispatched_at,omitempty"`
+	CompletedAt  int64                  `json:"completed_at,omitempty"`
+}
+
+// Target is a node, optionally a single agent on it, that an approved intent runs on
+type Target struct {
+	NodeID  string `json:"node_id"`
+	AgentID string `json:"agent_id,omitempty"`
+}
+
+// WorkStatus is the execution state of a work item
+type WorkStatus string
+
+const (
+	WorkPending   WorkStatus = "pending"
+	WorkRunning   WorkStatus = "running"
+	WorkSucceeded WorkStatus = "succeeded"
+	WorkFailed    WorkStatus = "failed"
+)
+
+// Terminal reports whether the work item has finished
+func (s WorkStatus) Terminal() bool {
+	return s == WorkSucceeded || s == WorkFailed
+}
+
+// WorkItem is the delivery of an approved intent to one target
+type WorkItem struct {
+	Target
+	CommandID string     `json:"command_id,omitempty"`
+	JobID     string     `json:"job_id,omitempty"`
+	Status    WorkStatus `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	UpdatedAt int64      `json:"updated_at"`
+}
+
+// Signature is one operator's WebAuthn-backed approval of an intent
+type Signature struct {
+	Operator     string `json:"operator"`
+	CredentialID string `json:"credential_id"`
+	Reason       string `json:"reason"`
+	SignedAt     int64  `json:"signed_at"`
+}
+
+// Policy is the approval quorum for an action: Required distinct operators, drawn from Approvers
+// when it is not empty. Policies apply to intents created after they are set.
+type Policy struct {
+	Action    string   `json:"action"`
+	Required  int      `json:"required_approvals"`
+	Approvers []string `json:"approvers"`
+	UpdatedBy string   `json:"updated_by,omitempty"`
+	UpdatedAt int64    `json:"updated_at,omitempty"`
+}
+
+// DefaultPolicyAction names the policy used for actions without their own
+const DefaultPolicyAction = "*"
+
+// PolicyChangeAction is the reserved intent action that proposes a policy (carried as its payload).
+// It needs the quorum of the policy it replaces and applies the new one once approved.
+const PolicyChangeAction = "intent.policy.set"
+
+// Status is an intent approval state
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusApproved Status = "approved"
+	StatusDenied   Status = "denied"
+	StatusExpired  Status = "expired"
+	// Execution of approved intents
+	StatusDispatched Status = "dispatched"
+	StatusCompleted  Status = "completed"
+	StatusFailed     Status = "failed"
+)
+
+// transitions lists the allowed status changes; denied, expired, completed and failed intents are final
+var transitions = map[Status][]Status{
+	StatusPending:    {StatusApproved, StatusDenied, StatusExpired},
+	StatusApproved:   {StatusDispatched},
+	StatusDispatched: {StatusCompleted, StatusFailed},
+}
+
+// CanTransition reports whether an intent may move from one status to another
+func CanTransition(from, to Status) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
 		}
 	}
-	return pending
+	return false
 }
 
-// AddIntent adds a new intent to the store (for testing/simulation)
-func AddIntent(intent IntentApproval) {
-	intentStore = append(intentStore, intent)
-}
+// Intent defaults
+const (
+	MaxIntentTargets = 100
+	DefaultIntentTTL = 24 * time.Hour
+	MaxIntentTTL     = 30 * 24 * time.Hour
+	// SystemOperator records decisions made by the backend itself (TTL expiry)
+	SystemOperator = "system"
+)
+
+// Consent errors
+var (
+	ErrIntentNotFound    = errors.New("intent not found")
+	ErrInvalidTransition = errors.New("invalid intent status transition")
+	ErrReasonRequired    = errors.New("a reason is required for this decision")
+	ErrInvalidIntent     = errors.New("invalid intent")
+	ErrNotInitialized    = errors.New("consent store not initialized")
+	ErrNotApprover       = errors.New("operator is not an approver for this action")
+	ErrAlreadyApproved   = errors.New("operator has already approved this intent")
+	ErrChallengeNotFound = errors.New("no approval challenge outstanding")
+	ErrIntentChanged     = errors.New("signed intent hash does not match the intent")
+	ErrInvalidPolicy     = errors.New("invalid approval policy")
+)
 
+// CreateRequest describes a new intent awaiting approval
+type CreateRequest struct {
+	Action     string                 `json:"action"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Reason     string                 `json:"reason"`
+	TTLSeconds int                    `json:"ttlSeconds"` // 0 = DefaultIntentTTL
+	Targets    []Target               `json:"targets"`    // none = approval only, nothing is dispatched
+	Payload    map[string]interface{} `json:"payload"`
+}
@@ -0,0 +1,407 @@
+package consent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// M-of-N quorum approvals
+// An intent is approved once RequiredApprovals distinct eligible operators have each completed a
+// WebAuthn assertion whose challenge starts with the intent hash. The WebAuthn ceremony itself runs in
+// the API layer; this file holds the hash, the policies, the outstanding challenges and the signatures.
+
+// Hash returns the hex SHA-256 of the intent content an approver signs. It covers everything that
+// defines what would run and who may approve it, so a signature cannot be moved to another intent.
+func Hash(intent *IntentApproval) string {
+	approvers := intent.Approvers
+	if approvers == nil {
+		approvers = []string{}
+	}
+	// encoding/json sorts map keys, which keeps the encoding canonical
+	content, _ := json.Marshal(map[string]interface{}{
+		"id":                 intent.ID,
+		"action":             intent.Action,
+		"requested_by":       intent.RequestedBy,
+		"metadata":           intent.Metadata,
+		"reason":             intent.Reason,
+		"timestamp":          intent.Timestamp,
+		"expires_at":         intent.ExpiresAt,
+		"required_approvals": intent.RequiredApprovals,
+		"approvers":          approvers,
+		"targets":            intent.Targets,
+		"payload":            intent.Payload,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// mayApprove reports whether an operator is eligible to approve an intent
+func mayApprove(intent *IntentApproval, operator string) bool {
+	if len(intent.Approvers) == 0 {
+		return true
+	}
+	for _, approver := range intent.Approvers {
+		if approver == operator {
+			return true
+		}
+	}
+	return false
+}
+
+// hasApproved reports whether an operator has already signed an intent
+func hasApproved(intent *IntentApproval, operator string) bool {
+	for _, sig := range intent.Approvals {
+		if sig.Operator == operator {
+			return true
+		}
+	}
+	return false
+}
+
+// checkApprovable returns why an operator may not approve an intent now (nil when allowed)
+func checkApprovable(intent *IntentApproval, operator string, now time.Time) error {
+	if intent.Status != StatusPending {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, intent.Status, StatusApproved)
+	}
+	if !now.Before(time.UnixMilli(intent.ExpiresAt)) {
+		return fmt.Errorf("%w: intent expired", ErrInvalidTransition)
+	}
+	if !mayApprove(intent, operator) {
+		return ErrNotApprover
+	}
+	if hasApproved(intent, operator) {
+		return ErrAlreadyApproved
+	}
+	return nil
+}
+
+// BeginApproval checks that an operator may approve an intent and returns it for signing
+func BeginApproval(ctx context.Context, id, operator string) (*IntentApproval, error) {
+	intent, err := Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkApprovable(intent, operator, time.Now()); err != nil {
+		return nil, err
+	}
+	return intent, nil
+}
+
+// SaveChallenge stores the WebAuthn session of an approval ceremony, replacing any earlier one
+func SaveChallenge(ctx context.Context, id, operator string, session []byte, expires time.Time) error {
+	if db == nil {
+		return ErrNotInitialized
+	}
+	_, err := db.Exec(ctx,
+		`INSERT INTO public.intent_approval_challenges (intent_id, operator, session_data, created_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (intent_id, operator) DO UPDATE
+		 SET session_data = EXCLUDED.session_data, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at`,
+		id, operator, session, time.Now(), expires,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save approval challenge: %w", err)
+	}
+	return nil
+}
+
+// TakeChallenge consumes the outstanding approval ceremony of an operator; challenges are single use
+func TakeChallenge(ctx context.Context, id, operator string) ([]byte, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrIntentNotFound
+	}
+	var session []byte
+	var live bool
+	err := db.QueryRow(ctx,
+		`DELETE FROM public.intent_approval_challenges
+		 WHERE intent_id = $1 AND operator = $2
+		 RETURNING session_data, expires_at > $3`,
+		id, operator, time.Now(),
+	).Scan(&session, &live)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !live) {
+		return nil, ErrChallengeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load approval challenge: %w", err)
+	}
+	return session, nil
+}
+
+// ApprovalRequest is a verified operator approval of an intent
+type ApprovalRequest struct {
+	IntentID     string
+	Operator     string
+	CredentialID string
+	Hash         string // intent hash carried in the verified WebAuthn challenge
+	Reason       string
+}
+
+// Approve records a WebAuthn-verified approval; the intent flips to approved once the quorum is met
+func Approve(ctx context.Context, req ApprovalRequest) (*IntentApproval, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	if req.Reason == "" {
+		return nil, ErrReasonRequired
+	}
+	if _, err := uuid.Parse(req.IntentID); err != nil {
+		return nil, ErrIntentNotFound
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	intent, err := scanIntent(tx.QueryRow(ctx,
+		`SELECT `+intentColumns+` FROM public.intent_approvals WHERE id = $1 FOR UPDATE`,
+		req.IntentID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIntentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load intent: %w", err)
+	}
+	if err := attachDetails(ctx, tx, intent); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := checkApprovable(intent, req.Operator, now); err != nil {
+		return nil, err
+	}
+	if req.Hash != intent.Hash {
+		return nil, ErrIntentChanged
+	}
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO public.intent_approval_signatures (intent_id, operator, credential_id, intent_hash, reason, signed_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		intent.ID, req.Operator, req.CredentialID, req.Hash, req.Reason, now,
+	); err != nil {
+		return nil, fmt.Errorf("failed to record approval: %w", err)
+	}
+	intent.Approvals = append(intent.Approvals, Signature{
+		Operator:     req.Operator,
+		CredentialID: req.CredentialID,
+		Reason:       req.Reason,
+		SignedAt:     now.UnixMilli(),
+	})
+	if err := audit(ctx, tx, "intent_approval_signed", req.Operator, map[string]interface{}{
+		"intentId":     intent.ID,
+		"action":       intent.Action,
+		"hash":         req.Hash,
+		"credentialId": req.CredentialID,
+		"approvals":    len(intent.Approvals),
+		"required":     intent.RequiredApprovals,
+		"reason":       req.Reason,
+	}, now); err != nil {
+		return nil, err
+	}
+
+	if len(intent.Approvals) >= intent.RequiredApprovals {
+		approvals := intent.Approvals
+		intent, err = scanIntent(tx.QueryRow(ctx,
+			`UPDATE public.intent_approvals
+			 SET status = 'approved', decided_by = $2, decided_at = $3, decision_reason = $4, updated_at = $3
+			 WHERE id = $1
+			 RETURNING `+intentColumns,
+			intent.ID, req.Operator, now, req.Reason,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to record decision: %w", err)
+		}
+		intent.Approvals = approvals
+
+		operators := make([]string, 0, len(approvals))
+		for _, sig := range approvals {
+			operators = append(operators, sig.Operator)
+		}
+		if err := audit(ctx, tx, "intent_approved", req.Operator, map[string]interface{}{
+			"intentId":  intent.ID,
+			"action":    intent.Action,
+			"from":      string(StatusPending),
+			"to":        string(StatusApproved),
+			"hash":      intent.Hash,
+			"approvers": operators,
+			"reason":    req.Reason,
+		}, now); err != nil {
+			return nil, err
+		}
+		if intent.Action == PolicyChangeAction {
+			if err := applyPolicyChange(ctx, tx, intent, operators, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record approval: %w", err)
+	}
+
+	// Deliver the approved intent right away; the sweep retries if this fails
+	dispatched, err := dispatch(ctx, intent)
+	if err != nil {
+		log.Printf("Intent %s approved but not dispatched yet: %v", intent.ID, err)
+		return intent, nil
+	}
+	return dispatched, nil
+}
+
+// policyFor returns the policy for an action: its own, else the default, else one approval by anyone
+func policyFor(ctx context.Context, q querier, action string) (*Policy, error) {
+	var policy Policy
+	var updatedAt time.Time
+	err := q.QueryRow(ctx,
+		`SELECT action, required_approvals, approvers, updated_by, updated_at
+		 FROM public.intent_approval_policies
+		 WHERE action = $1 OR action = $2
+		 ORDER BY action = $1 DESC
+		 LIMIT 1`,
+		action, DefaultPolicyAction,
+	).Scan(&policy.Action, &policy.Required, &policy.Approvers, &policy.UpdatedBy, &updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &Policy{Action: DefaultPolicyAction, Required: 1, Approvers: []string{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load approval policy: %w", err)
+	}
+	policy.UpdatedAt = updatedAt.UnixMilli()
+	return &policy, nil
+}
+
+// GetPolicy returns the policy that applies to new intents for an action
+func GetPolicy(ctx context.Context, action string) (*Policy, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	return policyFor(ctx, db, action)
+}
+
+// ListPolicies returns all configured approval policies
+func ListPolicies(ctx context.Context) ([]Policy, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	rows, err := db.Query(ctx,
+		`SELECT action, required_approvals, approvers, updated_by, updated_at
+		 FROM public.intent_approval_policies
+		 ORDER BY action`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list approval policies: %w", err)
+	}
+	defer rows.Close()
+
+	policies := []Policy{}
+	for rows.Next() {
+		var policy Policy
+		var updatedAt time.Time
+		if err := rows.Scan(&policy.Action, &policy.Required, &policy.Approvers, &policy.UpdatedBy, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan approval policy: %w", err)
+		}
+		policy.UpdatedAt = updatedAt.UnixMilli()
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// normalizePolicy validates a policy and drops empty and duplicate approvers
+func normalizePolicy(policy Policy) (Policy, error) {
+	if policy.Action == "" || len(policy.Action) > 255 {
+		return Policy{}, fmt.Errorf("%w: action is required (max 255 characters)", ErrInvalidPolicy)
+	}
+	if policy.Action == PolicyChangeAction {
+		return Policy{}, fmt.Errorf("%w: changes to %s follow the default policy", ErrInvalidPolicy, PolicyChangeAction)
+	}
+	if policy.Required < 1 {
+		return Policy{}, fmt.Errorf("%w: required_approvals must be at least 1", ErrInvalidPolicy)
+	}
+	approvers := []string{}
+	seen := map[string]bool{}
+	for _, approver := range policy.Approvers {
+		if approver == "" || seen[approver] {
+			continue
+		}
+		seen[approver] = true
+		approvers = append(approvers, approver)
+	}
+	if len(approvers) > 0 && len(approvers) < policy.Required {
+		return Policy{}, fmt.Errorf("%w: required_approvals (%d) exceeds the number of approvers (%d)",
+			ErrInvalidPolicy, policy.Required, len(approvers))
+	}
+	return Policy{Action: policy.Action, Required: policy.Required, Approvers: approvers}, nil
+}
+
+// ProposePolicy creates the intent that changes the policy of an action. It is approved like any other
+// intent, by the quorum of the policy currently in force for that action, and the new policy applies
+// once it is.
+func ProposePolicy(ctx context.Context, policy Policy, operator, reason string) (*IntentApproval, error) {
+	policy, err := normalizePolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	return create(ctx, CreateRequest{
+		Action:   PolicyChangeAction,
+		Metadata: map[string]interface{}{"policyAction": policy.Action},
+		Reason:   reason,
+		Payload: map[string]interface{}{
+			"action":             policy.Action,
+			"required_approvals": policy.Required,
+			"approvers":          policy.Approvers,
+		},
+	}, operator, policy.Action)
+}
+
+// policyFromIntent returns the policy proposed by an approved policy change intent
+func policyFromIntent(intent *IntentApproval) (Policy, error) {
+	var policy Policy
+	content, err := json.Marshal(intent.Payload)
+	if err == nil {
+		err = json.Unmarshal(content, &policy)
+	}
+	if err != nil {
+		return Policy{}, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	return normalizePolicy(policy)
+}
+
+// applyPolicyChange creates or replaces the policy proposed by an approved intent and audits the change
+// Pending intents keep the quorum they were created with.
+func applyPolicyChange(ctx context.Context, tx pgx.Tx, intent *IntentApproval, approvers []string, now time.Time) error {
+	policy, err := policyFromIntent(intent)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO public.intent_approval_policies (action, required_approvals, approvers, updated_by, updated_at)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (action) DO UPDATE
+		 SET required_approvals = EXCLUDED.required_approvals, approvers = EXCLUDED.approvers,
+		     updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at`,
+		policy.Action, policy.Required, policy.Approvers, intent.RequestedBy, now,
+	); err != nil {
+		return fmt.Errorf("failed to save approval policy: %w", err)
+	}
+	return audit(ctx, tx, "intent_policy_updated", intent.RequestedBy, map[string]interface{}{
+		"action":            policy.Action,
+		"requiredApprovals": policy.Required,
+		"approvers":         policy.Approvers,
+		"intentId":          intent.ID,
+		"approvedBy":        approvers,
+	}, now)
+}
@@ -0,0 +1,108 @@
+package consent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckApprovable(t *testing.T) {
+	now := time.Now()
+	pending := func(approvers []string, signed ...string) *IntentApproval {
+		intent := &IntentApproval{Status: StatusPending, ExpiresAt: now.Add(time.Hour).UnixMilli(), Approvers: approvers}
+		for _, operator := range signed {
+			intent.Approvals = append(intent.Approvals, Signature{Operator: operator})
+		}
+		return intent
+	}
+
+	tests := []struct {
+		name     string
+		intent   *IntentApproval
+		operator string
+		want     error
+	}{
+		{"anyone may approve", pending(nil), "alice", nil},
+		{"listed approver", pending([]string{"alice", "bob"}), "bob", nil},
+		{"second distinct approver", pending([]string{"alice", "bob"}, "alice"), "bob", nil},
+		{"not an approver", pending([]string{"alice", "bob"}), "mallory", ErrNotApprover},
+		{"same operator twice", pending(nil, "alice"), "alice", ErrAlreadyApproved},
+		{"already approved", &IntentApproval{Status: StatusApproved, ExpiresAt: now.Add(time.Hour).UnixMilli()}, "alice", ErrInvalidTransition},
+		{"expired", &IntentApproval{Status: StatusPending, ExpiresAt: now.UnixMilli()}, "alice", ErrInvalidTransition},
+	}
+	for _, tt := range tests {
+		if err := checkApprovable(tt.intent, tt.operator, now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: checkApprovable() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		want    Policy
+		wantErr bool
+	}{
+		{"any operator", Policy{Action: "deploy", Required: 2}, Policy{Action: "deploy", Required: 2, Approvers: []string{}}, false},
+		{"approvers deduplicated", Policy{Action: "*", Required: 2, Approvers: []string{"alice", "", "bob", "alice"}},
+			Policy{Action: "*", Required: 2, Approvers: []string{"alice", "bob"}}, false},
+		{"no action", Policy{Required: 1}, Policy{}, true},
+		{"reserved action", Policy{Action: PolicyChangeAction, Required: 1}, Policy{}, true},
+		{"no approvals", Policy{Action: "deploy"}, Policy{}, true},
+		{"more approvals than approvers", Policy{Action: "deploy", Required: 3, Approvers: []string{"alice", "bob", "bob"}}, Policy{}, true},
+	}
+	for _, tt := range tests {
+		got, err := normalizePolicy(tt.policy)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: normalizePolicy() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("%s: normalizePolicy() error = %v, want ErrInvalidPolicy", tt.name, err)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: normalizePolicy() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyFromIntent(t *testing.T) {
+	intent := &IntentApproval{Action: PolicyChangeAction, Payload: map[string]interface{}{
+		"action":             "deploy",
+		"required_approvals": float64(2),
+		"approvers":          []interface{}{"alice", "bob", "carol"},
+	}}
+	got, err := policyFromIntent(intent)
+	want := Policy{Action: "deploy", Required: 2, Approvers: []string{"alice", "bob", "carol"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("policyFromIntent() = %+v, %v; want %+v", got, err, want)
+	}
+
+	intent.Payload["required_approvals"] = float64(0)
+	if _, err := policyFromIntent(intent); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("policyFromIntent(no approvals) = %v, want ErrInvalidPolicy", err)
+	}
+}
+
+func TestCreateRejectsPolicyChangeAction(t *testing.T) {
+	// Policy changes are only created through ProposePolicy, with the quorum of the policy they replace
+	_, err := Create(context.Background(), CreateRequest{Action: PolicyChangeAction}, "alice")
+	if !errors.Is(err, ErrInvalidIntent) {
+		t.Fatalf("Create(%s) = %v, want ErrInvalidIntent", PolicyChangeAction, err)
+	}
+}
+
+func TestHashCoversQuorum(t *testing.T) {
+	intent := &IntentApproval{ID: "i-1", Action: "deploy", RequiredApprovals: 2, Approvers: []string{"alice", "bob"}}
+	base := Hash(intent)
+	if Hash(&IntentApproval{ID: "i-1", Action: "deploy", RequiredApprovals: 2, Approvers: []string{"alice", "bob"}}) != base {
+		t.Fatal("Hash() is not deterministic")
+	}
+	intent.RequiredApprovals = 1
+	if Hash(intent) == base {
+		t.Fatal("Hash() does not cover the required approvals")
+	}
+}
@@ -0,0 +1,453 @@
+package consent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Intent approval store backed by Postgres
+// Every decision is written to public.audit_log in the same transaction as the status change.
+
+const expirySweepInterval = 30 * time.Second
+
+var db *pgxpool.Pool
+
+// Init attaches the store to Postgres and starts expiring intents past their TTL and following the
+// execution of approved ones; install the Executor with SetExecutor first
+func Init(ctx context.Context, pool *pgxpool.Pool) error {
+	db = pool
+	if _, err := ExpireOverdue(ctx, time.Now()); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(expirySweepInterval)
+		for range ticker.C {
+			if _, err := ExpireOverdue(context.Background(), time.Now()); err != nil {
+				log.Printf("Intent expiry sweep failed: %v", err)
+			}
+			if err := sweepExecution(context.Background()); err != nil {
+				log.Printf("Intent execution sweep failed: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+const intentColumns = `id, requested_by, created_at, action, status, metadata, COALESCE(reason, ''), expires_at,
+	COALESCE(decided_by, ''), decided_at, COALESCE(decision_reason, ''), required_approvals, approvers, targets, payload,
+	dispatched_at, completed_at`
+
+// querier is satisfied by both the pool and a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func scanIntent(row pgx.Row) (*IntentApproval, error) {
+	var intent IntentApproval
+	var createdAt, expiresAt time.Time
+	var decidedAt, dispatchedAt, completedAt *time.Time
+	var metadata, targets, payload []byte
+	if err := row.Scan(&intent.ID, &intent.RequestedBy, &createdAt, &intent.Action, &intent.Status, &metadata,
+		&intent.Reason, &expiresAt, &intent.DecidedBy, &decidedAt, &intent.DecisionReason,
+		&intent.RequiredApprovals, &intent.Approvers, &targets, &payload, &dispatchedAt, &completedAt); err != nil {
+		return nil, err
+	}
+	intent.Timestamp = createdAt.UnixMilli()
+	intent.ExpiresAt = expiresAt.UnixMilli()
+	if decidedAt != nil {
+		intent.DecidedAt = decidedAt.UnixMilli()
+	}
+	if dispatchedAt != nil {
+		intent.DispatchedAt = dispatchedAt.UnixMilli()
+	}
+	if completedAt != nil {
+		intent.CompletedAt = completedAt.UnixMilli()
+	}
+	intent.Metadata = map[string]interface{}{}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &intent.Metadata); err != nil {
+			log.Printf("Failed to unmarshal metadata for intent %s: %v", intent.ID, err)
+		}
+	}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &intent.Payload); err != nil {
+			log.Printf("Failed to unmarshal payload for intent %s: %v", intent.ID, err)
+		}
+	}
+	intent.Targets = []Target{}
+	if len(targets) > 0 {
+		if err := json.Unmarshal(targets, &intent.Targets); err != nil {
+			log.Printf("Failed to unmarshal targets for intent %s: %v", intent.ID, err)
+		}
+	}
+	if intent.Approvers == nil {
+		intent.Approvers = []string{}
+	}
+	intent.Hash = Hash(&intent)
+	intent.Approvals = []Signature{}
+	intent.WorkItems = []WorkItem{}
+	return &intent, nil
+}
+
+// attachDetails loads the signed approvals and work items of the given intents
+func attachDetails(ctx context.Context, q querier, intents ...*IntentApproval) error {
+	if len(intents) == 0 {
+		return nil
+	}
+	byID := make(map[string]*IntentApproval, len(intents))
+	ids := make([]string, 0, len(intents))
+	for _, intent := range intents {
+		byID[intent.ID] = intent
+		ids = append(ids, intent.ID)
+	}
+	if err := attachApprovals(ctx, q, ids, byID); err != nil {
+		return err
+	}
+	return attachWorkItems(ctx, q, ids, byID)
+}
+
+// attachApprovals loads the signed approvals of the given intents
+func attachApprovals(ctx context.Context, q querier, ids []string, byID map[string]*IntentApproval) error {
+	rows, err := q.Query(ctx,
+		`SELECT intent_id::text, operator, credential_id, reason, signed_at
+		 FROM public.intent_approval_signatures
+		 WHERE intent_id = ANY($1::uuid[])
+		 ORDER BY signed_at`,
+		ids,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load approvals: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var intentID string
+		var sig Signature
+		var signedAt time.Time
+		if err := rows.Scan(&intentID, &sig.Operator, &sig.CredentialID, &sig.Reason, &signedAt); err != nil {
+			return fmt.Errorf("failed to scan approval: %w", err)
+		}
+		sig.SignedAt = signedAt.UnixMilli()
+		if intent, ok := byID[intentID]; ok {
+			intent.Approvals = append(intent.Approvals, sig)
+		}
+	}
+	return rows.Err()
+}
+
+// Create stores a new pending intent requested by an operator
+func Create(ctx context.Context, req CreateRequest, requestedBy string) (*IntentApproval, error) {
+	if req.Action == PolicyChangeAction {
+		return nil, fmt.Errorf("%w: action %s is reserved for policy changes", ErrInvalidIntent, PolicyChangeAction)
+	}
+	return create(ctx, req, requestedBy, req.Action)
+}
+
+// create stores a new pending intent whose quorum is the policy of quorumAction
+func create(ctx context.Context, req CreateRequest, requestedBy, quorumAction string) (*IntentApproval, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	if req.Action == "" || len(req.Action) > 255 {
+		return nil, fmt.Errorf("%w: action is required (max 255 characters)", ErrInvalidIntent)
+	}
+	ttl := DefaultIntentTTL
+	if req.TTLSeconds < 0 {
+		return nil, fmt.Errorf("%w: ttlSeconds must be positive", ErrInvalidIntent)
+	}
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl > MaxIntentTTL {
+		return nil, fmt.Errorf("%w: ttlSeconds exceeds maximum of %d", ErrInvalidIntent, int(MaxIntentTTL.Seconds()))
+	}
+	metadata := req.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIntent, err)
+	}
+	targets, err := normalizeTargets(req.Targets)
+	if err != nil {
+		return nil, err
+	}
+	targetsJSON, _ := json.Marshal(targets)
+	var payloadJSON []byte
+	if req.Payload != nil {
+		if payloadJSON, err = json.Marshal(req.Payload); err != nil {
+			return nil, fmt.Errorf("%w: invalid payload: %v", ErrInvalidIntent, err)
+		}
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	policy, err := policyFor(ctx, tx, quorumAction)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	intent, err := scanIntent(tx.QueryRow(ctx,
+		`INSERT INTO public.intent_approvals (requested_by, action, status, metadata, reason, created_at, expires_at, updated_at,
+		 	required_approvals, approvers, targets, payload)
+		 VALUES ($1, $2, 'pending', $3, NULLIF($4, ''), $5, $6, $5, $7, $8, $9, $10)
+		 RETURNING `+intentColumns,
+		requestedBy, req.Action, metadataJSON, req.Reason, now, now.Add(ttl), policy.Required, policy.Approvers,
+		targetsJSON, payloadJSON,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create intent: %w", err)
+	}
+	if err := audit(ctx, tx, "intent_created", requestedBy, map[string]interface{}{
+		"intentId":          intent.ID,
+		"action":            intent.Action,
+		"reason":            intent.Reason,
+		"requiredApprovals": intent.RequiredApprovals,
+		"approvers":         intent.Approvers,
+		"targets":           intent.Targets,
+		"hash":              intent.Hash,
+	}, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create intent: %w", err)
+	}
+	return intent, nil
+}
+
+// Get returns an intent by ID
+func Get(ctx context.Context, id string) (*IntentApproval, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrIntentNotFound
+	}
+	intent, err := scanIntent(db.QueryRow(ctx,
+		`SELECT `+intentColumns+` FROM public.intent_approvals WHERE id = $1`,
+		id,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIntentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := attachDetails(ctx, db, intent); err != nil {
+		return nil, err
+	}
+	return intent, nil
+}
+
+// List returns intents newest first, optionally filtered by status
+func List(ctx context.Context, status Status, limit int) ([]IntentApproval, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := db.Query(ctx,
+		`SELECT `+intentColumns+` FROM public.intent_approvals
+		 WHERE ($1 = '' OR status = $1)
+		 ORDER BY created_at DESC
+		 LIMIT $2`,
+		string(status), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list intents: %w", err)
+	}
+
+	return collectIntents(ctx, rows)
+}
+
+// GetPending returns all pending intent approvals that have not yet expired
+func GetPending(ctx context.Context) ([]IntentApproval, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	rows, err := db.Query(ctx,
+		`SELECT `+intentColumns+` FROM public.intent_approvals
+		 WHERE status = 'pending' AND expires_at > $1
+		 ORDER BY created_at`,
+		time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending intents: %w", err)
+	}
+
+	return collectIntents(ctx, rows)
+}
+
+// collectIntents scans intent rows and attaches their approvals
+func collectIntents(ctx context.Context, rows pgx.Rows) ([]IntentApproval, error) {
+	defer rows.Close()
+
+	var scanned []*IntentApproval
+	for rows.Next() {
+		intent, err := scanIntent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan intent: %w", err)
+		}
+		scanned = append(scanned, intent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := attachDetails(ctx, db, scanned...); err != nil {
+		return nil, err
+	}
+
+	intents := make([]IntentApproval, 0, len(scanned))
+	for _, intent := range scanned {
+		intents = append(intents, *intent)
+	}
+	return intents, nil
+}
+
+// Deny denies a pending intent
+func Deny(ctx context.Context, id, operator, reason string) (*IntentApproval, error) {
+	return decide(ctx, id, StatusDenied, operator, reason)
+}
+
+// Expire withdraws a pending intent before its TTL elapses
+func Expire(ctx context.Context, id, operator, reason string) (*IntentApproval, error) {
+	if reason == "" {
+		reason = "expired by operator"
+	}
+	return decide(ctx, id, StatusExpired, operator, reason)
+}
+
+// decide records a deny or expire decision on a pending intent and audits it
+// Approval goes through Approve, which enforces the signed quorum
+func decide(ctx context.Context, id string, to Status, operator, reason string) (*IntentApproval, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	if reason == "" {
+		return nil, ErrReasonRequired
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrIntentNotFound
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	current, err := scanIntent(tx.QueryRow(ctx,
+		`SELECT `+intentColumns+` FROM public.intent_approvals WHERE id = $1 FOR UPDATE`,
+		id,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIntentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load intent: %w", err)
+	}
+
+	now := time.Now()
+	// A pending intent past its TTL can only expire, whatever the sweeper has not caught up with yet
+	if current.Status == StatusPending && to != StatusExpired && !now.Before(time.UnixMilli(current.ExpiresAt)) {
+		return nil, fmt.Errorf("%w: intent expired", ErrInvalidTransition)
+	}
+	if !CanTransition(current.Status, to) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, current.Status, to)
+	}
+
+	intent, err := scanIntent(tx.QueryRow(ctx,
+		`UPDATE public.intent_approvals
+		 SET status = $2, decided_by = $3, decided_at = $4, decision_reason = $5, updated_at = $4
+		 WHERE id = $1
+		 RETURNING `+intentColumns,
+		id, string(to), operator, now, reason,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record decision: %w", err)
+	}
+	if err := attachDetails(ctx, tx, intent); err != nil {
+		return nil, err
+	}
+	if err := audit(ctx, tx, "intent_"+string(to), operator, map[string]interface{}{
+		"intentId": intent.ID,
+		"action":   intent.Action,
+		"from":     string(current.Status),
+		"to":       string(to),
+		"reason":   reason,
+	}, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record decision: %w", err)
+	}
+	return intent, nil
+}
+
+// ExpireOverdue expires pending intents past their TTL and returns how many were expired
+// Abandoned approval challenges are purged on the same sweep
+func ExpireOverdue(ctx context.Context, now time.Time) (int, error) {
+	if db == nil {
+		return 0, ErrNotInitialized
+	}
+	rows, err := db.Query(ctx,
+		`SELECT id FROM public.intent_approvals WHERE status = 'pending' AND expires_at <= $1`,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find overdue intents: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, fmt.Errorf("failed to find overdue intents: %w", err)
+	}
+	if _, err := db.Exec(ctx, `DELETE FROM public.intent_approval_challenges WHERE expires_at <= $1`, now); err != nil {
+		return 0, fmt.Errorf("failed to purge approval challenges: %w", err)
+	}
+
+	expired := 0
+	for _, id := range ids {
+		_, err := decide(ctx, id, StatusExpired, SystemOperator, "approval window elapsed")
+		if errors.Is(err, ErrInvalidTransition) {
+			continue // decided concurrently
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// audit writes an intent event to public.audit_log
+func audit(ctx context.Context, tx pgx.Tx, eventType, userID string, details map[string]interface{}, at time.Time) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO public.audit_log (event_type, user_id, details, created_at) VALUES ($1, $2, $3, $4)",
+		eventType, userID, string(detailsJSON), at,
+	); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
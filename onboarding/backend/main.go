@@ -5,14 +5,23 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/internal/consent"
+	fedmw "github.com/silentsage432/sage-gitops/onboarding/backend/middleware"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/tracing"
 )
 
 var (
@@ -26,6 +35,13 @@ func main() {
 
 	ctx := context.Background()
 
+	// Start tracing (OTLP collector, trace file or stdout)
+	shutdownTracing, err := tracing.Init(ctx)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize database connection
 	dbURL := os.Getenv("DATABASE_URL")
 	if dbURL == "" {
@@ -34,8 +50,13 @@ func main() {
 		dbURL = "postgres://silentsage@localhost:5432/sage_os?search_path=public"
 	}
 
-	var err error
-	dbPool, err = pgxpool.New(ctx, dbURL)
+	dbConfig, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		log.Fatalf("Failed to parse database URL: %v", err)
+	}
+	dbConfig.ConnConfig.Tracer = fedmw.NewPoolTracer("default")
+
+	dbPool, err = pgxpool.NewWithConfig(ctx, dbConfig)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -61,6 +82,52 @@ func main() {
 	// Cursor Patch: Initialize WebAuthn for new registration endpoints
 	InitWebAuthn()
 
+	// Load federation signing keyring (persistent, rotatable)
+	if err := initFederationKeyring(ctx, dbPool); err != nil {
+		log.Fatalf("Failed to initialize federation keyring: %v", err)
+	}
+
+	// Select the federation handshake challenge store
+	initChallengeStore(dbPool)
+
+	// Load federation revocation list (refreshed in the background)
+	if err := federation.InitRevocations(ctx, dbPool, revocationRefreshInterval()); err != nil {
+		log.Fatalf("Failed to load federation revocation list: %v", err)
+	}
+
+	// Load persisted federation nodes into the registry cache
+	if err := federation.InitNodeRegistry(ctx, dbPool); err != nil {
+		log.Fatalf("Failed to load federation node registry: %v", err)
+	}
+
+	// Persist federation events (daily partitions, retention enforced in the background)
+	if err := federation.InitEventLog(ctx, dbPool, eventRetentionDays()); err != nil {
+		log.Fatalf("Failed to initialize federation event log: %v", err)
+	}
+
+	// Start the federation agent command queue
+	if err := federation.InitCommandQueue(ctx, dbPool); err != nil {
+		log.Fatalf("Failed to initialize federation command queue: %v", err)
+	}
+
+	// Start the federation telemetry store
+	if err := federation.InitTelemetryStore(ctx, dbPool); err != nil {
+		log.Fatalf("Failed to initialize federation telemetry store: %v", err)
+	}
+
+	// Register federation bus handlers (unknown message types rejected or dead-lettered by policy)
+	if err := federation.InitBus(dbPool, busUnknownTypePolicy(), auditBusRejection); err != nil {
+		log.Fatalf("Failed to initialize federation bus: %v", err)
+	}
+
+	// Load intent approvals and start expiring intents past their TTL
+	// Approved intents are executed through the federation command queue
+	consent.SetExecutor(intentExecutor{})
+	followIntentJobs()
+	if err := consent.Init(ctx, dbPool); err != nil {
+		log.Fatalf("Failed to initialize intent approvals: %v", err)
+	}
+
 	// Generate or load RSA private key for JWT signing
 	keyBytes := os.Getenv("JWT_PRIVATE_KEY")
 	if keyBytes == "" {
@@ -95,8 +162,26 @@ func main() {
 		port = "8081"
 	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	// Stop on SIGINT/SIGTERM: finish in-flight requests, then flush queued telemetry
+	stopCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+	<-stopCtx.Done()
+
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown: %v", err)
+	}
+	if err := federation.CloseTelemetryStore(shutdownCtx); err != nil {
+		log.Printf("Warning: %v", err)
 	}
 }
@@ -0,0 +1,24 @@
+package metrics
+
+import "testing"
+
+func TestMethodLabel(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{"GET", "GET"},
+		{"POST", "POST"},
+		{"DELETE", "DELETE"},
+		{"OPTIONS", "OPTIONS"},
+		{"get", "OTHER"},
+		{"PROPFIND", "OTHER"},
+		{"X-RANDOM-1234", "OTHER"},
+		{"", "OTHER"},
+	}
+	for _, tt := range tests {
+		if got := methodLabel(tt.method); got != tt.want {
+			t.Errorf("methodLabel(%q) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics for the onboarding backend, served on GET /metrics
+// Packages record through the functions below; nothing here imports the rest of the backend, so the
+// federation, bootstrap and middleware packages can all depend on it.
+
+const namespace = "sage"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by chi route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by chi route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	handshakes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation",
+		Name:      "handshakes_total",
+		Help:      "Federation handshake attempts by stage (challenge, assert, join) and outcome (ok or error code).",
+	}, []string{"stage", "outcome"})
+
+	busMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation",
+		Name:      "bus_messages_total",
+		Help:      "Federation bus messages by type and outcome (ok, replayed, error); unregistered types count as \"unknown\".",
+	}, []string{"type", "outcome"})
+
+	busDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "federation",
+		Name:      "bus_message_duration_seconds",
+		Help:      "Federation bus dispatch latency by message type.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type"})
+
+	telemetryDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation",
+		Name:      "telemetry_dropped_samples_total",
+		Help:      "Accepted telemetry samples discarded after repeated failed writes to Postgres.",
+	})
+
+	routerPoolCache = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "federation_router",
+		Name:      "pool_cache_size",
+		Help:      "Node database pools cached by the FederationRouter.",
+	})
+
+	routerOpenBreakers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "federation_router",
+		Name:      "open_breakers",
+		Help:      "Node databases whose circuit breaker is open (failing health probes).",
+	})
+
+	routerFallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation_router",
+		Name:      "default_fallbacks_total",
+		Help:      "Tenant requests served from the default database instead of a node database, by reason.",
+	}, []string{"reason"})
+
+	residencyRefusals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation_router",
+		Name:      "residency_refusals_total",
+		Help:      "Strict-residency tenant requests refused (503) when no node database could serve them, by reason.",
+	}, []string{"reason"})
+
+	routerReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation_router",
+		Name:      "reads_total",
+		Help:      "Read-only tenant requests by the database that served them (replica, primary).",
+	}, []string{"target"})
+
+	migrationMirrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "federation_router",
+		Name:      "migration_mirrored_writes_total",
+		Help:      "Committed tenant transactions copied to the target node of a live tenant migration, by outcome (ok, error).",
+	}, []string{"outcome"})
+
+	kitGenerations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "bootstrap",
+		Name:      "kit_generations_total",
+		Help:      "Bootstrap kit generations by outcome (success, error).",
+	}, []string{"outcome"})
+
+	kitDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "bootstrap",
+		Name:      "kit_generation_duration_seconds",
+		Help:      "Bootstrap kit generation time (key generation, signing, packaging).",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+	})
+
+	nodeStates = &stateCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "federation", "nodes"),
+			"Registered federation nodes by lifecycle state.",
+			[]string{"state"}, nil,
+		),
+	}
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		handshakes,
+		busMessages, busDuration,
+		telemetryDropped,
+		routerPoolCache, routerOpenBreakers, routerFallbacks, residencyRefusals, routerReads, migrationMirrors,
+		kitGenerations, kitDuration,
+		nodeStates,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveHandshake counts a federation handshake step; outcome is "ok" or the error code returned
+func ObserveHandshake(stage, outcome string) {
+	handshakes.WithLabelValues(stage, outcome).Inc()
+}
+
+// ObserveBusMessage counts a dispatched bus message and its latency
+func ObserveBusMessage(msgType, outcome string, d time.Duration) {
+	busMessages.WithLabelValues(msgType, outcome).Inc()
+	busDuration.WithLabelValues(msgType).Observe(d.Seconds())
+}
+
+// SetRouterPoolCacheSize records the number of cached node database pools
+func SetRouterPoolCacheSize(n int) {
+	routerPoolCache.Set(float64(n))
+}
+
+// ObserveTelemetryDropped counts telemetry samples discarded after the writer gave up on them
+func ObserveTelemetryDropped(n int) {
+	telemetryDropped.Add(float64(n))
+}
+
+// SetRouterOpenBreakers records the number of node databases with an open circuit breaker
+func SetRouterOpenBreakers(n int) {
+	routerOpenBreakers.Set(float64(n))
+}
+
+// ObserveRouterFallback counts a tenant request routed to the default database
+func ObserveRouterFallback(reason string) {
+	routerFallbacks.WithLabelValues(reason).Inc()
+}
+
+// ObserveResidencyRefusal counts a strict-residency tenant request refused instead of falling back
+func ObserveResidencyRefusal(reason string) {
+	residencyRefusals.WithLabelValues(reason).Inc()
+}
+
+// ObserveRouterRead counts a read-only tenant request by the database that served it
+func ObserveRouterRead(target string) {
+	routerReads.WithLabelValues(target).Inc()
+}
+
+// ObserveMigrationMirror counts a committed tenant transaction copied to a migration target
+func ObserveMigrationMirror(outcome string) {
+	migrationMirrors.WithLabelValues(outcome).Inc()
+}
+
+// ObserveKitGeneration counts a bootstrap kit generation and its duration
+func ObserveKitGeneration(outcome string, d time.Duration) {
+	kitGenerations.WithLabelValues(outcome).Inc()
+	kitDuration.Observe(d.Seconds())
+}
+
+// SetNodeStateSource installs the function reporting node counts by state; it is called on every scrape
+// and every state in states is exported, at zero when no node is in it
+func SetNodeStateSource(states []string, source func() map[string]int) {
+	nodeStates.mu.Lock()
+	defer nodeStates.mu.Unlock()
+	nodeStates.states = states
+	nodeStates.source = source
+}
+
+// stateCollector exports node counts read from the registry at scrape time
+type stateCollector struct {
+	desc *prometheus.Desc
+
+	mu     sync.Mutex
+	states []string
+	source func() map[string]int
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	states, source := c.states, c.source
+	c.mu.Unlock()
+	if source == nil {
+		return
+	}
+
+	counts := source()
+	for _, state := range states {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(counts[state]), state)
+	}
+}
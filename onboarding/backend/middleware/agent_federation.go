@@ -10,38 +10,58 @@ import (
 // FederationContextKey is the key for storing federation payload in context
 type FederationContextKey struct{}
 
-// RequireAgentFederation is middleware that requires a valid federation token for agents
+// RequireAgentFederation returns middleware that requires a valid federation token for agents
 // Phase 13.11: Agents authenticate using the same Ed25519 federation token system
-func RequireAgentFederation(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("X-Federation-Token")
-
-		if token == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"error":"MISSING_FEDERATION_TOKEN"}`))
-			return
-		}
-
-		// Verify Ed25519 signature
-		payload, err := federation.VerifyFederationToken(token)
-		if err != nil || payload == nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte(`{"error":"INVALID_FEDERATION_TOKEN"}`))
-			return
-		}
-
-		// Register session for faster future lookups
-		RegisterFederationSession(token)
-
-		// Attach federation payload to request context
-		ctx := context.WithValue(r.Context(), FederationContextKey{}, payload)
-		r = r.WithContext(ctx)
-
-		// Agent authenticated — forward request
-		next.ServeHTTP(w, r)
-	})
+// The token must be unexpired and issued for the given audience
+func RequireAgentFederation(audience string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get("X-Federation-Token")
+
+			if token == "" {
+				writeFederationError(w, http.StatusUnauthorized, "MISSING_FEDERATION_TOKEN")
+				return
+			}
+
+			// Verify Ed25519 signature, validity window and audience
+			payload, err := federation.VerifyFederationTokenFor(token, audience)
+			if err != nil || payload == nil {
+				writeTokenError(w, err, "INVALID_FEDERATION_TOKEN")
+				return
+			}
+
+			// Register session for faster future lookups
+			RegisterFederationSession(token, payload)
+
+			// Attach federation payload to request context
+			ctx := context.WithValue(r.Context(), FederationContextKey{}, payload)
+			r = r.WithContext(ctx)
+
+			// Agent authenticated — forward request
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeTokenError writes the error code for a failed token verification
+// Claim failures get their dedicated code; anything else gets the fallback code
+func writeTokenError(w http.ResponseWriter, err error, fallback string) {
+	code := federation.TokenErrorCode(err)
+	switch code {
+	case "":
+		writeFederationError(w, http.StatusForbidden, fallback)
+	case "TOKEN_EXPIRED", "TOKEN_NOT_YET_VALID":
+		writeFederationError(w, http.StatusUnauthorized, code)
+	default:
+		writeFederationError(w, http.StatusForbidden, code)
+	}
+}
+
+// writeFederationError writes a JSON error body with the given status
+func writeFederationError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(`{"error":"` + code + `"}`))
 }
 
 // GetFederationPayload retrieves the federation payload from request context
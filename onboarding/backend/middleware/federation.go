@@ -2,12 +2,18 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/tracing"
 )
 
 // ContextKey is a type for context keys
@@ -26,15 +32,32 @@ const (
 
 // FederationRouter handles tenant/region lookup and database routing
 type FederationRouter struct {
-	defaultDB *pgxpool.Pool
-	nodeCache map[string]*pgxpool.Pool // Cache of node_id -> DB pool
+	defaultDB           *pgxpool.Pool
+	pools               *NodePools // node_id -> DB pool, health probed
+	onFallback          func(ctx context.Context, decision FallbackDecision)
+	replicaMaxStaleness time.Duration // bound of read-only requests without their own
+
+	residencyMu     sync.Mutex
+	residencyStates map[string]residencyState      // tenant_id -> cached residency policy
+	fallbackReports map[fallbackReportKey]time.Time // last reported fallback per tenant, reason and outcome
+
+	onMigration     func(ctx context.Context, migration TenantMigration)
+	migrationMu     sync.Mutex
+	migrationStates map[string]migrationState // tenant_id -> cached running migration
+	migrationRuns   map[string]*migrationRun  // migrations running on this backend
 }
 
+// ErrNoNodeRoute is returned by resolveDatabase for tenants without a node database
+var ErrNoNodeRoute = errors.New("no federation node database mapped for tenant")
+
 // NewFederationRouter creates a new federation router
-func NewFederationRouter(defaultDB *pgxpool.Pool) *FederationRouter {
+func NewFederationRouter(defaultDB *pgxpool.Pool, pools NodePoolConfig) *FederationRouter {
 	return &FederationRouter{
-		defaultDB: defaultDB,
-		nodeCache: make(map[string]*pgxpool.Pool),
+		defaultDB:           defaultDB,
+		pools:               NewNodePools(defaultDB, pools),
+		replicaMaxStaleness: DefaultReplicaMaxStaleness,
+		migrationStates:     make(map[string]migrationState),
+		migrationRuns:       make(map[string]*migrationRun),
 	}
 }
 
@@ -55,17 +78,32 @@ func (fr *FederationRouter) FederationMiddleware(next http.Handler) http.Handler
 		}
 
 		// Look up routing configuration
-		db, nodeID, err := fr.resolveDatabase(ctx, tenantID, region)
+		db, nodeID, routeRegion, err := fr.resolveDatabase(ctx, tenantID, region)
 		if err != nil {
-			log.Printf("Federation routing error for tenant %s: %v", tenantID, err)
-			// Fallback to default DB on error
-			ctx = context.WithValue(ctx, ContextKeyDB, fr.defaultDB)
-			ctx = context.WithValue(ctx, ContextKeyTenantID, tenantID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			// Fallback to default DB, unless the tenant's data must stay in its region
+			if !fr.fallback(w, r, tenantID, region, err) {
+				return
+			}
+			db, nodeID = fr.defaultDB, defaultNodeID
+		} else if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			// Read-only handlers of safe requests may be served from the region's replicas
+			ctx = context.WithValue(ctx, contextKeyReadRoute, &readRoute{
+				fr:          fr,
+				tenantID:    tenantID,
+				region:      routeRegion,
+				writeDB:     db,
+				writeNodeID: nodeID,
+			})
+		}
+
+		// A migrating tenant's committed writes are mirrored to its new node, or held during cutover
+		ctx, ok := fr.migrationGate(ctx, w, r, tenantID, nodeID)
+		if !ok {
 			return
 		}
 
 		// Set context values
+		ctx = tracing.WithRoute(ctx, tenantID, nodeID)
 		ctx = context.WithValue(ctx, ContextKeyDB, db)
 		ctx = context.WithValue(ctx, ContextKeyTenantID, tenantID)
 		if region != "" {
@@ -82,7 +120,7 @@ func (fr *FederationRouter) FederationMiddleware(next http.Handler) http.Handler
 // extractTenantID extracts tenant ID from request
 func (fr *FederationRouter) extractTenantID(r *http.Request) string {
 	// Try URL parameter first (chi router)
-	if tenantID := chi.URLParam(r, "tenantId"); tenantID != "" {
+	if tenantID := pathTenantID(r); tenantID != "" {
 		return tenantID
 	}
 
@@ -99,6 +137,29 @@ func (fr *FederationRouter) extractTenantID(r *http.Request) string {
 	return ""
 }
 
+// pathTenantID returns the {tenantId} path parameter of the route the request will reach
+// FederationMiddleware runs on a parent router, before chi has matched nested routes such as
+// /tenants/{tenantId}/status, so chi.URLParam is still empty there; the request is matched against
+// the whole routing tree with a scratch route context instead.
+func pathTenantID(r *http.Request) string {
+	if tenantID := chi.URLParam(r, "tenantId"); tenantID != "" {
+		return tenantID
+	}
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return ""
+	}
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	match := chi.NewRouteContext()
+	if !rctx.Routes.Match(match, r.Method, path) {
+		return ""
+	}
+	return match.URLParam("tenantId")
+}
+
 // extractRegion extracts region from request
 func (fr *FederationRouter) extractRegion(r *http.Request) string {
 	// Try query parameter
@@ -114,115 +175,84 @@ func (fr *FederationRouter) extractRegion(r *http.Request) string {
 	return ""
 }
 
-// resolveDatabase resolves the correct database for tenant/region
-func (fr *FederationRouter) resolveDatabase(ctx context.Context, tenantID, region string) (*pgxpool.Pool, string, error) {
+// resolveDatabase resolves the correct database for tenant/region, and the region of the node it picked
+func (fr *FederationRouter) resolveDatabase(ctx context.Context, tenantID, region string) (db *pgxpool.Pool, nodeID, routeRegion string, err error) {
+	ctx, span := tracing.Start(ctx, "FederationRouter.resolveDatabase",
+		tracing.TenantID(tenantID), attribute.String("sage.region", region))
+	defer func() {
+		span.SetAttributes(tracing.NodeID(nodeID))
+		tracing.End(span, err)
+	}()
+
 	// First, check tenant_federation_map for primary node
 	var primaryNodeID, primaryRegion string
-	err := fr.defaultDB.QueryRow(ctx,
+	var lastErr error // why the last candidate node could not be used
+	err = fr.defaultDB.QueryRow(ctx,
 		`SELECT primary_node_id, primary_region 
 		 FROM public.tenant_federation_map 
 		 WHERE tenant_id = $1`,
 		tenantID,
 	).Scan(&primaryNodeID, &primaryRegion)
 
-	if err == nil {
-		// If region matches or no region specified, use primary node
-		if region == "" || region == primaryRegion {
-			db, err := fr.getNodeDB(ctx, primaryNodeID)
-			if err == nil {
-				return db, primaryNodeID, nil
-			}
-		}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		lastErr = fmt.Errorf("failed to look up tenant federation map: %w", err)
 	}
 
-	// If region specified and different from primary, check federation_routing
-	if region != "" && region != primaryRegion {
-		var nodeID string
-		err := fr.defaultDB.QueryRow(ctx,
-			`SELECT node_id 
-			 FROM public.federation_routing 
-			 WHERE tenant_id = $1 AND region = $2 AND is_primary = true
-			 ORDER BY weight DESC
-			 LIMIT 1`,
-			tenantID,
-			region,
-		).Scan(&nodeID)
-
-		if err == nil {
-			db, err := fr.getNodeDB(ctx, nodeID)
+	// Writer nodes of the requested region (default: the tenant's primary region) share tenants by weight;
+	// a tenant stays on the same writer while it is healthy
+	routeRegion = region
+	if routeRegion == "" {
+		routeRegion = primaryRegion
+	}
+	if routeRegion != "" {
+		candidates, err := fr.loadCandidates(ctx, tenantID, routeRegion, true)
+		if err != nil {
+			lastErr = err
+		}
+		// The primary node takes part with the default weight unless it has its own routing row
+		if routeRegion == primaryRegion && primaryNodeID != "" && !hasCandidate(candidates, primaryNodeID) {
+			candidates = append(candidates, routeCandidate{nodeID: primaryNodeID, weight: defaultRouteWeight})
+		}
+		for _, c := range fr.orderWriters(tenantID, candidates) {
+			db, err := fr.getNodeDB(ctx, c.nodeID)
 			if err == nil {
-				return db, nodeID, nil
+				return db, c.nodeID, routeRegion, nil
 			}
+			lastErr = err
 		}
 	}
 
 	// Fallback: use primary node if we found one
-	if primaryNodeID != "" {
+	if primaryNodeID != "" && routeRegion != primaryRegion {
 		db, err := fr.getNodeDB(ctx, primaryNodeID)
 		if err == nil {
-			return db, primaryNodeID, nil
+			return db, primaryNodeID, primaryRegion, nil
 		}
+		lastErr = err
 	}
 
-	// Final fallback: default database
-	return fr.defaultDB, "default", nil
-}
-
-// getNodeDB gets or creates a database connection for a node
-func (fr *FederationRouter) getNodeDB(ctx context.Context, nodeID string) (*pgxpool.Pool, error) {
-	// Check cache first
-	if db, ok := fr.nodeCache[nodeID]; ok {
-		// Verify connection is still valid
-		if err := db.Ping(ctx); err == nil {
-			return db, nil
-		}
-		// Connection invalid, remove from cache
-		delete(fr.nodeCache, nodeID)
-	}
-
-	// Look up node configuration
-	var databaseURL string
-	var status string
-	err := fr.defaultDB.QueryRow(ctx,
-		`SELECT database_url, status 
-		 FROM public.federation_nodes 
-		 WHERE node_id = $1`,
-		nodeID,
-	).Scan(&databaseURL, &status)
-
-	if err != nil {
-		return nil, fmt.Errorf("federation node not found: %w", err)
-	}
-
-	if status != "active" {
-		return nil, fmt.Errorf("federation node is not active: %s", status)
-	}
-
-	// Create new connection pool
-	config, err := pgxpool.ParseConfig(databaseURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse database URL: %w", err)
-	}
-
-	db, err := pgxpool.NewWithConfig(ctx, config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create database connection: %w", err)
-	}
-
-	// Test connection
-	if err := db.Ping(ctx); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	// No node database can serve the tenant; the caller decides whether the default database may
+	if lastErr != nil {
+		return nil, "", "", lastErr
 	}
+	return nil, "", "", fmt.Errorf("%w: tenant %s", ErrNoNodeRoute, tenantID)
+}
 
-	// Cache the connection
-	fr.nodeCache[nodeID] = db
+// getNodeDB returns the cached pool of a node database
+// Health is checked by the pool manager's background probes, not per request.
+func (fr *FederationRouter) getNodeDB(ctx context.Context, nodeID string) (db *pgxpool.Pool, err error) {
+	ctx, span := tracing.Start(ctx, "FederationRouter.getNodeDB", tracing.NodeID(nodeID))
+	defer func() { tracing.End(span, err) }()
 
-	return db, nil
+	return fr.pools.Get(ctx, nodeID)
 }
 
 // GetDBFromContext extracts database connection from context
+// Under read-only intent (WithReadOnly) it may return a replica database of the tenant's region.
 func GetDBFromContext(ctx context.Context) (*pgxpool.Pool, error) {
+	if db := readDBFromContext(ctx); db != nil {
+		return db, nil
+	}
 	db, ok := ctx.Value(ContextKeyDB).(*pgxpool.Pool)
 	if !ok || db == nil {
 		return nil, fmt.Errorf("database connection not found in context")
@@ -248,11 +278,18 @@ func GetNodeIDFromContext(ctx context.Context) string {
 	return nodeID
 }
 
-// Close closes all cached database connections
+// Close stops health probing and closes all cached database connections
+// Tenant migrations running on this backend are stopped and marked failed.
 func (fr *FederationRouter) Close() {
-	for nodeID, db := range fr.nodeCache {
-		db.Close()
-		delete(fr.nodeCache, nodeID)
+	fr.migrationMu.Lock()
+	ids := make([]string, 0, len(fr.migrationRuns))
+	for id := range fr.migrationRuns {
+		ids = append(ids, id)
+	}
+	fr.migrationMu.Unlock()
+	for _, id := range ids {
+		fr.stopMigrationRun(id)
 	}
+	fr.pools.Close()
 }
 
@@ -1,68 +1,94 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
 )
 
 // Phase 13.2: Stateless token cache for validated sessions
 // Later this will evolve into distributed propagation
+// Cached payloads are re-checked for expiry and audience on every request
 var (
-	activeFederationSessions = make(map[string]bool)
+	activeFederationSessions = make(map[string]*federation.FederationTokenPayload)
 	sessionMutex             sync.RWMutex
 )
 
-// RegisterFederationSession registers a federation session token
-func RegisterFederationSession(token string) {
+// RegisterFederationSession registers a verified federation session token
+func RegisterFederationSession(token string, payload *federation.FederationTokenPayload) {
 	sessionMutex.Lock()
-	activeFederationSessions[token] = true
+	activeFederationSessions[token] = payload
 	sessionMutex.Unlock()
 }
 
-// IsFederationSessionValid checks if a federation session token is valid
+// IsFederationSessionValid checks if a federation session token is registered and unexpired
 func IsFederationSessionValid(token string) bool {
+	sessionMutex.RLock()
+	payload := activeFederationSessions[token]
+	sessionMutex.RUnlock()
+	return payload != nil && payload.ValidateTime(time.Now()) == nil
+}
+
+// lookupFederationSession returns the cached payload for a registered session token
+func lookupFederationSession(token string) *federation.FederationTokenPayload {
 	sessionMutex.RLock()
 	defer sessionMutex.RUnlock()
 	return activeFederationSessions[token]
 }
 
-// RevokeFederationSession revokes a federation session token
+// RevokeFederationSession drops a federation session token from the local cache
+// Durable revocation goes through federation.Revoke
 func RevokeFederationSession(token string) {
 	sessionMutex.Lock()
 	delete(activeFederationSessions, token)
 	sessionMutex.Unlock()
 }
 
-// RequireFederationSession is middleware that requires a valid federation session token
-func RequireFederationSession(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("X-Federation-Token")
-
-		if token == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"error":"NO_FEDERATION_TOKEN","required":true}`))
-			return
-		}
+// RequireFederationSession returns middleware that requires a valid federation session token
+// for the given audience
+func RequireFederationSession(audience string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get("X-Federation-Token")
 
-		if !IsFederationSessionValid(token) {
-			// Phase 13.4: Signature check fallback
-			payload, err := federation.VerifyFederationToken(token)
-			if err != nil || payload == nil {
+			if token == "" {
 				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusForbidden)
-				w.Write([]byte(`{"error":"INVALID_OR_EXPIRED_SESSION"}`))
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"NO_FEDERATION_TOKEN","required":true}`))
 				return
 			}
-			// Token is valid via signature verification
-			// Optionally register it for faster future lookups
-			RegisterFederationSession(token)
-		}
 
-		// Session authenticated — forward request
-		next.ServeHTTP(w, r)
-	})
-}
+			payload := lookupFederationSession(token)
+			if payload != nil {
+				// Cached session: claims can lapse and revocations can land after registration
+				err := payload.ValidateClaims(audience, time.Now())
+				if err == nil {
+					err = federation.CheckRevocation(token, payload)
+				}
+				if err != nil {
+					if errors.Is(err, federation.ErrTokenExpired) || errors.Is(err, federation.ErrTokenRevoked) {
+						RevokeFederationSession(token)
+					}
+					writeTokenError(w, err, "INVALID_OR_EXPIRED_SESSION")
+					return
+				}
+			} else {
+				// Phase 13.4: Signature check fallback
+				verified, err := federation.VerifyFederationTokenFor(token, audience)
+				if err != nil || verified == nil {
+					writeTokenError(w, err, "INVALID_OR_EXPIRED_SESSION")
+					return
+				}
+				// Token is valid via signature verification
+				// Register it for faster future lookups
+				RegisterFederationSession(token, verified)
+			}
 
+			// Session authenticated — forward request
+			next.ServeHTTP(w, r)
+		})
+	}
+}
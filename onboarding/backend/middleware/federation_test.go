@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestFederationMiddlewareTenantFromPath(t *testing.T) {
+	// Nothing listens on port 1, so every routing lookup fails and the tenant falls back
+	db, err := pgxpool.New(context.Background(), "postgres://sage@127.0.0.1:1/sage_os?connect_timeout=1")
+	if err != nil {
+		t.Fatalf("pgxpool.New: %v", err)
+	}
+	t.Cleanup(db.Close)
+	fr := &FederationRouter{
+		defaultDB:       db,
+		pools:           &NodePools{nodes: map[string]*nodePool{}},
+		migrationStates: map[string]migrationState{},
+	}
+
+	// Same shape as /federation/api in SetupRouter: the middleware sits above the {tenantId} route
+	var gotTenant string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		gotTenant = GetTenantIDFromContext(r.Context())
+	}
+	r := chi.NewRouter()
+	r.Route("/federation/api", func(r chi.Router) {
+		r.Use(fr.FederationMiddleware)
+		r.Route("/onboarding", func(r chi.Router) {
+			r.Get("/regions", handler)
+			r.Route("/tenants/{tenantId}", func(r chi.Router) {
+				r.Use(ReadOnly(0))
+				r.Get("/status", handler)
+			})
+			r.With(ReadOnly(0)).Get("/bootstrap/audit/{tenantId}", handler)
+		})
+	})
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"nested tenant route", "/federation/api/onboarding/tenants/acme/status", "acme"},
+		{"inline tenant route", "/federation/api/onboarding/bootstrap/audit/acme", "acme"},
+		{"query parameter", "/federation/api/onboarding/regions?tenantId=acme", "acme"},
+		{"no tenant", "/federation/api/onboarding/regions", ""},
+	}
+	for _, tt := range tests {
+		gotTenant = "unset"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", tt.name, w.Code, w.Body)
+			continue
+		}
+		if gotTenant != tt.want {
+			t.Errorf("%s: tenant in context = %q, want %q", tt.name, gotTenant, tt.want)
+		}
+	}
+}
+
+func TestStrictTenantRefused(t *testing.T) {
+	// A strict tenant whose routing lookup fails (nothing listens on port 1) must not reach the default database
+	db, err := pgxpool.New(context.Background(), "postgres://sage@127.0.0.1:1/sage_os?connect_timeout=1")
+	if err != nil {
+		t.Fatalf("pgxpool.New: %v", err)
+	}
+	t.Cleanup(db.Close)
+	tenantID := "6f1c2d3e-4b5a-4c7d-8e9f-0a1b2c3d4e5f"
+	fr := &FederationRouter{
+		defaultDB:       db,
+		pools:           &NodePools{nodes: map[string]*nodePool{}},
+		migrationStates: map[string]migrationState{},
+		residencyStates: map[string]residencyState{
+			tenantID: {policy: ResidencyPolicy{Strict: true, Reason: ResidencyReasonRequired}, fetchedAt: time.Now()},
+		},
+	}
+	assertRefused(t, fr, tenantID)
+}
+
+// assertRefused requests /tenants/{tenantId}/status through a chi router and expects 503 RESIDENCY_UNAVAILABLE
+func assertRefused(t *testing.T, fr *FederationRouter, tenantID string) {
+	t.Helper()
+	var decisions []FallbackDecision
+	fr.OnFallback(func(ctx context.Context, d FallbackDecision) { decisions = append(decisions, d) })
+
+	r := chi.NewRouter()
+	r.Route("/federation/api", func(r chi.Router) {
+		r.Use(fr.FederationMiddleware)
+		r.Route("/tenants/{tenantId}", func(r chi.Router) {
+			r.Use(ReadOnly(0))
+			r.Get("/status", func(w http.ResponseWriter, r *http.Request) {
+				t.Error("handler ran for a refused strict tenant")
+			})
+		})
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/federation/api/tenants/"+tenantID+"/status", nil))
+		var body map[string]interface{}
+		_ = json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusServiceUnavailable || body["error"] != "RESIDENCY_UNAVAILABLE" {
+			t.Fatalf("request %d: status %d, body %v; want 503 RESIDENCY_UNAVAILABLE", i, w.Code, body)
+		}
+	}
+	// Repeated refusals are reported once
+	if len(decisions) != 1 || !decisions[0].Refused || decisions[0].TenantID != tenantID {
+		t.Fatalf("reported decisions = %+v, want one refusal", decisions)
+	}
+}
+
+func TestShouldReportFallback(t *testing.T) {
+	fr := &FederationRouter{}
+	now := time.Now()
+	fallback := FallbackDecision{TenantID: "tenant-1", Reason: FallbackNoNode}
+
+	tests := []struct {
+		name     string
+		decision FallbackDecision
+		at       time.Time
+		want     bool
+	}{
+		{"first fallback", fallback, now, true},
+		{"same fallback again", fallback, now.Add(time.Minute), false},
+		{"another reason", FallbackDecision{TenantID: "tenant-1", Reason: FallbackNodeUnavailable}, now, true},
+		{"refusal", FallbackDecision{TenantID: "tenant-1", Reason: FallbackNoNode, Refused: true}, now, true},
+		{"another tenant", FallbackDecision{TenantID: "tenant-2", Reason: FallbackNoNode}, now, true},
+		{"after the interval", fallback, now.Add(fallbackReportInterval), true},
+	}
+	for _, tt := range tests {
+		if got := fr.shouldReportFallback(tt.decision, tt.at); got != tt.want {
+			t.Errorf("%s: shouldReportFallback() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStrictTenantWithoutNodeRefused(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	var tenantID string
+	if err := pool.QueryRow(ctx,
+		`INSERT INTO public.tenants (name, config_data, residency_strict) VALUES ('residency test', '{}', true) RETURNING id`,
+	).Scan(&tenantID); err != nil {
+		t.Fatalf("insert tenant: %v", err)
+	}
+	t.Cleanup(func() { _, _ = pool.Exec(ctx, "DELETE FROM public.tenants WHERE id = $1", tenantID) })
+
+	fr := NewFederationRouter(pool, DefaultNodePoolConfig())
+	t.Cleanup(fr.Close)
+	assertRefused(t, fr, tenantID)
+}
@@ -0,0 +1,298 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/metrics"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/tracing"
+)
+
+// Tenant writes during migrations
+// FederationMiddleware looks up whether a tenant is migrating (cached for migrationStateTTL per backend).
+// While its rows are copied, requests routed to the source carry a writeMirror, and the source pool's
+// PoolTracer notes which tenant tables their INSERT, UPDATE and DELETE statements write. Once the
+// transaction commits (a statement outside a transaction commits on its own), the tenant's rows of those
+// tables written since the last copy or sync (by source transaction ids, tenant_migrations.mirror_xmin)
+// are upserted on the target. Rows are copied rather than statements replayed, so nothing reaches the
+// target before it is committed on the source, and values generated on the source (column defaults,
+// now(), gen_random_uuid()) arrive unchanged. Rolled back transactions are dropped. Copies and syncs of
+// all backends take turns under an advisory lock keyed by the migration id, so an older snapshot never
+// overwrites a newer one. The copy passes repair whatever the mirror misses (deleted rows, batches,
+// failed syncs). During cutover, tenant writes are refused so the final copy is exact; reads are still
+// served from the source.
+
+// migrationStateTTL is how long a backend caches whether a tenant is migrating
+const migrationStateTTL = 2 * time.Second
+
+// mirrorTimeout bounds the sync of one committed transaction to the migration target
+const mirrorTimeout = 5 * time.Second
+
+// defaultNodeID names the default database where a node ID is expected
+const defaultNodeID = "default"
+
+const contextKeyWriteMirror ContextKey = "write_mirror"
+
+// migrationState is the cached migration of a tenant; the zero value means none
+type migrationState struct {
+	id        string
+	source    string
+	target    string
+	status    MigrationStatus
+	fetchedAt time.Time
+}
+
+// migrationState returns the running migration of a tenant
+// Lookup errors keep the last known state, so a database hiccup does not lift a write freeze.
+func (fr *FederationRouter) migrationState(ctx context.Context, tenantID string) migrationState {
+	fr.migrationMu.Lock()
+	cached, ok := fr.migrationStates[tenantID]
+	fr.migrationMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < migrationStateTTL {
+		return cached
+	}
+
+	state := migrationState{fetchedAt: time.Now()}
+	err := fr.defaultDB.QueryRow(ctx,
+		`SELECT id, source_node_id, target_node_id, status
+		 FROM public.tenant_migrations
+		 WHERE tenant_id = $1 AND status IN ('copying', 'catching_up', 'cutting_over')
+		   AND updated_at > NOW() - make_interval(secs => $2)`,
+		tenantID,
+		migrationStaleAfter.Seconds(),
+	).Scan(&state.id, &state.source, &state.target, &state.status)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Failed to look up migration of tenant %s: %v", tenantID, err)
+		return cached
+	}
+
+	fr.migrationMu.Lock()
+	fr.migrationStates[tenantID] = state
+	fr.migrationMu.Unlock()
+	return state
+}
+
+// forgetMigrationState drops a tenant's cached migration, after a status change on this backend
+func (fr *FederationRouter) forgetMigrationState(tenantID string) {
+	fr.migrationMu.Lock()
+	delete(fr.migrationStates, tenantID)
+	fr.migrationMu.Unlock()
+}
+
+// migrationGate applies a tenant's migration to a request routed to nodeID: writes to the source are
+// mirrored to the target, and refused with 503 TENANT_MIGRATING during cutover
+func (fr *FederationRouter) migrationGate(ctx context.Context, w http.ResponseWriter, r *http.Request, tenantID, nodeID string) (context.Context, bool) {
+	state := fr.migrationState(ctx, tenantID)
+	if state.status == "" || nodeID != state.source {
+		return ctx, true
+	}
+
+	if state.status == MigrationCuttingOver && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":       "TENANT_MIGRATING",
+			"message":     "the tenant is being moved to another node; retry shortly",
+			"tenantId":    tenantID,
+			"migrationId": state.id,
+		})
+		return ctx, false
+	}
+
+	source, err := fr.migrationDB(ctx, state.source)
+	var target *pgxpool.Pool
+	if err == nil {
+		target, err = fr.pools.Get(ctx, state.target)
+	}
+	if err != nil {
+		// The next copy pass picks up the writes
+		log.Printf("Cannot mirror writes of tenant %s to node %s: %v", tenantID, state.target, err)
+		metrics.ObserveMigrationMirror("error")
+		return ctx, true
+	}
+	return context.WithValue(ctx, contextKeyWriteMirror, &writeMirror{
+		migrationID: state.id,
+		tenantID:    tenantID,
+		sourceID:    state.source,
+		targetID:    state.target,
+		source:      source,
+		target:      target,
+		db:          fr.defaultDB,
+	}), true
+}
+
+// writeMirror copies a request's committed writes on the migration source to the target
+type writeMirror struct {
+	migrationID string
+	tenantID    string
+	sourceID    string
+	targetID    string
+	source      *pgxpool.Pool
+	target      *pgxpool.Pool
+	db          *pgxpool.Pool // default database, holding the migration's mirror lock
+}
+
+// sync upserts the tenant's rows of the written tables that changed since the last copy or sync
+func (m *writeMirror) sync(ctx context.Context, written map[string]bool) error {
+	return withMirrorLock(ctx, m.db, m.migrationID, func(since int64) (int64, error) {
+		if since == 0 {
+			// Nothing copied yet; the first copy reads a snapshot that includes these writes
+			return 0, nil
+		}
+		return copyTenantTables(ctx, m.source, m.target, m.tenantID, mirroredTables(written), since)
+	})
+}
+
+// mirroredTables lists the tenant tables a sync copies, in tenantTables order. The tenants table always
+// comes first, since the other tables reference it.
+func mirroredTables(written map[string]bool) []tenantTable {
+	tables := []tenantTable{tenantTables[0]}
+	for _, t := range tenantTables[1:] {
+		if written[t.name] {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// mirroredWrite matches the table written by INSERT, UPDATE and DELETE statements
+var mirroredWrite = regexp.MustCompile(`(?is)^\s*(?:INSERT\s+INTO|UPDATE|DELETE\s+FROM)\s+(?:public\.)?"?([a-z_]+)`)
+
+// mirroredTable returns the tenant table a statement writes, if any
+func mirroredTable(sql string) (string, bool) {
+	match := mirroredWrite.FindStringSubmatch(sql)
+	if match == nil {
+		return "", false
+	}
+	for _, t := range tenantTables {
+		if strings.EqualFold(match[1], t.name) {
+			return t.name, true
+		}
+	}
+	return "", false
+}
+
+type mirroredQueryKey struct{}
+
+// mirroredQuery is a tenant write in flight
+type mirroredQuery struct {
+	mirror *writeMirror
+	table  string
+}
+
+// pendingMirror collects the tenant writes of an open transaction
+type pendingMirror struct {
+	mirror  *writeMirror
+	written map[string]bool
+}
+
+// txStatusIdle is the transaction status the server reports when no transaction is open
+const txStatusIdle = 'I'
+
+// transactionOutcome reports whether the statement that just ended closed the connection's transaction,
+// and if so whether its writes were committed. A statement outside a transaction commits on its own;
+// COMMIT of a failed transaction reports ROLLBACK.
+func transactionOutcome(txStatus byte, commandTag string, err error) (ended, committed bool) {
+	if txStatus != txStatusIdle {
+		return false, false
+	}
+	return true, err == nil && !strings.EqualFold(commandTag, "ROLLBACK")
+}
+
+// PoolTracer is the pgx.QueryTracer of a routed pool: it traces queries and mirrors tenant writes
+// during migrations
+type PoolTracer struct {
+	node  string
+	trace *tracing.QueryTracer
+
+	mu      sync.Mutex
+	pending map[*pgx.Conn][]pendingMirror // tenant writes of open transactions, by connection
+}
+
+// NewPoolTracer returns the tracer for a pool; node is "default" or the node ID of a node database
+func NewPoolTracer(node string) *PoolTracer {
+	return &PoolTracer{node: node, trace: tracing.NewQueryTracer(node), pending: make(map[*pgx.Conn][]pendingMirror)}
+}
+
+func (t *PoolTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx = t.trace.TraceQueryStart(ctx, conn, data)
+	if conn.PgConn().TxStatus() == txStatusIdle {
+		// Writes left over from a transaction whose end was not seen (e.g. a failed COMMIT round trip)
+		t.take(conn)
+	}
+	if mirror, ok := ctx.Value(contextKeyWriteMirror).(*writeMirror); ok && mirror.sourceID == t.node {
+		if table, ok := mirroredTable(data.SQL); ok {
+			ctx = context.WithValue(ctx, mirroredQueryKey{}, mirroredQuery{mirror: mirror, table: table})
+		}
+	}
+	return ctx
+}
+
+func (t *PoolTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.trace.TraceQueryEnd(ctx, conn, data)
+	if q, ok := ctx.Value(mirroredQueryKey{}).(mirroredQuery); ok && data.Err == nil && data.CommandTag.RowsAffected() > 0 {
+		t.note(conn, q)
+	}
+
+	ended, committed := transactionOutcome(conn.PgConn().TxStatus(), data.CommandTag.String(), data.Err)
+	if !ended {
+		return
+	}
+	pending := t.take(conn)
+	if !committed || len(pending) == 0 {
+		return
+	}
+
+	// Synced in the background, so the connection goes back to the pool first, outside the request's
+	// cancellation, and never mirrored again
+	ctx = context.WithValue(context.WithoutCancel(ctx), contextKeyWriteMirror, nil)
+	ctx = context.WithValue(ctx, mirroredQueryKey{}, nil)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, mirrorTimeout)
+		defer cancel()
+		for _, p := range pending {
+			if err := p.mirror.sync(ctx, p.written); err != nil {
+				log.Printf("Failed to mirror writes of migration %s to node %s: %v", p.mirror.migrationID, p.mirror.targetID, err)
+				metrics.ObserveMigrationMirror("error")
+				continue
+			}
+			metrics.ObserveMigrationMirror("ok")
+		}
+	}()
+}
+
+// note records a tenant write of the connection's current transaction
+func (t *PoolTracer) note(conn *pgx.Conn, q mirroredQuery) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending[conn]
+	for _, p := range pending {
+		if p.mirror.migrationID == q.mirror.migrationID {
+			p.written[q.table] = true
+			return
+		}
+	}
+	t.pending[conn] = append(pending, pendingMirror{mirror: q.mirror, written: map[string]bool{q.table: true}})
+}
+
+// take removes and returns the tenant writes recorded for a connection
+func (t *PoolTracer) take(conn *pgx.Conn) []pendingMirror {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending, ok := t.pending[conn]
+	if ok {
+		delete(t.pending, conn)
+	}
+	return pending
+}
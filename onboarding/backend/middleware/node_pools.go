@@ -0,0 +1,498 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/metrics"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/tracing"
+)
+
+// Node database pool manager
+// FederationRouter keeps one pgxpool per node database. Requests never ping: a background prober checks
+// every cached pool each ProbeInterval and refreshes node settings from federation_nodes, evicting pools of
+// nodes that are no longer routable (status not active, no database, decommissioned) or whose database URL
+// or pool size changed. Each node has a circuit breaker: after BreakerThreshold consecutive failures the
+// node is unavailable and only probed again after a backoff that doubles on every failed retry. Probes also
+// measure the replication lag of replica databases, which bounds the staleness of reads routed to them.
+
+// Pool manager errors
+var (
+	ErrNodeNotRoutable = errors.New("federation node is not routable")
+	ErrNodeUnavailable = errors.New("federation node database is unavailable")
+)
+
+// NodePoolConfig tunes the node pool manager
+type NodePoolConfig struct {
+	ProbeInterval     time.Duration // health probe and settings refresh period
+	ProbeTimeout      time.Duration // timeout of one probe or first connection
+	BreakerThreshold  int           // consecutive failures that open a node's breaker
+	BreakerBackoff    time.Duration // first open period, doubled on every failed retry
+	BreakerMaxBackoff time.Duration
+	MaxConns          int32 // pool size for nodes without pool_max_conns (0 = pgx default)
+	MinConns          int32 // idle connections kept for nodes without pool_min_conns
+}
+
+// DefaultNodePoolConfig returns the pool manager defaults
+func DefaultNodePoolConfig() NodePoolConfig {
+	return NodePoolConfig{
+		ProbeInterval:     15 * time.Second,
+		ProbeTimeout:      3 * time.Second,
+		BreakerThreshold:  3,
+		BreakerBackoff:    30 * time.Second,
+		BreakerMaxBackoff: 10 * time.Minute,
+	}
+}
+
+// nodeSettings are the pool-relevant columns of a routable node
+type nodeSettings struct {
+	databaseURL string
+	maxConns    int32 // 0 = pgx default
+	minConns    int32
+}
+
+// nodePool is a cached node pool and its breaker
+type nodePool struct {
+	nodeID   string
+	settings nodeSettings
+	pool     *pgxpool.Pool
+	ready    chan struct{} // closed when the first connection attempt is over
+	err      error         // why the first attempt could not create a pool
+
+	connected bool // a probe (or the first connection) has succeeded
+	failures  int  // consecutive failed probes
+	backoff   time.Duration
+	openUntil time.Time
+
+	lag      time.Duration // replication lag at the last successful probe (0 for primaries)
+	lagKnown bool          // false for replicas that have not replayed anything yet
+	probedAt time.Time     // when lag was measured
+}
+
+// available reports whether requests may use the pool
+func (n *nodePool) available(threshold int) bool {
+	return n.connected && n.failures < threshold
+}
+
+// due reports whether the prober should check the pool; open breakers wait for their backoff
+func (n *nodePool) due(threshold int, now time.Time) bool {
+	return n.failures < threshold || !now.Before(n.openUntil)
+}
+
+// record applies a probe result to the breaker
+func (n *nodePool) record(err error, lag time.Duration, lagKnown bool, cfg NodePoolConfig, now time.Time) {
+	n.lag, n.lagKnown, n.probedAt = lag, lagKnown && err == nil, now
+	if err == nil {
+		if n.failures >= cfg.BreakerThreshold {
+			log.Printf("Federation node %s database recovered, breaker closed", n.nodeID)
+		}
+		n.connected, n.failures, n.backoff, n.openUntil = true, 0, 0, time.Time{}
+		return
+	}
+
+	n.failures++
+	if n.failures < cfg.BreakerThreshold {
+		return
+	}
+	if n.backoff == 0 {
+		n.backoff = cfg.BreakerBackoff
+	} else {
+		n.backoff = min(n.backoff*2, cfg.BreakerMaxBackoff)
+	}
+	n.openUntil = now.Add(n.backoff)
+	log.Printf("Federation node %s database unavailable (%d failures: %v), breaker open for %s",
+		n.nodeID, n.failures, err, n.backoff)
+}
+
+// NodePools caches node database pools behind health probes and circuit breakers
+type NodePools struct {
+	defaultDB *pgxpool.Pool
+	cfg       NodePoolConfig
+
+	mu    sync.Mutex
+	nodes map[string]*nodePool
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewNodePools creates the pool manager and starts its prober
+func NewNodePools(defaultDB *pgxpool.Pool, cfg NodePoolConfig) *NodePools {
+	p := &NodePools{
+		defaultDB: defaultDB,
+		cfg:       cfg,
+		nodes:     make(map[string]*nodePool),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Get returns the pool of a node database, connecting it on first use
+// Concurrent first requests for a node share one connection attempt.
+func (p *NodePools) Get(ctx context.Context, nodeID string) (*pgxpool.Pool, error) {
+	p.mu.Lock()
+	entry, ok := p.nodes[nodeID]
+	if !ok {
+		entry = &nodePool{nodeID: nodeID, ready: make(chan struct{})}
+		p.nodes[nodeID] = entry
+	}
+	p.mu.Unlock()
+	if !ok {
+		p.connect(ctx, entry)
+	}
+
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	if !entry.available(p.cfg.BreakerThreshold) {
+		return nil, fmt.Errorf("%w: %s", ErrNodeUnavailable, nodeID)
+	}
+	return entry.pool, nil
+}
+
+// Healthy reports whether a node may take requests: it is not connected yet, or its breaker is closed
+func (p *NodePools) Healthy(nodeID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.nodes[nodeID]
+	if !ok {
+		return true
+	}
+	select {
+	case <-entry.ready:
+		return entry.available(p.cfg.BreakerThreshold)
+	default:
+		return true
+	}
+}
+
+// lagAt bounds the replication lag at now: the lag measured by the last probe plus the time since,
+// since a replica that stopped replaying right after the probe has fallen behind by that much
+func (n *nodePool) lagAt(now time.Time) (time.Duration, bool) {
+	if !n.lagKnown {
+		return 0, false
+	}
+	return n.lag + max(now.Sub(n.probedAt), 0), true
+}
+
+// Lag returns the replication lag of a connected node database, bounded from its last probe
+func (p *NodePools) Lag(nodeID string) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.nodes[nodeID]
+	if !ok {
+		return 0, false
+	}
+	select {
+	case <-entry.ready:
+		return entry.lagAt(time.Now())
+	default:
+		return 0, false
+	}
+}
+
+// connect loads a node's settings and opens its pool; nodes without a pool leave the cache again
+// It runs detached from the request's cancellation, since other requests may be waiting on it.
+func (p *NodePools) connect(ctx context.Context, entry *nodePool) {
+	defer close(entry.ready)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), p.cfg.ProbeTimeout)
+	defer cancel()
+
+	settings, err := p.loadSettings(ctx, entry.nodeID)
+	var pool *pgxpool.Pool
+	if err == nil {
+		pool, err = newNodePool(ctx, entry.nodeID, settings)
+	}
+	if err != nil {
+		p.mu.Lock()
+		entry.err = err
+		if p.nodes[entry.nodeID] == entry {
+			delete(p.nodes, entry.nodeID)
+		}
+		p.mu.Unlock()
+		return
+	}
+
+	lag, lagKnown, pingErr := checkNodeDB(ctx, entry.nodeID, pool)
+	p.mu.Lock()
+	entry.settings, entry.pool = settings, pool
+	entry.record(pingErr, lag, lagKnown, p.cfg, time.Now())
+	metrics.SetRouterPoolCacheSize(len(p.nodes))
+	p.mu.Unlock()
+}
+
+// loadSettings reads the pool settings of a routable node
+func (p *NodePools) loadSettings(ctx context.Context, nodeID string) (nodeSettings, error) {
+	_, settings, err := p.scanSettings(p.defaultDB.QueryRow(ctx,
+		`SELECT `+nodeSettingsColumns+` FROM public.federation_nodes WHERE node_id = $1`,
+		nodeID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nodeSettings{}, fmt.Errorf("%w: %s not found", ErrNodeNotRoutable, nodeID)
+	}
+	if err != nil && !errors.Is(err, ErrNodeNotRoutable) {
+		return nodeSettings{}, fmt.Errorf("failed to load federation node: %w", err)
+	}
+	return settings, err
+}
+
+const nodeSettingsColumns = `node_id, COALESCE(database_url, ''), status, COALESCE(lifecycle_state, ''),
+	pool_max_conns, pool_min_conns`
+
+// scanSettings scans a federation_nodes row, failing with ErrNodeNotRoutable for nodes without a usable database
+func (p *NodePools) scanSettings(row pgx.Row) (string, nodeSettings, error) {
+	var nodeID, databaseURL, status, lifecycle string
+	var maxConns, minConns *int32
+	if err := row.Scan(&nodeID, &databaseURL, &status, &lifecycle, &maxConns, &minConns); err != nil {
+		return "", nodeSettings{}, err
+	}
+
+	switch {
+	case status != "active":
+		return nodeID, nodeSettings{}, fmt.Errorf("%w: %s is %s", ErrNodeNotRoutable, nodeID, status)
+	case lifecycle == "decommissioned":
+		return nodeID, nodeSettings{}, fmt.Errorf("%w: %s is decommissioned", ErrNodeNotRoutable, nodeID)
+	case databaseURL == "":
+		// Heartbeat-only nodes (e.g. Pi agents) are registered without a database
+		return nodeID, nodeSettings{}, fmt.Errorf("%w: %s has no database", ErrNodeNotRoutable, nodeID)
+	}
+
+	settings := nodeSettings{databaseURL: databaseURL, maxConns: p.cfg.MaxConns, minConns: p.cfg.MinConns}
+	if maxConns != nil {
+		settings.maxConns = *maxConns
+	}
+	if minConns != nil {
+		settings.minConns = *minConns
+	}
+	if settings.maxConns > 0 && settings.minConns > settings.maxConns {
+		settings.minConns = settings.maxConns
+	}
+	return nodeID, settings, nil
+}
+
+// newNodePool creates the pool of a node database; connections are opened lazily
+func newNodePool(ctx context.Context, nodeID string, settings nodeSettings) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(settings.databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse database URL: %w", err)
+	}
+	config.ConnConfig.Tracer = NewPoolTracer(nodeID)
+	if settings.maxConns > 0 {
+		config.MaxConns = settings.maxConns
+	}
+	if settings.minConns > 0 {
+		config.MinConns = min(settings.minConns, config.MaxConns)
+	}
+
+	db, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database connection: %w", err)
+	}
+	return db, nil
+}
+
+// replicationLagQuery measures how far a node database trails its primary: 0 for primaries, the age of
+// the last replayed transaction for replicas, and NULL for replicas that have not replayed one yet.
+// A replica counts as caught up (0) only while its WAL receiver is streaming and has replayed everything
+// received; with the receiver disconnected, receive and replay positions match but the replica falls
+// further behind every second. Reading the receiver status needs pg_read_all_stats (e.g. pg_monitor);
+// without it an idle replica reports the age of its last transaction and reads go to the writer.
+const replicationLagQuery = `SELECT (CASE
+	WHEN NOT pg_is_in_recovery() THEN 0
+	WHEN EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming')
+	     AND pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+END)::float8`
+
+// checkNodeDB pings a node database and measures its replication lag under its own span, so slow node
+// databases stand out
+func checkNodeDB(ctx context.Context, nodeID string, db *pgxpool.Pool) (lag time.Duration, lagKnown bool, err error) {
+	ctx, span := tracing.Start(ctx, "FederationRouter.ping", tracing.NodeID(nodeID))
+	defer func() { tracing.End(span, err) }()
+
+	var seconds *float64
+	if err = db.QueryRow(ctx, replicationLagQuery).Scan(&seconds); err != nil || seconds == nil {
+		return 0, false, err
+	}
+	return time.Duration(max(*seconds, 0) * float64(time.Second)), true, nil
+}
+
+// run probes cached pools until Close
+func (p *NodePools) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.cfg.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), p.cfg.ProbeInterval)
+			p.refresh(ctx)
+			p.probe(ctx)
+			cancel()
+		}
+	}
+}
+
+// connectedLocked lists the cached nodes whose first connection attempt is over
+func (p *NodePools) connectedLocked() []*nodePool {
+	entries := make([]*nodePool, 0, len(p.nodes))
+	for _, entry := range p.nodes {
+		select {
+		case <-entry.ready:
+			entries = append(entries, entry)
+		default:
+		}
+	}
+	return entries
+}
+
+// refresh re-reads node settings and evicts pools that are no longer routable or are out of date
+func (p *NodePools) refresh(ctx context.Context) {
+	p.mu.Lock()
+	entries := p.connectedLocked()
+	p.mu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.nodeID
+	}
+	rows, err := p.defaultDB.Query(ctx,
+		`SELECT `+nodeSettingsColumns+` FROM public.federation_nodes WHERE node_id = ANY($1)`,
+		ids,
+	)
+	if err != nil {
+		log.Printf("Failed to refresh federation node pool settings: %v", err)
+		return
+	}
+	current := make(map[string]nodeSettings, len(ids))
+	for rows.Next() {
+		nodeID, settings, err := p.scanSettings(rows)
+		if errors.Is(err, ErrNodeNotRoutable) {
+			continue
+		}
+		if err != nil {
+			rows.Close()
+			log.Printf("Failed to refresh federation node pool settings: %v", err)
+			return
+		}
+		current[nodeID] = settings
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to refresh federation node pool settings: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		settings, ok := current[entry.nodeID]
+		switch {
+		case !ok:
+			p.evict(entry, "no longer routable")
+		case settings != entry.settings:
+			p.evict(entry, "settings changed")
+		}
+	}
+}
+
+// evict drops a node's pool; the next request for the node connects it again
+func (p *NodePools) evict(entry *nodePool, reason string) {
+	p.mu.Lock()
+	if p.nodes[entry.nodeID] != entry {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.nodes, entry.nodeID)
+	metrics.SetRouterPoolCacheSize(len(p.nodes))
+	p.updateBreakersLocked()
+	p.mu.Unlock()
+
+	log.Printf("Evicted federation node %s database pool: %s", entry.nodeID, reason)
+	// Close waits for in-flight requests to release their connections
+	go entry.pool.Close()
+}
+
+// probe pings every pool that is due, concurrently
+func (p *NodePools) probe(ctx context.Context) {
+	now := time.Now()
+	p.mu.Lock()
+	var due []*nodePool
+	for _, entry := range p.connectedLocked() {
+		if entry.pool != nil && entry.due(p.cfg.BreakerThreshold, now) {
+			due = append(due, entry)
+		}
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, entry := range due {
+		wg.Add(1)
+		go func(entry *nodePool) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, p.cfg.ProbeTimeout)
+			lag, lagKnown, err := checkNodeDB(probeCtx, entry.nodeID, entry.pool)
+			cancel()
+
+			p.mu.Lock()
+			entry.record(err, lag, lagKnown, p.cfg, time.Now())
+			p.mu.Unlock()
+		}(entry)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	p.updateBreakersLocked()
+	p.mu.Unlock()
+}
+
+func (p *NodePools) updateBreakersLocked() {
+	open := 0
+	for _, entry := range p.nodes {
+		if entry.failures >= p.cfg.BreakerThreshold {
+			open++
+		}
+	}
+	metrics.SetRouterOpenBreakers(open)
+}
+
+// Close stops the prober and closes every cached pool
+func (p *NodePools) Close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+	<-p.done
+
+	p.mu.Lock()
+	entries := p.connectedLocked()
+	p.nodes = make(map[string]*nodePool)
+	metrics.SetRouterPoolCacheSize(0)
+	metrics.SetRouterOpenBreakers(0)
+	p.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.pool != nil {
+			entry.pool.Close()
+		}
+	}
+}
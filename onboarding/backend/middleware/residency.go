@@ -0,0 +1,288 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/metrics"
+)
+
+// Fail-closed data residency
+// When no node database can serve a tenant, FederationMiddleware used to fall back to the default
+// database. Tenants in strict residency mode are refused with 503 RESIDENCY_UNAVAILABLE instead, so
+// regulated data never lands outside the tenant's region. Strict mode is set per tenant by operators
+// (tenants.residency_strict) or derived from the onboarding config: residencyRequired, or a regulated
+// sensitivity. Decisions, fallbacks and refusals alike, are reported to the router's OnFallback hook.
+
+// ErrTenantNotFound is returned for residency lookups of unknown tenants
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// regulatedSensitivities are data sensitivities that must stay in the tenant's region
+var regulatedSensitivities = map[string]bool{
+	"PHI / HIPAA": true,
+	"PCI":         true,
+}
+
+// Residency policy reasons
+const (
+	ResidencyReasonOverride          = "override"
+	ResidencyReasonRequired          = "residency_required"
+	ResidencyReasonSensitivity       = "sensitivity"
+	ResidencyReasonPolicyUnavailable = "policy_unavailable"
+)
+
+// ResidencyPolicy is a tenant's data residency mode
+type ResidencyPolicy struct {
+	Strict            bool   `json:"strict"`
+	Reason            string `json:"reason,omitempty"`  // why the tenant is strict
+	Override          *bool  `json:"override"`          // operator setting; nil = derived from config
+	ResidencyRequired bool   `json:"residencyRequired"` // from the onboarding config
+	Sensitivity       string `json:"sensitivity,omitempty"`
+}
+
+// LoadResidencyPolicy reads a tenant's residency mode
+func LoadResidencyPolicy(ctx context.Context, db *pgxpool.Pool, tenantID string) (ResidencyPolicy, error) {
+	if _, err := uuid.Parse(tenantID); err != nil {
+		return ResidencyPolicy{}, ErrTenantNotFound
+	}
+	policy, err := scanResidencyPolicy(db.QueryRow(ctx,
+		`SELECT `+residencyColumns+` FROM public.tenants WHERE id = $1`,
+		tenantID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ResidencyPolicy{}, ErrTenantNotFound
+	}
+	if err != nil {
+		return ResidencyPolicy{}, fmt.Errorf("failed to load residency policy: %w", err)
+	}
+	return policy, nil
+}
+
+// SetResidencyOverride sets (or with nil clears) the operator override of a tenant's residency mode
+func SetResidencyOverride(ctx context.Context, db *pgxpool.Pool, tenantID string, strict *bool) (ResidencyPolicy, error) {
+	if _, err := uuid.Parse(tenantID); err != nil {
+		return ResidencyPolicy{}, ErrTenantNotFound
+	}
+	policy, err := scanResidencyPolicy(db.QueryRow(ctx,
+		`UPDATE public.tenants SET residency_strict = $2, updated_at = NOW()
+		 WHERE id = $1
+		 RETURNING `+residencyColumns,
+		tenantID, strict,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ResidencyPolicy{}, ErrTenantNotFound
+	}
+	if err != nil {
+		return ResidencyPolicy{}, fmt.Errorf("failed to update residency policy: %w", err)
+	}
+	return policy, nil
+}
+
+const residencyColumns = `residency_strict,
+	COALESCE(config_data->'dataRegionsConfig'->>'residencyRequired', '') = 'true',
+	COALESCE(config_data->'dataRegionsConfig'->>'sensitivity', '')`
+
+func scanResidencyPolicy(row pgx.Row) (ResidencyPolicy, error) {
+	var policy ResidencyPolicy
+	if err := row.Scan(&policy.Override, &policy.ResidencyRequired, &policy.Sensitivity); err != nil {
+		return policy, err
+	}
+	switch {
+	case policy.Override != nil:
+		policy.Strict, policy.Reason = *policy.Override, ResidencyReasonOverride
+	case policy.ResidencyRequired:
+		policy.Strict, policy.Reason = true, ResidencyReasonRequired
+	case regulatedSensitivities[policy.Sensitivity]:
+		policy.Strict, policy.Reason = true, ResidencyReasonSensitivity
+	}
+	if !policy.Strict {
+		policy.Reason = ""
+	}
+	return policy, nil
+}
+
+// Fallback reasons
+const (
+	FallbackNoNode          = "no_node"          // the tenant has no node database mapped
+	FallbackNodeUnavailable = "node_unavailable" // mapped node databases are down, inactive or breaker-open
+	FallbackError           = "error"            // routing lookup failed
+)
+
+// FallbackDecision describes a tenant request that could not be routed to a node database
+type FallbackDecision struct {
+	TenantID    string
+	Region      string
+	Reason      string // FallbackNoNode, FallbackNodeUnavailable or FallbackError
+	Cause       error
+	KnownTenant bool // false for tenant IDs not in public.tenants
+	Policy      ResidencyPolicy
+	Refused     bool // strict tenant: the request got 503 RESIDENCY_UNAVAILABLE
+}
+
+// Residency caching
+// Unmapped tenants are the common case, so their policy is cached instead of queried per request,
+// and each tenant's fallbacks are logged and reported to OnFallback once per fallbackReportInterval
+// per reason and outcome (the metrics still count every request).
+const (
+	residencyPolicyTTL     = 30 * time.Second
+	fallbackReportInterval = 10 * time.Minute
+	maxResidencyEntries    = 10000 // per cache; further tenants are looked up / reported uncached
+)
+
+// residencyState is a cached residency lookup (err is nil or ErrTenantNotFound)
+type residencyState struct {
+	policy    ResidencyPolicy
+	err       error
+	fetchedAt time.Time
+}
+
+// fallbackReportKey identifies the fallbacks reported together
+type fallbackReportKey struct {
+	tenantID string
+	reason   string
+	refused  bool
+}
+
+// OnFallback registers a hook called for fallback decisions (before the response is written),
+// at most once per fallbackReportInterval for the same tenant, reason and outcome
+func (fr *FederationRouter) OnFallback(hook func(ctx context.Context, decision FallbackDecision)) {
+	fr.onFallback = hook
+}
+
+// residencyPolicy returns a tenant's residency mode, cached for residencyPolicyTTL
+// Failed lookups are not cached, so a strict tenant is refused until its policy can be read.
+func (fr *FederationRouter) residencyPolicy(ctx context.Context, tenantID string, now time.Time) (ResidencyPolicy, error) {
+	fr.residencyMu.Lock()
+	cached, ok := fr.residencyStates[tenantID]
+	fr.residencyMu.Unlock()
+	if ok && now.Sub(cached.fetchedAt) < residencyPolicyTTL {
+		return cached.policy, cached.err
+	}
+
+	policy, err := LoadResidencyPolicy(ctx, fr.defaultDB, tenantID)
+	if err != nil && !errors.Is(err, ErrTenantNotFound) {
+		return policy, err
+	}
+
+	fr.residencyMu.Lock()
+	defer fr.residencyMu.Unlock()
+	if fr.residencyStates == nil {
+		fr.residencyStates = make(map[string]residencyState)
+	}
+	if len(fr.residencyStates) >= maxResidencyEntries {
+		for id, state := range fr.residencyStates {
+			if now.Sub(state.fetchedAt) >= residencyPolicyTTL {
+				delete(fr.residencyStates, id)
+			}
+		}
+	}
+	if _, ok := fr.residencyStates[tenantID]; ok || len(fr.residencyStates) < maxResidencyEntries {
+		fr.residencyStates[tenantID] = residencyState{policy: policy, err: err, fetchedAt: now}
+	}
+	return policy, err
+}
+
+// ForgetResidencyPolicy drops a tenant's cached residency mode after an operator changed it
+// Other backends pick up the change within residencyPolicyTTL.
+func (fr *FederationRouter) ForgetResidencyPolicy(tenantID string) {
+	fr.residencyMu.Lock()
+	defer fr.residencyMu.Unlock()
+	delete(fr.residencyStates, tenantID)
+}
+
+// shouldReportFallback reports whether a fallback decision is the first of its kind for the tenant
+// within fallbackReportInterval
+func (fr *FederationRouter) shouldReportFallback(decision FallbackDecision, now time.Time) bool {
+	key := fallbackReportKey{decision.TenantID, decision.Reason, decision.Refused}
+
+	fr.residencyMu.Lock()
+	defer fr.residencyMu.Unlock()
+	if last, ok := fr.fallbackReports[key]; ok && now.Sub(last) < fallbackReportInterval {
+		return false
+	}
+	if fr.fallbackReports == nil {
+		fr.fallbackReports = make(map[fallbackReportKey]time.Time)
+	}
+	if len(fr.fallbackReports) >= maxResidencyEntries {
+		for k, last := range fr.fallbackReports {
+			if now.Sub(last) >= fallbackReportInterval {
+				delete(fr.fallbackReports, k)
+			}
+		}
+	}
+	if len(fr.fallbackReports) < maxResidencyEntries {
+		fr.fallbackReports[key] = now
+	}
+	return true
+}
+
+// fallback decides whether a tenant request without a node database may use the default database
+// It writes the 503 response and returns false for strict tenants.
+func (fr *FederationRouter) fallback(w http.ResponseWriter, r *http.Request, tenantID, region string, cause error) bool {
+	ctx := r.Context()
+	now := time.Now()
+	decision := FallbackDecision{TenantID: tenantID, Region: region, Reason: fallbackReason(cause), Cause: cause}
+
+	policy, err := fr.residencyPolicy(ctx, tenantID, now)
+	switch {
+	case errors.Is(err, ErrTenantNotFound):
+		// Not a registered tenant: there is no regulated data to keep in region
+	case err != nil:
+		// The tenant's mode is unknown, so fail closed
+		log.Printf("Residency policy lookup failed for tenant %s: %v", tenantID, err)
+		decision.KnownTenant = true
+		decision.Policy = ResidencyPolicy{Strict: true, Reason: ResidencyReasonPolicyUnavailable}
+		decision.Refused = true
+	default:
+		decision.KnownTenant = true
+		decision.Policy = policy
+		decision.Refused = policy.Strict
+	}
+
+	report := fr.shouldReportFallback(decision, now)
+	if decision.Refused {
+		if report {
+			log.Printf("Federation routing refused for tenant %s (%s residency): %v", tenantID, decision.Policy.Reason, cause)
+		}
+		metrics.ObserveResidencyRefusal(decision.Reason)
+	} else {
+		if report {
+			log.Printf("Federation routing for tenant %s falls back to the default database: %v", tenantID, cause)
+		}
+		metrics.ObserveRouterFallback(decision.Reason)
+	}
+	if report && fr.onFallback != nil {
+		fr.onFallback(ctx, decision)
+	}
+
+	if decision.Refused {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    "RESIDENCY_UNAVAILABLE",
+			"message":  "no database in the tenant's residency region is available",
+			"tenantId": tenantID,
+		})
+		return false
+	}
+	return true
+}
+
+func fallbackReason(err error) string {
+	switch {
+	case errors.Is(err, ErrNoNodeRoute):
+		return FallbackNoNode
+	case errors.Is(err, ErrNodeUnavailable), errors.Is(err, ErrNodeNotRoutable):
+		return FallbackNodeUnavailable
+	}
+	return FallbackError
+}
@@ -0,0 +1,897 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Live tenant migration between federation nodes
+// A migration moves a tenant's rows (tenantTables) from the database it is routed to (its primary node,
+// or the default database for unmapped tenants) to a target node, in public.tenant_migrations:
+//   - copying: committed writes of the tenant's requests are mirrored to the target (dual-write, see
+//     PoolTracer) while the rows are copied from one consistent snapshot of the source
+//   - catching_up: copies are repeated until row counts and checksums of every table match
+//   - cutting_over: tenant writes are refused while a final copy is made and verified, then
+//     tenant_federation_map and federation_routing move to the target in one transaction
+//   - completed: the tenant is routed to the target; its rows are left in place on the source
+//
+// A migration that fails, or has not completed yet, can be rolled back: the target's copy is deleted and
+// routing is left untouched. Every status change is reported to the router's OnMigration hook.
+
+// MigrationStatus is a tenant migration state
+type MigrationStatus string
+
+const (
+	MigrationPending     MigrationStatus = "pending"
+	MigrationCopying     MigrationStatus = "copying"
+	MigrationCatchingUp  MigrationStatus = "catching_up"
+	MigrationCuttingOver MigrationStatus = "cutting_over"
+	MigrationCompleted   MigrationStatus = "completed"
+	MigrationFailed      MigrationStatus = "failed"
+	MigrationRollingBack MigrationStatus = "rolling_back"
+	MigrationRolledBack  MigrationStatus = "rolled_back"
+)
+
+// Tenant migration errors
+var (
+	ErrMigrationNotFound = errors.New("tenant migration not found")
+	ErrMigrationActive   = errors.New("tenant already has an unfinished migration")
+	ErrMigrationState    = errors.New("tenant migration is not in a valid state for this operation")
+	ErrMigrationTarget   = errors.New("invalid tenant migration target")
+
+	errMigrationAborted = errors.New("tenant migration was rolled back")
+)
+
+// Tenant migration tuning
+const (
+	migrationCopyPasses  = 5                      // catch-up copies before cutting over anyway
+	migrationSettle      = 2 * migrationStateTTL  // every backend has seen a status change
+	migrationDrain       = 5 * time.Second        // writes admitted before the freeze finish
+	migrationPassTimeout = 10 * time.Minute       // one copy and verification
+	migrationHeartbeat   = 15 * time.Second       // updated_at refresh while running
+	migrationStaleAfter  = 4 * migrationHeartbeat // an unrefreshed migration no longer holds tenant writes
+)
+
+// tenantTable is a table holding tenant rows on node databases
+type tenantTable struct {
+	name   string
+	column string // tenant key
+	key    string // primary key columns
+}
+
+// tenantTables are copied in this (foreign key) order; tenants comes first
+var tenantTables = []tenantTable{
+	{"tenants", "id", "id"},
+	{"tenant_policies", "tenant_id", "id"},
+	{"tenant_agents", "tenant_id", "tenant_id, agent_id"},
+	{"bootstrap_kits", "tenant_id", "id"},
+	{"bootstrap_kit_audit_log", "tenant_id", "id"},
+	{"activity_events", "tenant_id", "id"},
+	{"audit_log", "tenant_id", "id"},
+}
+
+// TableProgress compares one tenant table on source and target
+type TableProgress struct {
+	Table          string `json:"table"`
+	SourceRows     int64  `json:"sourceRows"`
+	TargetRows     int64  `json:"targetRows"`
+	SourceChecksum string `json:"sourceChecksum"`
+	TargetChecksum string `json:"targetChecksum"`
+	Match          bool   `json:"match"`
+}
+
+// MigrationProgress is the copy state of a tenant migration
+type MigrationProgress struct {
+	Passes     int             `json:"passes"`           // copies made so far
+	Tables     []TableProgress `json:"tables,omitempty"` // last verification
+	Verified   bool            `json:"verified"`         // every table matched at the last verification
+	VerifiedAt *time.Time      `json:"verifiedAt,omitempty"`
+}
+
+// TenantMigration is a move of a tenant's data to another node database
+type TenantMigration struct {
+	ID           string            `json:"id"`
+	TenantID     string            `json:"tenantId"`
+	SourceNodeID string            `json:"sourceNodeId"` // "default" for the default database
+	TargetNodeID string            `json:"targetNodeId"`
+	TargetRegion string            `json:"targetRegion"`
+	Status       MigrationStatus   `json:"status"`
+	Progress     MigrationProgress `json:"progress"`
+	Error        string            `json:"error,omitempty"`
+	RequestedBy  string            `json:"requestedBy,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	UpdatedAt    time.Time         `json:"updatedAt"`
+	CompletedAt  *time.Time        `json:"completedAt,omitempty"`
+}
+
+// migrationRun is a migration running on this backend
+type migrationRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// OnMigration registers a hook called on every status change of a tenant migration
+// The context carries the database the tenant is served from at that point (ContextKeyDB).
+func (fr *FederationRouter) OnMigration(hook func(ctx context.Context, migration TenantMigration)) {
+	fr.onMigration = hook
+}
+
+const migrationColumns = `id, tenant_id, source_node_id, target_node_id, target_region, status, progress,
+	COALESCE(error, ''), COALESCE(requested_by, ''), created_at, updated_at, completed_at`
+
+func scanMigration(row pgx.Row) (*TenantMigration, error) {
+	var m TenantMigration
+	var progress []byte
+	if err := row.Scan(&m.ID, &m.TenantID, &m.SourceNodeID, &m.TargetNodeID, &m.TargetRegion, &m.Status,
+		&progress, &m.Error, &m.RequestedBy, &m.CreatedAt, &m.UpdatedAt, &m.CompletedAt); err != nil {
+		return nil, err
+	}
+	if len(progress) > 0 {
+		if err := json.Unmarshal(progress, &m.Progress); err != nil {
+			return nil, fmt.Errorf("failed to decode migration progress: %w", err)
+		}
+	}
+	return &m, nil
+}
+
+// GetTenantMigration reads a tenant migration
+func GetTenantMigration(ctx context.Context, db *pgxpool.Pool, id string) (*TenantMigration, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrMigrationNotFound
+	}
+	m, err := scanMigration(db.QueryRow(ctx,
+		`SELECT `+migrationColumns+` FROM public.tenant_migrations WHERE id = $1`,
+		id,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMigrationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tenant migration: %w", err)
+	}
+	return m, nil
+}
+
+// ListTenantMigrations lists a tenant's migrations, newest first
+func ListTenantMigrations(ctx context.Context, db *pgxpool.Pool, tenantID string) ([]*TenantMigration, error) {
+	migrations := []*TenantMigration{}
+	if _, err := uuid.Parse(tenantID); err != nil {
+		return migrations, nil
+	}
+	rows, err := db.Query(ctx,
+		`SELECT `+migrationColumns+` FROM public.tenant_migrations
+		 WHERE tenant_id = $1
+		 ORDER BY created_at DESC`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanMigration(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tenant migrations: %w", err)
+		}
+		migrations = append(migrations, m)
+	}
+	return migrations, rows.Err()
+}
+
+// StartTenantMigration records a migration of a tenant to targetNodeID and runs it on this backend
+func (fr *FederationRouter) StartTenantMigration(ctx context.Context, tenantID, targetNodeID, requestedBy string) (*TenantMigration, error) {
+	if _, err := uuid.Parse(tenantID); err != nil {
+		return nil, ErrTenantNotFound
+	}
+	var exists bool
+	if err := fr.defaultDB.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM public.tenants WHERE id = $1)`,
+		tenantID,
+	).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to look up tenant: %w", err)
+	}
+	if !exists {
+		return nil, ErrTenantNotFound
+	}
+
+	// The source is the tenant's primary node, or the default database for unmapped tenants
+	sourceNodeID := defaultNodeID
+	err := fr.defaultDB.QueryRow(ctx,
+		`SELECT primary_node_id FROM public.tenant_federation_map WHERE tenant_id = $1`,
+		tenantID,
+	).Scan(&sourceNodeID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to look up tenant federation map: %w", err)
+	}
+	if targetNodeID == sourceNodeID {
+		return nil, fmt.Errorf("%w: tenant is already on node %s", ErrMigrationTarget, targetNodeID)
+	}
+
+	var targetRegion string
+	err = fr.defaultDB.QueryRow(ctx,
+		`SELECT region FROM public.federation_nodes WHERE node_id = $1`,
+		targetNodeID,
+	).Scan(&targetRegion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: node %s not found", ErrMigrationTarget, targetNodeID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up target node: %w", err)
+	}
+
+	source, err := fr.migrationDB(ctx, sourceNodeID)
+	if err != nil {
+		return nil, fmt.Errorf("source node %s: %w", sourceNodeID, err)
+	}
+	target, err := fr.pools.Get(ctx, targetNodeID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMigrationTarget, err)
+	}
+	// Rollback deletes the target's copy, which must never be the source or the control plane
+	if sameDatabase(target, source) || sameDatabase(target, fr.defaultDB) {
+		return nil, fmt.Errorf("%w: node %s uses the source or default database", ErrMigrationTarget, targetNodeID)
+	}
+
+	m, err := scanMigration(fr.defaultDB.QueryRow(ctx,
+		`INSERT INTO public.tenant_migrations (tenant_id, source_node_id, target_node_id, target_region, requested_by)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (tenant_id) WHERE status NOT IN ('completed', 'rolled_back') DO NOTHING
+		 RETURNING `+migrationColumns,
+		tenantID,
+		sourceNodeID,
+		targetNodeID,
+		targetRegion,
+		requestedBy,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMigrationActive
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record tenant migration: %w", err)
+	}
+
+	run := *m
+	fr.runMigration(&run)
+	return m, nil
+}
+
+// runMigration runs a migration in the background until it completes, fails or is rolled back
+func (fr *FederationRouter) runMigration(m *TenantMigration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &migrationRun{cancel: cancel, done: make(chan struct{})}
+	fr.migrationMu.Lock()
+	fr.migrationRuns[m.ID] = run
+	fr.migrationMu.Unlock()
+
+	go func() {
+		defer func() {
+			cancel()
+			fr.migrationMu.Lock()
+			delete(fr.migrationRuns, m.ID)
+			fr.migrationMu.Unlock()
+			close(run.done)
+		}()
+		go fr.heartbeatMigration(ctx, m.ID)
+
+		err := fr.migrate(ctx, m)
+		switch {
+		case err == nil:
+			log.Printf("Tenant %s migrated from %s to %s", m.TenantID, m.SourceNodeID, m.TargetNodeID)
+		case errors.Is(err, errMigrationAborted):
+			log.Printf("Tenant migration %s stopped: rolled back", m.ID)
+		default:
+			log.Printf("Tenant migration %s failed: %v", m.ID, err)
+			fr.failMigration(m, err, runningMigrationStatuses)
+		}
+	}()
+}
+
+// RecoverStaleMigrations marks failed the unfinished migrations whose engine stopped, such as after a
+// backend restart: they have not been refreshed for migrationStaleAfter. Tenant writes already went
+// back to the source when they went stale; a failed migration can be rolled back and started again.
+func (fr *FederationRouter) RecoverStaleMigrations(ctx context.Context) ([]*TenantMigration, error) {
+	rows, err := fr.defaultDB.Query(ctx,
+		`UPDATE public.tenant_migrations SET status = 'failed', error = $1, updated_at = NOW()
+		 WHERE status = ANY($2) AND updated_at < NOW() - make_interval(secs => $3)
+		 RETURNING `+migrationColumns,
+		fmt.Sprintf("migration engine stopped: not refreshed for %s", migrationStaleAfter),
+		runningMigrationStatuses,
+		migrationStaleAfter.Seconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover stale tenant migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var recovered []*TenantMigration
+	for rows.Next() {
+		m, err := scanMigration(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to recover stale tenant migrations: %w", err)
+		}
+		recovered = append(recovered, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to recover stale tenant migrations: %w", err)
+	}
+	for _, m := range recovered {
+		log.Printf("Tenant migration %s of tenant %s failed: %s", m.ID, m.TenantID, m.Error)
+		fr.forgetMigrationState(m.TenantID)
+		fr.notifyMigration(ctx, m)
+	}
+	return recovered, nil
+}
+
+// stopMigrationRun cancels a migration running on this backend and waits for it to stop
+func (fr *FederationRouter) stopMigrationRun(id string) {
+	fr.migrationMu.Lock()
+	run, ok := fr.migrationRuns[id]
+	fr.migrationMu.Unlock()
+	if ok {
+		run.cancel()
+		<-run.done
+	}
+}
+
+// heartbeatMigration keeps updated_at fresh so other backends keep honouring the migration
+func (fr *FederationRouter) heartbeatMigration(ctx context.Context, id string) {
+	ticker := time.NewTicker(migrationHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := fr.defaultDB.Exec(ctx,
+				`UPDATE public.tenant_migrations SET updated_at = NOW()
+				 WHERE id = $1 AND status IN ('pending', 'copying', 'catching_up', 'cutting_over')`,
+				id,
+			); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to refresh tenant migration %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// migrate copies the tenant's rows, catches up under dual-write and cuts over
+func (fr *FederationRouter) migrate(ctx context.Context, m *TenantMigration) error {
+	source, err := fr.migrationDB(ctx, m.SourceNodeID)
+	if err != nil {
+		return fmt.Errorf("source node %s: %w", m.SourceNodeID, err)
+	}
+	target, err := fr.pools.Get(ctx, m.TargetNodeID)
+	if err != nil {
+		return fmt.Errorf("target node %s: %w", m.TargetNodeID, err)
+	}
+
+	// Dual-write starts before the first copy, so the copy misses no write
+	if err := fr.advanceMigration(ctx, m, MigrationPending, MigrationCopying); err != nil {
+		return err
+	}
+	if err := sleepContext(ctx, migrationSettle); err != nil {
+		return err
+	}
+	for {
+		if err := fr.copyPass(ctx, m, source, target); err != nil {
+			return err
+		}
+		if m.Status == MigrationCopying {
+			if err := fr.advanceMigration(ctx, m, MigrationCopying, MigrationCatchingUp); err != nil {
+				return err
+			}
+		}
+		if m.Progress.Verified || m.Progress.Passes >= migrationCopyPasses {
+			break
+		}
+	}
+
+	// Hold tenant writes for the final copy; writes admitted before the freeze drain meanwhile
+	if err := fr.advanceMigration(ctx, m, MigrationCatchingUp, MigrationCuttingOver); err != nil {
+		return err
+	}
+	if err := sleepContext(ctx, migrationSettle+migrationDrain); err != nil {
+		return err
+	}
+	for attempt := 0; attempt < 2 && (attempt == 0 || !m.Progress.Verified); attempt++ {
+		if err := fr.copyPass(ctx, m, source, target); err != nil {
+			return err
+		}
+	}
+	if !m.Progress.Verified {
+		return fmt.Errorf("copy does not match the source: %s", mismatchedTables(m.Progress.Tables))
+	}
+	return fr.cutover(ctx, m)
+}
+
+// copyPass copies the tenant's rows to the target and verifies them
+func (fr *FederationRouter) copyPass(ctx context.Context, m *TenantMigration, source, target *pgxpool.Pool) error {
+	ctx, cancel := context.WithTimeout(ctx, migrationPassTimeout)
+	defer cancel()
+
+	if err := fr.copyTenant(ctx, m, source, target); err != nil {
+		return err
+	}
+	tables, err := compareTenant(ctx, source, target, m.TenantID)
+	if err != nil {
+		return err
+	}
+
+	progress := MigrationProgress{Passes: m.Progress.Passes + 1, Tables: tables, Verified: true}
+	for _, t := range tables {
+		progress.Verified = progress.Verified && t.Match
+	}
+	if progress.Verified {
+		now := time.Now()
+		progress.VerifiedAt = &now
+	}
+	return fr.saveProgress(ctx, m, progress)
+}
+
+// copyTenant replaces the tenant's rows on the target with a consistent snapshot of the source
+func (fr *FederationRouter) copyTenant(ctx context.Context, m *TenantMigration, source, target *pgxpool.Pool) error {
+	return withMirrorLock(ctx, fr.defaultDB, m.ID, func(int64) (int64, error) {
+		return copyTenantTables(ctx, source, target, m.TenantID, tenantTables, 0)
+	})
+}
+
+// withMirrorLock runs a copy of a migration under its mirror lock, an advisory lock on the default
+// database keyed by the migration id, so the copies and write mirror syncs of all backends take turns and
+// each reads a newer snapshot of the source than the one before. The copy is handed the source xmin the
+// last one reached (0 before the first copy) and returns its own, which is saved unless it is 0.
+func withMirrorLock(ctx context.Context, db *pgxpool.Pool, migrationID string, copy func(since int64) (int64, error)) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('tenant-migration-mirror:' || $1))`, migrationID); err != nil {
+			return fmt.Errorf("failed to lock migration mirror: %w", err)
+		}
+		var since *int64
+		if err := tx.QueryRow(ctx,
+			`SELECT mirror_xmin FROM public.tenant_migrations WHERE id = $1`,
+			migrationID,
+		).Scan(&since); err != nil {
+			return fmt.Errorf("failed to read migration mirror position: %w", err)
+		}
+		var from int64
+		if since != nil {
+			from = *since
+		}
+
+		xmin, err := copy(from)
+		if err != nil || xmin == 0 {
+			return err
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE public.tenant_migrations SET mirror_xmin = $2 WHERE id = $1`,
+			migrationID, xmin,
+		); err != nil {
+			return fmt.Errorf("failed to save migration mirror position: %w", err)
+		}
+		return nil
+	})
+}
+
+// copyTenantTables copies the tenant's rows of some tenant tables, in tenantTables order starting with
+// the tenants table, from a consistent snapshot of the source, and returns the snapshot's xmin: every
+// transaction before it is in the snapshot. With since 0 the target's rows are replaced; otherwise only
+// rows written by transactions from since on are upserted, and deleted rows wait for the next full copy.
+func copyTenantTables(ctx context.Context, source, target *pgxpool.Pool, tenantID string, tables []tenantTable, since int64) (int64, error) {
+	var xmin int64
+	snapshot := make([][]byte, len(tables))
+	err := pgx.BeginTxFunc(ctx, source, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		// The first statement takes the snapshot
+		if err := tx.QueryRow(ctx, `SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint`).Scan(&xmin); err != nil {
+			return fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		args := []interface{}{tenantID}
+		if since != 0 {
+			args = append(args, since)
+		}
+		for i, t := range tables {
+			if err := tx.QueryRow(ctx, snapshotQuery(t, since), args...).Scan(&snapshot[i]); err != nil {
+				return fmt.Errorf("failed to read %s: %w", t.name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	upserts := make([]string, len(tables))
+	for i, t := range tables {
+		if i > 0 && since == 0 {
+			continue
+		}
+		if upserts[i], err = upsertAssignments(ctx, target, t); err != nil {
+			return 0, err
+		}
+	}
+	err = pgx.BeginFunc(ctx, target, func(tx pgx.Tx) error {
+		if since == 0 {
+			// Child rows are replaced; the tenant row is upserted, since deleting it would cascade
+			for i := len(tables) - 1; i > 0; i-- {
+				t := tables[i]
+				if _, err := tx.Exec(ctx, fmt.Sprintf(`DELETE FROM public.%s WHERE %s = $1`, t.name, t.column), tenantID); err != nil {
+					return fmt.Errorf("failed to clear %s: %w", t.name, err)
+				}
+			}
+		}
+		for i, t := range tables {
+			sql := fmt.Sprintf(`INSERT INTO public.%s SELECT * FROM jsonb_populate_recordset(NULL::public.%s, $1::jsonb)`, t.name, t.name)
+			if upserts[i] != "" {
+				sql += fmt.Sprintf(` ON CONFLICT (%s) DO UPDATE SET %s`, t.key, upserts[i])
+			}
+			if _, err := tx.Exec(ctx, sql, snapshot[i]); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", t.name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return xmin, nil
+}
+
+// snapshotQuery reads the tenant's rows of a table ($1) as a JSON array; with since, only rows whose
+// transaction ($2, a 64-bit xid) is not older, compared by age since row xmins are 32-bit
+func snapshotQuery(t tenantTable, since int64) string {
+	sql := fmt.Sprintf(`SELECT COALESCE(jsonb_agg(to_jsonb(t)), '[]'::jsonb) FROM public.%s t WHERE t.%s = $1`, t.name, t.column)
+	if since == 0 {
+		return sql
+	}
+	return sql + ` AND age(t.xmin) <= age(($2::bigint % 4294967296)::text::xid)`
+}
+
+// upsertAssignments builds the SET list overwriting every non-key column of a table
+func upsertAssignments(ctx context.Context, db *pgxpool.Pool, t tenantTable) (string, error) {
+	rows, err := db.Query(ctx,
+		`SELECT column_name::text FROM information_schema.columns
+		 WHERE table_schema = 'public' AND table_name = $1 AND column_name <> ALL($2)
+		 ORDER BY ordinal_position`,
+		t.name,
+		strings.Split(t.key, ", "),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s columns: %w", t.name, err)
+	}
+	columns, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s columns: %w", t.name, err)
+	}
+
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		ident := pgx.Identifier{column}.Sanitize()
+		assignments[i] = ident + " = EXCLUDED." + ident
+	}
+	return strings.Join(assignments, ", "), nil
+}
+
+// tableSum is the row count and checksum of a tenant table
+type tableSum struct {
+	rows     int64
+	checksum string
+}
+
+// sumTenant counts and checksums the tenant's rows per table. updated_at is left out: triggers maintain
+// it on each database. Rows are rendered as JSON in UTC, so column order and session time zone do not matter.
+func sumTenant(ctx context.Context, db *pgxpool.Pool, tenantID string) ([]tableSum, error) {
+	sums := make([]tableSum, len(tenantTables))
+	err := pgx.BeginTxFunc(ctx, db, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SET LOCAL TIME ZONE 'UTC'`); err != nil {
+			return err
+		}
+		for i, t := range tenantTables {
+			if err := tx.QueryRow(ctx,
+				fmt.Sprintf(`SELECT count(*), COALESCE(md5(string_agg(h, '' ORDER BY h)), '')
+				 FROM (SELECT md5((to_jsonb(t) - 'updated_at')::text) AS h FROM public.%s t WHERE t.%s = $1) r`, t.name, t.column),
+				tenantID,
+			).Scan(&sums[i].rows, &sums[i].checksum); err != nil {
+				return fmt.Errorf("failed to checksum %s: %w", t.name, err)
+			}
+		}
+		return nil
+	})
+	return sums, err
+}
+
+// compareTenant verifies the target's copy by row counts and checksums
+func compareTenant(ctx context.Context, source, target *pgxpool.Pool, tenantID string) ([]TableProgress, error) {
+	sourceSums, err := sumTenant(ctx, source, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	targetSums, err := sumTenant(ctx, target, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
+
+	tables := make([]TableProgress, len(tenantTables))
+	for i, t := range tenantTables {
+		src, dst := sourceSums[i], targetSums[i]
+		tables[i] = TableProgress{
+			Table:          t.name,
+			SourceRows:     src.rows,
+			TargetRows:     dst.rows,
+			SourceChecksum: src.checksum,
+			TargetChecksum: dst.checksum,
+			Match:          src == dst,
+		}
+	}
+	return tables, nil
+}
+
+func mismatchedTables(tables []TableProgress) string {
+	var names []string
+	for _, t := range tables {
+		if !t.Match {
+			names = append(names, fmt.Sprintf("%s (%d/%d rows)", t.Table, t.TargetRows, t.SourceRows))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// cutover moves the tenant's routing to the target in one transaction with the migration's completion
+func (fr *FederationRouter) cutover(ctx context.Context, m *TenantMigration) error {
+	var completed *TenantMigration
+	err := pgx.BeginFunc(ctx, fr.defaultDB, func(tx pgx.Tx) error {
+		// A concurrent rollback wins if it got here first
+		var status MigrationStatus
+		if err := tx.QueryRow(ctx,
+			`SELECT status FROM public.tenant_migrations WHERE id = $1 FOR UPDATE`,
+			m.ID,
+		).Scan(&status); err != nil {
+			return fmt.Errorf("failed to lock tenant migration: %w", err)
+		}
+		if status != MigrationCuttingOver {
+			return errMigrationAborted
+		}
+
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO public.tenant_federation_map (tenant_id, primary_node_id, primary_region)
+			 VALUES ($1, $2, $3)
+			 ON CONFLICT (tenant_id) DO UPDATE
+			 SET primary_node_id = EXCLUDED.primary_node_id, primary_region = EXCLUDED.primary_region`,
+			m.TenantID, m.TargetNodeID, m.TargetRegion,
+		); err != nil {
+			return fmt.Errorf("failed to update tenant federation map: %w", err)
+		}
+
+		if m.SourceNodeID != defaultNodeID {
+			// Routing rows of the source move to the target; replicas of the source's region no longer
+			// follow the tenant's data, so they are parked
+			var sourceRegion string
+			if err := tx.QueryRow(ctx,
+				`SELECT region FROM public.federation_nodes WHERE node_id = $1`,
+				m.SourceNodeID,
+			).Scan(&sourceRegion); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("failed to look up source node: %w", err)
+			}
+			if _, err := tx.Exec(ctx,
+				`DELETE FROM public.federation_routing s
+				 WHERE s.tenant_id = $1 AND s.node_id = $2
+				   AND EXISTS (SELECT 1 FROM public.federation_routing t
+				               WHERE t.tenant_id = $1 AND t.node_id = $3 AND t.region = $4)`,
+				m.TenantID, m.SourceNodeID, m.TargetNodeID, m.TargetRegion,
+			); err != nil {
+				return fmt.Errorf("failed to update federation routing: %w", err)
+			}
+			if _, err := tx.Exec(ctx,
+				`UPDATE public.federation_routing SET node_id = $3, region = $4
+				 WHERE tenant_id = $1 AND node_id = $2`,
+				m.TenantID, m.SourceNodeID, m.TargetNodeID, m.TargetRegion,
+			); err != nil {
+				return fmt.Errorf("failed to update federation routing: %w", err)
+			}
+			if sourceRegion != "" {
+				if _, err := tx.Exec(ctx,
+					`UPDATE public.federation_routing SET weight = 0
+					 WHERE tenant_id = $1 AND region = $2 AND is_primary = false AND node_id <> $3`,
+					m.TenantID, sourceRegion, m.TargetNodeID,
+				); err != nil {
+					return fmt.Errorf("failed to park source replicas: %w", err)
+				}
+			}
+		}
+
+		var err error
+		completed, err = scanMigration(tx.QueryRow(ctx,
+			`UPDATE public.tenant_migrations
+			 SET status = 'completed', updated_at = NOW(), completed_at = NOW()
+			 WHERE id = $1
+			 RETURNING `+migrationColumns,
+			m.ID,
+		))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	*m = *completed
+	fr.forgetMigrationState(m.TenantID)
+	fr.notifyMigration(ctx, m)
+	return nil
+}
+
+// RollbackTenantMigration stops a migration that has not completed and deletes the target's copy
+// Routing is left on the source. A rollback that fails leaves the migration failed, to be retried.
+func (fr *FederationRouter) RollbackTenantMigration(ctx context.Context, id string) (*TenantMigration, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrMigrationNotFound
+	}
+	m, err := scanMigration(fr.defaultDB.QueryRow(ctx,
+		`UPDATE public.tenant_migrations
+		 SET status = 'rolling_back', error = NULL, updated_at = NOW()
+		 WHERE id = $1 AND status NOT IN ('completed', 'rolled_back')
+		 RETURNING `+migrationColumns,
+		id,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := GetTenantMigration(ctx, fr.defaultDB, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrMigrationState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to roll back tenant migration: %w", err)
+	}
+	fr.stopMigrationRun(id)
+	fr.forgetMigrationState(m.TenantID)
+	fr.notifyMigration(ctx, m)
+
+	target, err := fr.pools.Get(ctx, m.TargetNodeID)
+	if err == nil {
+		err = deleteTenantCopy(ctx, target, m.TenantID)
+	}
+	if err != nil {
+		err = fmt.Errorf("rollback failed: %w", err)
+		fr.failMigration(m, err, []string{string(MigrationRollingBack)})
+		return m, err
+	}
+
+	rolledBack, err := scanMigration(fr.defaultDB.QueryRow(ctx,
+		`UPDATE public.tenant_migrations
+		 SET status = 'rolled_back', updated_at = NOW(), completed_at = NOW()
+		 WHERE id = $1 AND status = 'rolling_back'
+		 RETURNING `+migrationColumns,
+		id,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to roll back tenant migration: %w", err)
+	}
+	fr.notifyMigration(ctx, rolledBack)
+	return rolledBack, nil
+}
+
+// deleteTenantCopy deletes the tenant's rows from a migration target
+func deleteTenantCopy(ctx context.Context, target *pgxpool.Pool, tenantID string) error {
+	return pgx.BeginFunc(ctx, target, func(tx pgx.Tx) error {
+		for i := len(tenantTables) - 1; i >= 0; i-- {
+			t := tenantTables[i]
+			if _, err := tx.Exec(ctx, fmt.Sprintf(`DELETE FROM public.%s WHERE %s = $1`, t.name, t.column), tenantID); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", t.name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// advanceMigration moves a migration from one status to the next; a rollback in between aborts it
+func (fr *FederationRouter) advanceMigration(ctx context.Context, m *TenantMigration, from, to MigrationStatus) error {
+	updated, err := scanMigration(fr.defaultDB.QueryRow(ctx,
+		`UPDATE public.tenant_migrations SET status = $3, updated_at = NOW()
+		 WHERE id = $1 AND status = $2
+		 RETURNING `+migrationColumns,
+		m.ID, from, to,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errMigrationAborted
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update tenant migration: %w", err)
+	}
+	*m = *updated
+	fr.forgetMigrationState(m.TenantID)
+	fr.notifyMigration(ctx, m)
+	return nil
+}
+
+// saveProgress records a copy pass
+func (fr *FederationRouter) saveProgress(ctx context.Context, m *TenantMigration, progress MigrationProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	tag, err := fr.defaultDB.Exec(ctx,
+		`UPDATE public.tenant_migrations SET progress = $3, updated_at = NOW()
+		 WHERE id = $1 AND status = $2`,
+		m.ID, m.Status, data,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record migration progress: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errMigrationAborted
+	}
+	m.Progress = progress
+	return nil
+}
+
+// runningMigrationStatuses are the statuses of a migration whose engine is (or should be) running
+var runningMigrationStatuses = []string{
+	string(MigrationPending), string(MigrationCopying), string(MigrationCatchingUp), string(MigrationCuttingOver),
+}
+
+// failMigration marks a migration in one of the from statuses failed; tenant writes go to the source again
+func (fr *FederationRouter) failMigration(m *TenantMigration, cause error, from []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	failed, err := scanMigration(fr.defaultDB.QueryRow(ctx,
+		`UPDATE public.tenant_migrations SET status = 'failed', error = $2, updated_at = NOW()
+		 WHERE id = $1 AND status = ANY($3)
+		 RETURNING `+migrationColumns,
+		m.ID, cause.Error(), from,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to record failure of tenant migration %s: %v", m.ID, err)
+		return
+	}
+	*m = *failed
+	fr.forgetMigrationState(m.TenantID)
+	fr.notifyMigration(ctx, m)
+}
+
+// notifyMigration reports a status change, with the database now serving the tenant in the context
+func (fr *FederationRouter) notifyMigration(ctx context.Context, m *TenantMigration) {
+	if fr.onMigration == nil {
+		return
+	}
+	nodeID := m.SourceNodeID
+	if m.Status == MigrationCompleted {
+		nodeID = m.TargetNodeID
+	}
+	ctx = context.WithValue(ctx, ContextKeyTenantID, m.TenantID)
+	if db, err := fr.migrationDB(ctx, nodeID); err == nil {
+		ctx = context.WithValue(ctx, ContextKeyDB, db)
+	}
+	fr.onMigration(context.WithoutCancel(ctx), *m)
+}
+
+// migrationDB returns the pool of a migration's source or target
+func (fr *FederationRouter) migrationDB(ctx context.Context, nodeID string) (*pgxpool.Pool, error) {
+	if nodeID == defaultNodeID {
+		return fr.defaultDB, nil
+	}
+	return fr.pools.Get(ctx, nodeID)
+}
+
+// sameDatabase reports whether two pools connect to the same database
+func sameDatabase(a, b *pgxpool.Pool) bool {
+	ca, cb := a.Config().ConnConfig, b.Config().ConnConfig
+	return ca.Host == cb.Host && ca.Port == cb.Port && ca.Database == cb.Database
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestMirroredTable(t *testing.T) {
+	tests := []struct {
+		sql   string
+		table string
+		ok    bool
+	}{
+		{"INSERT INTO public.activity_events (tenant_id, type) VALUES ($1, $2)", "activity_events", true},
+		{"  insert into tenant_agents (tenant_id, agent_id) values ($1, $2)", "tenant_agents", true},
+		{"UPDATE public.tenants SET status = $2 WHERE id = $1", "tenants", true},
+		{"UPDATE \"bootstrap_kits\" SET used = true", "bootstrap_kits", true},
+		{"DELETE FROM public.tenant_policies WHERE tenant_id = $1", "tenant_policies", true},
+		{"\n\tDELETE FROM audit_log WHERE tenant_id = $1", "audit_log", true},
+		{"SELECT * FROM public.tenants WHERE id = $1", "", false},
+		{"INSERT INTO public.federation_nodes (node_id) VALUES ($1)", "", false},
+		{"UPDATE public.tenant_migrations SET status = 'failed'", "", false},
+		{"WITH x AS (SELECT 1) INSERT INTO public.tenants SELECT * FROM x", "", false},
+	}
+	for _, tt := range tests {
+		table, ok := mirroredTable(tt.sql)
+		if table != tt.table || ok != tt.ok {
+			t.Errorf("mirroredTable(%q) = %q, %v; want %q, %v", tt.sql, table, ok, tt.table, tt.ok)
+		}
+	}
+}
+
+func TestMirroredTables(t *testing.T) {
+	names := func(tables []tenantTable) []string {
+		var out []string
+		for _, t := range tables {
+			out = append(out, t.name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name    string
+		written map[string]bool
+		want    []string
+	}{
+		{"tenant row only", map[string]bool{"tenants": true}, []string{"tenants"}},
+		{"child table brings the tenant row", map[string]bool{"activity_events": true}, []string{"tenants", "activity_events"}},
+		{"tenantTables order", map[string]bool{"audit_log": true, "tenant_policies": true, "bootstrap_kits": true},
+			[]string{"tenants", "tenant_policies", "bootstrap_kits", "audit_log"}},
+	}
+	for _, tt := range tests {
+		if got := names(mirroredTables(tt.written)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: mirroredTables() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTransactionOutcome(t *testing.T) {
+	tests := []struct {
+		name          string
+		txStatus      byte
+		tag           string
+		err           error
+		wantEnded     bool
+		wantCommitted bool
+	}{
+		{"statement outside a transaction", 'I', "INSERT 0 1", nil, true, true},
+		{"failed statement outside a transaction", 'I', "", errors.New("duplicate key"), true, false},
+		{"statement inside a transaction", 'T', "UPDATE 1", nil, false, false},
+		{"failed statement inside a transaction", 'E', "", errors.New("duplicate key"), false, false},
+		{"commit", 'I', "COMMIT", nil, true, true},
+		{"rollback", 'I', "ROLLBACK", nil, true, false},
+		{"commit of a failed transaction", 'I', "ROLLBACK", nil, true, false},
+	}
+	for _, tt := range tests {
+		ended, committed := transactionOutcome(tt.txStatus, tt.tag, tt.err)
+		if ended != tt.wantEnded || committed != tt.wantCommitted {
+			t.Errorf("%s: transactionOutcome() = %v, %v; want %v, %v", tt.name, ended, committed, tt.wantEnded, tt.wantCommitted)
+		}
+	}
+}
+
+func TestPoolTracerPending(t *testing.T) {
+	tracer := NewPoolTracer("node-a")
+	conn, other := &pgx.Conn{}, &pgx.Conn{}
+	first := &writeMirror{migrationID: "m-1"}
+	second := &writeMirror{migrationID: "m-2"}
+
+	tracer.note(conn, mirroredQuery{mirror: first, table: "tenants"})
+	tracer.note(conn, mirroredQuery{mirror: first, table: "activity_events"})
+	tracer.note(conn, mirroredQuery{mirror: second, table: "audit_log"})
+	tracer.note(other, mirroredQuery{mirror: first, table: "tenant_agents"})
+
+	pending := tracer.take(conn)
+	if len(pending) != 2 {
+		t.Fatalf("take() = %d mirrors, want 2", len(pending))
+	}
+	if want := map[string]bool{"tenants": true, "activity_events": true}; pending[0].mirror != first || !reflect.DeepEqual(pending[0].written, want) {
+		t.Errorf("first mirror = %+v, want %v", pending[0], want)
+	}
+	if pending[1].mirror != second || !pending[1].written["audit_log"] {
+		t.Errorf("second mirror = %+v", pending[1])
+	}
+	if again := tracer.take(conn); len(again) != 0 {
+		t.Errorf("take() after take() = %v", again)
+	}
+	if rest := tracer.take(other); len(rest) != 1 || !rest[0].written["tenant_agents"] {
+		t.Errorf("take(other) = %+v", rest)
+	}
+}
+
+func TestMismatchedTables(t *testing.T) {
+	tables := []TableProgress{
+		{Table: "tenants", SourceRows: 1, TargetRows: 1, Match: true},
+		{Table: "activity_events", SourceRows: 40, TargetRows: 38},
+		{Table: "audit_log", SourceRows: 5, TargetRows: 5},
+	}
+	if got, want := mismatchedTables(tables), "activity_events (38/40 rows), audit_log (5/5 rows)"; got != want {
+		t.Fatalf("mismatchedTables() = %q, want %q", got, want)
+	}
+}
+
+func TestSnapshotQuerySince(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+	table := tenantTable{name: fmt.Sprintf("mirror_test_%d", time.Now().UnixNano()), column: "tenant_id", key: "id"}
+	if _, err := pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE public.%s (id INT PRIMARY KEY, tenant_id TEXT NOT NULL, v TEXT)`, table.name)); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	t.Cleanup(func() { pool.Exec(context.Background(), fmt.Sprintf(`DROP TABLE public.%s`, table.name)) })
+	exec := func(sql string) {
+		t.Helper()
+		if _, err := pool.Exec(ctx, fmt.Sprintf(sql, table.name)); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+	ids := func(since int64) []int {
+		t.Helper()
+		args := []interface{}{"t1"}
+		if since != 0 {
+			args = append(args, since)
+		}
+		var rows []struct {
+			ID int `json:"id"`
+		}
+		if err := pool.QueryRow(ctx, snapshotQuery(table, since), args...).Scan(&rows); err != nil {
+			t.Fatalf("snapshot query: %v", err)
+		}
+		out := []int{}
+		for _, r := range rows {
+			out = append(out, r.ID)
+		}
+		sort.Ints(out)
+		return out
+	}
+
+	exec(`INSERT INTO public.%s VALUES (1, 't1', 'a'), (2, 't1', 'b'), (3, 't2', 'c')`)
+	var xmin int64
+	if err := pool.QueryRow(ctx, `SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint`).Scan(&xmin); err != nil {
+		t.Fatalf("read xmin: %v", err)
+	}
+	exec(`UPDATE public.%s SET v = 'b2' WHERE id = 2`)
+	exec(`INSERT INTO public.%s VALUES (4, 't1', 'd')`)
+
+	if got, want := ids(0), []int{1, 2, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("full snapshot = %v, want %v", got, want)
+	}
+	if got, want := ids(xmin), []int{2, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot since %d = %v, want %v", xmin, got, want)
+	}
+}
+
+func TestRunningMigrationStatuses(t *testing.T) {
+	running := map[string]bool{}
+	for _, s := range runningMigrationStatuses {
+		running[s] = true
+	}
+	tests := []struct {
+		status MigrationStatus
+		want   bool
+	}{
+		{MigrationPending, true},
+		{MigrationCopying, true},
+		{MigrationCatchingUp, true},
+		{MigrationCuttingOver, true},
+		{MigrationCompleted, false},
+		{MigrationFailed, false},
+		{MigrationRollingBack, false},
+		{MigrationRolledBack, false},
+	}
+	for _, tt := range tests {
+		if running[string(tt.status)] != tt.want {
+			t.Errorf("running(%s) = %v, want %v", tt.status, !tt.want, tt.want)
+		}
+	}
+}
+
+func TestMigrationGate(t *testing.T) {
+	// Node databases whose breaker is open, so no request gets a write mirror
+	ready := make(chan struct{})
+	close(ready)
+	fr := &FederationRouter{
+		pools: &NodePools{cfg: NodePoolConfig{BreakerThreshold: 3}, nodes: map[string]*nodePool{
+			"node-a": {nodeID: "node-a", ready: ready},
+			"node-b": {nodeID: "node-b", ready: ready},
+		}},
+		migrationStates: map[string]migrationState{
+			"tenant-1": {id: "m-1", source: "node-a", target: "node-b", status: MigrationCuttingOver, fetchedAt: time.Now()},
+			"tenant-2": {fetchedAt: time.Now()},
+			"tenant-3": {id: "m-3", source: "node-a", target: "node-b", status: MigrationCopying, fetchedAt: time.Now()},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		tenantID string
+		nodeID   string
+		method   string
+		wantPass bool
+	}{
+		{"write to the source during cutover", "tenant-1", "node-a", http.MethodPost, false},
+		{"delete on the source during cutover", "tenant-1", "node-a", http.MethodDelete, false},
+		{"read from the source during cutover", "tenant-1", "node-a", http.MethodGet, true},
+		{"write routed to another node", "tenant-1", "node-c", http.MethodPost, true},
+		{"tenant not migrating", "tenant-2", "node-a", http.MethodPost, true},
+		{"write while copying to an unavailable target", "tenant-3", "node-a", http.MethodPost, true},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, "/api/tenants/x", nil)
+		ctx, pass := fr.migrationGate(context.Background(), w, r, tt.tenantID, tt.nodeID)
+		if pass != tt.wantPass {
+			t.Errorf("%s: migrationGate() passed = %v, want %v", tt.name, pass, tt.wantPass)
+			continue
+		}
+		if pass {
+			if ctx.Value(contextKeyWriteMirror) != nil {
+				t.Errorf("%s: request carries a write mirror", tt.name)
+			}
+			continue
+		}
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" || body["error"] != "TENANT_MIGRATING" {
+			t.Errorf("%s: response %d %v", tt.name, w.Code, body)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/silentsage432/sage-gitops/onboarding/backend/metrics"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/tracing"
+)
+
+// Weighted and read-replica routing
+// federation_routing lists a tenant's nodes per region. Writer rows (is_primary = true) share tenants in
+// proportion to their weight: each tenant sticks to one healthy writer (weighted rendezvous hashing), so
+// its writes land in a single database and only move when that node is down or parked; weight 0 parks a
+// node. Replica rows
+// (is_primary = false) serve reads: a handler declares read-only intent with WithReadOnly (or a route with
+// the ReadOnly middleware), and GetDBFromContext then returns a replica in the region of the request's
+// writer node whose replication lag is within the staleness bound, or the writer database if none is.
+// Only GET and HEAD requests are ever served from replicas.
+
+// DefaultReplicaMaxStaleness is the replication lag a replica may have when handlers give no bound
+const DefaultReplicaMaxStaleness = 10 * time.Second
+
+// defaultRouteWeight is the weight of a tenant's primary node without a federation_routing row
+const defaultRouteWeight = 100
+
+const (
+	// ContextKeyReadOnly is the context key for the read-only intent (the staleness bound)
+	ContextKeyReadOnly ContextKey = "read_only"
+
+	contextKeyReadRoute ContextKey = "read_route"
+)
+
+// SetReplicaMaxStaleness sets the staleness bound of read-only requests that do not give their own
+func (fr *FederationRouter) SetReplicaMaxStaleness(maxStaleness time.Duration) {
+	fr.replicaMaxStaleness = maxStaleness
+}
+
+// WithReadOnly declares that the caller only reads, so GetDBFromContext may return a replica database
+// whose replication lag is at most maxStaleness (0 = the router's bound)
+func WithReadOnly(ctx context.Context, maxStaleness time.Duration) context.Context {
+	return context.WithValue(ctx, ContextKeyReadOnly, maxStaleness)
+}
+
+// ReadOnly declares read-only intent for every request of a route (see WithReadOnly)
+func ReadOnly(maxStaleness time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithReadOnly(r.Context(), maxStaleness)))
+		})
+	}
+}
+
+// routeCandidate is a federation_routing row
+type routeCandidate struct {
+	nodeID string
+	weight int
+}
+
+// loadCandidates lists a tenant's writer (or replica) nodes in a region
+func (fr *FederationRouter) loadCandidates(ctx context.Context, tenantID, region string, writers bool) ([]routeCandidate, error) {
+	rows, err := fr.defaultDB.Query(ctx,
+		`SELECT node_id, weight
+		 FROM public.federation_routing
+		 WHERE tenant_id = $1 AND region = $2 AND is_primary = $3`,
+		tenantID,
+		region,
+		writers,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up federation routing: %w", err)
+	}
+	defer rows.Close()
+
+	var candidates []routeCandidate
+	for rows.Next() {
+		var c routeCandidate
+		if err := rows.Scan(&c.nodeID, &c.weight); err != nil {
+			return nil, fmt.Errorf("failed to look up federation routing: %w", err)
+		}
+		candidates = append(candidates, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to look up federation routing: %w", err)
+	}
+	return candidates, nil
+}
+
+// orderCandidates returns the replicas to try, in order: healthy nodes in weighted random order, then
+// nodes whose breaker is open. Parked nodes (weight 0) are left out.
+func (fr *FederationRouter) orderCandidates(candidates []routeCandidate) []routeCandidate {
+	healthy, down := fr.partitionCandidates(candidates)
+	return append(weightedShuffle(healthy), down...)
+}
+
+// orderWriters returns a tenant's writer nodes to try, in order: healthy nodes in the tenant's weighted
+// rendezvous order, then nodes whose breaker is open. Parked nodes (weight 0) are left out.
+func (fr *FederationRouter) orderWriters(tenantID string, candidates []routeCandidate) []routeCandidate {
+	healthy, down := fr.partitionCandidates(candidates)
+	return append(weightedRendezvous(tenantID, healthy), down...)
+}
+
+// partitionCandidates splits unparked candidates into healthy nodes and nodes whose breaker is open
+func (fr *FederationRouter) partitionCandidates(candidates []routeCandidate) (healthy, down []routeCandidate) {
+	for _, c := range candidates {
+		switch {
+		case c.weight <= 0:
+		case fr.pools.Healthy(c.nodeID):
+			healthy = append(healthy, c)
+		default:
+			down = append(down, c)
+		}
+	}
+	return healthy, down
+}
+
+// weightedRendezvous orders candidates by their weighted rendezvous score for a tenant. The order is the
+// same on every request and backend; across tenants a node comes first in proportion to its weight, and
+// adding or removing a node only moves the tenants that node gains or loses.
+func weightedRendezvous(tenantID string, candidates []routeCandidate) []routeCandidate {
+	type scored struct {
+		c     routeCandidate
+		score float64
+	}
+	ranked := make([]scored, len(candidates))
+	for i, c := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(tenantID))
+		h.Write([]byte{0})
+		h.Write([]byte(c.nodeID))
+		u := (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53) // uniform in (0, 1)
+		ranked[i] = scored{c, -float64(c.weight) / math.Log(u)}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].c.nodeID < ranked[j].c.nodeID
+	})
+	ordered := make([]routeCandidate, len(ranked))
+	for i, r := range ranked {
+		ordered[i] = r.c
+	}
+	return ordered
+}
+
+// weightedShuffle orders candidates by weighted sampling without replacement: a node with twice the weight
+// is twice as likely to come first
+func weightedShuffle(candidates []routeCandidate) []routeCandidate {
+	total := 0
+	for _, c := range candidates {
+		total += c.weight
+	}
+	remaining := append([]routeCandidate(nil), candidates...)
+	ordered := make([]routeCandidate, 0, len(candidates))
+	for len(remaining) > 0 {
+		n, i := rand.IntN(total), 0
+		for n >= remaining[i].weight {
+			n -= remaining[i].weight
+			i++
+		}
+		ordered = append(ordered, remaining[i])
+		total -= remaining[i].weight
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+	return ordered
+}
+
+func hasCandidate(candidates []routeCandidate, nodeID string) bool {
+	for _, c := range candidates {
+		if c.nodeID == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// mix64 is the splitmix64 finalizer; FNV alone leaves IDs that differ only in their last byte with
+// nearly equal high bits
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// readRoute picks the replica of a request's read-only queries on the first GetDBFromContext that asks
+// for one, so all reads of a request see the same database
+type readRoute struct {
+	fr          *FederationRouter
+	tenantID    string
+	region      string
+	writeDB     *pgxpool.Pool
+	writeNodeID string
+
+	once      sync.Once
+	replica   *pgxpool.Pool
+	replicaID string
+	lag       time.Duration
+}
+
+// db returns the replica if its lag is within maxStaleness, else the writer database
+func (rr *readRoute) db(ctx context.Context, maxStaleness time.Duration) *pgxpool.Pool {
+	if maxStaleness <= 0 {
+		maxStaleness = rr.fr.replicaMaxStaleness
+	}
+	rr.once.Do(func() { rr.pick(ctx, maxStaleness) })
+	if rr.replica != nil && rr.lag <= maxStaleness {
+		return rr.replica
+	}
+	return rr.writeDB
+}
+
+// pick selects a replica of the writer node's region by weight among those within maxStaleness
+func (rr *readRoute) pick(ctx context.Context, maxStaleness time.Duration) {
+	ctx, span := tracing.Start(ctx, "FederationRouter.resolveReplica",
+		tracing.TenantID(rr.tenantID), attribute.String("sage.region", rr.region))
+	candidates, err := rr.fr.loadCandidates(ctx, rr.tenantID, rr.region, false)
+	if err != nil {
+		log.Printf("Replica routing for tenant %s falls back to node %s: %v", rr.tenantID, rr.writeNodeID, err)
+	}
+	for _, c := range rr.fr.orderCandidates(candidates) {
+		db, err := rr.fr.getNodeDB(ctx, c.nodeID)
+		if err != nil {
+			continue
+		}
+		if lag, ok := rr.fr.pools.Lag(c.nodeID); ok && lag <= maxStaleness {
+			rr.replica, rr.replicaID, rr.lag = db, c.nodeID, lag
+			break
+		}
+	}
+
+	if rr.replica != nil {
+		span.SetAttributes(tracing.NodeID(rr.replicaID))
+		metrics.ObserveRouterRead("replica")
+	} else {
+		span.SetAttributes(tracing.NodeID(rr.writeNodeID))
+		metrics.ObserveRouterRead("primary")
+	}
+	tracing.End(span, err)
+}
+
+// readDBFromContext returns the database of a read-only request, nil without read-only intent
+func readDBFromContext(ctx context.Context) *pgxpool.Pool {
+	maxStaleness, ok := ctx.Value(ContextKeyReadOnly).(time.Duration)
+	if !ok {
+		return nil
+	}
+	route, ok := ctx.Value(contextKeyReadRoute).(*readRoute)
+	if !ok || route == nil {
+		return nil
+	}
+	return route.db(ctx, maxStaleness)
+}
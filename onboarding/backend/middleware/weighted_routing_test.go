@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWeightedShuffle(t *testing.T) {
+	candidates := []routeCandidate{{"a", 60}, {"b", 20}, {"c", 20}}
+
+	const draws = 20000
+	first := map[string]int{}
+	for i := 0; i < draws; i++ {
+		ordered := weightedShuffle(candidates)
+		if len(ordered) != len(candidates) {
+			t.Fatalf("weightedShuffle() returned %d candidates, want %d", len(ordered), len(candidates))
+		}
+		ids := []string{ordered[0].nodeID, ordered[1].nodeID, ordered[2].nodeID}
+		sort.Strings(ids)
+		if ids[0] != "a" || ids[1] != "b" || ids[2] != "c" {
+			t.Fatalf("weightedShuffle() = %v, not a permutation", ordered)
+		}
+		first[ordered[0].nodeID]++
+	}
+
+	for _, tt := range []struct {
+		nodeID string
+		share  float64
+	}{{"a", 0.6}, {"b", 0.2}, {"c", 0.2}} {
+		if got := float64(first[tt.nodeID]) / draws; math.Abs(got-tt.share) > 0.03 {
+			t.Errorf("node %s came first in %.3f of draws, want about %.1f", tt.nodeID, got, tt.share)
+		}
+	}
+	if got := weightedShuffle(nil); len(got) != 0 {
+		t.Errorf("weightedShuffle(nil) = %v", got)
+	}
+}
+
+func TestOrderCandidates(t *testing.T) {
+	ready := make(chan struct{})
+	close(ready)
+	fr := &FederationRouter{pools: &NodePools{
+		cfg: NodePoolConfig{BreakerThreshold: 3},
+		nodes: map[string]*nodePool{
+			"up":   {nodeID: "up", ready: ready, connected: true},
+			"down": {nodeID: "down", ready: ready, connected: true, failures: 3},
+		},
+	}}
+
+	tests := []struct {
+		name       string
+		candidates []routeCandidate
+		want       []string
+	}{
+		{"healthy before open breakers", []routeCandidate{{"down", 100}, {"up", 1}}, []string{"up", "down"}},
+		{"unprobed nodes count as healthy", []routeCandidate{{"down", 100}, {"new", 1}}, []string{"new", "down"}},
+		{"parked nodes are left out", []routeCandidate{{"up", 0}, {"new", 10}, {"down", 0}}, []string{"new"}},
+		{"all parked", []routeCandidate{{"up", 0}}, nil},
+	}
+	for _, tt := range tests {
+		got := fr.orderCandidates(tt.candidates)
+		var ids []string
+		for _, c := range got {
+			ids = append(ids, c.nodeID)
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("%s: orderCandidates() = %v, want %v", tt.name, ids, tt.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("%s: orderCandidates() = %v, want %v", tt.name, ids, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestWeightedRendezvous(t *testing.T) {
+	candidates := []routeCandidate{{"a", 60}, {"b", 20}, {"c", 20}}
+
+	const tenants = 20000
+	first := map[string]int{}
+	moved := 0
+	for i := 0; i < tenants; i++ {
+		tenantID := fmt.Sprintf("tenant-%d", i)
+		ordered := weightedRendezvous(tenantID, candidates)
+		if len(ordered) != len(candidates) {
+			t.Fatalf("weightedRendezvous() returned %d candidates, want %d", len(ordered), len(candidates))
+		}
+		// The same tenant always gets the same order, whatever the input order
+		reversed := []routeCandidate{candidates[2], candidates[1], candidates[0]}
+		if again := weightedRendezvous(tenantID, reversed); again[0] != ordered[0] || again[1] != ordered[1] {
+			t.Fatalf("tenant %s: order %v, then %v", tenantID, ordered, again)
+		}
+		first[ordered[0].nodeID]++
+
+		// Removing node c only moves the tenants that were on c
+		without := weightedRendezvous(tenantID, candidates[:2])
+		if without[0] != ordered[0] {
+			if ordered[0].nodeID != "c" {
+				t.Fatalf("tenant %s moved from %s to %s when c was removed", tenantID, ordered[0].nodeID, without[0].nodeID)
+			}
+			moved++
+		}
+	}
+
+	for _, tt := range []struct {
+		nodeID string
+		share  float64
+	}{{"a", 0.6}, {"b", 0.2}, {"c", 0.2}} {
+		if got := float64(first[tt.nodeID]) / tenants; math.Abs(got-tt.share) > 0.03 {
+			t.Errorf("node %s came first for %.3f of tenants, want about %.1f", tt.nodeID, got, tt.share)
+		}
+	}
+	if moved != first["c"] {
+		t.Errorf("%d tenants moved when c was removed, want the %d on c", moved, first["c"])
+	}
+}
+
+func TestNodePoolLagAt(t *testing.T) {
+	probed := time.Now()
+	tests := []struct {
+		name      string
+		pool      nodePool
+		at        time.Time
+		want      time.Duration
+		wantKnown bool
+	}{
+		{"just probed", nodePool{lag: 2 * time.Second, lagKnown: true, probedAt: probed}, probed, 2 * time.Second, true},
+		{"probe age is added", nodePool{lag: 2 * time.Second, lagKnown: true, probedAt: probed}, probed.Add(9 * time.Second), 11 * time.Second, true},
+		{"caught-up primary", nodePool{lagKnown: true, probedAt: probed}, probed.Add(15 * time.Second), 15 * time.Second, true},
+		{"unknown lag", nodePool{probedAt: probed}, probed, 0, false},
+	}
+	for _, tt := range tests {
+		got, known := tt.pool.lagAt(tt.at)
+		if got != tt.want || known != tt.wantKnown {
+			t.Errorf("%s: lagAt() = %s, %v; want %s, %v", tt.name, got, known, tt.want, tt.wantKnown)
+		}
+	}
+}
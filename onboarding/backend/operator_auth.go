@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// OCT scope required for federation administration (key rotation, revocation, node lifecycle)
+const scopeFederationAdmin = "federation.admin"
+
+// How long a WebAuthn verification can be exchanged for an OCT
+const octTicketTTL = 2 * time.Minute
+
+// Scope of event stream tickets; it grants nothing but opening GET /federation/events/stream
+const scopeEventStream = "federation.events.stream"
+
+// How long an event stream ticket can be used to (re)connect
+const eventStreamTicketTTL = 5 * time.Minute
+
+var errInvalidOCTTicket = errors.New("invalid or expired OCT ticket")
+
+// octScopes returns the scopes of an OCT issued to an operator
+// federation.admin is only granted to the operators listed in FEDERATION_ADMIN_OPERATORS and
+// intent.admin to those in INTENT_ADMIN_OPERATORS (both comma separated).
+func octScopes(operator string) []string {
+	scopes := []string{"tenant.create", "agent.plan.create", "bootstrap.sign", scopeIntentRequest, scopeIntentApprove}
+	if isDesignatedOperator(operator, os.Getenv("FEDERATION_ADMIN_OPERATORS")) {
+		scopes = append(scopes, scopeFederationAdmin)
+	}
+	if isDesignatedOperator(operator, os.Getenv("INTENT_ADMIN_OPERATORS")) {
+		scopes = append(scopes, scopeIntentAdmin)
+	}
+	return scopes
+}
+
+// isDesignatedOperator reports whether an operator is in a comma-separated list of designated operators
+func isDesignatedOperator(operator, designated string) bool {
+	if operator == "" {
+		return false
+	}
+	for _, name := range strings.Split(designated, ",") {
+		if strings.TrimSpace(name) == operator {
+			return true
+		}
+	}
+	return false
+}
+
+// issueOCTTicket records that an operator just completed a WebAuthn verification and returns the
+// single-use ticket that /rho2/auth/issue exchanges for an OCT naming that operator
+func issueOCTTicket(ctx context.Context, operator string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	db := getDB(ctx)
+	_, _ = db.Exec(ctx, "DELETE FROM public.oct_issue_tickets WHERE expires_at <= $1", now)
+	if _, err := db.Exec(ctx,
+		"INSERT INTO public.oct_issue_tickets (ticket_hash, operator, created_at, expires_at) VALUES ($1, $2, $3, $4)",
+		octTicketHash(ticket), operator, now, now.Add(octTicketTTL),
+	); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// redeemOCTTicket consumes a ticket and returns the operator it was issued to
+func redeemOCTTicket(ctx context.Context, ticket string) (string, error) {
+	var operator string
+	var live bool
+	err := getDB(ctx).QueryRow(ctx,
+		"DELETE FROM public.oct_issue_tickets WHERE ticket_hash = $1 RETURNING operator, expires_at > $2",
+		octTicketHash(ticket), time.Now(),
+	).Scan(&operator, &live)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !live) {
+		return "", errInvalidOCTTicket
+	}
+	if err != nil {
+		return "", err
+	}
+	return operator, nil
+}
+
+// octTicketHash returns the hex SHA-256 under which a ticket is stored
+func octTicketHash(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireOperatorScope verifies the OCT bearer token and checks that it carries the given scope.
+// On failure it writes the error response and returns ok=false.
+func requireOperatorScope(w http.ResponseWriter, r *http.Request, scope string) (jwt.MapClaims, bool) {
+	// Force bypass for development
+	if os.Getenv("BYPASS_OCT") == "true" {
+		return jwt.MapClaims{"sub": "dev-operator"}, true
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" || len(authHeader) < 7 || authHeader[:7] != "Bearer " {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	tokenString := authHeader[7:]
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return &privateKey.PublicKey, nil
+	})
+
+	if err != nil || !token.Valid {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	scopes, _ := claims["scopes"].([]interface{})
+	for _, s := range scopes {
+		if s == scope {
+			return claims, true
+		}
+	}
+
+	http.Error(w, "Insufficient permissions", http.StatusForbidden)
+	return nil, false
+}
+
+// operatorID returns the operator subject from OCT claims
+func operatorID(claims jwt.MapClaims) string {
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+// issueEventStreamTicket signs a short-lived token that lets EventSource clients, which cannot
+// send an Authorization header, open the federation event stream with ?ticket=
+func issueEventStreamTicket(operator string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(eventStreamTicketTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":    operator,
+		"iat":    now.Unix(),
+		"exp":    expiresAt.Unix(),
+		"scopes": []string{scopeEventStream},
+		"type":   "event_stream_ticket",
+	})
+	ticket, err := token.SignedString(privateKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return ticket, expiresAt, nil
+}
+
+// requireEventStreamAccess accepts either an OCT bearer token with federation.admin or a
+// ?ticket= issued by issueEventStreamTicket. On failure it writes the error response.
+func requireEventStreamAccess(w http.ResponseWriter, r *http.Request) bool {
+	ticket := r.URL.Query().Get("ticket")
+	if ticket == "" || os.Getenv("BYPASS_OCT") == "true" {
+		_, ok := requireOperatorScope(w, r, scopeFederationAdmin)
+		return ok
+	}
+
+	token, err := jwt.Parse(ticket, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return &privateKey.PublicKey, nil
+	})
+	if err != nil || !token.Valid {
+		http.Error(w, "Invalid ticket", http.StatusUnauthorized)
+		return false
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if claims["type"] != "event_stream_ticket" {
+		http.Error(w, "Invalid ticket", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
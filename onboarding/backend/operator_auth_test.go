@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsDesignatedOperator(t *testing.T) {
+	tests := []struct {
+		operator   string
+		designated string
+		want       bool
+	}{
+		{"alice", "alice", true},
+		{"bob", "alice, bob", true},
+		{"carol", "alice,bob", false},
+		{"alice", "", false},
+		{"", "alice,", false},
+		{"ali", "alice", false},
+	}
+	for _, tt := range tests {
+		if got := isDesignatedOperator(tt.operator, tt.designated); got != tt.want {
+			t.Errorf("isDesignatedOperator(%q, %q) = %v, want %v", tt.operator, tt.designated, got, tt.want)
+		}
+	}
+}
+
+func TestOCTScopes(t *testing.T) {
+	t.Setenv("FEDERATION_ADMIN_OPERATORS", "alice, carol")
+	t.Setenv("INTENT_ADMIN_OPERATORS", "alice")
+	base := []string{"tenant.create", "agent.plan.create", "bootstrap.sign", "intent.request", "intent.approve"}
+	tests := []struct {
+		operator string
+		want     []string
+	}{
+		{"bob", base},
+		{"carol", append(append([]string{}, base...), "federation.admin")},
+		{"alice", append(append([]string{}, base...), "federation.admin", "intent.admin")},
+	}
+	for _, tt := range tests {
+		if got := octScopes(tt.operator); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("octScopes(%s) = %v, want %v", tt.operator, got, tt.want)
+		}
+	}
+}
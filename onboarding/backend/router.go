@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -8,7 +10,11 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/silentsage432/sage-gitops/onboarding/backend/federation"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/internal/consent"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/metrics"
 	fedmw "github.com/silentsage432/sage-gitops/onboarding/backend/middleware"
+	"github.com/silentsage432/sage-gitops/onboarding/backend/tracing"
 )
 
 // SetupRouter sets up all routes including federation routes
@@ -17,18 +23,28 @@ func SetupRouter(dbPool *pgxpool.Pool) chi.Router {
 
 	// Middleware
 	r.Use(chimw.Logger)
+	r.Use(tracing.HTTP)
+	r.Use(metrics.HTTP)
 	r.Use(chimw.Recoverer)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000", "https://localhost:3000"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Tenant-ID", "X-Region", "X-Federation-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Tenant-ID", "X-Region", "X-Federation-Token", "Last-Event-ID", "traceparent", "tracestate"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	// Initialize federation router
-	federationRouter := fedmw.NewFederationRouter(dbPool)
+	federationRouter := fedmw.NewFederationRouter(dbPool, nodePoolConfig())
+	federationRouter.OnFallback(recordRoutingFallback)
+	federationRouter.OnMigration(recordMigrationEvent)
+	federationRouter.SetReplicaMaxStaleness(envDuration("FEDERATION_REPLICA_MAX_STALENESS", fedmw.DefaultReplicaMaxStaleness))
+
+	// Tenant migrations left unfinished by a backend that stopped are marked failed, ready for rollback
+	if _, err := federationRouter.RecoverStaleMigrations(context.Background()); err != nil {
+		log.Printf("Warning: %v", err)
+	}
 
 	// Phase 13.1: Federation Auth Handshake API (stateless)
 	// These routes are public - no session required
@@ -40,11 +56,43 @@ func SetupRouter(dbPool *pgxpool.Pool) chi.Router {
 		r.Post("/node/join", handleFederationNodeJoin)
 		r.Get("/export", handleFederationExport)
 		r.Post("/import", handleFederationImport)
+		r.Get("/keys", handleListFederationKeys)
+	})
+
+	// Federation administration (operator OCT with federation.admin scope)
+	r.Route("/api/federation/admin", func(r chi.Router) {
+		r.Post("/keys/rotate", handleRotateFederationKey)
+		r.Get("/revocations", handleListRevocations)
+		r.Post("/revocations", handleCreateRevocation)
+		r.Delete("/revocations/{revocationId}", handleDeleteRevocation)
+		r.Get("/tokens", handleListFederationTokens)
+		r.Post("/nodes/{nodeId}/decommission", handleDecommissionNode)
+		r.Put("/nodes/{nodeId}/relay", handleSetNodeRelay)
+		r.Get("/events", handleQueryFederationEvents)
+		r.Get("/telemetry", handleQueryTelemetry)
+		r.Get("/bus/handlers", handleListBusHandlers)
+		r.Get("/dead-letters", handleListDeadLetters)
+		r.Get("/commands", handleListCommands)
+		r.Post("/commands", handleEnqueueCommand)
+		r.Get("/commands/{commandId}", handleGetCommand)
+		r.Get("/jobs", handleListJobs)
+		r.Get("/jobs/{jobId}", handleGetJob)
+		r.Post("/jobs/{jobId}/cancel", handleCancelJob)
+		r.Get("/nodes/{nodeId}/jobs", handleListJobs)
+		r.Get("/tenants/{tenantId}/jobs", handleListJobs)
+		r.Get("/tenants/{tenantId}/lifecycle", handleGetLifecycleSettings)
+		r.Put("/tenants/{tenantId}/lifecycle", handleSetLifecycleSettings)
+		r.Get("/tenants/{tenantId}/residency", handleGetTenantResidency)
+		r.Put("/tenants/{tenantId}/residency", handleSetTenantResidency(federationRouter))
+		r.Get("/tenants/{tenantId}/migrations", handleListTenantMigrations)
+		r.Post("/tenants/{tenantId}/migrations", handleStartTenantMigration(federationRouter))
+		r.Get("/migrations/{migrationId}", handleGetTenantMigration)
+		r.Post("/migrations/{migrationId}/rollback", handleRollbackTenantMigration(federationRouter))
 	})
 
 	// Phase 13.2: All protected federation APIs require valid session
 	r.Route("/api/federation", func(r chi.Router) {
-		r.Use(fedmw.RequireFederationSession)
+		r.Use(fedmw.RequireFederationSession(federation.AudienceAPI))
 		
 		// Protected federation endpoints go here
 		// Example: r.Post("/sync", handleFederationSync)
@@ -59,21 +107,24 @@ func SetupRouter(dbPool *pgxpool.Pool) chi.Router {
 	})
 
 	// Phase 14.5: Federation Events API
-	// Expose event stream for UI awareness and dashboards
+	// Expose event stream for UI awareness and dashboards (the live stream requires federation.admin)
 	r.Route("/federation/events", func(r chi.Router) {
 		r.Get("/", handleFederationEvents)
+		r.Get("/stream", handleFederationEventStream)
+		r.Post("/stream/ticket", handleIssueEventStreamTicket)
 	})
 
 	// Phase 13.11: Agent Federation API
 	// Agents authenticate using Ed25519 federation tokens
 	r.Route("/api/federation/agents", func(r chi.Router) {
-		r.Use(fedmw.RequireAgentFederation)
+		r.Use(fedmw.RequireAgentFederation(federation.AudienceAgents))
 		
 		// Agent telemetry endpoint
 		r.Post("/telemetry", handleAgentTelemetry)
 		
 		// Agent command endpoint (for receiving commands)
 		r.Post("/commands", handleAgentCommands)
+		r.Post("/commands/{commandId}/ack", handleAgentCommandAck)
 		
 		// Agent job endpoint (for job execution)
 		r.Post("/jobs", handleAgentJobs)
@@ -85,7 +136,7 @@ func SetupRouter(dbPool *pgxpool.Pool) chi.Router {
 	// Phase 13.11: Federation Bus
 	// Secure messaging endpoint for the federation backplane
 	r.Route("/federation/bus", func(r chi.Router) {
-		r.Use(fedmw.RequireAgentFederation)
+		r.Use(fedmw.RequireAgentFederation(federation.AudienceBus))
 		r.Post("/", handleFederationBus)
 	})
 
@@ -109,8 +160,9 @@ func SetupRouter(dbPool *pgxpool.Pool) chi.Router {
 			r.Get("/identity/providers", handleListIdentityProviders)
 			r.Post("/identity/validate", handleValidateIdentity)
 
-			// Dashboard endpoints
+			// Dashboard endpoints (read-only: may be served from the region's replicas)
 			r.Route("/tenants/{tenantId}", func(r chi.Router) {
+				r.Use(fedmw.ReadOnly(0))
 				r.Get("/telemetry", handleTenantTelemetry)
 				r.Get("/status", handleTenantStatus)
 				r.Get("/activity", handleTenantActivity)
@@ -118,7 +170,7 @@ func SetupRouter(dbPool *pgxpool.Pool) chi.Router {
 			})
 
 			// Bootstrap audit log
-			r.Get("/bootstrap/audit/{tenantId}", handleBootstrapAudit)
+			r.With(fedmw.ReadOnly(0)).Get("/bootstrap/audit/{tenantId}", handleBootstrapAudit)
 		})
 	})
 
@@ -170,6 +222,20 @@ func SetupRouter(dbPool *pgxpool.Pool) chi.Router {
 	// Returns pending intent approvals (read-only, no execution)
 	r.Get("/api/intent/pending", GetPendingIntent)
 
+	// Intent approval workflow: create, then approve / deny / expire (each decision audited)
+	// Approval is WebAuthn-signed per operator and needs the M-of-N quorum of the action's policy
+	r.Route("/api/intent/approvals", func(r chi.Router) {
+		r.Post("/", handleCreateIntent)
+		r.Get("/", handleListIntents)
+		r.Get("/{intentId}", handleGetIntent)
+		r.Post("/{intentId}/approve/begin", handleBeginIntentApproval)
+		r.Post("/{intentId}/approve", handleApproveIntent)
+		r.Post("/{intentId}/deny", intentDecisionHandler(consent.Deny))
+		r.Post("/{intentId}/expire", intentDecisionHandler(consent.Expire))
+	})
+	r.Get("/api/intent/policies", handleListIntentPolicies)
+	r.Put("/api/intent/policies/{action}", handleSetIntentPolicy)
+
 	// Cursor Patch: WebAuthn Registration Routes
 	// Phase 3: Add WebAuthn Verification Routes
 	r.Route("/api/auth", func(r chi.Router) {
@@ -188,6 +254,9 @@ func SetupRouter(dbPool *pgxpool.Pool) chi.Router {
 		w.Write([]byte("OK"))
 	})
 
+	// Prometheus metrics
+	r.Get("/metrics", metrics.Handler().ServeHTTP)
+
 	return r
 }
 
@@ -0,0 +1,196 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenTelemetry tracing for the onboarding backend
+// Spans go to an OTLP/HTTP collector when OTEL_EXPORTER_OTLP_ENDPOINT (or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set, otherwise to the file named by OTEL_TRACES_FILE, otherwise
+// to stdout. OTEL_SDK_DISABLED=true turns tracing off. Trace context travels as W3C traceparent/tracestate.
+// Like metrics, nothing here imports the rest of the backend.
+
+const (
+	serviceName = "sage-onboarding-backend"
+	tracerName  = "github.com/silentsage432/sage-gitops/onboarding/backend"
+)
+
+// Span attributes shared across the backend
+const (
+	TenantIDKey = attribute.Key("sage.tenant.id")
+	NodeIDKey   = attribute.Key("sage.node.id")
+)
+
+var (
+	tracer     = otel.Tracer(tracerName)
+	propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+)
+
+// Init installs the global tracer provider and propagator
+// The returned function flushes pending spans and closes the exporter.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		log.Println("Tracing disabled (OTEL_SDK_DISABLED)")
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closeOutput, target, err := newExporter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(), // OTEL_SERVICE_NAME, OTEL_RESOURCE_ATTRIBUTES
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	log.Printf("Tracing spans exported to %s", target)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeOutput != nil {
+			err = errors.Join(err, closeOutput())
+		}
+		return err
+	}, nil
+}
+
+// newExporter picks the span exporter from the environment
+func newExporter(ctx context.Context) (sdktrace.SpanExporter, func() error, string, error) {
+	if endpoint := otlpEndpoint(); endpoint != "" {
+		// Endpoint, headers, TLS and timeout are read from the standard OTEL_EXPORTER_OTLP_* variables
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		return exporter, nil, "OTLP collector " + endpoint, nil
+	}
+
+	if path := os.Getenv("OTEL_TRACES_FILE"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, "", fmt.Errorf("failed to create file trace exporter: %w", err)
+		}
+		return exporter, f.Close, "file " + path, nil
+	}
+
+	exporter, err := stdouttrace.New()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to create stdout trace exporter: %w", err)
+	}
+	return exporter, nil, "stdout", nil
+}
+
+func otlpEndpoint() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+}
+
+// Start starts an internal span as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartSpan starts a span with explicit options (kind, links, ...)
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, opts...)
+}
+
+// End ends a span, marking it failed when err is set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// EndOutcome ends a span with an outcome attribute; any outcome other than "ok" marks it failed
+func EndOutcome(span trace.Span, outcome string) {
+	span.SetAttributes(attribute.String("sage.outcome", outcome))
+	if outcome != "ok" {
+		span.SetStatus(codes.Error, outcome)
+	}
+	span.End()
+}
+
+// TenantID and NodeID build the shared span attributes
+func TenantID(id string) attribute.KeyValue { return TenantIDKey.String(id) }
+func NodeID(id string) attribute.KeyValue   { return NodeIDKey.String(id) }
+
+type routeKey struct{}
+
+type route struct {
+	tenantID string
+	nodeID   string
+}
+
+// WithRoute records the tenant and node a request was routed to
+// Both are set on the current span and on every query span started under the returned context.
+func WithRoute(ctx context.Context, tenantID, nodeID string) context.Context {
+	r := route{tenantID: tenantID, nodeID: nodeID}
+	trace.SpanFromContext(ctx).SetAttributes(r.attributes()...)
+	return context.WithValue(ctx, routeKey{}, r)
+}
+
+func (r route) attributes() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if r.tenantID != "" {
+		attrs = append(attrs, TenantID(r.tenantID))
+	}
+	if r.nodeID != "" {
+		attrs = append(attrs, NodeID(r.nodeID))
+	}
+	return attrs
+}
+
+// ContextWithTraceParent returns ctx carrying the remote span context of a W3C traceparent/tracestate pair
+// ok is false (and ctx unchanged) when traceparent is missing or malformed.
+func ContextWithTraceParent(ctx context.Context, traceparent, tracestate string) (context.Context, bool) {
+	if traceparent == "" {
+		return ctx, false
+	}
+	carrier := propagation.MapCarrier{"traceparent": traceparent}
+	if tracestate != "" {
+		carrier["tracestate"] = tracestate
+	}
+	remote := propagation.TraceContext{}.Extract(ctx, carrier)
+	if !trace.SpanContextFromContext(remote).IsRemote() {
+		return ctx, false
+	}
+	return remote, true
+}
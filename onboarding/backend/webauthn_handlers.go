@@ -363,10 +363,19 @@ func handleWebAuthnVerifyFinish(w http.ResponseWriter, r *http.Request) {
 		// Don't fail the request - WebAuthn verification succeeded, federation activation is best-effort
 	}
 
+	// The ticket is exchanged at /rho2/auth/issue for an OCT naming this operator
+	octTicket, err := issueOCTTicket(ctx, req.Operator)
+	if err != nil {
+		log.Printf("WebAuthn Verify Finish: issueOCTTicket failed for operator %s: %v", req.Operator, err)
+		http.Error(w, "Failed to store OCT ticket", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"status":   "verified",
-		"identity": user.WebAuthnName(),
+		"status":    "verified",
+		"identity":  user.WebAuthnName(),
+		"octTicket": octTicket,
 	})
 }
 
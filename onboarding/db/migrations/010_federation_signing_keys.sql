@@ -0,0 +1,22 @@
+-- Migration: 010_federation_signing_keys.sql
+-- Description: Persistent Ed25519 signing keys for federation tokens (keyring with key IDs)
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Federation Signing Keys Table
+-- Newest key signs new tokens; the current key plus the retained previous keys verify
+CREATE TABLE IF NOT EXISTS public.federation_signing_keys (
+    kid VARCHAR(64) PRIMARY KEY,
+    private_seed BYTEA NOT NULL,
+    public_key BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_signing_keys_created_at ON public.federation_signing_keys(created_at DESC);
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_signing_keys IS 'Ed25519 federation token signing keys, selected by the kid claim';
+COMMENT ON COLUMN public.federation_signing_keys.private_seed IS 'Ed25519 private key seed (32 bytes); restrict access to this table';
+COMMENT ON COLUMN public.federation_signing_keys.public_key IS 'Ed25519 public key distributed to nodes for verification';
@@ -0,0 +1,25 @@
+-- Migration: 011_federation_revocations.sql
+-- Description: Durable revocation list for federation tokens (by token hash, node or tenant)
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Federation Revocations Table
+-- Consulted by both federation middlewares through an in-process cache
+CREATE TABLE IF NOT EXISTS public.federation_revocations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('token', 'node', 'tenant')),
+    value VARCHAR(255) NOT NULL,
+    reason TEXT,
+    revoked_by VARCHAR(255) NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(kind, value)
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_revocations_kind ON public.federation_revocations(kind);
+CREATE INDEX IF NOT EXISTS idx_federation_revocations_revoked_at ON public.federation_revocations(revoked_at DESC);
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_revocations IS 'Revoked federation tokens, nodes and tenants';
+COMMENT ON COLUMN public.federation_revocations.value IS 'SHA256 hex of the token (kind=token), node ID (kind=node) or tenant ID (kind=tenant)';
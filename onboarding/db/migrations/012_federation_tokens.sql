@@ -0,0 +1,28 @@
+-- Migration: 012_federation_tokens.sql
+-- Description: Registry of issued and imported federation tokens (by hash) for listing and revocation
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Federation Tokens Table
+-- Tokens are referenced by SHA256 hash only; the token itself is never stored
+CREATE TABLE IF NOT EXISTS public.federation_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    kid VARCHAR(64) NOT NULL,
+    node_id VARCHAR(255) NOT NULL,
+    tenant_id VARCHAR(255) NOT NULL,
+    audience TEXT[] NOT NULL DEFAULT '{}',
+    source VARCHAR(50) NOT NULL CHECK (source IN ('handshake', 'node_join', 'import')),
+    issued_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    registered_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_tokens_node_id ON public.federation_tokens(node_id);
+CREATE INDEX IF NOT EXISTS idx_federation_tokens_tenant_id ON public.federation_tokens(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_federation_tokens_registered_at ON public.federation_tokens(registered_at DESC);
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_tokens IS 'Federation tokens issued by the handshake / node join or imported from CLI and UI';
+COMMENT ON COLUMN public.federation_tokens.token_hash IS 'SHA256 hex of the token; matches federation_revocations.value for kind=token';
@@ -0,0 +1,27 @@
+-- Migration: 013_federation_node_keys.sql
+-- Description: Node public keys for the asymmetric federation handshake
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Federation Node Keys Table
+-- Public halves of the node keys shipped in bootstrap kits, registered at kit generation time
+CREATE TABLE IF NOT EXISTS public.federation_node_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    node_id VARCHAR(255) NOT NULL,
+    tenant_id UUID NOT NULL REFERENCES public.tenants(id) ON DELETE CASCADE,
+    algorithm VARCHAR(50) NOT NULL CHECK (algorithm IN ('ed25519', 'rsa-sha256')),
+    public_key BYTEA NOT NULL,
+    kit_fingerprint TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(node_id, algorithm)
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_node_keys_node_id ON public.federation_node_keys(node_id);
+CREATE INDEX IF NOT EXISTS idx_federation_node_keys_tenant_id ON public.federation_node_keys(tenant_id);
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_node_keys IS 'Node public keys verifying the federation handshake assert step';
+COMMENT ON COLUMN public.federation_node_keys.public_key IS 'Raw Ed25519 public key, or PKIX DER RSA public key (rsa-sha256)';
+COMMENT ON COLUMN public.federation_node_keys.kit_fingerprint IS 'Fingerprint of the bootstrap kit that shipped the private key';
@@ -0,0 +1,30 @@
+-- Migration: 014_federation_handshake_challenges.sql
+-- Description: Shared store for single-use federation handshake challenges (multi-replica backends)
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Federation Handshake Challenges Table
+-- Rows are deleted when asserted, so each challenge/nonce pair can be used once
+CREATE UNLOGGED TABLE IF NOT EXISTS public.federation_handshake_challenges (
+    challenge VARCHAR(128) PRIMARY KEY,
+    nonce VARCHAR(128) NOT NULL,
+    node_id VARCHAR(255) NOT NULL,
+    tenant_id VARCHAR(255) NOT NULL,
+    fingerprint TEXT NOT NULL DEFAULT '',
+    algo VARCHAR(50) NOT NULL,
+    key_algorithm VARCHAR(50),
+    public_key BYTEA,
+    source VARCHAR(255) NOT NULL DEFAULT '',
+    issued_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_handshake_challenges_node ON public.federation_handshake_challenges(node_id, source, expires_at);
+CREATE INDEX IF NOT EXISTS idx_federation_handshake_challenges_expires_at ON public.federation_handshake_challenges(expires_at);
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_handshake_challenges IS 'Outstanding federation handshake challenges; consumed on assert';
+COMMENT ON COLUMN public.federation_handshake_challenges.public_key IS 'Node key snapshot used to verify the assert (NULL in legacy HMAC mode)';
+COMMENT ON COLUMN public.federation_handshake_challenges.source IS 'Client address the challenge was issued to; outstanding challenges are capped per node and source';
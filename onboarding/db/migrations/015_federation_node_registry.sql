@@ -0,0 +1,29 @@
+-- Migration: 015_federation_node_registry.sql
+-- Description: Extend federation_nodes with heartbeat-reported node metadata (persistent node registry)
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Nodes registered by heartbeat (e.g. Pi agents) have no database of their own
+ALTER TABLE public.federation_nodes ALTER COLUMN database_url DROP NOT NULL;
+ALTER TABLE public.federation_nodes ALTER COLUMN region SET DEFAULT '';
+
+-- Heartbeat-reported metadata
+-- (region stays the routing region set by operators; the agent's own region goes to reported_region)
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(255);
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS reported_region VARCHAR(100);
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS agent_version VARCHAR(100);
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS hardware JSONB;
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS ip_address TEXT;
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS first_seen_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_federation_nodes_tenant_id ON public.federation_nodes(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_federation_nodes_last_seen_at ON public.federation_nodes(last_seen_at DESC);
+
+-- Comments for documentation
+COMMENT ON COLUMN public.federation_nodes.status IS 'Routing status used by FederationRouter (active = routable database)';
+COMMENT ON COLUMN public.federation_nodes.reported_region IS 'Region reported in heartbeats; never used for routing or residency';
+COMMENT ON COLUMN public.federation_nodes.hardware IS 'Hardware info reported in heartbeats (model, cpu, memory, ...)';
+COMMENT ON COLUMN public.federation_nodes.last_seen_at IS 'Timestamp of the last heartbeat (NULL for database-only nodes)';
@@ -0,0 +1,35 @@
+-- Migration: 016_federation_node_lifecycle.sql
+-- Description: Node lifecycle state machine (pending → joined → online → degraded → offline → decommissioned)
+--              and per-tenant heartbeat thresholds
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Lifecycle state of heartbeat-registered nodes (independent of the routing status column)
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS lifecycle_state VARCHAR(32);
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS missed_heartbeats INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS state_changed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS state_reason TEXT;
+
+ALTER TABLE public.federation_nodes DROP CONSTRAINT IF EXISTS federation_nodes_lifecycle_state_check;
+ALTER TABLE public.federation_nodes ADD CONSTRAINT federation_nodes_lifecycle_state_check
+    CHECK (lifecycle_state IS NULL OR lifecycle_state IN ('pending', 'joined', 'online', 'degraded', 'offline', 'decommissioned'));
+
+CREATE INDEX IF NOT EXISTS idx_federation_nodes_lifecycle_state ON public.federation_nodes(lifecycle_state);
+
+-- Per-tenant heartbeat thresholds (tenants without a row use the built-in defaults)
+CREATE TABLE IF NOT EXISTS public.federation_tenant_settings (
+    tenant_id VARCHAR(255) PRIMARY KEY,
+    heartbeat_interval_ms INTEGER NOT NULL CHECK (heartbeat_interval_ms > 0),
+    degraded_after_missed INTEGER NOT NULL CHECK (degraded_after_missed > 0),
+    offline_after_missed INTEGER NOT NULL,
+    updated_by VARCHAR(255) NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (offline_after_missed > degraded_after_missed)
+);
+
+-- Comments for documentation
+COMMENT ON COLUMN public.federation_nodes.lifecycle_state IS 'Node lifecycle: pending, joined, online, degraded, offline, decommissioned';
+COMMENT ON COLUMN public.federation_nodes.missed_heartbeats IS 'Consecutive heartbeat intervals missed when the state last changed';
+COMMENT ON TABLE public.federation_tenant_settings IS 'Per-tenant federation node heartbeat thresholds';
@@ -0,0 +1,30 @@
+-- Migration: 017_federation_events.sql
+-- Description: Durable federation event log, range-partitioned by day
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Daily partitions (federation_events_YYYYMMDD) are created ahead of time and dropped
+-- after the retention period by the backend (federation/event_log.go)
+CREATE TABLE IF NOT EXISTS public.federation_events (
+    id BIGINT NOT NULL,
+    ts TIMESTAMP WITH TIME ZONE NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    node_id VARCHAR(255),
+    tenant_id VARCHAR(255),
+    data JSONB,
+    PRIMARY KEY (ts, id)
+) PARTITION BY RANGE (ts);
+
+-- Catches events outside any daily partition (e.g. clock skew) so writes never fail
+CREATE TABLE IF NOT EXISTS public.federation_events_default
+    PARTITION OF public.federation_events DEFAULT;
+
+CREATE INDEX IF NOT EXISTS idx_federation_events_type_ts ON public.federation_events(type, ts DESC);
+CREATE INDEX IF NOT EXISTS idx_federation_events_node_ts ON public.federation_events(node_id, ts DESC);
+CREATE INDEX IF NOT EXISTS idx_federation_events_tenant_ts ON public.federation_events(tenant_id, ts DESC);
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_events IS 'Durable federation event log (partitioned by day, retention enforced by the backend)';
+COMMENT ON COLUMN public.federation_events.id IS 'Monotonic event ID (also the SSE event ID)';
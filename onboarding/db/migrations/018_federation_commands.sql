@@ -0,0 +1,48 @@
+-- Migration: 018_federation_commands.sql
+-- Description: Per-node/per-agent command queue for federation agents
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Commands enqueued by operators and pulled by agents via /api/federation/agents/commands
+-- Lifecycle: queued → delivered → acknowledged → completed | failed
+--            queued/delivered → expired (TTL), delivered → queued (lease lapsed without ack)
+CREATE TABLE IF NOT EXISTS public.federation_commands (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    node_id VARCHAR(255) NOT NULL,
+    agent_id VARCHAR(255),                  -- NULL = any agent on the node
+    tenant_id VARCHAR(255),
+    command_type VARCHAR(100) NOT NULL,
+    payload JSONB,
+    priority INTEGER NOT NULL DEFAULT 0,    -- higher is delivered first
+    idempotency_key VARCHAR(255),
+    status VARCHAR(32) NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'delivered', 'acknowledged', 'completed', 'failed', 'expired')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    result JSONB,
+    error TEXT,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    lease_expires_at TIMESTAMP WITH TIME ZONE,
+    acknowledged_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Idempotency keys are scoped per node
+CREATE UNIQUE INDEX IF NOT EXISTS idx_federation_commands_idempotency
+    ON public.federation_commands(node_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+-- Pull path: queued commands for a node by priority
+CREATE INDEX IF NOT EXISTS idx_federation_commands_pull
+    ON public.federation_commands(node_id, priority DESC, created_at) WHERE status = 'queued';
+
+CREATE INDEX IF NOT EXISTS idx_federation_commands_tenant_id ON public.federation_commands(tenant_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_federation_commands_status ON public.federation_commands(status);
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_commands IS 'Federation agent command queue (persisted across restarts)';
+COMMENT ON COLUMN public.federation_commands.lease_expires_at IS 'Delivered commands not acknowledged by this time are requeued';
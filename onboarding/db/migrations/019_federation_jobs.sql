@@ -0,0 +1,50 @@
+-- Migration: 019_federation_jobs.sql
+-- Description: Job execution tracking for federation agent commands (retries, timeouts, cancellation)
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Commands can be cancelled and held back until a retry is due
+ALTER TABLE public.federation_commands ADD COLUMN IF NOT EXISTS not_before TIMESTAMP WITH TIME ZONE;
+ALTER TABLE public.federation_commands DROP CONSTRAINT IF EXISTS federation_commands_status_check;
+ALTER TABLE public.federation_commands ADD CONSTRAINT federation_commands_status_check
+    CHECK (status IN ('queued', 'delivered', 'acknowledged', 'completed', 'failed', 'expired', 'cancelled'));
+
+-- One job per command; it tracks execution across retry attempts
+-- Lifecycle: queued → running → succeeded | failed | timed_out, queued/running → cancelled
+CREATE TABLE IF NOT EXISTS public.federation_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    command_id UUID NOT NULL UNIQUE REFERENCES public.federation_commands(id) ON DELETE CASCADE,
+    node_id VARCHAR(255) NOT NULL,
+    agent_id VARCHAR(255),
+    tenant_id VARCHAR(255),
+    job_type VARCHAR(100) NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'running', 'succeeded', 'failed', 'timed_out', 'cancelled')),
+    attempt INTEGER NOT NULL DEFAULT 1,
+    max_attempts INTEGER NOT NULL DEFAULT 1 CHECK (max_attempts > 0),
+    timeout_seconds INTEGER NOT NULL CHECK (timeout_seconds > 0),
+    retry_backoff_seconds INTEGER NOT NULL DEFAULT 0,
+    exit_code INTEGER,
+    result JSONB,
+    stdout_excerpt TEXT,
+    stderr_excerpt TEXT,
+    error TEXT,
+    cancelled_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    deadline_at TIMESTAMP WITH TIME ZONE,     -- running jobs past this are timed out by the sweeper
+    next_retry_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_jobs_node_id ON public.federation_jobs(node_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_federation_jobs_tenant_id ON public.federation_jobs(tenant_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_federation_jobs_running_deadline
+    ON public.federation_jobs(deadline_at) WHERE status = 'running';
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_jobs IS 'Execution tracking for federation agent commands';
+COMMENT ON COLUMN public.federation_jobs.stdout_excerpt IS 'Tail of the agent-reported stdout (truncated)';
@@ -0,0 +1,54 @@
+-- Migration: 020_federation_telemetry.sql
+-- Description: Agent telemetry samples with 1-minute and 1-hour rollups
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Raw samples ingested from /api/federation/agents/telemetry and bus "telemetry" messages
+-- Kept for a short window; longer ranges are served from the rollups below
+CREATE TABLE IF NOT EXISTS public.federation_telemetry_samples (
+    ts TIMESTAMP WITH TIME ZONE NOT NULL,
+    tenant_id VARCHAR(255) NOT NULL DEFAULT '',
+    node_id VARCHAR(255) NOT NULL,
+    agent_id VARCHAR(255) NOT NULL DEFAULT '',
+    metric VARCHAR(200) NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}'::jsonb,
+    value DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_telemetry_samples_node
+    ON public.federation_telemetry_samples(node_id, metric, ts);
+CREATE INDEX IF NOT EXISTS idx_federation_telemetry_samples_tenant
+    ON public.federation_telemetry_samples(tenant_id, metric, ts);
+CREATE INDEX IF NOT EXISTS idx_federation_telemetry_samples_ts ON public.federation_telemetry_samples(ts);
+
+-- Downsampled series: one row per series and bucket at each resolution ('1m', '1h')
+-- labels_key is a canonical encoding of labels so the series identity can be part of the primary key
+CREATE TABLE IF NOT EXISTS public.federation_telemetry_rollups (
+    resolution VARCHAR(8) NOT NULL CHECK (resolution IN ('1m', '1h')),
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    tenant_id VARCHAR(255) NOT NULL DEFAULT '',
+    node_id VARCHAR(255) NOT NULL,
+    agent_id VARCHAR(255) NOT NULL DEFAULT '',
+    metric VARCHAR(200) NOT NULL,
+    labels_key TEXT NOT NULL DEFAULT '',
+    labels JSONB NOT NULL DEFAULT '{}'::jsonb,
+    sample_count BIGINT NOT NULL,
+    sum DOUBLE PRECISION NOT NULL,
+    min DOUBLE PRECISION NOT NULL,
+    max DOUBLE PRECISION NOT NULL,
+    last DOUBLE PRECISION NOT NULL,
+    last_ts TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (resolution, node_id, metric, agent_id, labels_key, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_telemetry_rollups_tenant
+    ON public.federation_telemetry_rollups(resolution, tenant_id, metric, bucket);
+CREATE INDEX IF NOT EXISTS idx_federation_telemetry_rollups_bucket
+    ON public.federation_telemetry_rollups(resolution, bucket);
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_telemetry_samples IS 'Raw federation agent telemetry samples (short retention)';
+COMMENT ON TABLE public.federation_telemetry_rollups IS 'Federation agent telemetry downsampled to 1-minute and 1-hour buckets';
+COMMENT ON COLUMN public.federation_telemetry_rollups.labels_key IS 'Canonical sorted key=value encoding of labels';
@@ -0,0 +1,26 @@
+-- Migration: 021_federation_dead_letters.sql
+-- Description: Dead-letter store for federation bus messages no handler accepted
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Bus messages of unknown types (when the unknown-type policy is dead_letter)
+CREATE TABLE IF NOT EXISTS public.federation_dead_letters (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_type VARCHAR(100) NOT NULL,
+    version INTEGER NOT NULL DEFAULT 0,
+    node_id VARCHAR(255) NOT NULL,
+    tenant_id VARCHAR(255),
+    remote_ip VARCHAR(64),
+    data JSONB,
+    reason TEXT NOT NULL,
+    received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_dead_letters_received_at ON public.federation_dead_letters(received_at DESC);
+CREATE INDEX IF NOT EXISTS idx_federation_dead_letters_node_id ON public.federation_dead_letters(node_id, received_at DESC);
+CREATE INDEX IF NOT EXISTS idx_federation_dead_letters_type ON public.federation_dead_letters(message_type, received_at DESC);
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_dead_letters IS 'Federation bus messages that no registered handler accepted';
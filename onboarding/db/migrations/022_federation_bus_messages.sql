@@ -0,0 +1,29 @@
+-- Migration: 022_federation_bus_messages.sql
+-- Description: Bus message IDs for idempotent delivery; envelope IDs on dead letters
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Message IDs seen per node within the deduplication window (24h) with the response sent
+-- response_status IS NULL while the first delivery is still being handled
+CREATE TABLE IF NOT EXISTS public.federation_bus_messages (
+    node_id VARCHAR(255) NOT NULL,
+    message_id VARCHAR(128) NOT NULL,
+    message_type VARCHAR(100) NOT NULL,
+    correlation_id VARCHAR(128),
+    sent_at TIMESTAMP WITH TIME ZONE,
+    received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    response_status INTEGER,
+    response_body JSONB,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (node_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_federation_bus_messages_received_at ON public.federation_bus_messages(received_at);
+
+ALTER TABLE public.federation_dead_letters ADD COLUMN IF NOT EXISTS message_id VARCHAR(128);
+ALTER TABLE public.federation_dead_letters ADD COLUMN IF NOT EXISTS correlation_id VARCHAR(128);
+
+-- Comments for documentation
+COMMENT ON TABLE public.federation_bus_messages IS 'Federation bus message IDs for deduplication of agent retries';
@@ -0,0 +1,12 @@
+-- Migration: 023_federation_node_relay.sql
+-- Description: Relay (gateway) capability for federation nodes forwarding bus messages for other nodes
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Granted by operators; session tokens of relay nodes carry the "relay" scope
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS relay_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Comments for documentation
+COMMENT ON COLUMN public.federation_nodes.relay_enabled IS 'Node may forward bus messages for other nodes of its tenant';
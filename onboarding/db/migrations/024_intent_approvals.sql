@@ -0,0 +1,34 @@
+-- Migration: 024_intent_approvals.sql
+-- Description: Durable intent approval workflow (pending -> approved | denied | expired)
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Intents awaiting operator approval; each decision is also written to audit_log
+CREATE TABLE IF NOT EXISTS public.intent_approvals (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    requested_by VARCHAR(255) NOT NULL,
+    action VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'denied', 'expired')),
+    metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    decided_by VARCHAR(255),
+    decided_at TIMESTAMP WITH TIME ZONE,
+    decision_reason TEXT,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK ((status = 'pending') = (decided_at IS NULL))
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_intent_approvals_status_created ON public.intent_approvals(status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_intent_approvals_pending_expiry ON public.intent_approvals(expires_at) WHERE status = 'pending';
+
+-- Comments for documentation
+COMMENT ON TABLE public.intent_approvals IS 'Execution intents awaiting operator approval';
+COMMENT ON COLUMN public.intent_approvals.status IS 'pending, approved, denied or expired; only pending intents can be decided';
+COMMENT ON COLUMN public.intent_approvals.expires_at IS 'Pending intents past this time are expired by the backend';
+COMMENT ON COLUMN public.intent_approvals.decided_by IS 'Operator (OCT subject) who decided the intent, or system for TTL expiry';
+COMMENT ON COLUMN public.intent_approvals.decision_reason IS 'Reason recorded with the decision';
@@ -0,0 +1,52 @@
+-- Migration: 025_intent_approval_quorum.sql
+-- Description: WebAuthn-signed, M-of-N quorum approvals for intents
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Per-action approval policies ('*' is the default for actions without their own policy)
+CREATE TABLE IF NOT EXISTS public.intent_approval_policies (
+    action VARCHAR(255) PRIMARY KEY,
+    required_approvals INTEGER NOT NULL CHECK (required_approvals >= 1),
+    approvers TEXT[] NOT NULL DEFAULT '{}',
+    updated_by VARCHAR(255) NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (cardinality(approvers) = 0 OR cardinality(approvers) >= required_approvals)
+);
+
+-- The policy in force when an intent is created is copied onto it
+ALTER TABLE public.intent_approvals ADD COLUMN IF NOT EXISTS required_approvals INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE public.intent_approvals ADD COLUMN IF NOT EXISTS approvers TEXT[] NOT NULL DEFAULT '{}';
+
+-- One WebAuthn-signed approval per operator per intent
+CREATE TABLE IF NOT EXISTS public.intent_approval_signatures (
+    intent_id UUID NOT NULL REFERENCES public.intent_approvals(id) ON DELETE CASCADE,
+    operator VARCHAR(255) NOT NULL,
+    credential_id TEXT NOT NULL,
+    intent_hash CHAR(64) NOT NULL,
+    reason TEXT NOT NULL,
+    signed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (intent_id, operator)
+);
+
+-- Outstanding WebAuthn approval ceremonies (single use)
+CREATE TABLE IF NOT EXISTS public.intent_approval_challenges (
+    intent_id UUID NOT NULL REFERENCES public.intent_approvals(id) ON DELETE CASCADE,
+    operator VARCHAR(255) NOT NULL,
+    session_data JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (intent_id, operator)
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_intent_approval_challenges_expires_at ON public.intent_approval_challenges(expires_at);
+
+-- Comments for documentation
+COMMENT ON TABLE public.intent_approval_policies IS 'M-of-N approval quorum per intent action';
+COMMENT ON COLUMN public.intent_approval_policies.approvers IS 'Operators allowed to approve (N); empty means any operator holding intent.approve';
+COMMENT ON COLUMN public.intent_approvals.required_approvals IS 'Distinct operator approvals (M) needed before the intent is approved';
+COMMENT ON TABLE public.intent_approval_signatures IS 'Operator approvals, each backed by a WebAuthn assertion over the intent hash';
+COMMENT ON COLUMN public.intent_approval_signatures.intent_hash IS 'SHA-256 (hex) of the intent content the operator signed';
+COMMENT ON TABLE public.intent_approval_challenges IS 'Pending WebAuthn approval ceremonies; challenge = intent hash || random nonce';
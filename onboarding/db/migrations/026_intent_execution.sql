@@ -0,0 +1,42 @@
+-- Migration: 026_intent_execution.sql
+-- Description: Dispatch approved intents to agents as work items and track their outcome
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- What an approved intent runs: the action with payload, on each target node (optionally one agent)
+ALTER TABLE public.intent_approvals ADD COLUMN IF NOT EXISTS targets JSONB NOT NULL DEFAULT '[]'::jsonb;
+ALTER TABLE public.intent_approvals ADD COLUMN IF NOT EXISTS payload JSONB;
+ALTER TABLE public.intent_approvals ADD COLUMN IF NOT EXISTS dispatched_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE public.intent_approvals ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP WITH TIME ZONE;
+
+-- Execution statuses follow approval
+ALTER TABLE public.intent_approvals DROP CONSTRAINT IF EXISTS intent_approvals_status_check;
+ALTER TABLE public.intent_approvals ADD CONSTRAINT intent_approvals_status_check
+    CHECK (status IN ('pending', 'approved', 'denied', 'expired', 'dispatched', 'completed', 'failed'));
+
+-- One work item per target, linked to the agent command and job delivering it
+CREATE TABLE IF NOT EXISTS public.intent_work_items (
+    intent_id UUID NOT NULL REFERENCES public.intent_approvals(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    node_id VARCHAR(255) NOT NULL,
+    agent_id VARCHAR(255),
+    command_id UUID UNIQUE REFERENCES public.federation_commands(id) ON DELETE SET NULL,
+    job_id UUID,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (intent_id, seq)
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_intent_work_items_open ON public.intent_work_items(updated_at) WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_intent_approvals_approved ON public.intent_approvals(created_at) WHERE status = 'approved';
+
+-- Comments for documentation
+COMMENT ON COLUMN public.intent_approvals.targets IS 'Nodes (and optional agents) the intent runs on once approved; empty = approval only';
+COMMENT ON COLUMN public.intent_approvals.payload IS 'Command payload delivered to each target';
+COMMENT ON TABLE public.intent_work_items IS 'Delivery of an approved intent to one target, linked to its agent command and job';
+COMMENT ON COLUMN public.intent_work_items.status IS 'pending, running, succeeded or failed; the intent completes or fails once all have finished';
@@ -0,0 +1,20 @@
+-- Migration: 027_federation_node_pools.sql
+-- Description: Per-node connection pool sizing for the FederationRouter node database pools
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- NULL = backend default (FEDERATION_NODE_POOL_MAX_CONNS / FEDERATION_NODE_POOL_MIN_CONNS)
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS pool_max_conns INTEGER;
+ALTER TABLE public.federation_nodes ADD COLUMN IF NOT EXISTS pool_min_conns INTEGER;
+
+ALTER TABLE public.federation_nodes DROP CONSTRAINT IF EXISTS federation_nodes_pool_conns_check;
+ALTER TABLE public.federation_nodes ADD CONSTRAINT federation_nodes_pool_conns_check
+    CHECK ((pool_max_conns IS NULL OR pool_max_conns > 0)
+       AND (pool_min_conns IS NULL OR pool_min_conns >= 0)
+       AND (pool_max_conns IS NULL OR pool_min_conns IS NULL OR pool_min_conns <= pool_max_conns));
+
+-- Comments for documentation
+COMMENT ON COLUMN public.federation_nodes.pool_max_conns IS 'Maximum connections of the FederationRouter pool for this node database (NULL = default)';
+COMMENT ON COLUMN public.federation_nodes.pool_min_conns IS 'Connections the FederationRouter pool keeps open for this node database (NULL = default)';
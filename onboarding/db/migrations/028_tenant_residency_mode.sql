@@ -0,0 +1,13 @@
+-- Migration: 028_tenant_residency_mode.sql
+-- Description: Per-tenant fail-closed data residency mode for FederationRouter
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Operator override of the residency mode; NULL derives it from the onboarding config
+-- (dataRegionsConfig.residencyRequired or a regulated sensitivity such as "PHI / HIPAA")
+ALTER TABLE public.tenants ADD COLUMN IF NOT EXISTS residency_strict BOOLEAN;
+
+-- Comments for documentation
+COMMENT ON COLUMN public.tenants.residency_strict IS 'true: refuse requests (503 RESIDENCY_UNAVAILABLE) when no node database can serve the tenant; false: fall back to the default database; NULL: derived from config_data';
@@ -0,0 +1,18 @@
+-- Migration: 029_federation_routing_weights.sql
+-- Description: Weighted writer routing and read replicas in federation_routing
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Weights are shares of a region's requests; 0 parks a node
+ALTER TABLE public.federation_routing DROP CONSTRAINT IF EXISTS federation_routing_weight_check;
+ALTER TABLE public.federation_routing ADD CONSTRAINT federation_routing_weight_check CHECK (weight >= 0);
+
+-- FederationRouter looks up the writers (or replicas) of a tenant's region on every request
+CREATE INDEX IF NOT EXISTS idx_federation_routing_tenant_region
+    ON public.federation_routing(tenant_id, region, is_primary);
+
+-- Comments for documentation
+COMMENT ON COLUMN public.federation_routing.is_primary IS 'true: writer node of the region; false: read replica serving read-only GET requests within the staleness bound';
+COMMENT ON COLUMN public.federation_routing.weight IS 'Share of the region''s requests among its writers (or replicas); 0 parks the node';
@@ -0,0 +1,36 @@
+-- Migration: 030_tenant_migrations.sql
+-- Description: Live tenant migration between federation node databases
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Tenant Migrations Table
+-- One row per move of a tenant's data to another node database
+CREATE TABLE IF NOT EXISTS public.tenant_migrations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES public.tenants(id) ON DELETE CASCADE,
+    source_node_id VARCHAR(255) NOT NULL,
+    target_node_id VARCHAR(255) NOT NULL REFERENCES public.federation_nodes(node_id) ON DELETE RESTRICT,
+    target_region VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'copying', 'catching_up', 'cutting_over', 'completed', 'failed', 'rolling_back', 'rolled_back')),
+    progress JSONB NOT NULL DEFAULT '{}'::jsonb,
+    error TEXT,
+    requested_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- At most one unfinished migration per tenant (a failed one must be rolled back first)
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tenant_migrations_active
+    ON public.tenant_migrations(tenant_id) WHERE status NOT IN ('completed', 'rolled_back');
+CREATE INDEX IF NOT EXISTS idx_tenant_migrations_tenant_created ON public.tenant_migrations(tenant_id, created_at DESC);
+
+-- Comments for documentation
+COMMENT ON TABLE public.tenant_migrations IS 'Moves of a tenant''s rows between node databases: copy with dual-write, verify, cut over, or roll back';
+COMMENT ON COLUMN public.tenant_migrations.source_node_id IS 'Node the tenant was routed to when the migration started; default = the default database';
+COMMENT ON COLUMN public.tenant_migrations.status IS 'pending, copying, catching_up, cutting_over, completed, failed, rolling_back or rolled_back';
+COMMENT ON COLUMN public.tenant_migrations.progress IS 'Copy passes and the last per-table row counts and checksums of source and target';
+COMMENT ON COLUMN public.tenant_migrations.updated_at IS 'Heartbeat of the backend running the migration; stale migrations no longer hold tenant writes';
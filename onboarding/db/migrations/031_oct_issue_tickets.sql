@@ -0,0 +1,21 @@
+-- Migration: 031_oct_issue_tickets.sql
+-- Description: Single-use tickets binding an issued OCT to the operator who completed WebAuthn verification
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- One ticket per successful WebAuthn verification, exchanged once for an OCT at /rho2/auth/issue
+CREATE TABLE IF NOT EXISTS public.oct_issue_tickets (
+    ticket_hash CHAR(64) PRIMARY KEY,
+    operator VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_oct_issue_tickets_expires_at ON public.oct_issue_tickets(expires_at);
+
+-- Comments for documentation
+COMMENT ON TABLE public.oct_issue_tickets IS 'Pending OCT issuances; each ticket names the operator whose WebAuthn assertion it came from';
+COMMENT ON COLUMN public.oct_issue_tickets.ticket_hash IS 'SHA-256 (hex) of the ticket returned to the client; the ticket itself is not stored';
@@ -0,0 +1,22 @@
+-- Migration: 032_federation_revocation_expiry.sql
+-- Description: Record when revoked tokens expire so their revocation entries can be purged
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Token revocations only matter until the token itself expires
+ALTER TABLE public.federation_revocations ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+
+-- Backfill existing token entries from the token registry
+UPDATE public.federation_revocations r
+SET expires_at = t.expires_at
+FROM public.federation_tokens t
+WHERE r.kind = 'token' AND r.expires_at IS NULL AND t.token_hash = r.value;
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_federation_revocations_token_expires_at
+    ON public.federation_revocations(expires_at) WHERE kind = 'token';
+
+-- Comments for documentation
+COMMENT ON COLUMN public.federation_revocations.expires_at IS 'Expiry of the revoked token (kind=token); the entry is purged after it. NULL when unknown or for node/tenant entries';
@@ -0,0 +1,13 @@
+-- Migration: 033_tenant_migration_mirror_xmin.sql
+-- Description: Incremental write mirroring of tenant migrations
+-- Database: sage_os
+-- Schema: public
+
+SET search_path TO public;
+
+-- Copies and write mirror syncs (serialized across backends by an advisory lock keyed by the
+-- migration id) record how far into the source's transactions they have copied
+ALTER TABLE public.tenant_migrations ADD COLUMN IF NOT EXISTS mirror_xmin BIGINT;
+
+-- Comments for documentation
+COMMENT ON COLUMN public.tenant_migrations.mirror_xmin IS 'Source snapshot xmin of the last copy or write mirror sync; rows written by later transactions are mirrored next';
@@ -24,6 +24,13 @@ RESPONSE=$(curl -s -X POST \
 if [ $? -eq 0 ]; then
   echo "✔ Node joined federation successfully"
   echo "$RESPONSE" | jq .
+
+  # The kit token is only valid for joining; the bus and agents API use the session token
+  SESSION_TOKEN=$(echo "$RESPONSE" | jq -r '.federationToken // empty')
+  if [ -n "$SESSION_TOKEN" ]; then
+    echo "$SESSION_TOKEN" > /etc/sage/federation-token
+    chmod 600 /etc/sage/federation-token
+  fi
 else
   echo "✗ Failed to join federation"
   exit 1
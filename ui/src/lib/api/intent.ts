@@ -3,8 +3,42 @@ export interface IntentApproval {
   requested_by: string;
   timestamp: number;
   action: string;
-  status: "pending" | "approved" | "denied";
+  status: "pending" | "approved" | "denied" | "expired" | "dispatched" | "completed" | "failed";
   metadata?: Record<string, any>;
+  reason?: string;
+  expires_at: number;
+  decided_by?: string;
+  decided_at?: number;
+  decision_reason?: string;
+  required_approvals: number;
+  approvers: string[];
+  approvals: IntentSignature[];
+  hash: string;
+  targets: IntentTarget[];
+  payload?: Record<string, any>;
+  work_items: IntentWorkItem[];
+  dispatched_at?: number;
+  completed_at?: number;
+}
+
+export interface IntentTarget {
+  node_id: string;
+  agent_id?: string;
+}
+
+export interface IntentWorkItem extends IntentTarget {
+  command_id?: string;
+  job_id?: string;
+  status: "pending" | "running" | "succeeded" | "failed";
+  error?: string;
+  updated_at: number;
+}
+
+export interface IntentSignature {
+  operator: string;
+  credential_id: string;
+  reason: string;
+  signed_at: number;
 }
 
 export async function fetchPendingIntents(): Promise<IntentApproval[]> {